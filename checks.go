@@ -27,9 +27,40 @@ type Check struct {
 	Name             string
 	Profiles         []Profile
 	UpstreamServices []url.URL
+	ForceResult      ForceResult
+	Shadow           bool          // run during scans without affecting their result
+	Timeout          time.Duration // per call to the upstream service; 0 uses services.checks.defaultTimeout
 	CreatedAt        time.Time
 	UpdatedAt        time.Time
 	DeletedAt        *time.Time
+	ServedStale      bool `db:"-"` // served expired from the cache, see services.cache.serveStaleOnError
+}
+
+// ForceResult overrides the result of a check during scans, without calling
+// its upstream services. Overrides are only honoured when
+// services.checks.allowForceResult is enabled.
+type ForceResult string
+
+const (
+	// ForceResultNone runs the check as normal.
+	ForceResultNone ForceResult = "none"
+	// ForceResultPass makes the check pass.
+	ForceResultPass ForceResult = "pass"
+	// ForceResultFail makes the check fail.
+	ForceResultFail ForceResult = "fail"
+)
+
+// Result returns the forced result of a check, and whether a result is forced
+// at all.
+func (f ForceResult) Result() (result bool, forced bool) {
+	switch f {
+	case ForceResultPass:
+		return true, true
+	case ForceResultFail:
+		return false, true
+	default:
+		return false, false
+	}
 }
 
 func (c Check) EntityType() string { return "Check" }
@@ -43,6 +74,8 @@ type CheckService interface {
 	CreateCheck(ctx context.Context, check CreateCheckRequest) (Check, error)
 	UpdateCheck(ctx context.Context, check UpdateCheckRequest) (Check, error)
 	DeleteCheck(ctx context.Context, id string) error
+	// GetCheckUsage returns the profiles that include the check called name.
+	GetCheckUsage(ctx context.Context, name string) ([]Profile, error)
 }
 
 type GetCheckRequest struct {
@@ -50,13 +83,21 @@ type GetCheckRequest struct {
 	Name string `json:"name" validate:"required_without=ID,excluded_with=ID"`
 }
 
+func (p *GetCheckRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Checks.NormalizeNames.Normalize(p.Name)
+}
+
 type GetCheckResponse struct {
 	Name             string   `json:"name"`
 	Profiles         []string `json:"profiles"`
 	UpstreamServices []string `json:"upstream_services"`
+	ForceResult      string   `json:"force_result,omitempty"`
+	Shadow           bool     `json:"shadow,omitempty"`
+	TimeoutMS        int64    `json:"timeout_ms,omitempty"`
 	CreatedAt        string   `json:"createdAt"`
 	UpdatedAt        string   `json:"updatedAt"`
 	DeletedAt        string   `json:"deletedAt,omitempty"`
+	ServedStale      bool     `json:"served_stale,omitempty"` // see services.cache.serveStaleOnError
 }
 
 func (r *GetCheckResponse) FromCheck(c Check) *GetCheckResponse {
@@ -71,11 +112,17 @@ func (r *GetCheckResponse) FromCheck(c Check) *GetCheckResponse {
 	r.Name = c.Name
 	r.Profiles = p
 	r.UpstreamServices = u
+	if c.ForceResult != ForceResultNone {
+		r.ForceResult = string(c.ForceResult)
+	}
+	r.Shadow = c.Shadow
+	r.TimeoutMS = c.Timeout.Milliseconds()
 	r.CreatedAt = c.CreatedAt.Format(TimeFormatInResponse)
 	r.UpdatedAt = c.UpdatedAt.Format(TimeFormatInResponse)
 	if c.DeletedAt != nil {
 		r.DeletedAt = c.DeletedAt.Format(TimeFormatInResponse)
 	}
+	r.ServedStale = c.ServedStale
 	return r
 }
 
@@ -90,9 +137,18 @@ type GetChecksResponse struct {
 }
 
 type CreateCheckRequest struct {
-	Name             string   `json:"name" validate:"required"`
-	Profiles         []string `json:"profiles"`
-	UpstreamServices []string `json:"upstream_services" validate:"dive,url"`
+	Name             string      `json:"name" validate:"required"`
+	Profiles         []string    `json:"profiles"`
+	UpstreamServices []string    `json:"upstream_services" validate:"dive,url"`
+	ForceResult      ForceResult `json:"force_result" validate:"omitempty,oneof=none pass fail"`
+	Shadow           bool        `json:"shadow"`
+	TimeoutMS        int64       `json:"timeout_ms" validate:"min=0"` // 0 uses services.checks.defaultTimeout
+	ValidateUpstream *bool       `json:"validate_upstream"`           // probe upstream services; defaults to services.checks.probeOnCreate
+}
+
+func (p *CreateCheckRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Checks.NormalizeNames.Normalize(p.Name)
+	config.Services.Profiles.NormalizeNames.normalizeAll(p.Profiles)
 }
 
 type CreateCheckResponse struct {
@@ -100,9 +156,18 @@ type CreateCheckResponse struct {
 }
 
 type UpdateCheckRequest struct {
-	Name             string   `json:"name" validate:"required"`
-	Profiles         []string `json:"profiles"`
-	UpstreamServices []string `json:"upstream_services" validate:"url"`
+	Name             string      `json:"name" validate:"required"`
+	Profiles         []string    `json:"profiles"`
+	UpstreamServices []string    `json:"upstream_services" validate:"url"`
+	ForceResult      ForceResult `json:"force_result" validate:"omitempty,oneof=none pass fail"` // unchanged if empty
+	Shadow           *bool       `json:"shadow"`                                                 // unchanged if omitted
+	TimeoutMS        *int64      `json:"timeout_ms" validate:"omitempty,min=0"`                  // unchanged if omitted
+	ValidateUpstream *bool       `json:"validate_upstream"`                                      // probe upstream services; defaults to services.checks.probeOnCreate
+}
+
+func (p *UpdateCheckRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Checks.NormalizeNames.Normalize(p.Name)
+	config.Services.Profiles.NormalizeNames.normalizeAll(p.Profiles)
 }
 
 type UpdateCheckResponse struct {
@@ -112,3 +177,44 @@ type UpdateCheckResponse struct {
 type DeleteCheckRequest struct {
 	Name string `json:"name" validate:"required"`
 }
+
+func (p *DeleteCheckRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Checks.NormalizeNames.Normalize(p.Name)
+}
+
+// GetCheckUsageRequest asks for the profiles that include the check called
+// Name.
+type GetCheckUsageRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+func (p *GetCheckUsageRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Checks.NormalizeNames.Normalize(p.Name)
+}
+
+// GetCheckUsageResponse lists the profiles, visible to the caller, that
+// include the check called Name.
+type GetCheckUsageResponse struct {
+	Name     string   `json:"name"`
+	Profiles []string `json:"profiles"`
+	Total    int      `json:"total"`
+}
+
+// TestCheckRequest calls the provider of the check called Name, or the
+// provider at Upstream, with either Text or Image as content.
+type TestCheckRequest struct {
+	Name     string `json:"name" validate:"required_without=Upstream,excluded_with=Upstream"`
+	Upstream string `json:"upstream" validate:"omitempty,url"`
+	Text     string `json:"text" validate:"required_without=Image,excluded_with=Image"`
+	Image    []byte `json:"image"` // base64 encoded
+}
+
+func (p *TestCheckRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Checks.NormalizeNames.Normalize(p.Name)
+}
+
+type TestCheckResponse struct {
+	Result   bool              `json:"result"`
+	Detail   string            `json:"detail"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
@@ -19,12 +19,17 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	http2 "net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"runtime/debug"
 	"syscall"
 	"time"
 
@@ -36,6 +41,7 @@ import (
 	"github.com/madsrc/sophrosyne"
 	"github.com/madsrc/sophrosyne/internal/cedar"
 	"github.com/madsrc/sophrosyne/internal/configProvider"
+	"github.com/madsrc/sophrosyne/internal/diagnostics"
 	"github.com/madsrc/sophrosyne/internal/healthchecker"
 	"github.com/madsrc/sophrosyne/internal/http"
 	"github.com/madsrc/sophrosyne/internal/http/middleware"
@@ -64,11 +70,21 @@ func main() {
 				Usage: "The path to the configuration file",
 				Value: "config.yaml",
 			},
+			&cli.BoolFlag{
+				Name:  "disable-config-watch",
+				Usage: "Load the configuration once instead of reloading it when the configuration file changes",
+				Value: false,
+			},
 			&cli.StringSliceFlag{
 				Name:  "secretfiles",
 				Usage: "Files to read individual configuration values from. Multiple files can be specified by separating them with a comma or supply the option multiple times. The name of the file is used to determine what configuration parameter the content of the file will be read in to. For example, a file called 'database.host' will have its content used as the the value for 'database.host' in the configuration. This option is recommended to be used for secrets.",
 				Value: nil,
 			},
+			&cli.StringFlag{
+				Name:  "secretsdir",
+				Usage: "A directory to read individual configuration values from. Every file in the directory is read as if given with --secretfiles, which take precedence. Hidden files and directories are skipped. This suits secrets mounted as a directory, such as Kubernetes secrets.",
+				Value: "",
+			},
 		},
 		Version: "0.0.0",
 		Commands: []*cli.Command{
@@ -82,9 +98,20 @@ func main() {
 			{
 				Name:  "version",
 				Usage: "print the version",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the version, commit, build date and Go version as JSON",
+						Value: false,
+					},
+				},
 				Action: func(c *cli.Context) error {
-					cli.VersionPrinter(c)
-					return nil
+					if !c.Bool("json") {
+						cli.VersionPrinter(c)
+						return nil
+					}
+					buildInfo, _ := debug.ReadBuildInfo()
+					return printVersionJSON(c.App.Writer, newVersionInfo(c.App.Version, buildInfo))
 				},
 			},
 			{
@@ -93,7 +120,7 @@ func main() {
 				Action: func(c *cli.Context) error {
 					validate := validator.NewValidator()
 
-					config, err := getConfig(c.String("config"), nil, c.StringSlice("secretfiles"), validate)
+					config, err := getConfig(c.String("config"), nil, c.StringSlice("secretfiles"), c.String("secretsdir"), c.Bool("disable-config-watch"), validate)
 					if err != nil {
 						return err
 					}
@@ -101,6 +128,7 @@ func main() {
 					if err != nil {
 						return err
 					}
+					defer migrationService.Close()
 
 					err = migrationService.Up()
 					if err != nil {
@@ -124,24 +152,53 @@ func main() {
 					_, _ = fmt.Fprint(c.App.Writer, msg)
 					return nil
 				},
+				Subcommands: []*cli.Command{
+					{
+						Name:  "down",
+						Usage: "roll back the most recently applied migrations",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "steps",
+								Usage: "the number of migrations to roll back",
+								Value: 1,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							validate := validator.NewValidator()
+
+							config, err := getConfig(c.String("config"), nil, c.StringSlice("secretfiles"), c.String("secretsdir"), c.Bool("disable-config-watch"), validate)
+							if err != nil {
+								return err
+							}
+							migrationService, err := migrate.NewMigrationService(config)
+							if err != nil {
+								return err
+							}
+							defer migrationService.Close()
+
+							return migrateDown(c.App.Writer, migrationService, c.Int("steps"))
+						},
+					},
+				},
 			},
 			{
 				Name:  "config",
 				Usage: "show the current configuration",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "show-secrets",
+						Usage: "include secrets such as the database password and site key in the output",
+						Value: false,
+					},
+				},
 				Action: func(c *cli.Context) error {
 					validate := validator.NewValidator()
-					config, err := getConfig(c.String("config"), nil, c.StringSlice("secretfiles"), validate)
+					config, err := getConfig(c.String("config"), nil, c.StringSlice("secretfiles"), c.String("secretsdir"), c.Bool("disable-config-watch"), validate)
 					if err != nil {
 						return err
 					}
 
-					dat, err := yaml.Marshal(config)
-					if err != nil {
-						return err
-					}
-
-					_, _ = fmt.Fprintf(c.App.Writer, "%s\n", dat)
-					return nil
+					return printConfig(c.App.Writer, config, c.Bool("show-secrets"))
 				},
 			},
 			{
@@ -163,7 +220,7 @@ func main() {
 					validate := validator.NewValidator()
 					config, err := getConfig(c.String("config"), map[string]interface{}{
 						"security.tls.insecureSkipVerify": c.Bool("insecure-skip-verify"),
-					}, c.StringSlice("secretfiles"), validate)
+					}, c.StringSlice("secretfiles"), c.String("secretsdir"), c.Bool("disable-config-watch"), validate)
 					if err != nil {
 						return err
 					}
@@ -192,6 +249,86 @@ func main() {
 
 				},
 			},
+			{
+				Name:  "user",
+				Usage: "manage users directly in the database, without the API",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "create",
+						Usage: "create a user and print its token",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "the name of the user",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "email",
+								Usage:    "the email address of the user",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:  "admin",
+								Usage: "make the user an administrator",
+								Value: false,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							return withUserService(c, nil, func(config *sophrosyne.Config, m migrator, newUserService func() (sophrosyne.UserService, error)) error {
+								return createUser(c.Context, c.App.Writer, config, m, newUserService, sophrosyne.CreateUserRequest{
+									Name:    c.String("name"),
+									Email:   c.String("email"),
+									IsAdmin: c.Bool("admin"),
+								})
+							})
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "list the users",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "verbose",
+								Usage: "print the users as JSON",
+								Value: false,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							return withUserService(c, nil, func(config *sophrosyne.Config, m migrator, newUserService func() (sophrosyne.UserService, error)) error {
+								return listUsers(c.Context, c.App.Writer, m, newUserService, c.Bool("verbose"))
+							})
+						},
+					},
+					{
+						Name:  "revoke",
+						Usage: "revoke the token of a user by rotating it, or by deleting the user",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Usage:    "the name of the user",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:  "delete",
+								Usage: "delete the user instead of rotating its token",
+								Value: false,
+							},
+							&cli.BoolFlag{
+								Name:  "verbose",
+								Usage: "print the outcome as JSON",
+								Value: false,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							// The revoked token must not remain valid for a grace window
+							overwrites := map[string]interface{}{"security.tokens.rotationGrace": 0}
+							return withUserService(c, overwrites, func(config *sophrosyne.Config, m migrator, newUserService func() (sophrosyne.UserService, error)) error {
+								return revokeUser(c.Context, c.App.Writer, m, newUserService, config.Services.Users.NormalizeNames.Normalize(c.String("name")), c.Bool("delete"), c.Bool("verbose"))
+							})
+						},
+					},
+				},
+			},
 		},
 	}
 
@@ -200,30 +337,447 @@ func main() {
 	}
 }
 
-func getConfig(filepath string, overwrites map[string]interface{}, secretfiles []string, validate *validator.Validator) (*sophrosyne.Config, error) {
+// printConfig writes config to w as YAML. Secrets are redacted unless
+// showSecrets is set.
+func printConfig(w io.Writer, config *sophrosyne.Config, showSecrets bool) error {
+	out := *config
+	if !showSecrets {
+		out = config.Redacted()
+	}
+
+	var node yaml.Node
+	err := node.Encode(out)
+	if err != nil {
+		return err
+	}
+	if !showSecrets {
+		redactedBytesToScalar(&node)
+	}
+
+	dat, err := yaml.Marshal(&node)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", dat)
+	return err
+}
+
+// redactedBytesToScalar replaces the sequences that byte slices are encoded as
+// with a plain [sophrosyne.RedactedValue] where the byte slice was redacted.
+func redactedBytesToScalar(node *yaml.Node) {
+	if node.Kind == yaml.SequenceNode {
+		var b []byte
+		if node.Decode(&b) == nil && string(b) == sophrosyne.RedactedValue {
+			*node = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: sophrosyne.RedactedValue}
+			return
+		}
+	}
+	for _, child := range node.Content {
+		redactedBytesToScalar(child)
+	}
+}
+
+func getConfig(filepath string, overwrites map[string]interface{}, secretfiles []string, secretsdir string, disableWatch bool, validate *validator.Validator) (*sophrosyne.Config, error) {
+	cp, err := getConfigProvider(filepath, overwrites, secretfiles, secretsdir, disableWatch, validate)
+	if err != nil {
+		return nil, err
+	}
+
+	return cp.Get(), nil
+}
+
+func getConfigProvider(filepath string, overwrites map[string]interface{}, secretfiles []string, secretsdir string, disableWatch bool, validate *validator.Validator) (*configProvider.ConfigProvider, error) {
 	cp, err := configProvider.NewConfigProvider(
 		filepath,
 		overwrites,
 		secretfiles,
+		secretsdir,
 		validate,
+		disableWatch,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return cp.Get(), nil
+	if err := cp.Get().Validate(); err != nil {
+		return nil, err
+	}
+
+	return cp, nil
+}
+
+// shutdownSignals trigger a graceful shutdown of the server. SIGTERM is what
+// container orchestrators send before killing a process.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// notifyShutdown returns a copy of parent that is cancelled when one of the
+// shutdownSignals is received, or when stop is called. Once stop is called,
+// the signals are no longer caught and a second one terminates the process.
+func notifyShutdown(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(parent, shutdownSignals...)
+}
+
+// reloadOnSignal calls reload every time the process receives SIGHUP, until
+// ctx is done. Errors are logged, leaving the previous configuration in place.
+// The signal is caught as soon as reloadOnSignal returns.
+func reloadOnSignal(ctx context.Context, logger *slog.Logger, reload func() error) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sig:
+				logger.InfoContext(ctx, "reloading configuration")
+				if err := reload(); err != nil {
+					logger.ErrorContext(ctx, "unable to reload configuration", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+type migrator interface {
+	Up() error
+	Pending() (bool, error)
+}
+
+// downMigrator rolls back migrations, see [migrate.MigrationService].
+type downMigrator interface {
+	Down(steps int) error
+	Versions() (version uint, dirty bool, err error)
+}
+
+// migrateDown rolls back steps migrations using m and reports the resulting
+// version of the database to w.
+func migrateDown(w io.Writer, m downMigrator, steps int) error {
+	err := m.Down(steps)
+	if errors.Is(err, migrate.ErrNoChange) {
+		_, _ = fmt.Fprint(w, "No migrations to roll back\n")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	v, dirty, err := m.Versions()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		_, _ = fmt.Fprint(w, "Migrations rolled back. No migrations remain applied\n")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("Migrations rolled back. Database at version '%d'", v)
+	if dirty {
+		msg = fmt.Sprintf("%s (dirty)", msg)
+	}
+	_, _ = fmt.Fprintf(w, "%s\n", msg)
+	return nil
+}
+
+var errMigrationsPending = errors.New("database has pending migrations and automatic migration is disabled - run the migrate command before starting the server")
+
+// applyMigrations brings the database schema up to date. If autoMigrate is
+// false, no migrations are applied and an error is returned if the schema is
+// behind.
+func applyMigrations(ctx context.Context, logger *slog.Logger, m migrator, autoMigrate bool) error {
+	if !autoMigrate {
+		logger.DebugContext(ctx, "Automatic migrations disabled, checking for pending migrations")
+		pending, err := m.Pending()
+		if err != nil {
+			return err
+		}
+		if pending {
+			return errMigrationsPending
+		}
+		return nil
+	}
+
+	logger.DebugContext(ctx, "Applying migrations")
+	err := m.Up()
+	if err != nil {
+		if !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+		logger.DebugContext(ctx, "No migrations to apply")
+	}
+	return nil
+}
+
+// versionInfo describes the build of the binary, as printed by the version
+// command with --json.
+type versionInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+	Go      string `json:"go"`
+}
+
+// newVersionInfo returns the versionInfo of version, taking the commit and date
+// from the VCS information stamped into buildInfo by the Go toolchain. They are
+// empty if buildInfo is nil or was built without VCS information.
+func newVersionInfo(version string, buildInfo *debug.BuildInfo) versionInfo {
+	v := versionInfo{Version: version, Go: runtime.Version()}
+	if buildInfo == nil {
+		return v
+	}
+	v.Go = buildInfo.GoVersion
+	for _, setting := range buildInfo.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			v.Commit = setting.Value
+		case "vcs.time":
+			v.Date = setting.Value
+		}
+	}
+	return v
+}
+
+func printVersionJSON(w io.Writer, v versionInfo) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// withUserService loads the configuration, with overwrites applied, and calls
+// fn with it, the migrations of the database and a function connecting to the
+// users in the database. It is shared by the user commands. Unlike the server,
+// connecting does not create the root user or the default profile.
+func withUserService(c *cli.Context, overwrites map[string]interface{}, fn func(config *sophrosyne.Config, m migrator, newUserService func() (sophrosyne.UserService, error)) error) error {
+	validate := validator.NewValidator()
+	config, err := getConfig(c.String("config"), overwrites, c.StringSlice("secretfiles"), c.String("secretsdir"), true, validate)
+	if err != nil {
+		return err
+	}
+	logger := slog.New(slog.NewTextHandler(c.App.ErrWriter, nil))
+
+	migrationService, err := migrate.NewMigrationService(config)
+	if err != nil {
+		return err
+	}
+	defer migrationService.Close()
+
+	return fn(config, migrationService, func() (sophrosyne.UserService, error) {
+		checkService, err := pgx.NewCheckService(c.Context, config, logger)
+		if err != nil {
+			return nil, err
+		}
+		profileService, err := pgx.OpenProfileService(c.Context, config, logger, checkService)
+		if err != nil {
+			return nil, err
+		}
+		return pgx.OpenUserService(c.Context, config, logger, rand.Reader, profileService)
+	})
+}
+
+var errUserMigrationsPending = errors.New("database has pending migrations - run the migrate command before managing users")
+
+// requireMigrated returns errUserMigrationsPending if the database has pending
+// migrations, as users could otherwise be read or written in an outdated
+// schema.
+func requireMigrated(m migrator) error {
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	if pending {
+		return errUserMigrationsPending
+	}
+	return nil
+}
+
+// createUser creates the user described by req, connecting to the database
+// with newUserService, and writes the raw token of the user to w.
+func createUser(ctx context.Context, w io.Writer, config *sophrosyne.Config, m migrator, newUserService func() (sophrosyne.UserService, error), req sophrosyne.CreateUserRequest) error {
+	req.NormalizeNames(config)
+
+	err := requireMigrated(m)
+	if err != nil {
+		return err
+	}
+
+	userService, err := newUserService()
+	if err != nil {
+		return err
+	}
+
+	user, err := userService.CreateUser(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "Created user '%s' (admin: %t).\n\nToken, shown only this once:\n\n%s\n", user.Name, user.IsAdmin, base64.StdEncoding.EncodeToString(user.Token))
+	return err
+}
+
+// listUsers writes all users to w, one per line, or as a JSON array if asJSON
+// is set.
+func listUsers(ctx context.Context, w io.Writer, m migrator, newUserService func() (sophrosyne.UserService, error), asJSON bool) error {
+	err := requireMigrated(m)
+	if err != nil {
+		return err
+	}
+	userService, err := newUserService()
+	if err != nil {
+		return err
+	}
+
+	users := []sophrosyne.GetUserResponse{}
+	cursor := &sophrosyne.DatabaseCursor{}
+	for {
+		page, err := userService.GetUsers(ctx, cursor)
+		if err != nil {
+			return err
+		}
+		for _, u := range page {
+			users = append(users, *(&sophrosyne.GetUserResponse{}).FromUser(u))
+		}
+		if cursor.Position == "" {
+			break
+		}
+	}
+
+	if asJSON {
+		return json.NewEncoder(w).Encode(users)
+	}
+	for _, u := range users {
+		_, err = fmt.Fprintf(w, "%s\t%s\tadmin: %t\n", u.Name, u.Email, u.IsAdmin)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type revokeResult struct {
+	Name   string `json:"name"`
+	Action string `json:"action"` // "rotated" or "deleted"
+}
+
+// revokeUser revokes the token of the user called name by rotating it, without
+// revealing the new token, or by deleting the user if del is set. The outcome
+// is written to w, as JSON if asJSON is set.
+func revokeUser(ctx context.Context, w io.Writer, m migrator, newUserService func() (sophrosyne.UserService, error), name string, del bool, asJSON bool) error {
+	err := requireMigrated(m)
+	if err != nil {
+		return err
+	}
+	userService, err := newUserService()
+	if err != nil {
+		return err
+	}
+
+	result := revokeResult{Name: name, Action: "rotated"}
+	if del {
+		result.Action = "deleted"
+		err = userService.DeleteUser(ctx, name)
+	} else {
+		_, err = userService.RotateToken(ctx, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return json.NewEncoder(w).Encode(result)
+	}
+	if del {
+		_, err = fmt.Fprintf(w, "Deleted user '%s'.\n", name)
+	} else {
+		_, err = fmt.Fprintf(w, "Revoked the token of user '%s'.\n", name)
+	}
+	return err
+}
+
+// backoff retries operations that depend on the database being reachable,
+// waiting between attempts with an exponentially increasing delay.
+type backoff struct {
+	config sophrosyne.ConnectRetryConfig
+	now    func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) error
+}
+
+func newBackoff(config sophrosyne.ConnectRetryConfig) *backoff {
+	return &backoff{
+		config: config,
+		now:    time.Now,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			t := time.NewTimer(d)
+			defer t.Stop()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-t.C:
+				return nil
+			}
+		},
+	}
+}
+
+// retry calls fn until it succeeds or the configured timeout would be exceeded
+// by waiting for another attempt. The last error returned by fn is returned.
+func retry[T any](ctx context.Context, logger *slog.Logger, b *backoff, name string, fn func() (T, error)) (T, error) {
+	deadline := b.now().Add(b.config.Timeout)
+	wait := b.config.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		v, err := fn()
+		if err == nil {
+			return v, nil
+		}
+		if b.now().Add(wait).After(deadline) {
+			return v, err
+		}
+		logger.WarnContext(ctx, "database operation failed, retrying", "operation", name, "attempt", attempt, "backoff", wait, "error", err)
+		if sleepErr := b.sleep(ctx, wait); sleepErr != nil {
+			return v, errors.Join(err, sleepErr)
+		}
+		wait = min(wait*2, b.config.MaxBackoff)
+	}
+}
+
+// logStartupDiagnostics logs a single line summarizing the version, listener,
+// enabled features and effective configuration, with secrets redacted. Nothing
+// is logged unless logging.startupDiagnostics is enabled.
+func logStartupDiagnostics(ctx context.Context, logger *slog.Logger, config *sophrosyne.Config, version string) {
+	if !config.Logging.StartupDiagnostics {
+		return
+	}
+	keySource := "generated"
+	if config.Security.TLS.KeyPath != "" {
+		keySource = "file"
+	}
+	logger.InfoContext(ctx, "startup diagnostics",
+		"version", version,
+		"listenAddress", fmt.Sprintf(":%d", config.Server.Port),
+		slog.Group("tls",
+			"keyType", config.Security.TLS.KeyType,
+			"key", keySource,
+		),
+		slog.Group("features",
+			"tracing", config.Tracing.Enabled,
+			"metrics", config.Metrics.Enabled,
+			"prometheus", config.Metrics.Prometheus.Enabled,
+			"autoMigrate", config.Database.AutoMigrate,
+			"retention", config.Services.Retention.Enabled,
+			"probeOnCreate", config.Services.Checks.ProbeOnCreate,
+			"hashEmails", config.Services.Users.HashEmails,
+			"staticRootToken", config.Development.StaticRootToken != "",
+		),
+		"config", config.Redacted(),
+	)
 }
 
 func run(c *cli.Context) error {
-	// Handle SIGINT (CTRL+C) gracefully.
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	// Handle SIGINT (CTRL+C) and SIGTERM gracefully.
+	ctx, stop := notifyShutdown(context.Background())
 	defer stop()
 
 	validate := validator.NewValidator()
-	config, err := getConfig(c.String("config"), nil, c.StringSlice("secretfiles"), validate)
+	cp, err := getConfigProvider(c.String("config"), nil, c.StringSlice("secretfiles"), c.String("secretsdir"), c.Bool("disable-config-watch"), validate)
 	if err != nil {
 		return err
 	}
+	config := cp.Get()
 
 	otelService, err := otel.NewOtelService()
 	if err != nil {
@@ -232,7 +786,22 @@ func run(c *cli.Context) error {
 
 	logger := slog.New(sophrosyne.NewLogHandler(config, otelService))
 
-	otelShutdown, err := otel.SetupOTelSDK(ctx, config)
+	logStartupDiagnostics(ctx, logger, config, c.App.Version)
+
+	recentErrors, err := diagnostics.NewRecentErrors(config.Logging.RecentErrors)
+	if err != nil {
+		return err
+	}
+
+	var authFailures sophrosyne.AuthFailureRecorder
+	if config.Logging.AuthFailures > 0 {
+		authFailures, err = diagnostics.NewAuthFailures(config.Logging.AuthFailures)
+		if err != nil {
+			return err
+		}
+	}
+
+	otelShutdown, metricsHandler, err := otel.SetupOTelSDK(ctx, config)
 	if err != nil {
 		return err
 	}
@@ -241,34 +810,30 @@ func run(c *cli.Context) error {
 		err = errors.Join(err, otelShutdown(ctx))
 	}()
 
-	migrationService, err := migrate.NewMigrationService(config)
+	dbBackoff := newBackoff(config.Database.ConnectRetry)
+	migrationService, err := retry(ctx, logger, dbBackoff, "migrate", func() (*migrate.MigrationService, error) {
+		return migrate.NewMigrationService(config)
+	})
 	if err != nil {
 		return err
 	}
-
-	logger.DebugContext(ctx, "Applying migrations")
-	err = migrationService.Up()
-	if err != nil {
-		if !errors.Is(err, migrate.ErrNoChange) {
-			panic(err)
-		} else {
-			logger.DebugContext(ctx, "No migrations to apply")
-		}
-	}
+	// Only connecting is retried, as pending or dirty migrations and failing
+	// statements are not resolved by trying again.
+	err = applyMigrations(ctx, logger, migrationService, config.Database.AutoMigrate)
 	sourceErr, dbError := migrationService.Close()
-	if sourceErr != nil {
-		return sourceErr
-	}
-	if dbError != nil {
-		return dbError
+	err = errors.Join(err, sourceErr, dbError)
+	if err != nil {
+		return err
 	}
 
-	checkServiceDatabase, err := pgx.NewCheckService(ctx, config, logger)
+	checkServiceDatabase, err := retry(ctx, logger, dbBackoff, "connect", func() (*pgx.CheckService, error) {
+		return pgx.NewCheckService(ctx, config, logger)
+	})
 	if err != nil {
 		return err
 	}
 
-	checkService := cache.NewCheckServiceCache(config, checkServiceDatabase, otelService)
+	checkService := cache.NewCheckServiceCache(config, checkServiceDatabase, otelService, otelService)
 
 	profileServiceDatabase, err := pgx.NewProfileService(ctx, config, logger, checkService)
 	if err != nil {
@@ -280,48 +845,81 @@ func run(c *cli.Context) error {
 		return err
 	}
 
-	userService := cache.NewUserServiceCache(config, userServiceDatabase, otelService)
+	userService := cache.NewUserServiceCache(config, userServiceDatabase, otelService, otelService)
+
+	profileService := cache.NewProfileServiceCache(config, profileServiceDatabase, otelService, otelService)
+
+	for name, observed := range map[string]otel.ObservableCache{
+		"checks":   checkService,
+		"users":    userService,
+		"profiles": profileService,
+	} {
+		err = otelService.RegisterCache(name, observed)
+		if err != nil {
+			return err
+		}
+	}
 
-	profileService := cache.NewProfileServiceCache(config, profileServiceDatabase, otelService)
+	authzProvider, err := cedar.NewAuthorizationProvider(ctx, config, logger, userService, otelService, profileService, checkService)
 
-	authzProvider, err := cedar.NewAuthorizationProvider(ctx, logger, userService, otelService, profileService, checkService)
+	rpcServer, err := rpc.NewRPCServer(config, logger, otelService, recentErrors)
+	if err != nil {
+		return err
+	}
 
-	rpcServer, err := rpc.NewRPCServer(logger)
+	rpcUserService, err := services.NewUserService(config, userService, authzProvider, logger, validate)
 	if err != nil {
 		return err
 	}
 
-	rpcUserService, err := services.NewUserService(userService, authzProvider, logger, validate)
+	rpcCheckService, err := services.NewCheckService(config, checkService, authzProvider, logger, validate)
 	if err != nil {
 		return err
 	}
 
-	rpcCheckService, err := services.NewCheckService(checkService, authzProvider, logger, validate)
+	rpcProfileService, err := services.NewProfileService(config, profileService, checkService, authzProvider, logger, validate)
 	if err != nil {
 		return err
 	}
 
-	rpcProfileService, err := services.NewProfileService(profileService, authzProvider, logger, validate)
+	rpcScanService, err := services.NewScanService(config, authzProvider, logger, validate, profileService, checkService, otelService, recentErrors)
 	if err != nil {
 		return err
 	}
 
-	rpcScanService, err := services.NewScanService(authzProvider, logger, validate, profileService, checkService)
+	rpcSystemService, err := services.NewSystemService(config, authzProvider, authzProvider, otelService, recentErrors, authFailures, logger, validate)
 	if err != nil {
 		return err
 	}
 
-	rpcServer.Register(rpcUserService.EntityID(), rpcUserService)
-	rpcServer.Register(rpcCheckService.EntityID(), rpcCheckService)
-	rpcServer.Register(rpcProfileService.EntityID(), rpcProfileService)
-	rpcServer.Register(rpcScanService.EntityID(), rpcScanService)
+	rpcServer.MustRegister(rpcUserService.EntityID(), rpcUserService)
+	rpcServer.MustRegister(rpcCheckService.EntityID(), rpcCheckService)
+	rpcServer.MustRegister(rpcProfileService.EntityID(), rpcProfileService)
+	rpcServer.MustRegister(rpcScanService.EntityID(), rpcScanService)
+	rpcServer.MustRegister(rpcSystemService.EntityID(), rpcSystemService)
 
-	tlsConfig, err := tls.NewTLSServerConfig(config, rand.Reader)
+	tlsConfig, certReloader, err := tls.NewReloadableTLSServerConfig(config, rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	// The level of the logger follows the configuration by itself, the
+	// certificate has to be read again.
+	reloadOnSignal(ctx, logger, func() error {
+		if err := cp.Reload(); err != nil {
+			return err
+		}
+		return certReloader.Reload()
+	})
 
 	healthcheckService, err := healthchecker.NewHealthcheckService(
-		[]sophrosyne.HealthChecker{
-			userService,
-			userServiceDatabase,
+		config,
+		map[string]sophrosyne.HealthChecker{
+			"cache":    userService,
+			"database": userServiceDatabase,
+		},
+		map[string]sophrosyne.HealthChecker{
+			"providers": services.NewProviderHealth(config, checkService, logger),
 		},
 	)
 
@@ -335,26 +933,73 @@ func run(c *cli.Context) error {
 		middleware.PanicCatcher(
 			logger,
 			otelService,
+			recentErrors,
 			middleware.SetupTracing(
 				otelService,
-				middleware.RequestLogging(
+				middleware.FeatureOverrides(
+					config,
 					logger,
-					middleware.Authentication(
-						nil,
-						config,
-						userService,
+					middleware.RequestLogging(
 						logger,
-						http.RPCHandler(logger, rpcServer, config),
+						middleware.Admission(
+							config,
+							logger,
+							middleware.Compression(
+								config,
+								logger,
+								middleware.Authentication(
+									nil,
+									config,
+									userService,
+									authFailures,
+									logger,
+									middleware.JSONContentType(
+										config,
+										logger,
+										http.RPCHandler(logger, rpcServer, config),
+									),
+								),
+							),
+						),
 					),
 				),
 			),
 		),
 	)
+	if config.Server.Schema.Enabled {
+		var exceptions []string
+		if !config.Server.Schema.Authenticated {
+			exceptions = []string{"/v1/rpc/schema"}
+		}
+		s.Handle(
+			"/v1/rpc/schema",
+			middleware.PanicCatcher(
+				logger,
+				otelService,
+				recentErrors,
+				middleware.SetupTracing(
+					otelService,
+					middleware.RequestLogging(
+						logger,
+						middleware.Authentication(
+							exceptions,
+							config,
+							userService,
+							authFailures,
+							logger,
+							http.SchemaHandler(logger, rpcServer.Schema()),
+						),
+					),
+				),
+			),
+		)
+	}
 	s.Handle(
 		"/healthz",
 		middleware.PanicCatcher(
 			logger,
 			otelService,
+			recentErrors,
 			middleware.SetupTracing(
 				otelService,
 				middleware.RequestLogging(
@@ -364,6 +1009,53 @@ func run(c *cli.Context) error {
 			),
 		),
 	)
+	s.Handle(
+		"/v1/health",
+		middleware.PanicCatcher(
+			logger,
+			otelService,
+			recentErrors,
+			middleware.SetupTracing(
+				otelService,
+				middleware.RequestLogging(
+					logger,
+					middleware.Authentication(
+						nil,
+						config,
+						userService,
+						authFailures,
+						logger,
+						http.AuthenticatedHealthcheckHandler(logger, healthcheckService),
+					),
+				),
+			),
+		),
+	)
+	if metricsHandler != nil {
+		s.Handle(
+			"/metrics",
+			middleware.PanicCatcher(
+				logger,
+				otelService,
+				recentErrors,
+				middleware.SetupTracing(
+					otelService,
+					middleware.RequestLogging(
+						logger,
+						metricsHandler,
+					),
+				),
+			),
+		)
+	}
+
+	if config.Services.Retention.Enabled {
+		pruner, err := pgx.NewPruner(ctx, config, logger)
+		if err != nil {
+			return err
+		}
+		go pruner.Run(ctx)
+	}
 
 	srvErr := make(chan error, 1)
 	go func() {
@@ -377,7 +1069,7 @@ func run(c *cli.Context) error {
 
 		return err
 	case <-ctx.Done():
-		// Wait for first CTRL+C.
+		// Wait for first CTRL+C or SIGTERM.
 		// Stop receiving signal notifications as soon as possible.
 		stop()
 	}
@@ -0,0 +1,663 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/madsrc/sophrosyne"
+	"github.com/madsrc/sophrosyne/internal/migrate"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+)
+
+type fakeMigrator struct {
+	upErr      error
+	upCalls    int
+	pending    bool
+	pendingErr error
+}
+
+func (f *fakeMigrator) Up() error {
+	f.upCalls++
+	return f.upErr
+}
+
+func (f *fakeMigrator) Pending() (bool, error) {
+	return f.pending, f.pendingErr
+}
+
+func TestApplyMigrations(t *testing.T) {
+	errSomething := errors.New("something went wrong")
+	cases := []struct {
+		name        string
+		migrator    *fakeMigrator
+		autoMigrate bool
+		wantErr     error
+		wantUpCalls int
+	}{
+		{
+			name:        "auto migrate applies migrations",
+			migrator:    &fakeMigrator{},
+			autoMigrate: true,
+			wantUpCalls: 1,
+		},
+		{
+			name:        "auto migrate with no change",
+			migrator:    &fakeMigrator{upErr: migrate.ErrNoChange},
+			autoMigrate: true,
+			wantUpCalls: 1,
+		},
+		{
+			name:        "auto migrate returns error",
+			migrator:    &fakeMigrator{upErr: errSomething},
+			autoMigrate: true,
+			wantErr:     errSomething,
+			wantUpCalls: 1,
+		},
+		{
+			name:     "pending migrations refuse to start",
+			migrator: &fakeMigrator{pending: true},
+			wantErr:  errMigrationsPending,
+		},
+		{
+			name:     "up to date starts",
+			migrator: &fakeMigrator{pending: false},
+		},
+		{
+			name:     "pending check returns error",
+			migrator: &fakeMigrator{pendingErr: errSomething},
+			wantErr:  errSomething,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			err := applyMigrations(context.Background(), logger, tc.migrator, tc.autoMigrate)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.wantUpCalls, tc.migrator.upCalls)
+		})
+	}
+}
+
+// fakeBackoff returns a backoff whose clock only advances when sleeping, and
+// records every wait.
+func fakeBackoff(config sophrosyne.ConnectRetryConfig) (*backoff, *[]time.Duration) {
+	var waits []time.Duration
+	now := time.Unix(0, 0)
+	return &backoff{
+		config: config,
+		now:    func() time.Time { return now },
+		sleep: func(ctx context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			now = now.Add(d)
+			return ctx.Err()
+		},
+	}, &waits
+}
+
+func TestRetry(t *testing.T) {
+	errUnavailable := errors.New("database unavailable")
+	config := sophrosyne.ConnectRetryConfig{
+		Timeout:        10 * time.Second,
+		InitialBackoff: time.Second,
+		MaxBackoff:     3 * time.Second,
+	}
+	// failing returns a function that fails the first n calls.
+	failing := func(n int, calls *int) func() (string, error) {
+		return func() (string, error) {
+			*calls++
+			if *calls <= n {
+				return "", errUnavailable
+			}
+			return "connected", nil
+		}
+	}
+	cases := []struct {
+		name      string
+		config    sophrosyne.ConnectRetryConfig
+		failures  int
+		wantErr   error
+		wantCalls int
+		wantWaits []time.Duration
+	}{
+		{
+			name:      "succeeds on first attempt",
+			config:    config,
+			wantCalls: 1,
+		},
+		{
+			name:      "eventually succeeds",
+			config:    config,
+			failures:  3,
+			wantCalls: 4,
+			wantWaits: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+		},
+		{
+			name:      "gives up at timeout",
+			config:    config,
+			failures:  100,
+			wantErr:   errUnavailable,
+			wantCalls: 5,
+			wantWaits: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name: "zero timeout disables retries",
+			config: sophrosyne.ConnectRetryConfig{
+				InitialBackoff: time.Second,
+				MaxBackoff:     time.Second,
+			},
+			failures:  1,
+			wantErr:   errUnavailable,
+			wantCalls: 1,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			b, waits := fakeBackoff(tc.config)
+			calls := 0
+
+			v, err := retry(context.Background(), logger, b, "test", failing(tc.failures, &calls))
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "connected", v)
+			}
+			require.Equal(t, tc.wantCalls, calls)
+			require.Equal(t, tc.wantWaits, *waits)
+		})
+	}
+
+	t.Run("stops when context is cancelled", func(t *testing.T) {
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		b, _ := fakeBackoff(config)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		calls := 0
+
+		_, err := retry(ctx, logger, b, "test", failing(100, &calls))
+		require.ErrorIs(t, err, errUnavailable)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, 1, calls)
+	})
+}
+
+func TestLogStartupDiagnostics(t *testing.T) {
+	config := &sophrosyne.Config{}
+	config.Logging.StartupDiagnostics = true
+	config.Server.Port = 8443
+	config.Security.TLS.KeyType = "EC-P384"
+	config.Security.SiteKey = []byte("a very secret site key")
+	config.Security.Salt = []byte("a very secret salt")
+	config.Database.User = "sophrosyne"
+	config.Database.Password = "hunter2"
+	config.Development.StaticRootToken = "root-token"
+	config.Metrics.Prometheus.Enabled = true
+
+	var buf bytes.Buffer
+	logStartupDiagnostics(context.Background(), slog.New(slog.NewJSONHandler(&buf, nil)), config, "1.2.3")
+
+	out := buf.String()
+	require.NotContains(t, out, "hunter2")
+	require.NotContains(t, out, "root-token")
+	require.NotContains(t, out, base64.StdEncoding.EncodeToString(config.Security.SiteKey))
+	require.NotContains(t, out, base64.StdEncoding.EncodeToString(config.Security.Salt))
+
+	var entry struct {
+		Msg           string `json:"msg"`
+		Version       string `json:"version"`
+		ListenAddress string `json:"listenAddress"`
+		TLS           struct {
+			KeyType string `json:"keyType"`
+			Key     string `json:"key"`
+		} `json:"tls"`
+		Features map[string]bool   `json:"features"`
+		Config   sophrosyne.Config `json:"config"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, "startup diagnostics", entry.Msg)
+	require.Equal(t, "1.2.3", entry.Version)
+	require.Equal(t, ":8443", entry.ListenAddress)
+	require.Equal(t, "EC-P384", entry.TLS.KeyType)
+	require.Equal(t, "generated", entry.TLS.Key)
+	require.True(t, entry.Features["prometheus"])
+	require.True(t, entry.Features["staticRootToken"])
+	require.False(t, entry.Features["tracing"])
+	require.Equal(t, "sophrosyne", entry.Config.Database.User)
+	require.Equal(t, sophrosyne.RedactedValue, entry.Config.Database.Password)
+	require.Equal(t, sophrosyne.RedactedValue, entry.Config.Development.StaticRootToken)
+	require.Equal(t, []byte(sophrosyne.RedactedValue), entry.Config.Security.SiteKey)
+	require.Equal(t, []byte(sophrosyne.RedactedValue), entry.Config.Security.Salt)
+	require.Equal(t, "hunter2", config.Database.Password, "the configuration in use is left untouched")
+}
+
+func TestLogStartupDiagnostics_Disabled(t *testing.T) {
+	var buf bytes.Buffer
+	logStartupDiagnostics(context.Background(), slog.New(slog.NewJSONHandler(&buf, nil)), &sophrosyne.Config{}, "1.2.3")
+	require.Empty(t, buf.String())
+}
+
+func TestCreateUser(t *testing.T) {
+	req := sophrosyne.CreateUserRequest{Name: "ops", Email: "ops@example.com", IsAdmin: true}
+	errSomething := errors.New("something went wrong")
+	cases := []struct {
+		name       string
+		migrator   *fakeMigrator
+		setup      func(us *sophrosyne2.MockUserService)
+		wantErr    error
+		wantOutput string
+	}{
+		{
+			name:     "created",
+			migrator: &fakeMigrator{},
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().CreateUser(context.Background(), req).Return(sophrosyne.User{Name: "ops", IsAdmin: true, Token: []byte("token")}, nil).Once()
+			},
+			wantOutput: "Created user 'ops' (admin: true).\n\nToken, shown only this once:\n\ndG9rZW4=\n",
+		},
+		{
+			name:     "pending migrations",
+			migrator: &fakeMigrator{pending: true},
+			wantErr:  errUserMigrationsPending,
+		},
+		{
+			name:     "unable to check migrations",
+			migrator: &fakeMigrator{pendingErr: errSomething},
+			wantErr:  errSomething,
+		},
+		{
+			name:     "email in use",
+			migrator: &fakeMigrator{},
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().CreateUser(context.Background(), req).Return(sophrosyne.User{}, fmt.Errorf("%w: %w", sophrosyne.ErrEmailInUse, errSomething)).Once()
+			},
+			wantErr: sophrosyne.ErrEmailInUse,
+		},
+		{
+			name:     "unable to create",
+			migrator: &fakeMigrator{},
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().CreateUser(context.Background(), req).Return(sophrosyne.User{}, errSomething).Once()
+			},
+			wantErr: errSomething,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &sophrosyne.Config{}
+			userService := sophrosyne2.NewMockUserService(t)
+			connected := false
+			if tc.setup != nil {
+				tc.setup(userService)
+			}
+
+			var out bytes.Buffer
+			err := createUser(context.Background(), &out, config, tc.migrator, func() (sophrosyne.UserService, error) {
+				connected = true
+				return userService, nil
+			}, req)
+
+			if tc.wantOutput != "" {
+				require.NoError(t, err)
+				require.Equal(t, tc.wantOutput, out.String())
+				return
+			}
+			require.Error(t, err)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+			}
+			require.Empty(t, out.String())
+			require.Equal(t, tc.setup != nil, connected, "connected to the database")
+		})
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	first := sophrosyne.User{Name: "first", Email: "first@example.com", IsAdmin: true}
+	second := sophrosyne.User{Name: "second", Email: "second@example.com"}
+	cases := []struct {
+		name   string
+		asJSON bool
+		want   string
+	}{
+		{
+			name: "text",
+			want: "first\tfirst@example.com\tadmin: true\nsecond\tsecond@example.com\tadmin: false\n",
+		},
+		{
+			name:   "json",
+			asJSON: true,
+			want:   `[{"name":"first","email":"first@example.com","is_admin":true,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"},{"name":"second","email":"second@example.com","is_admin":false,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}]` + "\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			userService := sophrosyne2.NewMockUserService(t)
+			userService.EXPECT().GetUsers(context.Background(), mock.Anything).RunAndReturn(func(ctx context.Context, cursor *sophrosyne.DatabaseCursor) ([]sophrosyne.User, error) {
+				if cursor.Position == "" {
+					cursor.Advance("1")
+					return []sophrosyne.User{first}, nil
+				}
+				cursor.Reset()
+				return []sophrosyne.User{second}, nil
+			}).Twice()
+
+			var out bytes.Buffer
+			err := listUsers(context.Background(), &out, &fakeMigrator{}, func() (sophrosyne.UserService, error) {
+				return userService, nil
+			}, tc.asJSON)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, out.String())
+		})
+	}
+}
+
+func TestListUsers_PendingMigrations(t *testing.T) {
+	err := listUsers(context.Background(), io.Discard, &fakeMigrator{pending: true}, func() (sophrosyne.UserService, error) {
+		t.Fatal("connected to the database with pending migrations")
+		return nil, nil
+	}, false)
+	require.ErrorIs(t, err, errUserMigrationsPending)
+}
+
+func TestRevokeUser(t *testing.T) {
+	cases := []struct {
+		name   string
+		del    bool
+		asJSON bool
+		setup  func(us *sophrosyne2.MockUserService)
+		want   string
+	}{
+		{
+			name: "rotate",
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().RotateToken(context.Background(), "ops").Return([]byte("new token"), nil).Once()
+			},
+			want: "Revoked the token of user 'ops'.\n",
+		},
+		{
+			name:   "rotate as json",
+			asJSON: true,
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().RotateToken(context.Background(), "ops").Return([]byte("new token"), nil).Once()
+			},
+			want: `{"name":"ops","action":"rotated"}` + "\n",
+		},
+		{
+			name: "delete",
+			del:  true,
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().DeleteUser(context.Background(), "ops").Return(nil).Once()
+			},
+			want: "Deleted user 'ops'.\n",
+		},
+		{
+			name:   "delete as json",
+			del:    true,
+			asJSON: true,
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().DeleteUser(context.Background(), "ops").Return(nil).Once()
+			},
+			want: `{"name":"ops","action":"deleted"}` + "\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			userService := sophrosyne2.NewMockUserService(t)
+			tc.setup(userService)
+
+			var out bytes.Buffer
+			err := revokeUser(context.Background(), &out, &fakeMigrator{}, func() (sophrosyne.UserService, error) {
+				return userService, nil
+			}, "ops", tc.del, tc.asJSON)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, out.String())
+			require.NotContains(t, out.String(), "new token")
+		})
+	}
+}
+
+func TestRevokeUser_NotFound(t *testing.T) {
+	userService := sophrosyne2.NewMockUserService(t)
+	userService.EXPECT().RotateToken(context.Background(), "missing").Return(nil, sophrosyne.ErrNotFound).Once()
+
+	var out bytes.Buffer
+	err := revokeUser(context.Background(), &out, &fakeMigrator{}, func() (sophrosyne.UserService, error) {
+		return userService, nil
+	}, "missing", false, false)
+	require.ErrorIs(t, err, sophrosyne.ErrNotFound)
+	require.Empty(t, out.String())
+}
+
+func TestPrintVersionJSON(t *testing.T) {
+	cases := []struct {
+		name      string
+		buildInfo *debug.BuildInfo
+		want      string
+	}{
+		{
+			name: "with vcs information",
+			buildInfo: &debug.BuildInfo{
+				GoVersion: "go1.22.7",
+				Settings: []debug.BuildSetting{
+					{Key: "vcs", Value: "git"},
+					{Key: "vcs.revision", Value: "a323e62"},
+					{Key: "vcs.time", Value: "2024-10-16T09:00:00Z"},
+				},
+			},
+			want: `{"version":"1.2.3","commit":"a323e62","date":"2024-10-16T09:00:00Z","go":"go1.22.7"}`,
+		},
+		{
+			name:      "without vcs information",
+			buildInfo: &debug.BuildInfo{GoVersion: "go1.22.7"},
+			want:      `{"version":"1.2.3","commit":"","date":"","go":"go1.22.7"}`,
+		},
+		{
+			name: "without build information",
+			want: `{"version":"1.2.3","commit":"","date":"","go":"` + runtime.Version() + `"}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := printVersionJSON(&out, newVersionInfo("1.2.3", tc.buildInfo))
+			require.NoError(t, err)
+			require.JSONEq(t, tc.want, out.String())
+		})
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	config := &sophrosyne.Config{}
+	config.Database.User = "sophrosyne"
+	config.Database.Password = "hunter2"
+	config.Security.SiteKey = []byte("a very secret site key")
+	config.Security.Salt = []byte("a very secret salt")
+
+	t.Run("secrets are redacted", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, printConfig(&buf, config, false))
+
+		var out struct {
+			Database struct {
+				User     string `yaml:"user"`
+				Password string `yaml:"password"`
+			} `yaml:"database"`
+			Security struct {
+				SiteKey string `yaml:"sitekey"`
+				Salt    string `yaml:"salt"`
+			} `yaml:"security"`
+		}
+		require.NoError(t, yaml.Unmarshal(buf.Bytes(), &out))
+		require.Equal(t, "sophrosyne", out.Database.User)
+		require.Equal(t, sophrosyne.RedactedValue, out.Database.Password)
+		require.Equal(t, sophrosyne.RedactedValue, out.Security.SiteKey)
+		require.Equal(t, sophrosyne.RedactedValue, out.Security.Salt)
+		require.NotContains(t, buf.String(), "hunter2")
+	})
+
+	t.Run("secrets are shown on request", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, printConfig(&buf, config, true))
+
+		var out sophrosyne.Config
+		require.NoError(t, yaml.Unmarshal(buf.Bytes(), &out))
+		require.Equal(t, "hunter2", out.Database.Password)
+		require.Equal(t, config.Security.SiteKey, out.Security.SiteKey)
+		require.NotContains(t, buf.String(), sophrosyne.RedactedValue)
+	})
+}
+
+type fakeDownMigrator struct {
+	downErr    error
+	steps      int
+	version    uint
+	dirty      bool
+	versionErr error
+}
+
+func (f *fakeDownMigrator) Down(steps int) error {
+	f.steps = steps
+	return f.downErr
+}
+
+func (f *fakeDownMigrator) Versions() (uint, bool, error) {
+	return f.version, f.dirty, f.versionErr
+}
+
+func TestMigrateDown(t *testing.T) {
+	errSomething := errors.New("something went wrong")
+	cases := []struct {
+		name       string
+		migrator   *fakeDownMigrator
+		wantErr    error
+		wantOutput string
+	}{
+		{
+			name:       "rolled back",
+			migrator:   &fakeDownMigrator{version: 7},
+			wantOutput: "Migrations rolled back. Database at version '7'\n",
+		},
+		{
+			name:       "rolled back to dirty",
+			migrator:   &fakeDownMigrator{version: 7, dirty: true},
+			wantOutput: "Migrations rolled back. Database at version '7' (dirty)\n",
+		},
+		{
+			name:       "rolled back everything",
+			migrator:   &fakeDownMigrator{versionErr: migrate.ErrNilVersion},
+			wantOutput: "Migrations rolled back. No migrations remain applied\n",
+		},
+		{
+			name:       "nothing to roll back",
+			migrator:   &fakeDownMigrator{downErr: migrate.ErrNoChange},
+			wantOutput: "No migrations to roll back\n",
+		},
+		{
+			name:     "down fails",
+			migrator: &fakeDownMigrator{downErr: errSomething},
+			wantErr:  errSomething,
+		},
+		{
+			name:     "version fails",
+			migrator: &fakeDownMigrator{versionErr: errSomething},
+			wantErr:  errSomething,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := migrateDown(&buf, tc.migrator, 2)
+			require.ErrorIs(t, err, tc.wantErr)
+			require.Equal(t, tc.wantOutput, buf.String())
+			require.Equal(t, 2, tc.migrator.steps)
+		})
+	}
+}
+
+func sendSignal(t *testing.T, sig os.Signal) {
+	t.Helper()
+	p, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, p.Signal(sig))
+}
+
+func TestNotifyShutdown(t *testing.T) {
+	for _, sig := range []os.Signal{os.Interrupt, syscall.SIGTERM} {
+		t.Run(sig.String(), func(t *testing.T) {
+			ctx, stop := notifyShutdown(context.Background())
+			defer stop()
+
+			sendSignal(t, sig)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s did not trigger a shutdown", sig)
+			}
+		})
+	}
+}
+
+func TestReloadOnSignal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var reloads atomic.Int32
+	reloaded := make(chan struct{}, 2)
+	reloadOnSignal(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), func() error {
+		defer func() { reloaded <- struct{}{} }()
+		if reloads.Add(1) == 1 {
+			return errors.New("invalid configuration")
+		}
+		return nil
+	})
+
+	// An error reloading must not stop later reloads.
+	for i := 1; i <= 2; i++ {
+		sendSignal(t, syscall.SIGHUP)
+		select {
+		case <-reloaded:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("SIGHUP %d did not trigger a reload", i)
+		}
+	}
+	require.Equal(t, int32(2), reloads.Load())
+}
@@ -16,7 +16,13 @@
 
 package sophrosyne
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
 
 // The ConfigProvider interface is used to retrieve the configuration of the
 // application.
@@ -58,32 +64,95 @@ var DefaultConfig = map[string]interface{}{
 	"database.host":                           "localhost",
 	"database.port":                           5432,
 	"database.name":                           "postgres",
+	"database.autoMigrate":                    true,
+	"database.connectRetry.timeout":           0,
+	"database.connectRetry.initialBackoff":    500 * time.Millisecond,
+	"database.connectRetry.maxBackoff":        10 * time.Second,
+	"database.readRetry.maxAttempts":          3,
+	"database.readRetry.initialBackoff":       50 * time.Millisecond,
+	"database.readRetry.maxBackoff":           500 * time.Millisecond,
 	"server.port":                             8080,
 	"logging.level":                           LogLevelInfo,
 	"logging.format":                          LogFormatJSON,
 	"logging.enabled":                         true,
+	"logging.stackTraces":                     false,
+	"logging.recentErrors":                    100,
+	"logging.authFailures":                    0,
+	"logging.startupDiagnostics":              true,
+	"logging.authzAudit":                      true,
 	"tracing.enabled":                         true,
 	"tracing.batch.timeout":                   5,
 	"tracing.output":                          OtelOutputStdout,
+	"tracing.dropOnBackpressure":              false,
 	"metrics.enabled":                         false,
 	"metrics.interval":                        60,
 	"metrics.output":                          OtelOutputStdout,
+	"metrics.prometheus.enabled":              false,
 	"principals.root.name":                    "root",
 	"principals.root.email":                   "root@localhost",
 	"principals.root.recreate":                false,
 	"services.users.pageSize":                 2,
+	"services.users.missingProfile":           MissingProfileModeFail,
+	"services.users.requireEmail":             false,
+	"services.users.hashEmails":               false,
+	"services.users.uniqueEmail":              true,
+	"services.users.maxBatchSize":             100,
+	"services.users.tokenDelivery":            TokenDeliveryResponse,
+	"services.users.tokenWebhookTimeout":      10 * time.Second,
+	"services.users.normalizeNames":           NameNormalizationNone,
+	"services.cache.serveStaleOnError":        false,
+	"services.cache.maxStale":                 5 * time.Minute,
 	"services.users.cache.TTL":                1 * time.Second,
 	"services.users.cache.cleanupInterval":    500 * time.Millisecond,
 	"security.tls.keyType":                    "EC-P384",
 	"security.tls.insecureSkipVerify":         false,
+	"security.tls.alpn":                       []string{"h2", "http/1.1"},
+	"security.tokens.ttl":                     0,
+	"security.tokens.rotationGrace":           0,
 	"services.profiles.pageSize":              2,
+	"services.profiles.normalizeNames":        NameNormalizationNone,
 	"services.profiles.cache.TTL":             1 * time.Second,
 	"services.profiles.cache.cleanupInterval": 500 * time.Millisecond,
 	"services.checks.pageSize":                2,
 	"services.checks.cache.TTL":               1 * time.Second,
 	"services.checks.cache.cleanupInterval":   500 * time.Millisecond,
+	"services.checks.probeOnCreate":           false,
+	"services.checks.probeTimeout":            2 * time.Second,
+	"services.checks.allowForceResult":        false,
+	"services.checks.defaultTimeout":          10 * time.Second,
+	"services.checks.normalizeNames":          NameNormalizationNone,
+	"services.scans.defaultAggregation":       ScanAggregationAllMustPass,
+	"services.scans.retry.maxAttempts":        3,
+	"services.scans.retry.baseDelay":          100 * time.Millisecond,
+	"services.scans.retry.maxDelay":           2 * time.Second,
+	"services.scans.maxConcurrency":           4,
+	"services.scans.requireProfile":           false,
+	"services.scans.cancelOnVerdict":          false,
+	"services.scans.imageFetch.maxSize":       10 * megabyte,
+	"services.scans.imageFetch.schemes":       []string{"https"},
+	"services.scans.imageFetch.timeout":       10 * time.Second,
+	"services.retention.enabled":              false,
+	"services.retention.deletedTTL":           30 * 24 * time.Hour,
+	"services.retention.interval":             1 * time.Hour,
+	"services.retention.batchSize":            1000,
+	"services.retention.entities":             []string{"users", "profiles", "checks"},
 	"server.maxBodySize":                      20 * megabyte,
 	"server.advertisedHost":                   "localhost",
+	"server.cursorMode":                       CursorModePlain,
+	"server.idFormat":                         IDFormatXID,
+	"server.maxConnections":                   0,
+	"server.maxRPCIDLength":                   256,
+	"server.maxBatchLength":                   100,
+	"server.batchConcurrency":                 4,
+	"server.strictContentType":                true,
+	"server.compression":                      []string{"zstd", "gzip"},
+	"server.shutdownTimeout":                  30 * time.Second,
+	"server.admissionQueue.maxConcurrent":     0,
+	"server.admissionQueue.maxDepth":          100,
+	"server.admissionQueue.maxWait":           1 * time.Second,
+	"server.schema.enabled":                   true,
+	"server.schema.authenticated":             true,
+	"health.detail":                           HealthDetailMinimal,
 }
 
 const megabyte int64 = 1048576
@@ -99,50 +168,109 @@ const megabyte int64 = 1048576
 type Config struct {
 	Principals struct {
 		Root struct {
-			Name     string `key:"name" validate:"required"`
-			Email    string `key:"email" validate:"required"`
-			Recreate bool   `key:"recreate"`
+			Name            string `key:"name" validate:"required"`
+			Email           string `key:"email" validate:"required"`
+			Recreate        bool   `key:"recreate"`
+			TokenOutputFile string `key:"tokenOutputFile"`
 		} `key:"root" validate:"required"`
 	} `key:"principals" validate:"required"`
 	Database struct {
-		User     string `key:"user" validate:"required"`
-		Password string `key:"password" validate:"required"`
-		Host     string `key:"host" validate:"required"`
-		Port     int    `key:"port" validate:"required,min=1,max=65535"`
-		Name     string `key:"name" validate:"required"`
+		User         string             `key:"user" validate:"required"`
+		Password     string             `key:"password" validate:"required"`
+		Host         string             `key:"host" validate:"required"`
+		Port         int                `key:"port" validate:"required,min=1,max=65535"`
+		Name         string             `key:"name" validate:"required"`
+		AutoMigrate  bool               `key:"autoMigrate"`
+		ConnectRetry ConnectRetryConfig `key:"connectRetry"`
+		ReadRetry    ReadRetryConfig    `key:"readRetry"` // of reads failing with ErrConnection; writes are never retried
 	} `key:"database"`
 	Server  ServerConfig `key:"server"`
 	Logging struct {
-		Enabled bool      `key:"enabled"`
-		Level   LogLevel  `key:"level" validate:"required,oneof=debug info"`
-		Format  LogFormat `key:"format" validate:"required,oneof=text json"`
+		Enabled            bool      `key:"enabled"`
+		Level              LogLevel  `key:"level" validate:"required,oneof=debug info"`
+		Format             LogFormat `key:"format" validate:"required,oneof=text json"`
+		StackTraces        bool      `key:"stackTraces"`                            // only honoured at the debug level
+		RecentErrors       int       `key:"recentErrors" validate:"required,min=1"` // kept for System::GetRecentErrors
+		AuthFailures       int       `key:"authFailures" validate:"min=0"`          // kept for System::GetAuthFailures; 0 disables recording
+		StartupDiagnostics bool      `key:"startupDiagnostics"`                     // log a summary of the configuration at startup
+		AuthzAudit         bool      `key:"authzAudit"`                             // log every authorization decision
 	} `key:"logging"`
 	Tracing struct {
 		Enabled bool `key:"enabled"`
 		Batch   struct {
 			Timeout int `key:"timeout"`
 		} `key:"batch"`
-		Output OtelOutput `key:"output" validate:"required,oneof=stdout http"`
+		Output             OtelOutput `key:"output" validate:"required,oneof=stdout http"`
+		DropOnBackpressure bool       `key:"dropOnBackpressure"` // drop new spans while the export queue is full
 	} `key:"tracing"`
 	Metrics struct {
-		Enabled  bool       `key:"enabled"`
-		Interval int        `key:"interval"`
-		Output   OtelOutput `key:"output" validate:"required,oneof=stdout http"`
+		Enabled    bool       `key:"enabled"`
+		Interval   int        `key:"interval"`
+		Output     OtelOutput `key:"output" validate:"required,oneof=stdout http"`
+		Prometheus struct {
+			Enabled bool `key:"enabled"` // serves /metrics, independent of metrics.enabled
+		} `key:"prometheus"`
 	} `key:"metrics"`
 	Security SecurityConfig `key:"security" validate:"required"`
+	Health   struct {
+		Detail HealthDetail `key:"detail" validate:"required,oneof=minimal detailed"` // of the authenticated healthcheck; /healthz is always minimal
+	} `key:"health"`
 	Services struct {
+		Cache struct {
+			ServeStaleOnError bool          `key:"serveStaleOnError"`                                            // serve expired entries when a read fails with a connection error
+			MaxStale          time.Duration `key:"maxStale" validate:"required_if=ServeStaleOnError true,min=0"` // how long after expiry an entry may still be served
+		} `key:"cache"`
 		Users struct {
-			PageSize int         `key:"pageSize" validate:"required,min=2"`
-			Cache    CacheConfig `key:"cache" validate:"required"`
+			PageSize            int                `key:"pageSize" validate:"required,min=2"`
+			Cache               CacheConfig        `key:"cache" validate:"required"`
+			MissingProfile      MissingProfileMode `key:"missingProfile" validate:"required,oneof=fail default none"`
+			RequireEmail        bool               `key:"requireEmail"`
+			HashEmails          bool               `key:"hashEmails"`                             // irreversible, see ProtectEmail; only before users have emails
+			UniqueEmail         bool               `key:"uniqueEmail"`                            // checked when users are written
+			MaxBatchSize        int                `key:"maxBatchSize" validate:"required,min=1"` // names per DeleteUsers call
+			TokenDelivery       TokenDeliveryMode  `key:"tokenDelivery" validate:"required,oneof=response file webhook"`
+			TokenDirectory      string             `key:"tokenDirectory" validate:"required_if=TokenDelivery file"`
+			TokenWebhook        string             `key:"tokenWebhook" validate:"required_if=TokenDelivery webhook,omitempty,url"`
+			TokenWebhookTimeout time.Duration      `key:"tokenWebhookTimeout" validate:"required,min=1"`
+			NormalizeNames      NameNormalization  `key:"normalizeNames" validate:"required,oneof=none trim lowercase"`
 		} `key:"users" validate:"required"`
 		Profiles struct {
-			PageSize int         `key:"pageSize" validate:"required,min=2"`
-			Cache    CacheConfig `key:"cache" validate:"required"`
+			PageSize       int               `key:"pageSize" validate:"required,min=2"`
+			Cache          CacheConfig       `key:"cache" validate:"required"`
+			NormalizeNames NameNormalization `key:"normalizeNames" validate:"required,oneof=none trim lowercase"`
 		} `key:"profiles" validate:"required"`
 		Checks struct {
-			PageSize int         `key:"pageSize" validate:"required,min=2"`
-			Cache    CacheConfig `key:"cache" validate:"required"`
+			PageSize         int               `key:"pageSize" validate:"required,min=2"`
+			Cache            CacheConfig       `key:"cache" validate:"required"`
+			ProbeOnCreate    bool              `key:"probeOnCreate"` // probe upstream services on CreateCheck/UpdateCheck
+			ProbeTimeout     time.Duration     `key:"probeTimeout" validate:"required,min=1"`
+			AllowForceResult bool              `key:"allowForceResult"`                // honour ForceResult on checks; not for production
+			DefaultTimeout   time.Duration     `key:"defaultTimeout" validate:"min=0"` // for checks without their own; 0 means none
+			NormalizeNames   NameNormalization `key:"normalizeNames" validate:"required,oneof=none trim lowercase"`
 		} `key:"checks" validate:"required"`
+		Scans struct {
+			DefaultAggregation ScanAggregation `key:"defaultAggregation" validate:"required,oneof=allMustPass anyMustPass"` // for profiles without their own strategy
+			Retry              struct {
+				MaxAttempts int           `key:"maxAttempts" validate:"required,min=1"` // 1 disables retries
+				BaseDelay   time.Duration `key:"baseDelay" validate:"required,min=1"`
+				MaxDelay    time.Duration `key:"maxDelay" validate:"required,min=1"`
+			} `key:"retry"` // for check provider calls failing with Unavailable or DeadlineExceeded
+			MaxConcurrency  int  `key:"maxConcurrency" validate:"required,min=1"` // checks of a scan run at the same time
+			RequireProfile  bool `key:"requireProfile"`                           // reject scans not naming a profile
+			CancelOnVerdict bool `key:"cancelOnVerdict"`                          // cancel pending checks once the result of a scan is determined
+			ImageFetch      struct {
+				MaxSize int64         `key:"maxSize" validate:"required,min=1"` // in bytes
+				Schemes []string      `key:"schemes" validate:"dive,oneof=http https"`
+				Timeout time.Duration `key:"timeout" validate:"required,min=1"`
+			} `key:"imageFetch"` // of images passed to PerformScan as image_url
+		} `key:"scans" validate:"required"`
+		Retention struct {
+			Enabled    bool          `key:"enabled"`
+			DeletedTTL time.Duration `key:"deletedTTL" validate:"required,min=1"`
+			Interval   time.Duration `key:"interval" validate:"required,min=1"`
+			BatchSize  int           `key:"batchSize" validate:"required,min=1"` // per entity type and run
+			Entities   []string      `key:"entities" validate:"dive,oneof=users profiles checks"`
+		} `key:"retention"`
 	} `key:"services" validate:"required"`
 	Development struct {
 		StaticRootToken string `key:"staticRootToken"`
@@ -154,23 +282,169 @@ type CacheConfig struct {
 	CleanupInterval time.Duration `key:"cleanupInterval" validate:"required,min=1"`
 }
 
+type ConnectRetryConfig struct {
+	Timeout        time.Duration `key:"timeout" validate:"min=0"` // 0 disables retries
+	InitialBackoff time.Duration `key:"initialBackoff" validate:"required,min=1"`
+	MaxBackoff     time.Duration `key:"maxBackoff" validate:"required,min=1"`
+}
+
+type ReadRetryConfig struct {
+	MaxAttempts    int           `key:"maxAttempts" validate:"required,min=1,max=10"` // 1 disables retries
+	InitialBackoff time.Duration `key:"initialBackoff" validate:"required,min=1"`
+	MaxBackoff     time.Duration `key:"maxBackoff" validate:"required,min=1"`
+}
+
 type TLSConfig struct {
-	KeyType            string `key:"keyType" validate:"required,oneof=RSA-4096 EC-P224 EC-P256 EC-P384 EC-P521 ED25519"`
-	CertificatePath    string `key:"certificatePath"`
-	KeyPath            string `key:"keyPath"`
-	InsecureSkipVerify bool   `key:"insecureSkipVerify"`
+	KeyType            string        `key:"keyType" validate:"required,oneof=RSA-4096 EC-P224 EC-P256 EC-P384 EC-P521 ED25519"`
+	CertificatePath    string        `key:"certificatePath"`
+	KeyPath            string        `key:"keyPath"`
+	ClientCertPath     string        `key:"clientCertPath" validate:"required_with=ClientKeyPath"` // presented to servers requiring mTLS
+	ClientKeyPath      string        `key:"clientKeyPath" validate:"required_with=ClientCertPath"`
+	ClientCAPath       string        `key:"clientCAPath" validate:"required_if=RequireClientCert true"` // CA bundle used to verify client certificates
+	RequireClientCert  bool          `key:"requireClientCert"`
+	InsecureSkipVerify bool          `key:"insecureSkipVerify"`
+	CertValidity       time.Duration `key:"certValidity" validate:"min=0"` // lifetime of generated certificates; 0 means one year
+	URISANs            []string      `key:"uriSANs" validate:"dive,uri"`   // added to generated certificates
+	ALPN               []string      `key:"alpn" validate:"dive,required,max=255"`
 }
 
 type SecurityConfig struct {
-	SiteKey []byte    `key:"siteKey" validate:"required,min=64,max=64"`
-	Salt    []byte    `key:"salt" validate:"required,min=32,max=32"`
-	TLS     TLSConfig `key:"tls" validate:"required"`
+	SiteKey      []byte            `key:"siteKey" validate:"required,min=64,max=64"`
+	Salt         []byte            `key:"salt" validate:"required,min=32,max=32"`
+	TLS          TLSConfig         `key:"tls" validate:"required"`
+	AuthzActions map[string]string `key:"authzActions" validate:"dive,keys,required,endkeys,required"`
+	Tokens       struct {
+		TTL           time.Duration `key:"ttl" validate:"min=0"`           // lifetime of user tokens; 0 means they never expire. Not applied to the root user
+		RotationGrace time.Duration `key:"rotationGrace" validate:"min=0"` // how long RotateToken keeps the previous token valid
+	} `key:"tokens"`
+}
+
+// RedactedValue replaces secrets in a configuration returned by
+// [Config.Redacted].
+const RedactedValue = "***REDACTED***"
+
+// Redacted returns a copy of the configuration that is safe to log or print.
+// Secrets that are set are replaced by [RedactedValue].
+func (c *Config) Redacted() Config {
+	r := *c
+	if r.Database.Password != "" {
+		r.Database.Password = RedactedValue
+	}
+	if r.Development.StaticRootToken != "" {
+		r.Development.StaticRootToken = RedactedValue
+	}
+	if len(r.Security.SiteKey) > 0 {
+		r.Security.SiteKey = []byte(RedactedValue)
+	}
+	if len(r.Security.Salt) > 0 {
+		r.Security.Salt = []byte(RedactedValue)
+	}
+	return r
+}
+
+// IncludeStackTraces reports whether stack traces captured by errors such as
+// [UnreachableCodeError] and [PanicError] should be exposed, which requires
+// both logging.stackTraces and the debug log level.
+func (c *Config) IncludeStackTraces() bool {
+	return c != nil && c.Logging.StackTraces && c.Logging.Level == LogLevelDebug
+}
+
+// AuthzAction returns the authorization action used when authorizing the named
+// RPC method. Methods not remapped by security.authzActions use their own
+// name.
+func (c *Config) AuthzAction(method string) AuthorizationAction {
+	if c != nil {
+		if action, ok := c.Security.AuthzActions[method]; ok {
+			return AuthorizationAction(action)
+		}
+	}
+	return AuthorizationAction(method)
+}
+
+// ConfigFieldError describes a configuration field violating an invariant
+// checked by [Config.Validate].
+type ConfigFieldError struct {
+	Field    string // the configuration key, such as security.siteKey
+	Expected string
+	Got      string
+}
+
+func (e ConfigFieldError) Error() string {
+	return fmt.Sprintf("invalid configuration value for %s: expected %s, got %s", e.Field, e.Expected, e.Got)
+}
+
+// tlsKeyTypes are the key types accepted by security.tls.keyType.
+var tlsKeyTypes = []string{"RSA-4096", "EC-P224", "EC-P256", "EC-P384", "EC-P521", "ED25519"}
+
+// Validate checks invariants of the configuration that would otherwise
+// surface as failures far from their cause, such as keys of the wrong length
+// or backoffs that can never be reached. Every violation is reported as a
+// [ConfigFieldError], joined into a single error. Secrets are never included
+// in the errors, only their length.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field, expected string, got any) {
+		if !ok {
+			errs = append(errs, ConfigFieldError{Field: field, Expected: expected, Got: fmt.Sprint(got)})
+		}
+	}
+
+	check(len(c.Security.SiteKey) == 64, "security.siteKey", "exactly 64 bytes", fmt.Sprintf("%d bytes", len(c.Security.SiteKey)))
+	check(len(c.Security.Salt) == 32, "security.salt", "exactly 32 bytes", fmt.Sprintf("%d bytes", len(c.Security.Salt)))
+	check(slices.Contains(tlsKeyTypes, c.Security.TLS.KeyType), "security.tls.keyType", "one of "+strings.Join(tlsKeyTypes, ", "), fmt.Sprintf("%q", c.Security.TLS.KeyType))
+
+	check(c.Database.Host != "", "database.host", "a host name", `""`)
+	check(c.Database.Port >= 1 && c.Database.Port <= 65535, "database.port", "a port between 1 and 65535", c.Database.Port)
+	check(c.Server.Port >= 1 && c.Server.Port <= 65535, "server.port", "a port between 1 and 65535", c.Server.Port)
+
+	check(c.Services.Users.PageSize >= 2, "services.users.pageSize", "at least 2", c.Services.Users.PageSize)
+	check(c.Services.Profiles.PageSize >= 2, "services.profiles.pageSize", "at least 2", c.Services.Profiles.PageSize)
+	check(c.Services.Checks.PageSize >= 2, "services.checks.pageSize", "at least 2", c.Services.Checks.PageSize)
+
+	check(c.Database.ConnectRetry.InitialBackoff <= c.Database.ConnectRetry.MaxBackoff, "database.connectRetry.initialBackoff", fmt.Sprintf("at most database.connectRetry.maxBackoff (%s)", c.Database.ConnectRetry.MaxBackoff), c.Database.ConnectRetry.InitialBackoff)
+	check(c.Database.ReadRetry.InitialBackoff <= c.Database.ReadRetry.MaxBackoff, "database.readRetry.initialBackoff", fmt.Sprintf("at most database.readRetry.maxBackoff (%s)", c.Database.ReadRetry.MaxBackoff), c.Database.ReadRetry.InitialBackoff)
+	check(c.Services.Scans.Retry.BaseDelay <= c.Services.Scans.Retry.MaxDelay, "services.scans.retry.baseDelay", fmt.Sprintf("at most services.scans.retry.maxDelay (%s)", c.Services.Scans.Retry.MaxDelay), c.Services.Scans.Retry.BaseDelay)
+
+	return errors.Join(errs...)
 }
 
 type ServerConfig struct {
-	Port           int    `key:"port" validate:"required,min=1,max=65535"`
-	MaxBodySize    int64  `key:"maxBodySize" validate:"required,min=1"` // in bytes
-	AdvertisedHost string `key:"advertisedHost" validate:"required"`
+	Port              int                  `key:"port" validate:"required,min=1,max=65535"`
+	MaxBodySize       int64                `key:"maxBodySize" validate:"required,min=1"` // in bytes
+	AdvertisedHost    string               `key:"advertisedHost" validate:"required"`
+	CursorMode        CursorMode           `key:"cursorMode" validate:"required,oneof=plain hmac encrypted"`
+	IDFormat          IDFormat             `key:"idFormat" validate:"required,oneof=xid uuid"`
+	MaxConnections    int                  `key:"maxConnections" validate:"min=0"`            // 0 means unlimited
+	MaxRPCIDLength    int                  `key:"maxRPCIDLength" validate:"min=0"`            // in bytes, 0 means unlimited
+	MaxBatchLength    int                  `key:"maxBatchLength" validate:"min=0"`            // requests in an RPC batch, 0 means unlimited
+	BatchConcurrency  int                  `key:"batchConcurrency" validate:"required,min=1"` // requests of an RPC batch handled at the same time
+	StrictContentType bool                 `key:"strictContentType"`
+	Compression       []string             `key:"compression" validate:"unique,dive,oneof=gzip zstd"` // in order of preference
+	ShutdownTimeout   time.Duration        `key:"shutdownTimeout" validate:"min=0"`                   // 0 waits for all connections to close
+	AdmissionQueue    AdmissionQueueConfig `key:"admissionQueue"`
+	Schema            struct {
+		Enabled       bool `key:"enabled"`       // serve the schema of the RPC methods at /v1/rpc/schema
+		Authenticated bool `key:"authenticated"` // require a token to fetch the schema
+	} `key:"schema"`
+	FeatureOverrides FeatureOverridesConfig `key:"featureOverrides"`
+}
+
+// FeatureOverridesConfig lets clients connecting from one of TrustedCIDRs
+// enable the Allowed request features for a single RPC request, by listing
+// them in the X-Sophrosyne-Debug header. The address of the connection is
+// used, not any forwarding headers. Nothing is trusted by default.
+type FeatureOverridesConfig struct {
+	TrustedCIDRs []string         `key:"trustedCIDRs" validate:"dive,cidr"`
+	Allowed      []RequestFeature `key:"allowed" validate:"unique,dive,oneof=echo-params debug-log"`
+}
+
+// AdmissionQueueConfig bounds the number of RPC requests handled at once.
+// Requests arriving while all slots are taken wait in a queue of at most
+// MaxDepth requests for up to MaxWait, after which they are rejected as busy.
+type AdmissionQueueConfig struct {
+	MaxConcurrent int           `key:"maxConcurrent" validate:"min=0"` // 0 disables admission control
+	MaxDepth      int           `key:"maxDepth" validate:"min=0"`      // 0 rejects requests instead of queuing them
+	MaxWait       time.Duration `key:"maxWait" validate:"min=0"`
 }
 
 // ConfigEnvironmentPrefix is the prefix used to identify the environment
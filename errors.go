@@ -42,11 +42,24 @@ func (e UnreachableCodeError) LogValue() slog.Value {
 	return slog.GroupValue(slog.String("stack", string(e.stack)))
 }
 
+// Stack returns the stack trace captured when the error was created.
+func (e UnreachableCodeError) Stack() string {
+	return string(e.stack)
+}
+
 type PanicError struct {
 	reason string
 	stack  []byte
 }
 
+func NewPanicError(reason any) error {
+	stack := debug.Stack()
+	return &PanicError{
+		reason: fmt.Sprint(reason),
+		stack:  stack,
+	}
+}
+
 func (e PanicError) Error() string {
 	return fmt.Sprintf("panic encountered.\nReason: %s\nStack:\n%s", e.reason, e.stack)
 }
@@ -55,8 +68,29 @@ func (e PanicError) LogValue() slog.Value {
 	return slog.GroupValue(slog.String("reason", e.reason), slog.String("stack", string(e.stack)))
 }
 
+// Reason returns the value the panic was raised with, formatted as a string.
+func (e PanicError) Reason() string {
+	return e.reason
+}
+
+// Stack returns the stack trace captured when the panic was recovered.
+func (e PanicError) Stack() string {
+	return string(e.stack)
+}
+
 var ErrNotFound = errors.New("not found")
 
+// ErrConnection is returned, wrapped, by datastores when an operation failed
+// because of the connection to the database rather than the operation itself,
+// such as a connection being reset during a failover. Read operations failing
+// with it are safe to retry.
+var ErrConnection = errors.New("database connection error")
+
+// ErrEmailInUse is returned by datastores when a user cannot be
+// created or updated because another user has the same email and
+// [Config.Services.Users.UniqueEmail] is enabled.
+var ErrEmailInUse = errors.New("email already in use")
+
 type ConstraintViolationError struct {
 	UnderlyingError error
 	code            string
@@ -87,3 +121,8 @@ func (e ConstraintViolationError) Error() string {
 func (e ConstraintViolationError) Code() string {
 	return e.code
 }
+
+// Unwrap returns the error reported by the datastore.
+func (e ConstraintViolationError) Unwrap() error {
+	return e.UnderlyingError
+}
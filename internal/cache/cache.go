@@ -65,7 +65,9 @@ type cache struct {
 	items   map[string]cacheItem
 	lock    *sync.RWMutex
 	exp     time.Duration
+	stale   time.Duration // how long expired items are kept for GetStale
 	cleaner *cleaner
+	now     func() time.Time // clock used for expiry; time.Now if nil
 }
 
 // NewCache creates a new cache with the given expiration time and cleaning interval.
@@ -74,6 +76,13 @@ type cache struct {
 //
 // If the expiration time is 0 or less, [DefaultExpiration] will be used.
 func NewCache(exp time.Duration, cleanerInterval time.Duration) *Cache {
+	return NewStaleCache(exp, 0, cleanerInterval)
+}
+
+// NewStaleCache creates a new cache like [NewCache], but expired items are kept
+// for an additional stale duration, during which they can be retrieved with
+// [cache.GetStale].
+func NewStaleCache(exp time.Duration, stale time.Duration, cleanerInterval time.Duration) *Cache {
 	if exp <= 0 {
 		exp = DefaultExpiration
 	}
@@ -82,6 +91,7 @@ func NewCache(exp time.Duration, cleanerInterval time.Duration) *Cache {
 		items: make(map[string]cacheItem),
 		lock:  &sync.RWMutex{},
 		exp:   exp,
+		stale: max(stale, 0),
 	}
 
 	// Doing it this way ensures that the cleaner goroutine does not keep the returned Cache object from being
@@ -95,25 +105,36 @@ func NewCache(exp time.Duration, cleanerInterval time.Duration) *Cache {
 	return C
 }
 
+// clock returns the current time according to the clock used by the cache.
+func (c *cache) clock() time.Time {
+	if c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
 // Set sets the value of the item in the cache with the given key. If the given key already exists in the cache,
 // it's value will be overwritten, but the expiration time will remain unchanged.
 func (c *cache) Set(key string, value any) {
+	now := c.clock()
 	c.lock.Lock()
-	if item, exists := c.items[key]; exists && item.ExpiresAt.After(time.Now()) {
+	if item, exists := c.items[key]; exists && item.ExpiresAt.After(now) {
 		item.Value = value
 		c.items[key] = item
 		c.lock.Unlock()
 		return
 	}
-	c.items[key] = cacheItem{ExpiresAt: time.Now().Add(c.exp), Value: value}
+	c.items[key] = cacheItem{ExpiresAt: now.Add(c.exp), Value: value}
 	c.lock.Unlock()
 }
 
-// Get retrieves the value associated with the given key.
+// Get retrieves the value associated with the given key. Items that have
+// expired are not returned, even if they have not yet been removed by the
+// cleaner.
 func (c *cache) Get(key string) (any, bool) {
 	c.lock.RLock()
 	item, ok := c.items[key]
-	if !ok {
+	if !ok || item.ExpiresAt.Before(c.clock()) {
 		c.lock.RUnlock()
 		return nil, false
 	}
@@ -121,6 +142,19 @@ func (c *cache) Get(key string) (any, bool) {
 	return item.Value, true
 }
 
+// GetStale retrieves the value associated with the given key, even if it has
+// expired, as long as it has been expired for no longer than the stale duration
+// of the cache. The lookup is not counted in [cache.Stats].
+func (c *cache) GetStale(key string) (any, bool) {
+	c.lock.RLock()
+	item, ok := c.items[key]
+	c.lock.RUnlock()
+	if !ok || item.ExpiresAt.Add(c.stale).Before(c.clock()) {
+		return nil, false
+	}
+	return item.Value, true
+}
+
 // Delete removes the item with the specified key from the cache.
 func (c *cache) Delete(key string) {
 	c.lock.Lock()
@@ -128,16 +162,39 @@ func (c *cache) Delete(key string) {
 	c.lock.Unlock()
 }
 
+// DeleteValue removes every item whose value equals the given value. It is
+// used to evict index entries whose key is not known, such as the email a user
+// was looked up by.
+func (c *cache) DeleteValue(value any) {
+	c.lock.Lock()
+	for key, item := range c.items {
+		if item.Value == value {
+			delete(c.items, key)
+		}
+	}
+	c.lock.Unlock()
+}
+
+// ItemCount returns the number of items in the cache. This may include items that have expired, but have not yet been
+// removed by the cleaner.
+func (c *cache) ItemCount() int {
+	c.lock.RLock()
+	n := len(c.items)
+	c.lock.RUnlock()
+	return n
+}
+
 // Expire removes expired items from the cache.
 //
-// It iterates over the items in the cache and deletes any item whose expiration time is before the current time.
+// It iterates over the items in the cache and deletes any item whose expiration time, extended by the stale duration
+// of the cache, is before the current time.
 // The function does not take any parameters.
 // It does not return any values.
 func (c *cache) Expire() {
-	now := time.Now()
+	now := c.clock()
 	c.lock.Lock()
 	for key, item := range c.items {
-		if item.ExpiresAt.Before(now) {
+		if item.ExpiresAt.Add(c.stale).Before(now) {
 			delete(c.items, key)
 		}
 	}
@@ -206,3 +206,93 @@ func TestCache_Set_NoUpdateExpiry(t *testing.T) {
 	cache.Set("foo", "bar")
 	require.Equal(t, expiresAt, cache.items["foo"].ExpiresAt)
 }
+
+func TestCache_ItemCount(t *testing.T) {
+	cache := NewCache(50*time.Millisecond, 0)
+	require.Equal(t, 0, cache.ItemCount())
+
+	cache.Set("foo", "bar")
+	cache.Set("baz", "qux")
+	require.Equal(t, 2, cache.ItemCount())
+
+	// Overwriting an existing key does not add an entry.
+	cache.Set("foo", "quux")
+	require.Equal(t, 2, cache.ItemCount())
+
+	cache.Delete("foo")
+	require.Equal(t, 1, cache.ItemCount())
+
+	<-time.After(55 * time.Millisecond)
+	cache.Expire()
+	require.Equal(t, 0, cache.ItemCount())
+}
+
+func TestCache_Get_Expired(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tc := NewCache(time.Minute, 0)
+	tc.now = func() time.Time { return now }
+	tc.Set("foo", "bar")
+
+	now = now.Add(time.Minute)
+	v, found := tc.Get("foo")
+	require.True(t, found, "foo should not have expired yet")
+	require.Equal(t, "bar", v)
+
+	now = now.Add(time.Nanosecond)
+	v, found = tc.Get("foo")
+	require.False(t, found, "foo should have expired")
+	require.Nil(t, v)
+	require.Equal(t, 1, tc.ItemCount(), "expired items are left for the cleaner")
+}
+
+func TestCache_Set_Expired(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tc := NewCache(time.Minute, 0)
+	tc.now = func() time.Time { return now }
+	tc.Set("foo", "bar")
+
+	// Setting an expired item starts a new expiry period.
+	now = now.Add(2 * time.Minute)
+	tc.Set("foo", "baz")
+	require.Equal(t, now.Add(time.Minute), tc.items["foo"].ExpiresAt)
+	v, found := tc.Get("foo")
+	require.True(t, found)
+	require.Equal(t, "baz", v)
+}
+
+func TestCache_GetStale(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tc := NewStaleCache(time.Minute, time.Hour, 0)
+	tc.now = func() time.Time { return now }
+	tc.Set("foo", "bar")
+
+	now = now.Add(30 * time.Minute)
+	_, found := tc.Get("foo")
+	require.False(t, found, "foo should have expired")
+	v, found := tc.GetStale("foo")
+	require.True(t, found, "foo should still be served stale")
+	require.Equal(t, "bar", v)
+
+	tc.Expire()
+	require.Equal(t, 1, tc.ItemCount(), "stale items are kept by the cleaner")
+
+	now = now.Add(31*time.Minute + time.Nanosecond)
+	_, found = tc.GetStale("foo")
+	require.False(t, found, "foo should be too stale")
+	tc.Expire()
+	require.Equal(t, 0, tc.ItemCount())
+
+	_, found = tc.GetStale("missing")
+	require.False(t, found)
+}
+
+func TestNewCache_NoStale(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tc := NewCache(time.Minute, 0)
+	tc.now = func() time.Time { return now }
+	tc.Set("foo", "bar")
+
+	now = now.Add(time.Minute + time.Nanosecond)
+	_, found := tc.GetStale("foo")
+	require.False(t, found)
+}
@@ -28,29 +28,41 @@ type CheckServiceCache struct {
 	cache          *Cache
 	nameToIDCache  *Cache
 	checkService   sophrosyne.CheckService
+	readRetry      sophrosyne.ReadRetryConfig
 	tracingService sophrosyne.TracingService
+	metricService  sophrosyne.MetricService
 }
 
 // NewCheckServiceCache creates a new instance of CheckServiceCache.
-func NewCheckServiceCache(config *sophrosyne.Config, checkService sophrosyne.CheckService, tracingService sophrosyne.TracingService) *CheckServiceCache {
+func NewCheckServiceCache(config *sophrosyne.Config, checkService sophrosyne.CheckService, tracingService sophrosyne.TracingService, metricService sophrosyne.MetricService) *CheckServiceCache {
 	return &CheckServiceCache{
-		cache:          NewCache(config.Services.Checks.Cache.TTL, config.Services.Checks.Cache.CleanupInterval),
-		nameToIDCache:  NewCache(config.Services.Checks.Cache.TTL, config.Services.Checks.Cache.CleanupInterval),
+		cache:          NewStaleCache(config.Services.Checks.Cache.TTL, staleWindow(config), config.Services.Checks.Cache.CleanupInterval),
+		nameToIDCache:  NewStaleCache(config.Services.Checks.Cache.TTL, staleWindow(config), config.Services.Checks.Cache.CleanupInterval),
 		checkService:   checkService,
+		readRetry:      config.Database.ReadRetry,
 		tracingService: tracingService,
+		metricService:  metricService,
 	}
 }
 
 func (c CheckServiceCache) GetCheck(ctx context.Context, id string) (sophrosyne.Check, error) {
 	ctx, span := c.tracingService.StartSpan(ctx, "CheckServiceCache.GetCheck")
 	v, ok := c.cache.Get(id)
+	c.metricService.RecordCacheLookup(ctx, "checks", "GetCheck", ok)
 	if ok {
 		span.End()
 		return v.(sophrosyne.Check), nil
 	}
 
-	profile, err := c.checkService.GetCheck(ctx, id)
+	profile, err := retryRead(ctx, c.readRetry, func() (sophrosyne.Check, error) {
+		return c.checkService.GetCheck(ctx, id)
+	})
 	if err != nil {
+		if stale, ok := getStale[sophrosyne.Check](c.cache, id, err); ok {
+			stale.ServedStale = true
+			span.End()
+			return stale, nil
+		}
 		span.End()
 		return sophrosyne.Check{}, err
 	}
@@ -63,12 +75,20 @@ func (c CheckServiceCache) GetCheck(ctx context.Context, id string) (sophrosyne.
 func (c CheckServiceCache) GetCheckByName(ctx context.Context, name string) (sophrosyne.Check, error) {
 	ctx, span := c.tracingService.StartSpan(ctx, "CheckServiceCache.GetCheckByName")
 	id, ok := c.nameToIDCache.Get(name)
+	c.metricService.RecordCacheLookup(ctx, "checks", "GetCheckByName", ok)
 	if ok {
 		span.End()
 		return c.GetCheck(ctx, id.(string))
 	}
-	profile, err := c.checkService.GetCheckByName(ctx, name)
+	profile, err := retryRead(ctx, c.readRetry, func() (sophrosyne.Check, error) {
+		return c.checkService.GetCheckByName(ctx, name)
+	})
 	if err != nil {
+		if stale, ok := getStaleByKey[sophrosyne.Check](c.nameToIDCache, c.cache, name, err); ok {
+			stale.ServedStale = true
+			span.End()
+			return stale, nil
+		}
 		span.End()
 		return sophrosyne.Check{}, err
 	}
@@ -80,7 +100,9 @@ func (c CheckServiceCache) GetCheckByName(ctx context.Context, name string) (sop
 
 func (c CheckServiceCache) GetChecks(ctx context.Context, cursor *sophrosyne.DatabaseCursor) ([]sophrosyne.Check, error) {
 	ctx, span := c.tracingService.StartSpan(ctx, "CheckServiceCache.GetChecks")
-	profiles, err := c.checkService.GetChecks(ctx, cursor)
+	profiles, err := retryRead(ctx, c.readRetry, func() ([]sophrosyne.Check, error) {
+		return c.checkService.GetChecks(ctx, cursor)
+	})
 	if err != nil {
 		span.End()
 		return nil, err
@@ -138,3 +160,18 @@ func (c CheckServiceCache) DeleteCheck(ctx context.Context, id string) error {
 	span.End()
 	return nil
 }
+
+// GetCheckUsage is not cached, as the profiles of a check change through the
+// ProfileService as well.
+func (c CheckServiceCache) GetCheckUsage(ctx context.Context, name string) ([]sophrosyne.Profile, error) {
+	ctx, span := c.tracingService.StartSpan(ctx, "CheckServiceCache.GetCheckUsage")
+	defer span.End()
+	return retryRead(ctx, c.readRetry, func() ([]sophrosyne.Profile, error) {
+		return c.checkService.GetCheckUsage(ctx, name)
+	})
+}
+
+// ItemCount returns the number of checks currently held in the cache.
+func (c CheckServiceCache) ItemCount() int {
+	return c.cache.ItemCount()
+}
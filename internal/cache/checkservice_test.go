@@ -17,13 +17,16 @@
 package cache
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/madsrc/sophrosyne"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
 )
 
 var testCheck = sophrosyne.Check{
@@ -37,7 +40,7 @@ var secondTestCheck = sophrosyne.Check{
 
 func TestNewCheckServiceCache(t *testing.T) {
 	psc := NewCheckServiceCache(
-		&sophrosyne.Config{}, nil, nil)
+		&sophrosyne.Config{}, nil, nil, nil)
 	assert.NotNil(t, psc)
 }
 
@@ -285,3 +288,61 @@ func TestCheckServiceCache_DeleteCheck(t *testing.T) {
 		require.ErrorIs(t, err, assert.AnError)
 	})
 }
+
+func TestCheckServiceCache_ItemCount(t *testing.T) {
+	cts := setupTestStuff(t, nil)
+	checkServiceCache := getCheckServiceCache(t, cts)
+	cts.tracingService.On("StartSpan", cts.ctx, mock.Anything).Once().Return(cts.ctx, cts.span)
+	cts.span.On("End").Once().Return(nil)
+	require.Equal(t, 0, checkServiceCache.ItemCount())
+
+	cts.checkService.On("GetChecks", cts.ctx, mock.Anything).Once().Return([]sophrosyne.Check{testCheck, secondTestCheck}, nil)
+	_, err := checkServiceCache.GetChecks(cts.ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, checkServiceCache.ItemCount())
+
+	cts.checkService.On("GetCheck", cts.ctx, testCheck.ID).Once().Return(testCheck, nil)
+	cts.checkService.On("DeleteCheck", cts.ctx, testCheck.ID).Once().Return(nil)
+	err = checkServiceCache.DeleteCheck(cts.ctx, testCheck.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, checkServiceCache.ItemCount())
+}
+
+func TestCheckServiceCache_RecordsCacheLookups(t *testing.T) {
+	t.Run("hit", func(t *testing.T) {
+		cts := setupTestStuff(t, &commonTestStuff{metricService: sophrosyne2.NewMockMetricService(t)})
+		cts.metricService.EXPECT().RecordCacheLookup(cts.ctx, "checks", "GetCheck", true).Return().Once()
+		checkServiceCache := getCheckServiceCache(t, cts)
+		checkServiceCache.cache.Set(testCheck.ID, testCheck)
+
+		_, err := checkServiceCache.GetCheck(cts.ctx, testCheck.ID)
+
+		require.NoError(t, err)
+	})
+	t.Run("miss", func(t *testing.T) {
+		cts := setupTestStuff(t, &commonTestStuff{metricService: sophrosyne2.NewMockMetricService(t)})
+		cts.metricService.EXPECT().RecordCacheLookup(cts.ctx, "checks", "GetCheckByName", false).Return().Once()
+		cts.checkService.On("GetCheckByName", cts.ctx, testCheck.Name).Once().Return(testCheck, nil)
+		checkServiceCache := getCheckServiceCache(t, cts)
+
+		_, err := checkServiceCache.GetCheckByName(cts.ctx, testCheck.Name)
+
+		require.NoError(t, err)
+	})
+}
+
+func TestCheckServiceCache_ServeStale(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cts := setupTestStuff(t, nil)
+	checkServiceCache := getCheckServiceCache(t, cts)
+	checkServiceCache.cache = newStaleTestCache(&now)
+	checkServiceCache.cache.Set(testCheck.ID, testCheck)
+	now = now.Add(2 * time.Minute)
+	cts.checkService.On("GetCheck", cts.ctx, testCheck.ID).Once().Return(sophrosyne.Check{}, fmt.Errorf("%w: connection refused", sophrosyne.ErrConnection))
+
+	result, err := checkServiceCache.GetCheck(cts.ctx, testCheck.ID)
+
+	require.NoError(t, err)
+	require.Equal(t, testCheck.Name, result.Name)
+	require.True(t, result.ServedStale)
+}
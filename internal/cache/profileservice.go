@@ -28,28 +28,40 @@ type ProfileServiceCache struct {
 	cache          *Cache // cache for profiles
 	nameToIDCache  *Cache // cache for profile names to IDs.
 	profileService sophrosyne.ProfileService
+	readRetry      sophrosyne.ReadRetryConfig
 	tracingService sophrosyne.TracingService
+	metricService  sophrosyne.MetricService
 }
 
-func NewProfileServiceCache(config *sophrosyne.Config, profileService sophrosyne.ProfileService, tracingService sophrosyne.TracingService) *ProfileServiceCache {
+func NewProfileServiceCache(config *sophrosyne.Config, profileService sophrosyne.ProfileService, tracingService sophrosyne.TracingService, metricService sophrosyne.MetricService) *ProfileServiceCache {
 	return &ProfileServiceCache{
-		cache:          NewCache(config.Services.Profiles.Cache.TTL, config.Services.Profiles.Cache.CleanupInterval),
-		nameToIDCache:  NewCache(config.Services.Profiles.Cache.TTL, config.Services.Profiles.Cache.CleanupInterval),
+		cache:          NewStaleCache(config.Services.Profiles.Cache.TTL, staleWindow(config), config.Services.Profiles.Cache.CleanupInterval),
+		nameToIDCache:  NewStaleCache(config.Services.Profiles.Cache.TTL, staleWindow(config), config.Services.Profiles.Cache.CleanupInterval),
 		profileService: profileService,
+		readRetry:      config.Database.ReadRetry,
 		tracingService: tracingService,
+		metricService:  metricService,
 	}
 }
 
 func (p ProfileServiceCache) GetProfile(ctx context.Context, id string) (sophrosyne.Profile, error) {
 	ctx, span := p.tracingService.StartSpan(ctx, "ProfileServiceCache.GetProfile")
 	v, ok := p.cache.Get(id)
+	p.metricService.RecordCacheLookup(ctx, "profiles", "GetProfile", ok)
 	if ok {
 		span.End()
 		return v.(sophrosyne.Profile), nil
 	}
 
-	profile, err := p.profileService.GetProfile(ctx, id)
+	profile, err := retryRead(ctx, p.readRetry, func() (sophrosyne.Profile, error) {
+		return p.profileService.GetProfile(ctx, id)
+	})
 	if err != nil {
+		if stale, ok := getStale[sophrosyne.Profile](p.cache, id, err); ok {
+			stale.ServedStale = true
+			span.End()
+			return stale, nil
+		}
 		span.End()
 		return sophrosyne.Profile{}, err
 	}
@@ -62,12 +74,20 @@ func (p ProfileServiceCache) GetProfile(ctx context.Context, id string) (sophros
 func (p ProfileServiceCache) GetProfileByName(ctx context.Context, name string) (sophrosyne.Profile, error) {
 	ctx, span := p.tracingService.StartSpan(ctx, "ProfileServiceCache.GetProfileByName")
 	id, ok := p.nameToIDCache.Get(name)
+	p.metricService.RecordCacheLookup(ctx, "profiles", "GetProfileByName", ok)
 	if ok {
 		span.End()
 		return p.GetProfile(ctx, id.(string))
 	}
-	profile, err := p.profileService.GetProfileByName(ctx, name)
+	profile, err := retryRead(ctx, p.readRetry, func() (sophrosyne.Profile, error) {
+		return p.profileService.GetProfileByName(ctx, name)
+	})
 	if err != nil {
+		if stale, ok := getStaleByKey[sophrosyne.Profile](p.nameToIDCache, p.cache, name, err); ok {
+			stale.ServedStale = true
+			span.End()
+			return stale, nil
+		}
 		span.End()
 		return sophrosyne.Profile{}, err
 	}
@@ -79,7 +99,9 @@ func (p ProfileServiceCache) GetProfileByName(ctx context.Context, name string)
 
 func (p ProfileServiceCache) GetProfiles(ctx context.Context, cursor *sophrosyne.DatabaseCursor) ([]sophrosyne.Profile, error) {
 	ctx, span := p.tracingService.StartSpan(ctx, "ProfileServiceCache.GetProfiles")
-	profiles, err := p.profileService.GetProfiles(ctx, cursor)
+	profiles, err := retryRead(ctx, p.readRetry, func() ([]sophrosyne.Profile, error) {
+		return p.profileService.GetProfiles(ctx, cursor)
+	})
 	if err != nil {
 		span.End()
 		return nil, err
@@ -137,3 +159,8 @@ func (p ProfileServiceCache) DeleteProfile(ctx context.Context, name string) err
 	span.End()
 	return nil
 }
+
+// ItemCount returns the number of profiles currently held in the cache.
+func (p ProfileServiceCache) ItemCount() int {
+	return p.cache.ItemCount()
+}
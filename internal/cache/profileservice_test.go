@@ -17,13 +17,16 @@
 package cache
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/madsrc/sophrosyne"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
 )
 
 var testProfile = sophrosyne.Profile{
@@ -37,7 +40,7 @@ var secondTestProfile = sophrosyne.Profile{
 
 func TestNewProfileServiceCache(t *testing.T) {
 	psc := NewProfileServiceCache(
-		&sophrosyne.Config{}, nil, nil)
+		&sophrosyne.Config{}, nil, nil, nil)
 	assert.NotNil(t, psc)
 }
 
@@ -287,3 +290,44 @@ func TestProfileServiceCache_DeleteProfile(t *testing.T) {
 		require.ErrorIs(t, err, assert.AnError)
 	})
 }
+
+func TestProfileServiceCache_RecordsCacheLookups(t *testing.T) {
+	t.Run("hit", func(t *testing.T) {
+		cts := setupTestStuff(t, &commonTestStuff{metricService: sophrosyne2.NewMockMetricService(t)})
+		cts.metricService.EXPECT().RecordCacheLookup(cts.ctx, "profiles", "GetProfile", true).Return().Once()
+		profileServiceCache := getProfileServiceCache(t, cts)
+		profileServiceCache.cache.Set(testProfile.ID, testProfile)
+
+		_, err := profileServiceCache.GetProfile(cts.ctx, testProfile.ID)
+
+		require.NoError(t, err)
+	})
+	t.Run("miss", func(t *testing.T) {
+		cts := setupTestStuff(t, &commonTestStuff{metricService: sophrosyne2.NewMockMetricService(t)})
+		cts.metricService.EXPECT().RecordCacheLookup(cts.ctx, "profiles", "GetProfileByName", false).Return().Once()
+		cts.profileService.On("GetProfileByName", cts.ctx, testProfile.Name).Once().Return(testProfile, nil)
+		profileServiceCache := getProfileServiceCache(t, cts)
+
+		_, err := profileServiceCache.GetProfileByName(cts.ctx, testProfile.Name)
+
+		require.NoError(t, err)
+	})
+}
+
+func TestProfileServiceCache_ServeStale(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cts := setupTestStuff(t, nil)
+	profileServiceCache := getProfileServiceCache(t, cts)
+	profileServiceCache.cache = newStaleTestCache(&now)
+	profileServiceCache.nameToIDCache = newStaleTestCache(&now)
+	profileServiceCache.cache.Set(testProfile.ID, testProfile)
+	profileServiceCache.nameToIDCache.Set(testProfile.Name, testProfile.ID)
+	now = now.Add(2 * time.Minute)
+	cts.profileService.On("GetProfileByName", cts.ctx, testProfile.Name).Once().Return(sophrosyne.Profile{}, fmt.Errorf("%w: connection refused", sophrosyne.ErrConnection))
+
+	result, err := profileServiceCache.GetProfileByName(cts.ctx, testProfile.Name)
+
+	require.NoError(t, err)
+	require.Equal(t, testProfile.Name, result.Name)
+	require.True(t, result.ServedStale)
+}
@@ -0,0 +1,51 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+// retryRead calls fn, a read from the underlying datastore, until it succeeds,
+// fails with an error other than [sophrosyne.ErrConnection], or
+// config.MaxAttempts is reached. The wait between attempts doubles from
+// config.InitialBackoff up to config.MaxBackoff.
+//
+// Only reads may be retried, as a write failing with a connection error may
+// still have been applied.
+func retryRead[T any](ctx context.Context, config sophrosyne.ReadRetryConfig, fn func() (T, error)) (T, error) {
+	wait := config.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		v, err := fn()
+		if err == nil || !errors.Is(err, sophrosyne.ErrConnection) || attempt >= config.MaxAttempts {
+			return v, err
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return v, errors.Join(err, ctx.Err())
+		case <-t.C:
+		}
+		wait = min(wait*2, config.MaxBackoff)
+	}
+}
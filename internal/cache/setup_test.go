@@ -20,6 +20,7 @@ import (
 	"context"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 
@@ -33,6 +34,7 @@ type commonTestStuff struct {
 	profileService *sophrosyne2.MockProfileService
 	checkService   *sophrosyne2.MockCheckService
 	userService    *sophrosyne2.MockUserService
+	metricService  *sophrosyne2.MockMetricService
 	span           *sophrosyne2.MockSpan
 }
 
@@ -71,6 +73,11 @@ func setupTestStuff(t *testing.T, cts *commonTestStuff) *commonTestStuff {
 		cts.userService = sophrosyne2.NewMockUserService(t)
 	}
 
+	if cts.metricService == nil {
+		cts.metricService = sophrosyne2.NewMockMetricService(t)
+		cts.metricService.On("RecordCacheLookup", cts.ctx, mock.Anything, mock.Anything, mock.Anything).Maybe().Return()
+	}
+
 	t.Cleanup(cts.tearDown)
 
 	return cts
@@ -82,15 +89,23 @@ func (cts *commonTestStuff) tearDown() {
 	cts.profileService.AssertExpectations(cts.t)
 	cts.checkService.AssertExpectations(cts.t)
 	cts.userService.AssertExpectations(cts.t)
+	cts.metricService.AssertExpectations(cts.t)
+}
+
+// newTestCache returns a cache whose items do not expire for the duration of a
+// test.
+func newTestCache() *Cache {
+	return &Cache{&cache{items: make(map[string]cacheItem), lock: new(sync.RWMutex), exp: time.Hour}}
 }
 
 func getProfileServiceCache(t *testing.T, cts *commonTestStuff) *ProfileServiceCache {
 	t.Helper()
 	profileServiceCache := ProfileServiceCache{
-		cache:          &Cache{&cache{items: make(map[string]cacheItem), lock: new(sync.RWMutex)}},
-		nameToIDCache:  &Cache{&cache{items: make(map[string]cacheItem), lock: new(sync.RWMutex)}},
+		cache:          newTestCache(),
+		nameToIDCache:  newTestCache(),
 		profileService: cts.profileService,
 		tracingService: cts.tracingService,
+		metricService:  cts.metricService,
 	}
 	return &profileServiceCache
 }
@@ -98,11 +113,12 @@ func getProfileServiceCache(t *testing.T, cts *commonTestStuff) *ProfileServiceC
 func getUserServiceCache(t *testing.T, cts *commonTestStuff) *UserServiceCache {
 	t.Helper()
 	userServiceCache := UserServiceCache{
-		cache:          &Cache{&cache{items: make(map[string]cacheItem), lock: new(sync.RWMutex)}},
-		nameToIDCache:  &Cache{&cache{items: make(map[string]cacheItem), lock: new(sync.RWMutex)}},
-		emailToIDCache: &Cache{&cache{items: make(map[string]cacheItem), lock: new(sync.RWMutex)}},
+		cache:          newTestCache(),
+		nameToIDCache:  newTestCache(),
+		emailToIDCache: newTestCache(),
 		userService:    cts.userService,
 		tracingService: cts.tracingService,
+		metricService:  cts.metricService,
 	}
 	return &userServiceCache
 }
@@ -110,10 +126,19 @@ func getUserServiceCache(t *testing.T, cts *commonTestStuff) *UserServiceCache {
 func getCheckServiceCache(t *testing.T, cts *commonTestStuff) *CheckServiceCache {
 	t.Helper()
 	checkServiceCache := CheckServiceCache{
-		cache:          &Cache{&cache{items: make(map[string]cacheItem), lock: new(sync.RWMutex)}},
-		nameToIDCache:  &Cache{&cache{items: make(map[string]cacheItem), lock: new(sync.RWMutex)}},
+		cache:          newTestCache(),
+		nameToIDCache:  newTestCache(),
 		checkService:   cts.checkService,
 		tracingService: cts.tracingService,
+		metricService:  cts.metricService,
 	}
 	return &checkServiceCache
 }
+
+// newStaleTestCache returns a cache whose items expire after a minute and are
+// kept stale for another hour, according to the clock now.
+func newStaleTestCache(now *time.Time) *Cache {
+	c := NewStaleCache(time.Minute, time.Hour, 0)
+	c.now = func() time.Time { return *now }
+	return c
+}
@@ -0,0 +1,60 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+// staleWindow returns for how long expired entries are kept to be served
+// stale, which is zero unless services.cache.serveStaleOnError is enabled.
+func staleWindow(config *sophrosyne.Config) time.Duration {
+	if !config.Services.Cache.ServeStaleOnError {
+		return 0
+	}
+	return config.Services.Cache.MaxStale
+}
+
+// getStale returns the possibly expired entry with the given key from c, if c
+// keeps stale entries and err, returned by a read from the underlying
+// datastore, is a [sophrosyne.ErrConnection]. Writes must never fall back to
+// stale entries.
+func getStale[T any](c *Cache, key string, err error) (T, bool) {
+	var zero T
+	if c.stale <= 0 || !errors.Is(err, sophrosyne.ErrConnection) {
+		return zero, false
+	}
+	v, ok := c.GetStale(key)
+	if !ok {
+		return zero, false
+	}
+	return v.(T), true
+}
+
+// getStaleByKey is like getStale, but looks up the ID of the entry in keyToID,
+// a cache of names or emails to IDs, first.
+func getStaleByKey[T any](keyToID *Cache, entries *Cache, key string, err error) (T, bool) {
+	id, ok := getStale[string](keyToID, key, err)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return getStale[T](entries, id, err)
+}
@@ -27,29 +27,41 @@ type UserServiceCache struct {
 	nameToIDCache  *Cache
 	emailToIDCache *Cache
 	userService    sophrosyne.UserService
+	readRetry      sophrosyne.ReadRetryConfig
 	tracingService sophrosyne.TracingService
+	metricService  sophrosyne.MetricService
 }
 
-func NewUserServiceCache(config *sophrosyne.Config, userService sophrosyne.UserService, tracingService sophrosyne.TracingService) *UserServiceCache {
+func NewUserServiceCache(config *sophrosyne.Config, userService sophrosyne.UserService, tracingService sophrosyne.TracingService, metricService sophrosyne.MetricService) *UserServiceCache {
 	return &UserServiceCache{
-		cache:          NewCache(config.Services.Users.Cache.TTL, config.Services.Users.Cache.CleanupInterval),
-		nameToIDCache:  NewCache(config.Services.Users.Cache.TTL, config.Services.Users.Cache.CleanupInterval),
-		emailToIDCache: NewCache(config.Services.Users.Cache.TTL, config.Services.Users.Cache.CleanupInterval),
+		cache:          NewStaleCache(config.Services.Users.Cache.TTL, staleWindow(config), config.Services.Users.Cache.CleanupInterval),
+		nameToIDCache:  NewStaleCache(config.Services.Users.Cache.TTL, staleWindow(config), config.Services.Users.Cache.CleanupInterval),
+		emailToIDCache: NewStaleCache(config.Services.Users.Cache.TTL, staleWindow(config), config.Services.Users.Cache.CleanupInterval),
 		userService:    userService,
+		readRetry:      config.Database.ReadRetry,
 		tracingService: tracingService,
+		metricService:  metricService,
 	}
 }
 
 func (c *UserServiceCache) GetUser(ctx context.Context, id string) (sophrosyne.User, error) {
 	ctx, span := c.tracingService.StartSpan(ctx, "UserServiceCache.GetUser")
 	v, ok := c.cache.Get(id)
+	c.metricService.RecordCacheLookup(ctx, "users", "GetUser", ok)
 	if ok {
 		span.End()
 		return v.(sophrosyne.User), nil
 	}
 
-	user, err := c.userService.GetUser(ctx, id)
+	user, err := retryRead(ctx, c.readRetry, func() (sophrosyne.User, error) {
+		return c.userService.GetUser(ctx, id)
+	})
 	if err != nil {
+		if stale, ok := getStale[sophrosyne.User](c.cache, id, err); ok {
+			stale.ServedStale = true
+			span.End()
+			return stale, nil
+		}
 		span.End()
 		return sophrosyne.User{}, err
 	}
@@ -62,17 +74,25 @@ func (c *UserServiceCache) GetUser(ctx context.Context, id string) (sophrosyne.U
 func (c *UserServiceCache) GetUserByEmail(ctx context.Context, email string) (sophrosyne.User, error) {
 	ctx, span := c.tracingService.StartSpan(ctx, "UserServiceCache.GetUserByEmail")
 	v, ok := c.emailToIDCache.Get(email)
+	c.metricService.RecordCacheLookup(ctx, "users", "GetUserByEmail", ok)
 	if ok {
 		span.End()
 		return c.GetUser(ctx, v.(string))
 	}
-	user, err := c.userService.GetUserByEmail(ctx, email)
+	user, err := retryRead(ctx, c.readRetry, func() (sophrosyne.User, error) {
+		return c.userService.GetUserByEmail(ctx, email)
+	})
 	if err != nil {
+		if stale, ok := getStaleByKey[sophrosyne.User](c.emailToIDCache, c.cache, email, err); ok {
+			stale.ServedStale = true
+			span.End()
+			return stale, nil
+		}
 		span.End()
 		return sophrosyne.User{}, err
 	}
 
-	c.emailToIDCache.Set(user.Email, user.ID)
+	c.emailToIDCache.Set(email, user.ID)
 	span.End()
 	return user, nil
 }
@@ -80,12 +100,20 @@ func (c *UserServiceCache) GetUserByEmail(ctx context.Context, email string) (so
 func (c *UserServiceCache) GetUserByName(ctx context.Context, name string) (sophrosyne.User, error) {
 	ctx, span := c.tracingService.StartSpan(ctx, "UserServiceCache.GetUserByName")
 	v, ok := c.nameToIDCache.Get(name)
+	c.metricService.RecordCacheLookup(ctx, "users", "GetUserByName", ok)
 	if ok {
 		span.End()
 		return c.GetUser(ctx, v.(string))
 	}
-	user, err := c.userService.GetUserByName(ctx, name)
+	user, err := retryRead(ctx, c.readRetry, func() (sophrosyne.User, error) {
+		return c.userService.GetUserByName(ctx, name)
+	})
 	if err != nil {
+		if stale, ok := getStaleByKey[sophrosyne.User](c.nameToIDCache, c.cache, name, err); ok {
+			stale.ServedStale = true
+			span.End()
+			return stale, nil
+		}
 		span.End()
 		return sophrosyne.User{}, err
 	}
@@ -103,7 +131,9 @@ func (c *UserServiceCache) GetUserByName(ctx context.Context, name string) (soph
 // The returned user is written to the cache before being returned.
 func (c *UserServiceCache) GetUserByToken(ctx context.Context, token []byte) (sophrosyne.User, error) {
 	ctx, span := c.tracingService.StartSpan(ctx, "UserServiceCache.GetUserByToken")
-	user, err := c.userService.GetUserByToken(ctx, token)
+	user, err := retryRead(ctx, c.readRetry, func() (sophrosyne.User, error) {
+		return c.userService.GetUserByToken(ctx, token)
+	})
 	if err != nil {
 		span.End()
 		return sophrosyne.User{}, err
@@ -116,7 +146,9 @@ func (c *UserServiceCache) GetUserByToken(ctx context.Context, token []byte) (so
 
 func (c *UserServiceCache) GetUsers(ctx context.Context, cursor *sophrosyne.DatabaseCursor) ([]sophrosyne.User, error) {
 	ctx, span := c.tracingService.StartSpan(ctx, "UserServiceCache.GetUsers")
-	users, err := c.userService.GetUsers(ctx, cursor)
+	users, err := retryRead(ctx, c.readRetry, func() ([]sophrosyne.User, error) {
+		return c.userService.GetUsers(ctx, cursor)
+	})
 	if err != nil {
 		span.End()
 		return nil, err
@@ -156,21 +188,24 @@ func (c *UserServiceCache) UpdateUser(ctx context.Context, req sophrosyne.Update
 	return user, nil
 }
 
-func (c *UserServiceCache) DeleteUser(ctx context.Context, id string) error {
+func (c *UserServiceCache) DeleteUser(ctx context.Context, name string) error {
 	ctx, span := c.tracingService.StartSpan(ctx, "UserServiceCache.DeleteUser")
-	user, err := c.userService.GetUser(ctx, id)
+	user, err := c.userService.GetUserByName(ctx, name)
 	if err != nil {
 		span.End()
 		return err
 	}
-	err = c.userService.DeleteUser(ctx, id)
+	err = c.userService.DeleteUser(ctx, name)
 	if err != nil {
 		span.End()
 		return err
 	}
 
+	// The email index is keyed by the email the user was looked up by, which
+	// is not necessarily what is stored, so evict it by the ID it points to.
+	c.emailToIDCache.DeleteValue(user.ID)
 	c.nameToIDCache.Delete(user.Name)
-	c.cache.Delete(id)
+	c.cache.Delete(user.ID)
 	span.End()
 	return nil
 }
@@ -186,5 +221,10 @@ func (c *UserServiceCache) RotateToken(ctx context.Context, id string) ([]byte,
 func (c *UserServiceCache) Health(ctx context.Context) (bool, []byte) {
 	_, span := c.tracingService.StartSpan(ctx, "UserServiceCache.Health")
 	span.End()
-	return true, []byte(`{"ok"}`)
+	return true, []byte(`{"cache":{"healthy":true}}`)
+}
+
+// ItemCount returns the number of users currently held in the cache.
+func (c *UserServiceCache) ItemCount() int {
+	return c.cache.ItemCount()
 }
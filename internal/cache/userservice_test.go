@@ -17,13 +17,16 @@
 package cache
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/madsrc/sophrosyne"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
 )
 
 var testUser = sophrosyne.User{
@@ -37,7 +40,7 @@ var secondTestUser = sophrosyne.User{
 
 func TestNewUserServiceCache(t *testing.T) {
 	psc := NewUserServiceCache(
-		&sophrosyne.Config{}, nil, nil)
+		&sophrosyne.Config{}, nil, nil, nil)
 	assert.NotNil(t, psc)
 }
 
@@ -161,6 +164,23 @@ func TestUserServiceCache_GetUserByEmail(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, expectedUser, result)
 	})
+	t.Run("cached by requested email", func(t *testing.T) {
+		cts := setupTestStuff(t, nil)
+		userServiceCache := getUserServiceCache(t, cts)
+		// The underlying service may return the email as stored, such as when
+		// emails are hashed at rest.
+		expectedUser := testUser
+		expectedUser.Email = "protected"
+
+		cts.userService.On("GetUserByEmail", cts.ctx, testUser.Email).Once().Return(expectedUser, nil)
+
+		_, err := userServiceCache.GetUserByEmail(cts.ctx, testUser.Email)
+
+		require.NoError(t, err)
+		id, ok := userServiceCache.emailToIDCache.Get(testUser.Email)
+		require.True(t, ok)
+		require.Equal(t, expectedUser.ID, id)
+	})
 
 	t.Run("error retrieving from service", func(t *testing.T) {
 		cts := setupTestStuff(t, nil)
@@ -319,20 +339,42 @@ func TestUserServiceCache_DeleteUser(t *testing.T) {
 		cts := setupTestStuff(t, nil)
 		userServiceCache := getUserServiceCache(t, cts)
 		expectedUser := testUser
-		input := expectedUser.ID
+		input := expectedUser.Name
 		userServiceCache.cache.Set(expectedUser.ID, expectedUser)
 		userServiceCache.nameToIDCache.Set(expectedUser.Name, expectedUser.ID)
+		userServiceCache.emailToIDCache.Set("test@example.com", expectedUser.ID)
 
-		cts.userService.On("GetUser", cts.ctx, input).Once().Return(expectedUser, nil)
-		cts.userService.On("DeleteUser", cts.ctx, mock.Anything).Once().Return(nil)
+		cts.userService.On("GetUserByName", cts.ctx, input).Once().Return(expectedUser, nil)
+		cts.userService.On("DeleteUser", cts.ctx, input).Once().Return(nil)
 
 		err := userServiceCache.DeleteUser(cts.ctx, input)
 
 		require.NoError(t, err)
-		cacheEntry, ok := userServiceCache.cache.Get(expectedUser.ID)
+		_, ok := userServiceCache.cache.Get(expectedUser.ID)
 		require.False(t, ok)
-		require.NotEqual(t, expectedUser, cacheEntry)
-
+		_, ok = userServiceCache.nameToIDCache.Get(expectedUser.Name)
+		require.False(t, ok)
+		_, ok = userServiceCache.emailToIDCache.Get("test@example.com")
+		require.False(t, ok)
+	})
+	t.Run("deleted user is no longer served by email", func(t *testing.T) {
+		cts := setupTestStuff(t, nil)
+		cts.tracingService.On("StartSpan", cts.ctx, mock.Anything).Once().Return(cts.ctx, cts.span)
+		cts.span.On("End").Once().Return(nil)
+		userServiceCache := getUserServiceCache(t, cts)
+		user := testUser
+		user.Email = "test@example.com"
+		userServiceCache.cache.Set(user.ID, user)
+		userServiceCache.nameToIDCache.Set(user.Name, user.ID)
+		userServiceCache.emailToIDCache.Set("Test@Example.com", user.ID)
+
+		cts.userService.On("GetUserByName", cts.ctx, user.Name).Once().Return(user, nil)
+		cts.userService.On("DeleteUser", cts.ctx, user.Name).Once().Return(nil)
+		require.NoError(t, userServiceCache.DeleteUser(cts.ctx, user.Name))
+
+		cts.userService.On("GetUserByEmail", cts.ctx, "Test@Example.com").Once().Return(sophrosyne.User{}, sophrosyne.ErrNotFound)
+		_, err := userServiceCache.GetUserByEmail(cts.ctx, "Test@Example.com")
+		require.ErrorIs(t, err, sophrosyne.ErrNotFound)
 	})
 	t.Run("error getting user", func(t *testing.T) {
 		cts := setupTestStuff(t, nil)
@@ -342,7 +384,7 @@ func TestUserServiceCache_DeleteUser(t *testing.T) {
 		userServiceCache.cache.Set(expectedUser.ID, expectedUser)
 		userServiceCache.nameToIDCache.Set(expectedUser.Name, expectedUser.ID)
 
-		cts.userService.On("GetUser", cts.ctx, input).Once().Return(expectedUser, assert.AnError)
+		cts.userService.On("GetUserByName", cts.ctx, input).Once().Return(sophrosyne.User{}, assert.AnError)
 
 		err := userServiceCache.DeleteUser(cts.ctx, input)
 
@@ -354,10 +396,10 @@ func TestUserServiceCache_DeleteUser(t *testing.T) {
 	t.Run("error deleting", func(t *testing.T) {
 		cts := setupTestStuff(t, nil)
 		userServiceCache := getUserServiceCache(t, cts)
-		input := testUser.ID
+		input := testUser.Name
 
-		cts.userService.On("GetUser", cts.ctx, input).Once().Return(testUser, nil)
-		cts.userService.On("DeleteUser", cts.ctx, mock.Anything).Once().Return(assert.AnError)
+		cts.userService.On("GetUserByName", cts.ctx, input).Once().Return(testUser, nil)
+		cts.userService.On("DeleteUser", cts.ctx, input).Once().Return(assert.AnError)
 
 		err := userServiceCache.DeleteUser(cts.ctx, input)
 
@@ -387,5 +429,184 @@ func TestUserServiceCache_Health(t *testing.T) {
 	ok, result := userServiceCache.Health(cts.ctx)
 
 	require.True(t, ok)
-	require.Equal(t, []byte(`{"ok"}`), result)
+	require.Equal(t, []byte(`{"cache":{"healthy":true}}`), result)
+}
+
+func TestUserServiceCache_RecordsCacheLookups(t *testing.T) {
+	t.Run("hit", func(t *testing.T) {
+		cts := setupTestStuff(t, &commonTestStuff{metricService: sophrosyne2.NewMockMetricService(t)})
+		cts.metricService.EXPECT().RecordCacheLookup(cts.ctx, "users", "GetUserByName", true).Return().Once()
+		cts.metricService.EXPECT().RecordCacheLookup(cts.ctx, "users", "GetUser", true).Return().Once()
+		cts.tracingService.On("StartSpan", cts.ctx, mock.Anything).Once().Return(cts.ctx, cts.span)
+		cts.span.On("End").Once().Return(nil)
+		userServiceCache := getUserServiceCache(t, cts)
+		userServiceCache.nameToIDCache.Set(testUser.Name, testUser.ID)
+		userServiceCache.cache.Set(testUser.ID, testUser)
+
+		_, err := userServiceCache.GetUserByName(cts.ctx, testUser.Name)
+
+		require.NoError(t, err)
+	})
+	t.Run("miss", func(t *testing.T) {
+		cts := setupTestStuff(t, &commonTestStuff{metricService: sophrosyne2.NewMockMetricService(t)})
+		cts.metricService.EXPECT().RecordCacheLookup(cts.ctx, "users", "GetUserByEmail", false).Return().Once()
+		cts.userService.On("GetUserByEmail", cts.ctx, testUser.Email).Once().Return(testUser, nil)
+		userServiceCache := getUserServiceCache(t, cts)
+
+		_, err := userServiceCache.GetUserByEmail(cts.ctx, testUser.Email)
+
+		require.NoError(t, err)
+	})
+}
+
+func TestUserServiceCache_ReadRetry(t *testing.T) {
+	readRetry := sophrosyne.ReadRetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	connErr := fmt.Errorf("%w: connection reset by peer", sophrosyne.ErrConnection)
+
+	t.Run("read retried on connection error", func(t *testing.T) {
+		cts := setupTestStuff(t, nil)
+		userServiceCache := getUserServiceCache(t, cts)
+		userServiceCache.readRetry = readRetry
+
+		cts.userService.On("GetUser", cts.ctx, testUser.ID).Once().Return(sophrosyne.User{}, connErr)
+		cts.userService.On("GetUser", cts.ctx, testUser.ID).Once().Return(testUser, nil)
+
+		result, err := userServiceCache.GetUser(cts.ctx, testUser.ID)
+
+		require.NoError(t, err)
+		require.Equal(t, testUser, result)
+	})
+	t.Run("read gives up after max attempts", func(t *testing.T) {
+		cts := setupTestStuff(t, nil)
+		userServiceCache := getUserServiceCache(t, cts)
+		userServiceCache.readRetry = readRetry
+
+		cts.userService.On("GetUsers", cts.ctx, mock.Anything).Times(3).Return(nil, connErr)
+
+		_, err := userServiceCache.GetUsers(cts.ctx, nil)
+
+		require.ErrorIs(t, err, sophrosyne.ErrConnection)
+	})
+	t.Run("read not retried on other errors", func(t *testing.T) {
+		cts := setupTestStuff(t, nil)
+		userServiceCache := getUserServiceCache(t, cts)
+		userServiceCache.readRetry = readRetry
+
+		cts.userService.On("GetUser", cts.ctx, testUser.ID).Once().Return(sophrosyne.User{}, sophrosyne.ErrNotFound)
+
+		_, err := userServiceCache.GetUser(cts.ctx, testUser.ID)
+
+		require.ErrorIs(t, err, sophrosyne.ErrNotFound)
+	})
+	t.Run("write not retried", func(t *testing.T) {
+		cts := setupTestStuff(t, nil)
+		userServiceCache := getUserServiceCache(t, cts)
+		userServiceCache.readRetry = readRetry
+
+		cts.userService.On("CreateUser", cts.ctx, mock.Anything).Once().Return(sophrosyne.User{}, connErr)
+
+		_, err := userServiceCache.CreateUser(cts.ctx, sophrosyne.CreateUserRequest{Name: testUser.Name})
+
+		require.ErrorIs(t, err, sophrosyne.ErrConnection)
+	})
+}
+
+func TestUserServiceCache_ServeStale(t *testing.T) {
+	connErr := fmt.Errorf("%w: connection reset by peer", sophrosyne.ErrConnection)
+	staleUser := testUser
+	staleUser.ServedStale = true
+
+	setup := func(t *testing.T) (*commonTestStuff, *UserServiceCache, *time.Time) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		cts := setupTestStuff(t, nil)
+		userServiceCache := getUserServiceCache(t, cts)
+		userServiceCache.cache = newStaleTestCache(&now)
+		userServiceCache.nameToIDCache = newStaleTestCache(&now)
+		userServiceCache.cache.Set(testUser.ID, testUser)
+		userServiceCache.nameToIDCache.Set(testUser.Name, testUser.ID)
+		now = now.Add(2 * time.Minute)
+		return cts, userServiceCache, &now
+	}
+
+	t.Run("stale entry served on connection error", func(t *testing.T) {
+		cts, userServiceCache, _ := setup(t)
+		cts.userService.On("GetUser", cts.ctx, testUser.ID).Once().Return(sophrosyne.User{}, connErr)
+
+		result, err := userServiceCache.GetUser(cts.ctx, testUser.ID)
+
+		require.NoError(t, err)
+		require.Equal(t, staleUser, result)
+		require.True(t, result.ServedStale)
+	})
+	t.Run("stale entry served by name on connection error", func(t *testing.T) {
+		cts, userServiceCache, _ := setup(t)
+		cts.userService.On("GetUserByName", cts.ctx, testUser.Name).Once().Return(sophrosyne.User{}, connErr)
+
+		result, err := userServiceCache.GetUserByName(cts.ctx, testUser.Name)
+
+		require.NoError(t, err)
+		require.Equal(t, staleUser, result)
+	})
+	t.Run("fresh entries are not flagged", func(t *testing.T) {
+		cts, userServiceCache, _ := setup(t)
+		cts.userService.On("GetUser", cts.ctx, testUser.ID).Once().Return(testUser, nil)
+
+		result, err := userServiceCache.GetUser(cts.ctx, testUser.ID)
+
+		require.NoError(t, err)
+		require.False(t, result.ServedStale)
+	})
+	t.Run("stale entry not served on other errors", func(t *testing.T) {
+		cts, userServiceCache, _ := setup(t)
+		cts.userService.On("GetUser", cts.ctx, testUser.ID).Once().Return(sophrosyne.User{}, sophrosyne.ErrNotFound)
+
+		_, err := userServiceCache.GetUser(cts.ctx, testUser.ID)
+
+		require.ErrorIs(t, err, sophrosyne.ErrNotFound)
+	})
+	t.Run("entry too stale", func(t *testing.T) {
+		cts, userServiceCache, now := setup(t)
+		*now = now.Add(time.Hour)
+		cts.userService.On("GetUser", cts.ctx, testUser.ID).Once().Return(sophrosyne.User{}, connErr)
+
+		_, err := userServiceCache.GetUser(cts.ctx, testUser.ID)
+
+		require.ErrorIs(t, err, sophrosyne.ErrConnection)
+	})
+	t.Run("stale entry not served when disabled", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		cts := setupTestStuff(t, nil)
+		userServiceCache := getUserServiceCache(t, cts)
+		userServiceCache.cache = NewCache(time.Minute, 0)
+		userServiceCache.cache.now = func() time.Time { return now }
+		userServiceCache.cache.Set(testUser.ID, testUser)
+		now = now.Add(2 * time.Minute)
+		cts.userService.On("GetUser", cts.ctx, testUser.ID).Once().Return(sophrosyne.User{}, connErr)
+
+		_, err := userServiceCache.GetUser(cts.ctx, testUser.ID)
+
+		require.ErrorIs(t, err, sophrosyne.ErrConnection)
+	})
+	t.Run("writes still fail", func(t *testing.T) {
+		cts, userServiceCache, _ := setup(t)
+		cts.userService.On("UpdateUser", cts.ctx, mock.Anything).Once().Return(sophrosyne.User{}, connErr)
+
+		_, err := userServiceCache.UpdateUser(cts.ctx, sophrosyne.UpdateUserRequest{Name: testUser.Name})
+
+		require.ErrorIs(t, err, sophrosyne.ErrConnection)
+	})
+}
+
+func TestNewUserServiceCache_ServeStaleOnError(t *testing.T) {
+	config := &sophrosyne.Config{}
+	config.Services.Cache.MaxStale = time.Hour
+
+	userServiceCache := NewUserServiceCache(config, nil, nil, nil)
+	require.Zero(t, userServiceCache.cache.stale, "stale entries are only kept when enabled")
+
+	config.Services.Cache.ServeStaleOnError = true
+	userServiceCache = NewUserServiceCache(config, nil, nil, nil)
+	require.Equal(t, time.Hour, userServiceCache.cache.stale)
+	require.Equal(t, time.Hour, userServiceCache.nameToIDCache.stale)
+	require.Equal(t, time.Hour, userServiceCache.emailToIDCache.stale)
 }
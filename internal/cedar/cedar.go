@@ -85,6 +85,7 @@ func CheckToEntity(u sophrosyne.Check) cedar.Entity {
 type AuthorizationProvider struct {
 	policySet      cedar.PolicySet
 	psMutex        *sync.RWMutex
+	config         *sophrosyne.Config
 	logger         *slog.Logger
 	userService    sophrosyne.UserService
 	profileService sophrosyne.ProfileService
@@ -92,8 +93,9 @@ type AuthorizationProvider struct {
 	tracingService sophrosyne.TracingService
 }
 
-func NewAuthorizationProvider(ctx context.Context, logger *slog.Logger, userService sophrosyne.UserService, tracingService sophrosyne.TracingService, profileService sophrosyne.ProfileService, checkService sophrosyne.CheckService) (*AuthorizationProvider, error) {
+func NewAuthorizationProvider(ctx context.Context, config *sophrosyne.Config, logger *slog.Logger, userService sophrosyne.UserService, tracingService sophrosyne.TracingService, profileService sophrosyne.ProfileService, checkService sophrosyne.CheckService) (*AuthorizationProvider, error) {
 	ap := AuthorizationProvider{
+		config:         config,
 		logger:         logger,
 		userService:    userService,
 		profileService: profileService,
@@ -172,10 +174,88 @@ func (a *AuthorizationProvider) fetchEntities(ctx context.Context, req cedar.Req
 func (a *AuthorizationProvider) IsAuthorized(ctx context.Context, req sophrosyne.AuthorizationRequest) bool {
 	ctx, span := a.tracingService.StartSpan(ctx, "AuthorizationProvider.IsAuthorized")
 	defer span.End()
+	cReq, entities, err := a.prepareRequest(ctx, req)
+	if err != nil {
+		a.audit(ctx, req, cedar.Deny, cedar.Diagnostic{})
+		return false
+	}
+
+	a.psMutex.RLock()
+	defer a.psMutex.RUnlock()
+	a.logger.DebugContext(ctx, "checking authorization", "request", cReq)
+	decision, diag := a.policySet.IsAuthorized(entities, cReq)
+	a.audit(ctx, req, decision, diag)
+	return decision == cedar.Allow
+}
+
+// audit logs the decision made for req, along with the policies that
+// determined it, unless disabled by logging.authzAudit.
+func (a *AuthorizationProvider) audit(ctx context.Context, req sophrosyne.AuthorizationRequest, decision cedar.Decision, diag cedar.Diagnostic) {
+	if a.config != nil && !a.config.Logging.AuthzAudit {
+		return
+	}
+	result := "deny"
+	if decision == cedar.Allow {
+		result = "allow"
+	}
+	attrs := []any{
+		"principal", req.Principal.EntityID(),
+		"action", req.Action.EntityID(),
+	}
+	if req.Resource != nil {
+		attrs = append(attrs, slog.Group("resource", "type", req.Resource.EntityType(), "id", req.Resource.EntityID()))
+	}
+	policies := make([]int, 0, len(diag.Reasons))
+	for _, reason := range diag.Reasons {
+		policies = append(policies, reason.Policy)
+	}
+	attrs = append(attrs, "result", result, "policies", policies)
+	if len(diag.Errors) > 0 {
+		attrs = append(attrs, "errors", diag.Errors)
+	}
+	a.logger.InfoContext(ctx, "authorization decision", attrs...)
+}
+
+// SimulateAuthorization evaluates reqs against both the installed policies and
+// the candidate policies, without installing the candidate policies. Requests
+// that cannot be evaluated are denied by both, as they would be by
+// IsAuthorized.
+func (a *AuthorizationProvider) SimulateAuthorization(ctx context.Context, policies []byte, reqs []sophrosyne.AuthorizationRequest) ([]sophrosyne.SimulatedAuthzDecision, error) {
+	ctx, span := a.tracingService.StartSpan(ctx, "AuthorizationProvider.SimulateAuthorization")
+	defer span.End()
+	candidate, err := cedar.NewPolicySet("candidate.cedar", policies)
+	if err != nil {
+		return nil, err
+	}
+
+	a.psMutex.RLock()
+	current := a.policySet
+	a.psMutex.RUnlock()
+
+	out := make([]sophrosyne.SimulatedAuthzDecision, 0, len(reqs))
+	for _, req := range reqs {
+		var d sophrosyne.SimulatedAuthzDecision
+		cReq, entities, err := a.prepareRequest(ctx, req)
+		if err == nil {
+			cur, _ := current.IsAuthorized(entities, cReq)
+			cand, _ := candidate.IsAuthorized(entities, cReq)
+			d.Current = cur == cedar.Allow
+			d.Candidate = cand == cedar.Allow
+		}
+		d.Changed = d.Current != d.Candidate
+		out = append(out, d)
+	}
+	a.logger.DebugContext(ctx, "simulated authorization", "decisions", out)
+	return out, nil
+}
+
+// prepareRequest converts req into a cedar request and fetches the entities
+// needed to evaluate it.
+func (a *AuthorizationProvider) prepareRequest(ctx context.Context, req sophrosyne.AuthorizationRequest) (cedar.Request, cedar.Entities, error) {
 	reqCtx, err := contextToRecord(req.Context)
 	if err != nil {
 		a.logger.InfoContext(ctx, "error converting context to record", "error", err.Error())
-		return false
+		return cedar.Request{}, nil, err
 	}
 
 	cReq := cedar.Request{
@@ -189,15 +269,9 @@ func (a *AuthorizationProvider) IsAuthorized(ctx context.Context, req sophrosyne
 	entities, err := a.fetchEntities(ctx, cReq)
 	if err != nil {
 		a.logger.InfoContext(ctx, "error fetching entities", "error", err.Error())
-		return false
+		return cedar.Request{}, nil, err
 	}
-
-	a.psMutex.RLock()
-	defer a.psMutex.RUnlock()
-	a.logger.DebugContext(ctx, "checking authorization", "request", cReq)
-	decision, diag := a.policySet.IsAuthorized(entities, cReq)
-	a.logger.InfoContext(ctx, "authorization decision", "decision", decision, "diag", diag)
-	return decision == cedar.Allow
+	return cReq, entities, nil
 }
 
 func contextToRecord(in map[string]interface{}) (*cedar.Record, error) {
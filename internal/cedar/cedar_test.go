@@ -0,0 +1,152 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package cedar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+)
+
+func TestAuthorizationProvider_SimulateAuthorization(t *testing.T) {
+	ctx := context.Background()
+	admin := sophrosyne.User{ID: "1", IsAdmin: true}
+	regular := sophrosyne.User{ID: "2"}
+
+	span := sophrosyne2.NewMockSpan(t)
+	span.EXPECT().End().Return()
+	tracingService := sophrosyne2.NewMockTracingService(t)
+	tracingService.EXPECT().StartSpan(mock.Anything, mock.Anything).Return(ctx, span)
+	userService := sophrosyne2.NewMockUserService(t)
+	userService.EXPECT().GetUser(mock.Anything, admin.ID).Return(admin, nil)
+	userService.EXPECT().GetUser(mock.Anything, regular.ID).Return(regular, nil)
+	userService.EXPECT().GetUser(mock.Anything, "3").Return(sophrosyne.User{}, sophrosyne.ErrNotFound)
+
+	a, err := NewAuthorizationProvider(ctx, nil, slog.Default(), userService, tracingService, nil, nil)
+	require.NoError(t, err)
+
+	candidate := []byte(`permit (principal, action == Action::"GetProfile", resource);`)
+	reqs := []sophrosyne.AuthorizationRequest{
+		{Principal: admin, Action: sophrosyne.AuthorizationAction("GetProfile")},
+		{Principal: admin, Action: sophrosyne.AuthorizationAction("DeleteProfile")},
+		{Principal: regular, Action: sophrosyne.AuthorizationAction("GetProfile")},
+		{Principal: regular, Action: sophrosyne.AuthorizationAction("DeleteProfile")},
+		{Principal: sophrosyne.User{ID: "3"}, Action: sophrosyne.AuthorizationAction("GetProfile")},
+	}
+
+	got, err := a.SimulateAuthorization(ctx, candidate, reqs)
+	require.NoError(t, err)
+	require.Equal(t, []sophrosyne.SimulatedAuthzDecision{
+		{Current: true, Candidate: true},
+		{Current: true, Candidate: false, Changed: true},
+		{Current: false, Candidate: true, Changed: true},
+		{Current: false, Candidate: false},
+		{Current: false, Candidate: false},
+	}, got)
+
+	// The candidate policies must not have been installed.
+	require.True(t, a.IsAuthorized(ctx, reqs[1]))
+	require.False(t, a.IsAuthorized(ctx, reqs[2]))
+
+	t.Run("invalid policies", func(t *testing.T) {
+		_, err := a.SimulateAuthorization(ctx, []byte("permit ("), reqs)
+		require.Error(t, err)
+	})
+}
+
+func TestAuthorizationProvider_IsAuthorized_Audit(t *testing.T) {
+	ctx := context.Background()
+	admin := sophrosyne.User{ID: "1", IsAdmin: true}
+	regular := sophrosyne.User{ID: "2"}
+	profile := sophrosyne.Profile{ID: "3", Name: "default"}
+
+	newProvider := func(t *testing.T, config *sophrosyne.Config, buf *bytes.Buffer) *AuthorizationProvider {
+		span := sophrosyne2.NewMockSpan(t)
+		span.EXPECT().End().Return()
+		tracingService := sophrosyne2.NewMockTracingService(t)
+		tracingService.EXPECT().StartSpan(mock.Anything, mock.Anything).Return(ctx, span)
+		userService := sophrosyne2.NewMockUserService(t)
+		userService.EXPECT().GetUser(mock.Anything, admin.ID).Return(admin, nil).Maybe()
+		userService.EXPECT().GetUser(mock.Anything, regular.ID).Return(regular, nil).Maybe()
+		profileService := sophrosyne2.NewMockProfileService(t)
+		profileService.EXPECT().GetProfile(mock.Anything, profile.ID).Return(profile, nil).Maybe()
+		a, err := NewAuthorizationProvider(ctx, config, slog.New(slog.NewJSONHandler(buf, nil)), userService, tracingService, profileService, nil)
+		require.NoError(t, err)
+		return a
+	}
+
+	type entry struct {
+		Level     string `json:"level"`
+		Msg       string `json:"msg"`
+		Principal string `json:"principal"`
+		Action    string `json:"action"`
+		Resource  struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		} `json:"resource"`
+		Result   string `json:"result"`
+		Policies []int  `json:"policies"`
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		config := &sophrosyne.Config{}
+		config.Logging.AuthzAudit = true
+		var buf bytes.Buffer
+		a := newProvider(t, config, &buf)
+
+		require.True(t, a.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{Principal: admin, Action: sophrosyne.AuthorizationAction("Profiles::GetProfile"), Resource: profile}))
+		require.False(t, a.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{Principal: regular, Action: sophrosyne.AuthorizationAction("Profiles::GetProfile")}))
+
+		lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+		require.Len(t, lines, 2)
+		var allowed, denied entry
+		require.NoError(t, json.Unmarshal(lines[0], &allowed))
+		require.NoError(t, json.Unmarshal(lines[1], &denied))
+
+		require.Equal(t, "INFO", allowed.Level)
+		require.Equal(t, "authorization decision", allowed.Msg)
+		require.Equal(t, "1", allowed.Principal)
+		require.Equal(t, "Profiles::GetProfile", allowed.Action)
+		require.Equal(t, "Profile", allowed.Resource.Type)
+		require.Equal(t, "3", allowed.Resource.ID)
+		require.Equal(t, "allow", allowed.Result)
+		require.Equal(t, []int{2}, allowed.Policies)
+
+		require.Equal(t, "2", denied.Principal)
+		require.Empty(t, denied.Resource.Type)
+		require.Equal(t, "deny", denied.Result)
+		require.Empty(t, denied.Policies)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var buf bytes.Buffer
+		a := newProvider(t, &sophrosyne.Config{}, &buf)
+
+		require.True(t, a.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{Principal: admin, Action: sophrosyne.AuthorizationAction("Profiles::GetProfile")}))
+		require.Empty(t, buf.String())
+	})
+}
@@ -18,6 +18,7 @@ package configProvider
 
 import (
 	"encoding/hex"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -48,7 +49,7 @@ func envExtractor(s string, v string) (string, interface{}) {
 	return key, v
 }
 
-func loadConfig(k *koanf.Koanf, defaultConfig map[string]interface{}, yamlFile koanf.Provider, overwrites map[string]interface{}, secretFiles []string) error {
+func loadConfig(k *koanf.Koanf, defaultConfig map[string]interface{}, yamlFile koanf.Provider, overwrites map[string]interface{}, secretFiles []string, secretsDir string) error {
 	_ = k.Load(confmap.Provider(defaultConfig, sophrosyne.ConfigDelimiter), nil)
 
 	if err := loadYamlConfig(k, yamlFile); err != nil {
@@ -59,7 +60,13 @@ func loadConfig(k *koanf.Koanf, defaultConfig map[string]interface{}, yamlFile k
 
 	_ = k.Load(confmap.Provider(overwrites, sophrosyne.ConfigDelimiter), nil)
 
-	for _, secretFile := range secretFiles {
+	// Files given explicitly take precedence over the ones in secretsDir
+	dirFiles, err := secretFilesInDir(secretsDir)
+	if err != nil {
+		return err
+	}
+
+	for _, secretFile := range append(dirFiles, secretFiles...) {
 		secret, err := secretFromFile(secretFile)
 		if err != nil {
 			return err
@@ -78,60 +85,96 @@ func loadYamlConfig(k *koanf.Koanf, yamlFile koanf.Provider) error {
 }
 
 type ConfigProvider struct {
-	k        *koanf.Koanf
-	config   *sophrosyne.Config
-	validate sophrosyne.Validator
-	mu       sync.Mutex
+	k           *koanf.Koanf
+	config      *sophrosyne.Config
+	validate    sophrosyne.Validator
+	mu          sync.Mutex
+	yamlFile    *file.File
+	overwrites  map[string]interface{}
+	secretFiles []string
+	secretsDir  string
 }
 
-func NewConfigProvider(yamlFilePath string, overwrites map[string]interface{}, secretFiles []string, validator sophrosyne.Validator) (*ConfigProvider, error) {
+// NewConfigProvider loads the configuration and, unless disableWatch is set,
+// reloads it whenever the YAML file changes.
+//
+// Every file in secretsDir, if not empty, is read like the secretFiles, which
+// take precedence.
+func NewConfigProvider(yamlFilePath string, overwrites map[string]interface{}, secretFiles []string, secretsDir string, validator sophrosyne.Validator, disableWatch bool) (*ConfigProvider, error) {
 	cfgProv := &ConfigProvider{
-		config:   &sophrosyne.Config{},
-		k:        koanf.New(sophrosyne.ConfigDelimiter),
-		validate: validator,
+		config:      &sophrosyne.Config{},
+		k:           koanf.New(sophrosyne.ConfigDelimiter),
+		validate:    validator,
+		yamlFile:    file.Provider(yamlFilePath),
+		overwrites:  overwrites,
+		secretFiles: secretFiles,
+		secretsDir:  secretsDir,
 	}
 
-	yamlFile := file.Provider(yamlFilePath)
+	if err := loadConfig(cfgProv.k, sophrosyne.DefaultConfig, cfgProv.yamlFile, overwrites, secretFiles, secretsDir); err != nil {
+		return nil, err
+	}
 
-	if err := loadConfig(cfgProv.k, sophrosyne.DefaultConfig, yamlFile, overwrites, secretFiles); err != nil {
+	_ = cfgProv.k.UnmarshalWithConf("", cfgProv.config, koanf.UnmarshalConf{Tag: "key"})
+
+	err := cfgProv.validate.Validate(cfgProv.config)
+	if err != nil {
 		return nil, err
 	}
 
-	_ = yamlFile.Watch(func(event interface{}, err error) {
+	if !disableWatch {
+		cfgProv.watch()
+	}
+
+	return cfgProv, nil
+}
+
+// watch reloads the configuration whenever the YAML file changes. Invalid
+// configurations are ignored, leaving the previous configuration in place.
+func (c *ConfigProvider) watch() {
+	_ = c.yamlFile.Watch(func(event interface{}, err error) {
 		if err != nil {
 			// Error occurred when watching the file.
 			return
 		}
-		// We have to reload not just the yaml file, but everything else as well.
-		// If we do not, we risk that values that have been removed from the
-		// yaml file are still present in the config.
-		err = loadConfig(cfgProv.k, sophrosyne.DefaultConfig, yamlFile, overwrites, secretFiles)
-		if err != nil {
-			// Error occurred when reloading the yaml file.
-			return
-		}
-		newConf := &sophrosyne.Config{}
-		_ = cfgProv.k.UnmarshalWithConf("", newConf, koanf.UnmarshalConf{Tag: "key"})
-		err = cfgProv.validate.Validate(newConf)
-		if err != nil {
-			// Error occurred when validating the config.
-			return
-		}
-		// Reuse the existing pointer (as this is what the user is already
-		// using) and just copy the new values over.
-		cfgProv.mu.Lock()
-		defer cfgProv.mu.Unlock()
-		*cfgProv.config = *newConf
+		_ = c.Reload()
 	})
+}
 
-	_ = cfgProv.k.UnmarshalWithConf("", cfgProv.config, koanf.UnmarshalConf{Tag: "key"})
-
-	err := cfgProv.validate.Validate(cfgProv.config)
+// Reload loads the configuration again from all of its sources. If the new
+// configuration cannot be loaded or is invalid, by its struct tags or by
+// [sophrosyne.Config.Validate], the error is returned and the previous
+// configuration is left in place.
+//
+// Reloads are serialized, as they are started both by the file watcher and
+// by signals.
+func (c *ConfigProvider) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// We have to reload not just the yaml file, but everything else as well,
+	// into a new instance. If we do not, we risk that values that have been
+	// removed from the yaml file are still present in the config.
+	k := koanf.New(sophrosyne.ConfigDelimiter)
+	err := loadConfig(k, sophrosyne.DefaultConfig, c.yamlFile, c.overwrites, c.secretFiles, c.secretsDir)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	return cfgProv, nil
+	newConf := &sophrosyne.Config{}
+	_ = k.UnmarshalWithConf("", newConf, koanf.UnmarshalConf{Tag: "key"})
+	err = c.validate.Validate(newConf)
+	if err != nil {
+		return err
+	}
+	err = newConf.Validate()
+	if err != nil {
+		return err
+	}
+	c.k = k
+	// Reuse the existing pointer (as this is what the user is already
+	// using) and just copy the new values over.
+	*c.config = *newConf
+	return nil
 }
 
 func (c *ConfigProvider) Get() *sophrosyne.Config {
@@ -154,3 +197,36 @@ func secretFromFile(filePath string) (map[string]interface{}, error) {
 
 	return out, nil
 }
+
+// secretFilesInDir returns the paths of the files in dir, to be read with
+// secretFromFile. Hidden entries are skipped, such as the ..data directory
+// Kubernetes mounts secrets with, as are directories. An empty dir has no
+// files.
+func secretFilesInDir(dir string) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		// Stat follows symlinks, which Kubernetes uses for the files
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
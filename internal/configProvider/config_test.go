@@ -20,7 +20,9 @@ package configProvider
 
 import (
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -172,6 +174,7 @@ func TestLoadConfig(t *testing.T) {
 		file.Provider(testYamlFilePath),
 		nil,
 		[]string{testFilePath},
+		"",
 	)
 	require.NoError(t, err)
 
@@ -212,12 +215,21 @@ func TestLoadConfigErr(t *testing.T) {
 				file.Provider(tc.yamlFile),
 				nil,
 				tc.secretFiles,
+				"",
 			)
 			require.Error(t, err)
 		})
 	}
 }
 
+// loadedString returns the string at key in the configuration loaded by c,
+// holding the lock that reloads take.
+func loadedString(c *ConfigProvider, key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.k.String(key)
+}
+
 func TestNewConfigProvider(t *testing.T) {
 	initialPw := "password"
 	yamlContent := []byte(`database:
@@ -228,10 +240,10 @@ func TestNewConfigProvider(t *testing.T) {
 	err := os.WriteFile(tempFile, yamlContent, 0644)
 	require.NoError(t, err)
 
-	c, err := NewConfigProvider(tempFile, nil, []string{securitySaltFilePath, securitySiteKeyFilePath}, validator.NewValidator())
+	c, err := NewConfigProvider(tempFile, nil, []string{securitySaltFilePath, securitySiteKeyFilePath}, "", validator.NewValidator(), false)
 	require.NoError(t, err)
 
-	require.Equal(t, initialPw, c.k.String(databasePasswordKey))
+	require.Equal(t, initialPw, loadedString(c, databasePasswordKey))
 
 	newYamlContent := []byte(`database:
   password: ` + newPasswordString)
@@ -242,7 +254,7 @@ func TestNewConfigProvider(t *testing.T) {
 	// change and reload the config
 	time.Sleep(100 * time.Millisecond)
 
-	assert.Equal(t, newPasswordString, c.k.String(databasePasswordKey))
+	assert.Equal(t, newPasswordString, loadedString(c, databasePasswordKey))
 
 	badYamlContent := []byte{0x00, 0x01, 0x02}
 	err = os.WriteFile(tempFile, badYamlContent, 0644)
@@ -252,11 +264,39 @@ func TestNewConfigProvider(t *testing.T) {
 
 	// The bad yaml content should not have been loaded and thus the previous
 	// value should still be present.
-	assert.Equal(t, newPasswordString, c.k.String(databasePasswordKey))
+	assert.Equal(t, newPasswordString, loadedString(c, databasePasswordKey))
+}
+
+func TestNewConfigProviderDisableWatch(t *testing.T) {
+	initialPw := "password"
+	yamlContent := []byte(`database:
+  password: ` + initialPw)
+
+	tempDir := t.TempDir()
+	tempFile := tempDir + rootConfigYamlPath
+	err := os.WriteFile(tempFile, yamlContent, 0644)
+	require.NoError(t, err)
+
+	c, err := NewConfigProvider(tempFile, nil, []string{securitySaltFilePath, securitySiteKeyFilePath}, "", validator.NewValidator(), true)
+	require.NoError(t, err)
+
+	cfg := c.Get()
+	require.Equal(t, initialPw, cfg.Database.Password)
+
+	newYamlContent := []byte(`database:
+  password: ` + newPasswordString)
+	err = os.WriteFile(tempFile, newYamlContent, 0644)
+	require.NoError(t, err)
+
+	// Give a watcher, had there been one, time to reload the config.
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, initialPw, loadedString(c, databasePasswordKey))
+	assert.Equal(t, initialPw, cfg.Database.Password)
 }
 
 func TestNewConfigProviderErrorNoYamlFile(t *testing.T) {
-	c, err := NewConfigProvider(nonExistentYamlFilePath, nil, []string{testFilePath}, nil)
+	c, err := NewConfigProvider(nonExistentYamlFilePath, nil, []string{testFilePath}, "", nil, false)
 	require.Error(t, err)
 	require.Nil(t, c)
 }
@@ -270,7 +310,7 @@ func TestNewConfigProviderErrorUpdateFailValidate(t *testing.T) {
 	err = os.WriteFile(tempFile, yamlContent, 0644)
 	require.NoError(t, err)
 
-	c, err := NewConfigProvider(tempFile, nil, []string{securitySaltFilePath, securitySiteKeyFilePath}, validator.NewValidator())
+	c, err := NewConfigProvider(tempFile, nil, []string{securitySaltFilePath, securitySiteKeyFilePath}, "", validator.NewValidator(), false)
 	require.NoError(t, err)
 
 	cfg := c.Get()
@@ -298,7 +338,7 @@ func TestNewConfigProviderErrorValidateYamlFile(t *testing.T) {
 	err := os.WriteFile(tempFile, yamlContent, 0644)
 	require.NoError(t, err)
 
-	c, err := NewConfigProvider(tempFile, nil, []string{testFilePath}, validator.NewValidator())
+	c, err := NewConfigProvider(tempFile, nil, []string{testFilePath}, "", validator.NewValidator(), false)
 	require.Error(t, err)
 	require.Nil(t, c)
 }
@@ -312,7 +352,7 @@ func TestConfigProviderGet(t *testing.T) {
 	err = os.WriteFile(tempFile, yamlContent, 0644)
 	require.NoError(t, err)
 
-	c, err := NewConfigProvider(tempFile, nil, []string{securitySaltFilePath, securitySiteKeyFilePath}, validator.NewValidator())
+	c, err := NewConfigProvider(tempFile, nil, []string{securitySaltFilePath, securitySiteKeyFilePath}, "", validator.NewValidator(), false)
 	require.NoError(t, err)
 
 	cfg := c.Get()
@@ -323,3 +363,131 @@ func TestConfigProviderGet(t *testing.T) {
 	require.Equal(t, 5432, cfg.Database.Port)
 	require.Equal(t, "postgres", cfg.Database.Name)
 }
+
+func TestSecretFilesInDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, databasePasswordKey), []byte("from dir"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, databaseUserKey), []byte("dir-user"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden"), []byte("hidden"), 0600))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "..data"), 0700))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested"), 0700))
+
+	files, err := secretFilesInDir(dir)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{filepath.Join(dir, databasePasswordKey), filepath.Join(dir, databaseUserKey)}, files)
+
+	files, err = secretFilesInDir("")
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	_, err = secretFilesInDir(filepath.Join(dir, "non-existent"))
+	require.Error(t, err)
+}
+
+func TestLoadConfigSecretsDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, databasePasswordKey), []byte("from dir"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, databaseUserKey), []byte("dir-user"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test.file"), []byte("overridden"), 0600))
+
+	k := koanf.New(sophrosyne.ConfigDelimiter)
+	err := loadConfig(
+		k,
+		map[string]interface{}{},
+		file.Provider(testYamlFilePath),
+		nil,
+		[]string{testFilePath},
+		dir,
+	)
+	require.NoError(t, err)
+
+	require.Equal(t, []byte("from dir"), k.Get(databasePasswordKey))
+	require.Equal(t, []byte("dir-user"), k.Get(databaseUserKey))
+	require.Equal(t, "localhost", k.String("database.host"), "values without a file are left as they are")
+
+	content, err := os.ReadFile(testFilePath)
+	require.NoError(t, err)
+	require.Equal(t, content, k.Get("test.file"), "secret files take precedence over the directory")
+}
+
+func TestNewConfigProviderSecretsDir(t *testing.T) {
+	tempDir := t.TempDir()
+	tempFile := tempDir + rootConfigYamlPath
+	require.NoError(t, os.WriteFile(tempFile, []byte(`database:
+  password: from-yaml`), 0644))
+
+	secretsDir := filepath.Join(tempDir, "secrets")
+	require.NoError(t, os.Mkdir(secretsDir, 0700))
+	for _, f := range []string{securitySaltFilePath, securitySiteKeyFilePath} {
+		content, err := os.ReadFile(f)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(secretsDir, filepath.Base(f)), content, 0600))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(secretsDir, databasePasswordKey), []byte(newPasswordString), 0600))
+
+	c, err := NewConfigProvider(tempFile, nil, nil, secretsDir, validator.NewValidator(), true)
+	require.NoError(t, err)
+	require.Equal(t, newPasswordString, c.Get().Database.Password)
+	siteKey, err := os.ReadFile(securitySiteKeyFilePath)
+	require.NoError(t, err)
+	require.Equal(t, siteKey, c.Get().Security.SiteKey)
+}
+
+func TestConfigProviderReload(t *testing.T) {
+	yamlContent := []byte(`database:
+  password: password`)
+
+	tempDir := t.TempDir()
+	tempFile := tempDir + rootConfigYamlPath
+	require.NoError(t, os.WriteFile(tempFile, yamlContent, 0644))
+
+	c, err := NewConfigProvider(tempFile, nil, []string{securitySaltFilePath, securitySiteKeyFilePath}, "", validator.NewValidator(), true)
+	require.NoError(t, err)
+	cfg := c.Get()
+
+	require.NoError(t, os.WriteFile(tempFile, []byte(`database:
+  password: `+newPasswordString), 0644))
+	require.NoError(t, c.Reload())
+	require.Equal(t, newPasswordString, cfg.Database.Password, "reloading must update the existing config")
+
+	require.NoError(t, os.WriteFile(tempFile, []byte(`database:
+  password: ""`), 0644))
+	require.Error(t, c.Reload())
+	require.Equal(t, newPasswordString, cfg.Database.Password, "an invalid config must not replace the existing one")
+
+	// Passes the struct tags, but not sophrosyne.Config.Validate.
+	require.NoError(t, os.WriteFile(tempFile, []byte(`database:
+  password: other-password
+  readRetry:
+    initialBackoff: 2s
+    maxBackoff: 1s`), 0644))
+	var fieldErr sophrosyne.ConfigFieldError
+	require.ErrorAs(t, c.Reload(), &fieldErr)
+	require.Equal(t, "database.readRetry.initialBackoff", fieldErr.Field)
+	require.Equal(t, newPasswordString, cfg.Database.Password, "an invalid config must not replace the existing one")
+}
+
+func TestConfigProviderReloadConcurrently(t *testing.T) {
+	tempDir := t.TempDir()
+	tempFile := tempDir + rootConfigYamlPath
+	require.NoError(t, os.WriteFile(tempFile, []byte(`database:
+  password: `+newPasswordString), 0644))
+
+	c, err := NewConfigProvider(tempFile, nil, []string{securitySaltFilePath, securitySiteKeyFilePath}, "", validator.NewValidator(), true)
+	require.NoError(t, err)
+
+	// Reloads are started by both the file watcher and signals. Run with
+	// -race to detect unsynchronized access.
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, c.Reload())
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, newPasswordString, c.Get().Database.Password)
+	require.Equal(t, newPasswordString, loadedString(c, databasePasswordKey))
+}
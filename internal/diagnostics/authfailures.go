@@ -0,0 +1,47 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diagnostics
+
+import (
+	"context"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+// AuthFailures is a fixed size ring buffer of recent authentication failures.
+// Once full, recording a failure evicts the oldest one.
+type AuthFailures struct {
+	events *ring[sophrosyne.AuthFailureEvent]
+}
+
+func NewAuthFailures(size int) (*AuthFailures, error) {
+	events, err := newRing[sophrosyne.AuthFailureEvent](size)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthFailures{events: events}, nil
+}
+
+// RecordAuthFailure records event.
+func (a *AuthFailures) RecordAuthFailure(ctx context.Context, event sophrosyne.AuthFailureEvent) {
+	a.events.add(event)
+}
+
+// RecentAuthFailures returns the recorded failures, newest first.
+func (a *AuthFailures) RecentAuthFailures(ctx context.Context) []sophrosyne.AuthFailureEvent {
+	return a.events.recent()
+}
@@ -0,0 +1,55 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package diagnostics
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+func TestNewAuthFailures(t *testing.T) {
+	_, err := NewAuthFailures(0)
+	require.Error(t, err)
+}
+
+func TestAuthFailures_RecordAuthFailure(t *testing.T) {
+	ctx := context.Background()
+	a, err := NewAuthFailures(3)
+	require.NoError(t, err)
+
+	require.Empty(t, a.RecentAuthFailures(ctx))
+
+	for i := range 5 {
+		a.RecordAuthFailure(ctx, sophrosyne.AuthFailureEvent{
+			Source: fmt.Sprintf("192.0.2.%d", i),
+			Reason: sophrosyne.AuthFailureInvalidToken,
+		})
+	}
+
+	var sources []string
+	for _, e := range a.RecentAuthFailures(ctx) {
+		sources = append(sources, e.Source)
+	}
+	require.Equal(t, []string{"192.0.2.4", "192.0.2.3", "192.0.2.2"}, sources)
+}
@@ -0,0 +1,86 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diagnostics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+// maxMessageLength is the maximum length, in bytes, of a recorded message.
+const maxMessageLength = 256
+
+type stackTracer interface {
+	Stack() string
+}
+
+// RecentErrors is a fixed size ring buffer of recently recorded panics and
+// internal errors. Once full, recording an error evicts the oldest one.
+type RecentErrors struct {
+	events *ring[sophrosyne.ErrorEvent]
+	now    func() time.Time
+}
+
+func NewRecentErrors(size int) (*RecentErrors, error) {
+	events, err := newRing[sophrosyne.ErrorEvent](size)
+	if err != nil {
+		return nil, err
+	}
+	return &RecentErrors{
+		events: events,
+		now:    time.Now,
+	}, nil
+}
+
+// RecordError records err as having happened while handling method.
+func (r *RecentErrors) RecordError(ctx context.Context, method string, err error) {
+	event := sophrosyne.ErrorEvent{
+		Time:    r.now(),
+		Method:  method,
+		Message: redact(err),
+	}
+	var st stackTracer
+	if errors.As(err, &st) {
+		event.Stack = st.Stack()
+	}
+	r.events.add(event)
+}
+
+// RecentErrors returns the recorded errors, newest first.
+func (r *RecentErrors) RecentErrors(ctx context.Context) []sophrosyne.ErrorEvent {
+	return r.events.recent()
+}
+
+// redact reduces the message of err to its first line, truncated to
+// maxMessageLength bytes. The remainder of a message, such as a stack trace
+// or a dump of the offending input, is not kept.
+func redact(err error) string {
+	msg := err.Error()
+	var panicErr *sophrosyne.PanicError
+	if errors.As(err, &panicErr) {
+		msg = panicErr.Reason()
+	}
+	msg, _, _ = strings.Cut(msg, "\n")
+	if len(msg) > maxMessageLength {
+		msg = strings.ToValidUTF8(msg[:maxMessageLength], "")
+	}
+	return msg
+}
@@ -0,0 +1,83 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package diagnostics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+func TestNewRecentErrors(t *testing.T) {
+	_, err := NewRecentErrors(0)
+	require.Error(t, err)
+}
+
+func TestRecentErrors_RecordError(t *testing.T) {
+	ctx := context.Background()
+	r, err := NewRecentErrors(3)
+	require.NoError(t, err)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	require.Empty(t, r.RecentErrors(ctx))
+
+	r.RecordError(ctx, "Scans::PerformScan", sophrosyne.NewPanicError("boom"))
+	got := r.RecentErrors(ctx)
+	require.Len(t, got, 1)
+	require.Equal(t, now, got[0].Time)
+	require.Equal(t, "Scans::PerformScan", got[0].Method)
+	require.Equal(t, "boom", got[0].Message)
+	require.Contains(t, got[0].Stack, "runtime/debug.Stack")
+
+	r.RecordError(ctx, "/v1/rpc", errors.New("first line\nsecret details"))
+	got = r.RecentErrors(ctx)
+	require.Len(t, got, 2)
+	require.Equal(t, "first line", got[0].Message)
+	require.Empty(t, got[0].Stack)
+	require.Equal(t, "boom", got[1].Message)
+}
+
+func TestRecentErrors_Eviction(t *testing.T) {
+	ctx := context.Background()
+	r, err := NewRecentErrors(3)
+	require.NoError(t, err)
+
+	for i := range 5 {
+		r.RecordError(ctx, "method", fmt.Errorf("error %d", i))
+	}
+
+	var messages []string
+	for _, e := range r.RecentErrors(ctx) {
+		messages = append(messages, e.Message)
+	}
+	require.Equal(t, []string{"error 4", "error 3", "error 2"}, messages)
+}
+
+func TestRedact(t *testing.T) {
+	require.Len(t, redact(errors.New(strings.Repeat("a", 1000))), maxMessageLength)
+	require.Equal(t, "panic encountered", redact(sophrosyne.NewPanicError("panic encountered\nwith input")))
+}
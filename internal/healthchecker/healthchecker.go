@@ -18,17 +18,27 @@ package healthchecker
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/madsrc/sophrosyne"
 )
 
 type HealthCheckService struct {
-	services []sophrosyne.HealthChecker
+	config            *sophrosyne.Config
+	services          map[string]sophrosyne.HealthChecker
+	authenticatedOnly map[string]sophrosyne.HealthChecker
 }
 
-func NewHealthcheckService(services []sophrosyne.HealthChecker) (*HealthCheckService, error) {
+// NewHealthcheckService returns a HealthCheckService reporting on services,
+// keyed by the name of the subsystem they represent. The services in
+// authenticatedOnly are only checked by AuthenticatedHealthcheck, keeping
+// checks that are expensive or depend on external systems out of the
+// unauthenticated liveness check.
+func NewHealthcheckService(config *sophrosyne.Config, services map[string]sophrosyne.HealthChecker, authenticatedOnly map[string]sophrosyne.HealthChecker) (*HealthCheckService, error) {
 	return &HealthCheckService{
-		services: services,
+		config:            config,
+		services:          services,
+		authenticatedOnly: authenticatedOnly,
 	}, nil
 }
 
@@ -42,7 +52,38 @@ func (h HealthCheckService) UnauthenticatedHealthcheck(ctx context.Context) bool
 	return true
 }
 
+type healthResponse struct {
+	Healthy  bool                         `json:"healthy"`
+	Services map[string]subsystemResponse `json:"services,omitempty"`
+}
+
+type subsystemResponse struct {
+	Healthy bool            `json:"healthy"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// AuthenticatedHealthcheck returns the health of the service as JSON. The
+// health of each subsystem is only included when [sophrosyne.Config.Health]
+// asks for [sophrosyne.HealthDetailDetailed].
 func (h HealthCheckService) AuthenticatedHealthcheck(ctx context.Context) ([]byte, error) {
-	//TODO implement me
-	panic("implement me")
+	detailed := h.config.Health.Detail == sophrosyne.HealthDetailDetailed
+	resp := healthResponse{Healthy: true}
+	if detailed {
+		resp.Services = make(map[string]subsystemResponse, len(h.services)+len(h.authenticatedOnly))
+	}
+	for _, services := range []map[string]sophrosyne.HealthChecker{h.services, h.authenticatedOnly} {
+		for name, service := range services {
+			ok, detail := service.Health(ctx)
+			resp.Healthy = resp.Healthy && ok
+			if !detailed {
+				continue
+			}
+			sub := subsystemResponse{Healthy: ok}
+			if json.Valid(detail) {
+				sub.Detail = detail
+			}
+			resp.Services[name] = sub
+		}
+	}
+	return json.Marshal(resp)
 }
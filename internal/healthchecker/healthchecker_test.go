@@ -0,0 +1,105 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package healthchecker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+)
+
+func TestHealthCheckService_AuthenticatedHealthcheck(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		detail sophrosyne.HealthDetail
+		dbOK   bool
+		want   string
+	}{
+		{
+			name:   "minimal",
+			detail: sophrosyne.HealthDetailMinimal,
+			dbOK:   true,
+			want:   `{"healthy":true}`,
+		},
+		{
+			name:   "minimal unhealthy",
+			detail: sophrosyne.HealthDetailMinimal,
+			want:   `{"healthy":false}`,
+		},
+		{
+			name:   "detailed",
+			detail: sophrosyne.HealthDetailDetailed,
+			dbOK:   true,
+			want:   `{"healthy":true,"services":{"cache":{"healthy":true},"database":{"healthy":true,"detail":{"users":{"healthy":true}}}}}`,
+		},
+		{
+			name:   "detailed unhealthy",
+			detail: sophrosyne.HealthDetailDetailed,
+			want:   `{"healthy":false,"services":{"cache":{"healthy":true},"database":{"healthy":false,"detail":{"users":{"healthy":false}}}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &sophrosyne.Config{}
+			config.Health.Detail = tt.detail
+
+			db := sophrosyne2.NewMockHealthChecker(t)
+			if tt.dbOK {
+				db.EXPECT().Health(ctx).Return(true, []byte(`{"users":{"healthy":true}}`))
+			} else {
+				db.EXPECT().Health(ctx).Return(false, []byte(`{"users":{"healthy":false}}`))
+			}
+			cache := sophrosyne2.NewMockHealthChecker(t)
+			cache.EXPECT().Health(ctx).Return(true, []byte(`not json`))
+
+			h, err := NewHealthcheckService(config, map[string]sophrosyne.HealthChecker{"cache": cache, "database": db}, nil)
+			require.NoError(t, err)
+
+			got, err := h.AuthenticatedHealthcheck(ctx)
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestHealthCheckService_AuthenticatedOnly(t *testing.T) {
+	ctx := context.Background()
+	config := &sophrosyne.Config{}
+	config.Health.Detail = sophrosyne.HealthDetailDetailed
+
+	db := sophrosyne2.NewMockHealthChecker(t)
+	db.EXPECT().Health(ctx).Return(true, nil)
+	providers := sophrosyne2.NewMockHealthChecker(t)
+
+	h, err := NewHealthcheckService(config, map[string]sophrosyne.HealthChecker{"database": db}, map[string]sophrosyne.HealthChecker{"providers": providers})
+	require.NoError(t, err)
+
+	// The mock fails the test if the providers are checked.
+	require.True(t, h.UnauthenticatedHealthcheck(ctx))
+
+	providers.EXPECT().Health(ctx).Return(false, []byte(`{"providers":{}}`)).Once()
+	got, err := h.AuthenticatedHealthcheck(ctx)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"healthy":false,"services":{"database":{"healthy":true},"providers":{"healthy":false,"detail":{"providers":{}}}}}`, string(got))
+}
@@ -19,6 +19,8 @@ package http
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -28,6 +30,8 @@ import (
 	"strings"
 	"time"
 
+	"golang.org/x/net/netutil"
+
 	"github.com/madsrc/sophrosyne"
 )
 
@@ -68,13 +72,55 @@ func NewServer(ctx context.Context, appConfig *sophrosyne.Config, validator soph
 }
 
 func (s *Server) Start() error {
-	s.logger.Info("Starting server", "port", s.appConfig.Server.Port)
-	return s.http.ListenAndServeTLS("", "")
+	s.logger.Info("Starting server", "port", s.appConfig.Server.Port, "maxConnections", s.appConfig.Server.MaxConnections)
+	ln, err := newListener(s.http.Addr, s.appConfig.Server.MaxConnections)
+	if err != nil {
+		return err
+	}
+	return s.http.ServeTLS(ln, "", "")
+}
+
+// newListener creates a TCP listener on addr. If maxConnections is greater
+// than 0, the listener will accept at most maxConnections simultaneous
+// connections. Connections beyond that wait until an existing connection is
+// closed.
+func newListener(addr string, maxConnections int) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if maxConnections > 0 {
+		ln = netutil.LimitListener(ln, maxConnections)
+	}
+	return ln, nil
 }
 
+// Shutdown gracefully shuts down the server. Connections still open once
+// server.shutdownTimeout has elapsed are closed forcibly.
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.logger.InfoContext(ctx, "Shutting down server")
-	return s.http.Shutdown(ctx)
+	return shutdownWithTimeout(ctx, s.logger, s.http, s.appConfig.Server.ShutdownTimeout)
+}
+
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// shutdownWithTimeout gracefully shuts down srv, falling back to closing it
+// if that takes longer than timeout. A timeout of 0 waits indefinitely.
+func shutdownWithTimeout(ctx context.Context, logger *slog.Logger, srv shutdowner, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger.WarnContext(ctx, "graceful shutdown timed out, closing remaining connections", "timeout", timeout)
+		return srv.Close()
+	}
+	return err
 }
 
 func (s *Server) Handle(path string, handler http.Handler) {
@@ -93,6 +139,9 @@ func RPCHandler(logger *slog.Logger, rpcService sophrosyne.RPCServer, config *so
 			WriteInternalServerError(r.Context(), w, logger)
 			return
 		}
+		if sophrosyne.RequestFeatureEnabled(r.Context(), sophrosyne.RequestFeatureEchoParams) {
+			logger.InfoContext(r.Context(), "echoing rpc request", "body", string(body))
+		}
 		b, err := rpcService.HandleRPCRequest(r.Context(), body)
 		if err != nil {
 			logger.ErrorContext(r.Context(), "error handling rpc request", "error", err)
@@ -103,6 +152,19 @@ func RPCHandler(logger *slog.Logger, rpcService sophrosyne.RPCServer, config *so
 	})
 }
 
+// SchemaHandler serves schema, describing the RPC methods, as JSON.
+func SchemaHandler(logger *slog.Logger, schema any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := json.Marshal(schema)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "unable to marshal rpc schema", "error", err)
+			WriteInternalServerError(r.Context(), w, logger)
+			return
+		}
+		WriteResponse(r.Context(), w, http.StatusOK, JSONContentType, b, logger)
+	})
+}
+
 func HealthcheckHandler(logger *slog.Logger, healthcheckService sophrosyne.HealthCheckService) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ok := healthcheckService.UnauthenticatedHealthcheck(r.Context())
@@ -115,6 +177,21 @@ func HealthcheckHandler(logger *slog.Logger, healthcheckService sophrosyne.Healt
 	})
 }
 
+// AuthenticatedHealthcheckHandler serves the health of the service, in the
+// detail allowed by the configuration. It must only be served to
+// authenticated users.
+func AuthenticatedHealthcheckHandler(logger *slog.Logger, healthcheckService sophrosyne.HealthCheckService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := healthcheckService.AuthenticatedHealthcheck(r.Context())
+		if err != nil {
+			logger.ErrorContext(r.Context(), "unable to perform healthcheck", "error", err)
+			WriteInternalServerError(r.Context(), w, logger)
+			return
+		}
+		WriteResponse(r.Context(), w, http.StatusOK, JSONContentType, b, logger)
+	})
+}
+
 func WriteResponse(ctx context.Context, w http.ResponseWriter, status int, contentType string, data []byte, logger *slog.Logger) {
 	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
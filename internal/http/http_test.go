@@ -0,0 +1,179 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package http
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+)
+
+func TestNewListener_MaxConnections(t *testing.T) {
+	const maxConnections = 2
+
+	ln, err := newListener("127.0.0.1:0", maxConnections)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, maxConnections+1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	for i := 0; i < maxConnections+1; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+	}
+
+	var conns []net.Conn
+	for i := 0; i < maxConnections; i++ {
+		select {
+		case conn := <-accepted:
+			conns = append(conns, conn)
+		case <-time.After(time.Second):
+			t.Fatalf("expected connection %d to be accepted", i+1)
+		}
+	}
+
+	select {
+	case <-accepted:
+		t.Fatal("accepted more connections than the limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Closing an accepted connection frees up room for the waiting one.
+	require.NoError(t, conns[0].Close())
+	select {
+	case conn := <-accepted:
+		require.NoError(t, conn.Close())
+	case <-time.After(time.Second):
+		t.Fatal("expected waiting connection to be accepted")
+	}
+	require.NoError(t, conns[1].Close())
+}
+
+func TestNewListener_Unlimited(t *testing.T) {
+	ln, err := newListener("127.0.0.1:0", 0)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	_, ok := ln.(*net.TCPListener)
+	require.True(t, ok)
+}
+
+type fakeShutdowner struct {
+	block  bool // Shutdown waits for the context to be done
+	closed bool
+}
+
+func (f *fakeShutdowner) Shutdown(ctx context.Context) error {
+	if !f.block {
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeShutdowner) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestShutdownWithTimeout(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("drained in time", func(t *testing.T) {
+		srv := &fakeShutdowner{}
+		require.NoError(t, shutdownWithTimeout(context.Background(), logger, srv, time.Second))
+		require.False(t, srv.closed)
+	})
+
+	t.Run("timeout forces close", func(t *testing.T) {
+		srv := &fakeShutdowner{block: true}
+		start := time.Now()
+		require.NoError(t, shutdownWithTimeout(context.Background(), logger, srv, 10*time.Millisecond))
+		require.True(t, srv.closed)
+		require.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("no timeout waits for context", func(t *testing.T) {
+		srv := &fakeShutdowner{block: true}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.ErrorIs(t, shutdownWithTimeout(ctx, logger, srv, 0), context.Canceled)
+		require.False(t, srv.closed)
+	})
+}
+
+func TestSchemaHandler(t *testing.T) {
+	schema := map[string][]string{"methods": {"Users::GetUser"}}
+	rec := httptest.NewRecorder()
+	SchemaHandler(slog.Default(), schema).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/rpc/schema", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, JSONContentType, rec.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"methods":["Users::GetUser"]}`, rec.Body.String())
+}
+
+func TestHealthcheckHandler_Minimal(t *testing.T) {
+	// The unauthenticated healthcheck must never reveal the detailed health,
+	// so AuthenticatedHealthcheck is not expected to be called.
+	for _, healthy := range []bool{true, false} {
+		healthcheckService := sophrosyne2.NewMockHealthCheckService(t)
+		healthcheckService.EXPECT().UnauthenticatedHealthcheck(context.Background()).Return(healthy).Once()
+
+		rec := httptest.NewRecorder()
+		HealthcheckHandler(slog.Default(), healthcheckService).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+		if healthy {
+			require.Equal(t, http.StatusOK, rec.Code)
+		} else {
+			require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		}
+		require.Empty(t, rec.Body.String())
+	}
+}
+
+func TestAuthenticatedHealthcheckHandler(t *testing.T) {
+	healthcheckService := sophrosyne2.NewMockHealthCheckService(t)
+	healthcheckService.EXPECT().AuthenticatedHealthcheck(context.Background()).Return([]byte(`{"healthy":true}`), nil).Once()
+
+	rec := httptest.NewRecorder()
+	AuthenticatedHealthcheckHandler(slog.Default(), healthcheckService).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/health", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, JSONContentType, rec.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"healthy":true}`, rec.Body.String())
+}
@@ -0,0 +1,81 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/madsrc/sophrosyne"
+
+	ownHttp "github.com/madsrc/sophrosyne/internal/http"
+)
+
+// Middleware to bound the number of requests handled at once.
+//
+// At most server.admissionQueue.maxConcurrent requests are passed on to next.
+// Requests arriving while all of them are taken queue up, at most
+// server.admissionQueue.maxDepth deep, and wait for up to
+// server.admissionQueue.maxWait for one to be released. Requests that do not
+// get one are rejected with 503 Service Unavailable and a Retry-After header.
+// Every request is let through if maxConcurrent is 0.
+func Admission(config *sophrosyne.Config, logger *slog.Logger, next http.Handler) http.Handler {
+	logger.Debug("Creating Admission middleware")
+	cfg := config.Server.AdmissionQueue
+	if cfg.MaxConcurrent <= 0 {
+		return next
+	}
+	slots := make(chan struct{}, cfg.MaxConcurrent)
+	queue := make(chan struct{}, cfg.MaxDepth)
+	retryAfter := strconv.Itoa(int(max(1, math.Ceil(cfg.MaxWait.Seconds()))))
+	busy := func(w http.ResponseWriter, r *http.Request, reason string) {
+		logger.InfoContext(r.Context(), "rejecting request, server is busy", "reason", reason)
+		w.Header().Set("Retry-After", retryAfter)
+		ownHttp.WriteResponse(r.Context(), w, http.StatusServiceUnavailable, ownHttp.PlainTextContentType, []byte("Server Busy"), logger)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			select {
+			case queue <- struct{}{}:
+			default:
+				busy(w, r, "queue full")
+				return
+			}
+			t := time.NewTimer(cfg.MaxWait)
+			select {
+			case slots <- struct{}{}:
+				t.Stop()
+				<-queue
+			case <-t.C:
+				<-queue
+				busy(w, r, "wait elapsed")
+				return
+			case <-r.Context().Done():
+				t.Stop()
+				<-queue
+				return
+			}
+		}
+		defer func() { <-slots }()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,133 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+// blockingHandler signals on entered when a request reaches it, and holds the
+// request until release is closed.
+func blockingHandler(entered chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func admissionConfig(maxConcurrent, maxDepth int, maxWait time.Duration) *sophrosyne.Config {
+	config := &sophrosyne.Config{}
+	config.Server.AdmissionQueue.MaxConcurrent = maxConcurrent
+	config.Server.AdmissionQueue.MaxDepth = maxDepth
+	config.Server.AdmissionQueue.MaxWait = maxWait
+	return config
+}
+
+// serve runs a request against h in the background and returns its recorder
+// once h returns.
+func serve(h http.Handler) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/rpc", nil))
+		done <- rec
+	}()
+	return done
+}
+
+func TestAdmission_Disabled(t *testing.T) {
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	h := Admission(admissionConfig(0, 0, 0), slog.Default(), blockingHandler(entered, release))
+
+	first, second := serve(h), serve(h)
+	<-entered
+	<-entered
+	close(release)
+	require.Equal(t, http.StatusOK, (<-first).Code)
+	require.Equal(t, http.StatusOK, (<-second).Code)
+}
+
+func TestAdmission_UnderCapacity(t *testing.T) {
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	h := Admission(admissionConfig(2, 0, 0), slog.Default(), blockingHandler(entered, release))
+
+	first, second := serve(h), serve(h)
+	<-entered
+	<-entered
+	close(release)
+	require.Equal(t, http.StatusOK, (<-first).Code)
+	require.Equal(t, http.StatusOK, (<-second).Code)
+}
+
+func TestAdmission_QueuedUntilCapacity(t *testing.T) {
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	h := Admission(admissionConfig(1, 1, time.Minute), slog.Default(), blockingHandler(entered, release))
+
+	first := serve(h)
+	<-entered
+	second := serve(h)
+	select {
+	case <-entered:
+		t.Fatal("second request admitted while the first is still running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	require.Equal(t, http.StatusOK, (<-first).Code)
+	require.Equal(t, http.StatusOK, (<-second).Code)
+}
+
+func TestAdmission_OverCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDepth int
+		maxWait  time.Duration
+		want     string
+	}{
+		{name: "wait elapsed", maxDepth: 1, maxWait: 10 * time.Millisecond, want: "1"},
+		{name: "queue full", maxDepth: 0, maxWait: 2500 * time.Millisecond, want: "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entered := make(chan struct{}, 1)
+			release := make(chan struct{})
+			h := Admission(admissionConfig(1, tt.maxDepth, tt.maxWait), slog.Default(), blockingHandler(entered, release))
+
+			first := serve(h)
+			<-entered
+			rec := <-serve(h)
+			require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			require.Equal(t, tt.want, rec.Header().Get("Retry-After"))
+
+			close(release)
+			require.Equal(t, http.StatusOK, (<-first).Code)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+// Middleware to compress responses.
+//
+// The encoding is negotiated from the Accept-Encoding header of the request,
+// choosing among the algorithms enabled in server.compression. When the client
+// accepts several of them equally, the order in server.compression decides.
+// Responses are left uncompressed when no enabled algorithm is acceptable.
+func Compression(config *sophrosyne.Config, logger *slog.Logger, next http.Handler) http.Handler {
+	logger.Debug("Creating Compression middleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), config.Server.Compression)
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var enc io.WriteCloser
+		switch encoding {
+		case "gzip":
+			enc = gzip.NewWriter(w)
+		case "zstd":
+			zw, err := zstd.NewWriter(w)
+			if err != nil {
+				logger.ErrorContext(r.Context(), "unable to create zstd writer", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			enc = zw
+		}
+		defer func() {
+			err := enc.Close()
+			if err != nil {
+				logger.ErrorContext(r.Context(), "unable to finish compressed response", "encoding", encoding, "error", err)
+			}
+		}()
+
+		w.Header().Set("Content-Encoding", encoding)
+		next.ServeHTTP(&compressWriter{ResponseWriter: w, enc: enc}, r)
+	})
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	enc io.Writer
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	// The length of the compressed body is not known up front.
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	return w.enc.Write(b)
+}
+
+// negotiateEncoding returns the entry of supported with the highest quality in
+// the Accept-Encoding header, or the empty string if none are acceptable.
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		qualities[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supported {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,92 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+func TestCompression(t *testing.T) {
+	body := `{"jsonrpc":"2.0","result":{"result":true},"id":"1"}`
+	tests := []struct {
+		name           string
+		enabled        []string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{name: "gzip", enabled: []string{"zstd", "gzip"}, acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{name: "zstd", enabled: []string{"zstd", "gzip"}, acceptEncoding: "zstd", wantEncoding: "zstd"},
+		{name: "server preference on tie", enabled: []string{"zstd", "gzip"}, acceptEncoding: "gzip, zstd", wantEncoding: "zstd"},
+		{name: "client quality wins", enabled: []string{"zstd", "gzip"}, acceptEncoding: "zstd;q=0.5, gzip", wantEncoding: "gzip"},
+		{name: "wildcard", enabled: []string{"gzip"}, acceptEncoding: "*", wantEncoding: "gzip"},
+		{name: "refused algorithm", enabled: []string{"gzip"}, acceptEncoding: "gzip;q=0, br", wantEncoding: ""},
+		{name: "not enabled", enabled: []string{"gzip"}, acceptEncoding: "zstd", wantEncoding: ""},
+		{name: "compression disabled", enabled: nil, acceptEncoding: "gzip, zstd", wantEncoding: ""},
+		{name: "no accept-encoding", enabled: []string{"zstd", "gzip"}, acceptEncoding: "", wantEncoding: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &sophrosyne.Config{}
+			config.Server.Compression = tt.enabled
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(body))
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/rpc", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			Compression(config, logger, next).ServeHTTP(rec, req)
+
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, tt.wantEncoding, rec.Header().Get("Content-Encoding"))
+			require.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+
+			var r io.Reader = rec.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				gr, err := gzip.NewReader(rec.Body)
+				require.NoError(t, err)
+				r = gr
+			case "zstd":
+				zr, err := zstd.NewReader(rec.Body)
+				require.NoError(t, err)
+				defer zr.Close()
+				r = zr
+			}
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, body, string(got))
+		})
+	}
+}
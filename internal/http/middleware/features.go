@@ -0,0 +1,97 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+// FeatureOverrideHeader lists the [sophrosyne.RequestFeature]s a client wants
+// enabled for its request, separated by commas.
+const FeatureOverrideHeader = "X-Sophrosyne-Debug"
+
+// Middleware to enable request features for a single request.
+//
+// Features listed in the [FeatureOverrideHeader] are enabled for the request if
+// the client connects from one of server.featureOverrides.trustedCIDRs and the
+// feature is in server.featureOverrides.allowed. The header is ignored
+// otherwise. Enabled features can be checked with
+// [sophrosyne.RequestFeatureEnabled].
+func FeatureOverrides(config *sophrosyne.Config, logger *slog.Logger, next http.Handler) http.Handler {
+	logger.Debug("Creating FeatureOverrides middleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(FeatureOverrideHeader)
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cfg := config.Server.FeatureOverrides
+		if !trustedSource(r.RemoteAddr, cfg.TrustedCIDRs) {
+			logger.DebugContext(r.Context(), "ignoring feature overrides from untrusted source", "remote", r.RemoteAddr)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var features []sophrosyne.RequestFeature
+		for _, entry := range strings.Split(header, ",") {
+			feature := sophrosyne.RequestFeature(strings.TrimSpace(entry))
+			if !slices.Contains(cfg.Allowed, feature) {
+				logger.DebugContext(r.Context(), "ignoring feature override that is not allowed", "feature", feature)
+				continue
+			}
+			features = append(features, feature)
+		}
+		if len(features) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), sophrosyne.RequestFeaturesContextKey{}, features)
+		logger.InfoContext(ctx, "enabling feature overrides for request", "features", features, "remote", r.RemoteAddr)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// trustedSource reports whether the IP address of remoteAddr, as found in
+// [http.Request.RemoteAddr], is in one of cidrs. Invalid entries are skipped.
+func trustedSource(remoteAddr string, cidrs []string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+func TestFeatureOverrides(t *testing.T) {
+	config := &sophrosyne.Config{}
+	config.Server.FeatureOverrides.TrustedCIDRs = []string{"10.0.0.0/8", "::1/128"}
+	config.Server.FeatureOverrides.Allowed = []sophrosyne.RequestFeature{sophrosyne.RequestFeatureEchoParams, sophrosyne.RequestFeatureDebugLog}
+
+	tests := []struct {
+		name   string
+		remote string
+		header string
+		want   []sophrosyne.RequestFeature
+	}{
+		{
+			name:   "trusted source",
+			remote: "10.1.2.3:51234",
+			header: "echo-params",
+			want:   []sophrosyne.RequestFeature{sophrosyne.RequestFeatureEchoParams},
+		},
+		{
+			name:   "trusted IPv6 source with several features",
+			remote: "[::1]:51234",
+			header: "echo-params, debug-log",
+			want:   []sophrosyne.RequestFeature{sophrosyne.RequestFeatureEchoParams, sophrosyne.RequestFeatureDebugLog},
+		},
+		{
+			name:   "untrusted source",
+			remote: "192.0.2.1:51234",
+			header: "echo-params",
+		},
+		{
+			name:   "feature not allowed",
+			remote: "10.1.2.3:51234",
+			header: "everything",
+		},
+		{
+			name:   "no header",
+			remote: "10.1.2.3:51234",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []sophrosyne.RequestFeature
+			h := FeatureOverrides(config, slog.Default(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for _, f := range []sophrosyne.RequestFeature{sophrosyne.RequestFeatureEchoParams, sophrosyne.RequestFeatureDebugLog} {
+					if sophrosyne.RequestFeatureEnabled(r.Context(), f) {
+						got = append(got, f)
+					}
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/rpc", nil)
+			req.RemoteAddr = tt.remote
+			if tt.header != "" {
+				req.Header.Set(FeatureOverrideHeader, tt.header)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFeatureOverrides_SingleRequest(t *testing.T) {
+	config := &sophrosyne.Config{}
+	config.Server.FeatureOverrides.TrustedCIDRs = []string{"10.0.0.0/8"}
+	config.Server.FeatureOverrides.Allowed = []sophrosyne.RequestFeature{sophrosyne.RequestFeatureEchoParams}
+
+	var enabled []bool
+	h := FeatureOverrides(config, slog.Default(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enabled = append(enabled, sophrosyne.RequestFeatureEnabled(r.Context(), sophrosyne.RequestFeatureEchoParams))
+	}))
+
+	withHeader := httptest.NewRequest(http.MethodPost, "/v1/rpc", nil)
+	withHeader.RemoteAddr = "10.1.2.3:51234"
+	withHeader.Header.Set(FeatureOverrideHeader, "echo-params")
+	h.ServeHTTP(httptest.NewRecorder(), withHeader)
+
+	withoutHeader := httptest.NewRequest(http.MethodPost, "/v1/rpc", nil)
+	withoutHeader.RemoteAddr = "10.1.2.3:51234"
+	h.ServeHTTP(httptest.NewRecorder(), withoutHeader)
+
+	require.Equal(t, []bool{true, false}, enabled)
+}
+
+func TestTrustedSource(t *testing.T) {
+	cidrs := []string{"not a cidr", "10.0.0.0/8"}
+	require.True(t, trustedSource("10.0.0.1:1234", cidrs))
+	require.True(t, trustedSource("[::ffff:10.0.0.1]:1234", cidrs), "IPv4-mapped addresses are unmapped")
+	require.False(t, trustedSource("11.0.0.1:1234", cidrs))
+	require.False(t, trustedSource("garbage", cidrs))
+	require.False(t, trustedSource("10.0.0.1:1234", nil))
+}
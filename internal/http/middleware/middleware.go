@@ -19,7 +19,10 @@ package middleware
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"log/slog"
+	"mime"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -34,13 +37,15 @@ import (
 // When a panic is encountered, a response is returned to the client using
 // [sophrosyne.RespondWithHTTPError] with a [sophrosyne.PanicError].
 //
-// This middleware should be the first middleware in the chain.
+// This middleware should be the first middleware in the chain. Panics in RPC
+// methods are recovered by the RPC server, which records them with the method
+// instead of the path.
 //
 // This middleware does not attempt to log the panic, but relies on the fact
 // that the creation of a [sophrosyne.PanicError] will capture the necessary
 // information, and the [sophrosyne.RespondWithHTTPError] function will ensure the
 // error is handled appropriately.
-func PanicCatcher(logger *slog.Logger, metricService sophrosyne.MetricService, next http.Handler) http.Handler {
+func PanicCatcher(logger *slog.Logger, metricService sophrosyne.MetricService, errorRecorder sophrosyne.ErrorRecorder, next http.Handler) http.Handler {
 	logger.Debug("Creating PanicCatcher middleware")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logger.DebugContext(r.Context(), "Entering PanicCatcher middleware")
@@ -48,6 +53,7 @@ func PanicCatcher(logger *slog.Logger, metricService sophrosyne.MetricService, n
 			logger.DebugContext(r.Context(), "Executing deferred function in PanicCatcher middleware")
 			if err := recover(); err != nil {
 				metricService.RecordPanic(r.Context())
+				errorRecorder.RecordError(r.Context(), r.URL.Path, sophrosyne.NewPanicError(err))
 				logger.ErrorContext(r.Context(), "Panic encountered", "error", err)
 				ownHttp.WriteInternalServerError(r.Context(), w, logger)
 			}
@@ -64,7 +70,7 @@ func SetupTracing(tracingService sophrosyne.TracingService, next http.Handler) h
 	}))
 }
 
-func Authentication(exceptions []string, config *sophrosyne.Config, userService sophrosyne.UserService, logger *slog.Logger, next http.Handler) http.Handler {
+func Authentication(exceptions []string, config *sophrosyne.Config, userService sophrosyne.UserService, authFailures sophrosyne.AuthFailureRecorder, logger *slog.Logger, next http.Handler) http.Handler {
 	logger.Debug("Creating Authentication middleware")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logger.DebugContext(r.Context(), "Entering Authentication middleware")
@@ -84,15 +90,27 @@ func Authentication(exceptions []string, config *sophrosyne.Config, userService
 		if !strings.HasPrefix(authHeader, "Bearer ") {
 			logger.DebugContext(r.Context(), "unable to extract token from Authorization header", "header", authHeader)
 			logger.InfoContext(r.Context(), "authentication", "result", "failed")
+			recordAuthFailure(r, authFailures, sophrosyne.AuthFailureMissingToken, "")
+			ownHttp.WriteResponse(r.Context(), w, http.StatusUnauthorized, ownHttp.PlainTextContentType, nil, logger)
+			return
+		}
+
+		// Reject empty tokens before they reach ProtectToken or the database
+		encodedToken := strings.TrimPrefix(authHeader, "Bearer ")
+		if strings.TrimSpace(encodedToken) == "" {
+			logger.DebugContext(r.Context(), "empty token in Authorization header")
+			logger.InfoContext(r.Context(), "authentication", "result", "failed")
+			recordAuthFailure(r, authFailures, sophrosyne.AuthFailureMissingToken, "")
 			ownHttp.WriteResponse(r.Context(), w, http.StatusUnauthorized, ownHttp.PlainTextContentType, nil, logger)
 			return
 		}
 
 		// Extract token
-		token, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authHeader, "Bearer "))
+		token, err := base64.StdEncoding.DecodeString(encodedToken)
 		if err != nil {
 			logger.DebugContext(r.Context(), "unable to decode token", "token", token, "error", err)
 			logger.InfoContext(r.Context(), "authentication", "result", "failed")
+			recordAuthFailure(r, authFailures, sophrosyne.AuthFailureMalformedToken, encodedToken)
 			ownHttp.WriteResponse(r.Context(), w, http.StatusUnauthorized, ownHttp.PlainTextContentType, nil, logger)
 			return
 		}
@@ -102,13 +120,20 @@ func Authentication(exceptions []string, config *sophrosyne.Config, userService
 
 		// Validate token
 		user, err := userService.GetUserByToken(r.Context(), hashedToken)
+		if err == nil && !user.ValidToken(hashedToken, time.Now()) {
+			err = errors.New("token of user does not match or has expired")
+		}
 		if err != nil {
 			logger.DebugContext(r.Context(), "unable to validate token", "error", err)
 			logger.InfoContext(r.Context(), "authentication", "result", "failed")
+			if !errors.Is(err, sophrosyne.ErrConnection) {
+				recordAuthFailure(r, authFailures, sophrosyne.AuthFailureInvalidToken, encodedToken)
+			}
 			ownHttp.WriteResponse(r.Context(), w, http.StatusUnauthorized, "text/plain", nil, logger)
 			return
 		}
-		user.Token = []byte{} // Overwrite the token, so we don't leak it into the context
+		user.Token = []byte{} // Overwrite the tokens, so we don't leak them into the context
+		user.PreviousToken = nil
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, sophrosyne.UserContextKey{}, &user)
 		r = r.WithContext(ctx)
@@ -118,6 +143,54 @@ func Authentication(exceptions []string, config *sophrosyne.Config, userService
 	})
 }
 
+// recordAuthFailure records the failure to authenticate r with recorder, if
+// any. Only the [sophrosyne.AuthFailureTokenHash] of token is recorded.
+func recordAuthFailure(r *http.Request, recorder sophrosyne.AuthFailureRecorder, reason sophrosyne.AuthFailureReason, token string) {
+	if recorder == nil {
+		return
+	}
+	source, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		source = r.RemoteAddr
+	}
+	recorder.RecordAuthFailure(r.Context(), sophrosyne.AuthFailureEvent{
+		Time:      time.Now(),
+		Source:    source,
+		Reason:    reason,
+		TokenHash: sophrosyne.AuthFailureTokenHash(token),
+	})
+}
+
+// Middleware to enforce that request bodies are JSON.
+//
+// When server.strictContentType is enabled, requests are rejected with
+// 415 Unsupported Media Type unless their Content-Type is application/json,
+// optionally with a UTF-8 charset. Otherwise every request is let through.
+func JSONContentType(config *sophrosyne.Config, logger *slog.Logger, next http.Handler) http.Handler {
+	logger.Debug("Creating JSONContentType middleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Server.StrictContentType && !isJSONContentType(r.Header.Get("Content-Type")) {
+			logger.DebugContext(r.Context(), "rejecting request with unsupported content type", "content_type", r.Header.Get("Content-Type"))
+			ownHttp.WriteResponse(r.Context(), w, http.StatusUnsupportedMediaType, ownHttp.PlainTextContentType, []byte("Content-Type must be application/json"), logger)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != ownHttp.JSONContentType {
+		return false
+	}
+	for k, v := range params {
+		if k != "charset" || !strings.EqualFold(v, "utf-8") {
+			return false
+		}
+	}
+	return true
+}
+
 type responseWrapper struct {
 	http.ResponseWriter
 	status      int
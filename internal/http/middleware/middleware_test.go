@@ -0,0 +1,247 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package middleware
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+	"github.com/madsrc/sophrosyne/internal/diagnostics"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+)
+
+func TestJSONContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		strict      bool
+		contentType string
+		wantStatus  int
+	}{
+		{name: "strict json", strict: true, contentType: "application/json", wantStatus: http.StatusOK},
+		{name: "strict json with charset", strict: true, contentType: "application/json; charset=UTF-8", wantStatus: http.StatusOK},
+		{name: "strict json with other charset", strict: true, contentType: "application/json; charset=latin1", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "strict missing", strict: true, contentType: "", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "strict wrong", strict: true, contentType: "text/plain", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "lenient json", contentType: "application/json", wantStatus: http.StatusOK},
+		{name: "lenient missing", contentType: "", wantStatus: http.StatusOK},
+		{name: "lenient wrong", contentType: "text/plain", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &sophrosyne.Config{}
+			config.Server.StrictContentType = tt.strict
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/rpc", strings.NewReader("{}"))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			JSONContentType(config, logger, next).ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestAuthentication_RejectsMalformedTokens(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+	}{
+		{name: "missing header", header: "", wantStatus: http.StatusUnauthorized},
+		{name: "wrong scheme", header: "Basic dG9rZW4=", wantStatus: http.StatusUnauthorized},
+		{name: "scheme without token", header: "Bearer", wantStatus: http.StatusUnauthorized},
+		{name: "empty token", header: "Bearer ", wantStatus: http.StatusUnauthorized},
+		{name: "whitespace token", header: "Bearer  \t ", wantStatus: http.StatusUnauthorized},
+		{name: "invalid base64", header: "Bearer not base64!", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No expectations are set, so any token lookup fails the test.
+			userService := sophrosyne2.NewMockUserService(t)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/rpc", strings.NewReader("{}"))
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			Authentication(nil, &sophrosyne.Config{}, userService, nil, logger, next).ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestAuthentication_ValidToken(t *testing.T) {
+	config := &sophrosyne.Config{}
+	userService := sophrosyne2.NewMockUserService(t)
+	hashedToken := sophrosyne.ProtectToken([]byte("token"), config)
+	userService.EXPECT().GetUserByToken(mock.Anything, hashedToken).Return(sophrosyne.User{ID: "1", Token: hashedToken}, nil).Once()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "1", sophrosyne.ExtractUser(r.Context()).ID)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/rpc", strings.NewReader("{}"))
+	req.Header.Set("Authorization", "Bearer dG9rZW4=")
+	rec := httptest.NewRecorder()
+	Authentication(nil, config, userService, nil, logger, next).ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAuthentication_TokenMismatch(t *testing.T) {
+	config := &sophrosyne.Config{}
+	userService := sophrosyne2.NewMockUserService(t)
+	userService.EXPECT().GetUserByToken(mock.Anything, sophrosyne.ProtectToken([]byte("token"), config)).Return(sophrosyne.User{ID: "1", Token: []byte("other")}, nil).Once()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("request with a mismatching token was let through")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/rpc", strings.NewReader("{}"))
+	req.Header.Set("Authorization", "Bearer dG9rZW4=")
+	rec := httptest.NewRecorder()
+	Authentication(nil, config, userService, nil, logger, next).ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestAuthentication_TokenExpiry(t *testing.T) {
+	config := &sophrosyne.Config{}
+	hashedToken := sophrosyne.ProtectToken([]byte("token"), config)
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name       string
+		user       sophrosyne.User
+		wantStatus int
+	}{
+		{name: "not expired", user: sophrosyne.User{ID: "1", Token: hashedToken, TokenExpiresAt: &future}, wantStatus: http.StatusOK},
+		{name: "expired", user: sophrosyne.User{ID: "1", Token: hashedToken, TokenExpiresAt: &past}, wantStatus: http.StatusUnauthorized},
+		{name: "rotated within grace", user: sophrosyne.User{ID: "1", Token: []byte("new"), PreviousToken: hashedToken, PreviousTokenExpiresAt: &future}, wantStatus: http.StatusOK},
+		{name: "rotated after grace", user: sophrosyne.User{ID: "1", Token: []byte("new"), PreviousToken: hashedToken, PreviousTokenExpiresAt: &past}, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userService := sophrosyne2.NewMockUserService(t)
+			userService.EXPECT().GetUserByToken(mock.Anything, hashedToken).Return(tt.user, nil).Once()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Empty(t, sophrosyne.ExtractUser(r.Context()).PreviousToken)
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/rpc", strings.NewReader("{}"))
+			req.Header.Set("Authorization", "Bearer dG9rZW4=")
+			rec := httptest.NewRecorder()
+			Authentication(nil, config, userService, nil, logger, next).ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestAuthentication_RecordsFailures(t *testing.T) {
+	config := &sophrosyne.Config{}
+	const token = "dG9rZW4="
+	tests := []struct {
+		name      string
+		header    string
+		lookupErr error
+		want      []sophrosyne.AuthFailureEvent
+	}{
+		{name: "success"},
+		{
+			name:   "missing token",
+			want:   []sophrosyne.AuthFailureEvent{{Source: "192.0.2.1", Reason: sophrosyne.AuthFailureMissingToken}},
+			header: "Basic abc",
+		},
+		{
+			name:   "malformed token",
+			header: "Bearer not base64!",
+			want:   []sophrosyne.AuthFailureEvent{{Source: "192.0.2.1", Reason: sophrosyne.AuthFailureMalformedToken, TokenHash: sophrosyne.AuthFailureTokenHash("not base64!")}},
+		},
+		{
+			name:      "unknown token",
+			header:    "Bearer " + token,
+			lookupErr: sophrosyne.ErrNotFound,
+			want:      []sophrosyne.AuthFailureEvent{{Source: "192.0.2.1", Reason: sophrosyne.AuthFailureInvalidToken, TokenHash: sophrosyne.AuthFailureTokenHash(token)}},
+		},
+		{
+			name:      "database unavailable",
+			header:    "Bearer " + token,
+			lookupErr: sophrosyne.ErrConnection,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.header == "" {
+				tt.header = "Bearer " + token
+			}
+			userService := sophrosyne2.NewMockUserService(t)
+			hashedToken := sophrosyne.ProtectToken([]byte("token"), config)
+			if strings.HasSuffix(tt.header, token) {
+				userService.EXPECT().GetUserByToken(mock.Anything, hashedToken).Return(sophrosyne.User{ID: "1", Token: hashedToken}, tt.lookupErr).Once()
+			}
+			failures, err := diagnostics.NewAuthFailures(10)
+			require.NoError(t, err)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/rpc", strings.NewReader("{}"))
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("Authorization", tt.header)
+			Authentication(nil, config, userService, failures, logger, next).ServeHTTP(httptest.NewRecorder(), req)
+
+			got := failures.RecentAuthFailures(context.Background())
+			require.Len(t, got, len(tt.want))
+			for i := range got {
+				require.WithinDuration(t, time.Now(), got[i].Time, time.Minute)
+				got[i].Time = time.Time{}
+				require.NotContains(t, got[i].TokenHash, token, "token stored in clear")
+				require.NotContains(t, got[i].TokenHash, "token", "token stored in clear")
+			}
+			require.ElementsMatch(t, tt.want, got)
+		})
+	}
+}
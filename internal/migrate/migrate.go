@@ -18,10 +18,13 @@ package migrate
 
 import (
 	"embed"
+	"errors"
 	"fmt"
+	"io/fs"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 
 	"github.com/madsrc/sophrosyne"
@@ -29,15 +32,24 @@ import (
 
 var ErrNoChange = migrate.ErrNoChange
 
+// ErrNilVersion is returned by [MigrationService.Versions] when no migrations
+// have been applied.
+var ErrNilVersion = migrate.ErrNilVersion
+
+// ErrInvalidSteps is returned by [MigrationService.Down] when asked to roll
+// back fewer than one migration.
+var ErrInvalidSteps = errors.New("steps must be at least 1")
+
 //go:embed migrations
-var fs embed.FS
+var migrationsFS embed.FS
 
 type MigrationService struct {
 	migrate *migrate.Migrate
+	source  source.Driver
 }
 
 func NewMigrationService(config *sophrosyne.Config) (*MigrationService, error) {
-	d, err := iofs.New(fs, "migrations")
+	d, err := iofs.New(migrationsFS, "migrations")
 	if err != nil {
 		return nil, err
 	}
@@ -48,6 +60,7 @@ func NewMigrationService(config *sophrosyne.Config) (*MigrationService, error) {
 	}
 	return &MigrationService{
 		migrate: m,
+		source:  d,
 	}, nil
 }
 
@@ -55,8 +68,27 @@ func (m *MigrationService) Up() error {
 	return m.migrate.Up()
 }
 
-func (m *MigrationService) Down() error {
-	return m.migrate.Down()
+// Down rolls back the given number of applied migrations. It returns
+// [ErrNoChange] if no migrations have been applied, and, like Up, fails
+// without changing anything if the database is in a dirty state.
+func (m *MigrationService) Down(steps int) error {
+	if steps < 1 {
+		return ErrInvalidSteps
+	}
+	err := m.migrate.Steps(-steps)
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, _, verr := m.migrate.Version(); errors.Is(verr, migrate.ErrNilVersion) {
+			return ErrNoChange
+		}
+	}
+	return err
+}
+
+// Migrate migrates the database up or down to targetVersion. It returns
+// [ErrNoChange] if the database is already at targetVersion, and, like Up,
+// fails without changing anything if the database is in a dirty state.
+func (m *MigrationService) Migrate(targetVersion uint) error {
+	return m.migrate.Migrate(targetVersion)
 }
 
 func (m *MigrationService) Close() (source error, database error) {
@@ -66,3 +98,41 @@ func (m *MigrationService) Close() (source error, database error) {
 func (m *MigrationService) Versions() (version uint, dirty bool, err error) {
 	return m.migrate.Version()
 }
+
+// Pending reports whether the database is behind the latest migration
+// embedded in the binary.
+//
+// A database that has never been migrated, or that has been left in a dirty
+// state by a failed migration, is considered to have pending migrations.
+func (m *MigrationService) Pending() (bool, error) {
+	latest, err := latestVersion(m.source)
+	if err != nil {
+		return false, err
+	}
+	current, dirty, err := m.migrate.Version()
+	if err != nil {
+		if errors.Is(err, migrate.ErrNilVersion) {
+			return true, nil
+		}
+		return false, err
+	}
+	return dirty || current < latest, nil
+}
+
+// latestVersion returns the highest migration version available in d.
+func latestVersion(d source.Driver) (uint, error) {
+	v, err := d.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := d.Next(v)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return v, nil
+			}
+			return 0, err
+		}
+		v = next
+	}
+}
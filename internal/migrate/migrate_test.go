@@ -0,0 +1,185 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/stub"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatestVersion(t *testing.T) {
+	cases := []struct {
+		name    string
+		files   fstest.MapFS
+		want    uint
+		wantErr bool
+	}{
+		{
+			name: "single migration",
+			files: fstest.MapFS{
+				"migrations/000001_first.up.sql":   {Data: []byte("SELECT 1;")},
+				"migrations/000001_first.down.sql": {Data: []byte("SELECT 1;")},
+			},
+			want: 1,
+		},
+		{
+			name: "multiple migrations with gaps",
+			files: fstest.MapFS{
+				"migrations/000001_first.up.sql":  {Data: []byte("SELECT 1;")},
+				"migrations/000002_second.up.sql": {Data: []byte("SELECT 1;")},
+				"migrations/000005_fifth.up.sql":  {Data: []byte("SELECT 1;")},
+			},
+			want: 5,
+		},
+		{
+			name: "no migrations",
+			files: fstest.MapFS{
+				"migrations/README": {Data: []byte("nothing to see here")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := iofs.New(tc.files, "migrations")
+			require.NoError(t, err)
+
+			got, err := latestVersion(d)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLatestVersionEmbedded(t *testing.T) {
+	d, err := iofs.New(migrationsFS, "migrations")
+	require.NoError(t, err)
+
+	got, err := latestVersion(d)
+	require.NoError(t, err)
+	require.NotZero(t, got)
+}
+
+// newStubMigrationService returns a MigrationService with three migrations,
+// backed by an in-memory database at the given version.
+func newStubMigrationService(t *testing.T, version int, dirty bool) (*MigrationService, *stub.Stub) {
+	t.Helper()
+	d, err := iofs.New(fstest.MapFS{
+		"migrations/000001_first.up.sql":    {Data: []byte("SELECT 1;")},
+		"migrations/000001_first.down.sql":  {Data: []byte("SELECT 1;")},
+		"migrations/000002_second.up.sql":   {Data: []byte("SELECT 2;")},
+		"migrations/000002_second.down.sql": {Data: []byte("SELECT 2;")},
+		"migrations/000003_third.up.sql":    {Data: []byte("SELECT 3;")},
+		"migrations/000003_third.down.sql":  {Data: []byte("SELECT 3;")},
+	}, "migrations")
+	require.NoError(t, err)
+
+	db, err := stub.WithInstance(nil, &stub.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.SetVersion(version, dirty))
+
+	m, err := migrate.NewWithInstance("iofs", d, "stub", db)
+	require.NoError(t, err)
+	return &MigrationService{migrate: m, source: d}, db.(*stub.Stub)
+}
+
+func TestMigrationService_Down(t *testing.T) {
+	cases := []struct {
+		name        string
+		version     int
+		dirty       bool
+		steps       int
+		wantErr     error
+		wantVersion int
+	}{
+		{name: "one step", version: 3, steps: 1, wantVersion: 2},
+		{name: "several steps", version: 3, steps: 2, wantVersion: 1},
+		{name: "all steps", version: 3, steps: 3, wantVersion: database.NilVersion},
+		{name: "nothing applied", version: database.NilVersion, steps: 1, wantErr: ErrNoChange, wantVersion: database.NilVersion},
+		{name: "invalid steps", version: 3, steps: 0, wantErr: ErrInvalidSteps, wantVersion: 3},
+		{name: "dirty", version: 3, dirty: true, steps: 1, wantErr: migrate.ErrDirty{Version: 3}, wantVersion: 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, db := newStubMigrationService(t, tc.version, tc.dirty)
+
+			err := m.Down(tc.steps)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.wantVersion, db.CurrentVersion)
+		})
+	}
+
+	t.Run("more steps than applied", func(t *testing.T) {
+		m, db := newStubMigrationService(t, 2, false)
+
+		err := m.Down(5)
+		require.ErrorAs(t, err, &migrate.ErrShortLimit{})
+		require.Equal(t, database.NilVersion, db.CurrentVersion)
+	})
+}
+
+func TestMigrationService_Migrate(t *testing.T) {
+	cases := []struct {
+		name        string
+		version     int
+		dirty       bool
+		target      uint
+		wantErr     error
+		wantVersion int
+	}{
+		{name: "down", version: 3, target: 1, wantVersion: 1},
+		{name: "up", version: 1, target: 3, wantVersion: 3},
+		{name: "already there", version: 2, target: 2, wantErr: ErrNoChange, wantVersion: 2},
+		{name: "dirty", version: 2, dirty: true, target: 1, wantErr: migrate.ErrDirty{Version: 2}, wantVersion: 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, db := newStubMigrationService(t, tc.version, tc.dirty)
+
+			err := m.Migrate(tc.target)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.wantVersion, db.CurrentVersion)
+		})
+	}
+
+	t.Run("unknown version", func(t *testing.T) {
+		m, db := newStubMigrationService(t, 1, false)
+
+		require.Error(t, m.Migrate(7))
+		require.Equal(t, 1, db.CurrentVersion)
+	})
+}
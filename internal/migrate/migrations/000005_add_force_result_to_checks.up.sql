@@ -0,0 +1,3 @@
+ALTER TABLE checks
+    ADD COLUMN force_result TEXT NOT NULL DEFAULT 'none'
+        CONSTRAINT checks_force_result_check CHECK (force_result IN ('none', 'pass', 'fail'));
@@ -0,0 +1,7 @@
+-- Emails are unique among users that are not deleted. Empty emails, allowed
+-- when services.users.requireEmail is disabled, are not subject to it. The
+-- index is replaced by migration 10.
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS users_email_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email) WHERE deleted_at IS NULL AND email <> '';
@@ -0,0 +1,2 @@
+ALTER TABLE checks
+    ADD COLUMN shadow BOOLEAN NOT NULL DEFAULT false;
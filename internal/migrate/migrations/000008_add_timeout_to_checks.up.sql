@@ -0,0 +1,2 @@
+ALTER TABLE checks
+    ADD COLUMN timeout INTERVAL NOT NULL DEFAULT '0';
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS token_expires_at,
+    DROP COLUMN IF EXISTS previous_token,
+    DROP COLUMN IF EXISTS previous_token_expires_at;
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    ADD COLUMN token_expires_at TIMESTAMPTZ,
+    ADD COLUMN previous_token BYTEA,
+    ADD COLUMN previous_token_expires_at TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_email_idx;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email) WHERE deleted_at IS NULL AND email <> '';
@@ -0,0 +1,6 @@
+-- Whether emails are unique is configurable with services.users.uniqueEmail,
+-- so it is checked when users are written rather than by a unique index. The
+-- index is kept for looking users up by email.
+DROP INDEX IF EXISTS users_email_key;
+
+CREATE INDEX IF NOT EXISTS users_email_idx ON users (email) WHERE deleted_at IS NULL;
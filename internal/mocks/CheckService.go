@@ -240,6 +240,65 @@ func (_c *MockCheckService_GetCheckByName_Call) RunAndReturn(run func(context.Co
 	return _c
 }
 
+// GetCheckUsage provides a mock function with given fields: ctx, name
+func (_m *MockCheckService) GetCheckUsage(ctx context.Context, name string) ([]sophrosyne.Profile, error) {
+	ret := _m.Called(ctx, name)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetCheckUsage")
+	}
+
+	var r0 []sophrosyne.Profile
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) ([]sophrosyne.Profile, error)); ok {
+		return rf(ctx, name)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) []sophrosyne.Profile); ok {
+		r0 = rf(ctx, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]sophrosyne.Profile)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockCheckService_GetCheckUsage_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetCheckUsage'
+type MockCheckService_GetCheckUsage_Call struct {
+	*mock.Call
+}
+
+// GetCheckUsage is a helper method to define mock.On call
+//   - ctx context.Context
+//   - name string
+func (_e *MockCheckService_Expecter) GetCheckUsage(ctx interface{}, name interface{}) *MockCheckService_GetCheckUsage_Call {
+	return &MockCheckService_GetCheckUsage_Call{Call: _e.mock.On("GetCheckUsage", ctx, name)}
+}
+
+func (_c *MockCheckService_GetCheckUsage_Call) Run(run func(ctx context.Context, name string)) *MockCheckService_GetCheckUsage_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockCheckService_GetCheckUsage_Call) Return(_a0 []sophrosyne.Profile, _a1 error) *MockCheckService_GetCheckUsage_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockCheckService_GetCheckUsage_Call) RunAndReturn(run func(context.Context, string) ([]sophrosyne.Profile, error)) *MockCheckService_GetCheckUsage_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // GetChecks provides a mock function with given fields: ctx, cursor
 func (_m *MockCheckService) GetChecks(ctx context.Context, cursor *sophrosyne.DatabaseCursor) ([]sophrosyne.Check, error) {
 	ret := _m.Called(ctx, cursor)
@@ -6,6 +6,8 @@ import (
 	context "context"
 
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // MockMetricService is an autogenerated mock type for the MetricService type
@@ -21,6 +23,42 @@ func (_m *MockMetricService) EXPECT() *MockMetricService_Expecter {
 	return &MockMetricService_Expecter{mock: &_m.Mock}
 }
 
+// RecordCacheLookup provides a mock function with given fields: ctx, service, method, hit
+func (_m *MockMetricService) RecordCacheLookup(ctx context.Context, service string, method string, hit bool) {
+	_m.Called(ctx, service, method, hit)
+}
+
+// MockMetricService_RecordCacheLookup_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RecordCacheLookup'
+type MockMetricService_RecordCacheLookup_Call struct {
+	*mock.Call
+}
+
+// RecordCacheLookup is a helper method to define mock.On call
+//   - ctx context.Context
+//   - service string
+//   - method string
+//   - hit bool
+func (_e *MockMetricService_Expecter) RecordCacheLookup(ctx interface{}, service interface{}, method interface{}, hit interface{}) *MockMetricService_RecordCacheLookup_Call {
+	return &MockMetricService_RecordCacheLookup_Call{Call: _e.mock.On("RecordCacheLookup", ctx, service, method, hit)}
+}
+
+func (_c *MockMetricService_RecordCacheLookup_Call) Run(run func(ctx context.Context, service string, method string, hit bool)) *MockMetricService_RecordCacheLookup_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string), args[3].(bool))
+	})
+	return _c
+}
+
+func (_c *MockMetricService_RecordCacheLookup_Call) Return() *MockMetricService_RecordCacheLookup_Call {
+	_c.Call.Return()
+	return _c
+}
+
+func (_c *MockMetricService_RecordCacheLookup_Call) RunAndReturn(run func(context.Context, string, string, bool)) *MockMetricService_RecordCacheLookup_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // RecordPanic provides a mock function with given fields: ctx
 func (_m *MockMetricService) RecordPanic(ctx context.Context) {
 	_m.Called(ctx)
@@ -54,6 +92,107 @@ func (_c *MockMetricService_RecordPanic_Call) RunAndReturn(run func(context.Cont
 	return _c
 }
 
+// RecordRPCCall provides a mock function with given fields: ctx, method, duration
+func (_m *MockMetricService) RecordRPCCall(ctx context.Context, method string, duration time.Duration) {
+	_m.Called(ctx, method, duration)
+}
+
+// MockMetricService_RecordRPCCall_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RecordRPCCall'
+type MockMetricService_RecordRPCCall_Call struct {
+	*mock.Call
+}
+
+// RecordRPCCall is a helper method to define mock.On call
+//   - ctx context.Context
+//   - method string
+//   - duration time.Duration
+func (_e *MockMetricService_Expecter) RecordRPCCall(ctx interface{}, method interface{}, duration interface{}) *MockMetricService_RecordRPCCall_Call {
+	return &MockMetricService_RecordRPCCall_Call{Call: _e.mock.On("RecordRPCCall", ctx, method, duration)}
+}
+
+func (_c *MockMetricService_RecordRPCCall_Call) Run(run func(ctx context.Context, method string, duration time.Duration)) *MockMetricService_RecordRPCCall_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(time.Duration))
+	})
+	return _c
+}
+
+func (_c *MockMetricService_RecordRPCCall_Call) Return() *MockMetricService_RecordRPCCall_Call {
+	_c.Call.Return()
+	return _c
+}
+
+func (_c *MockMetricService_RecordRPCCall_Call) RunAndReturn(run func(context.Context, string, time.Duration)) *MockMetricService_RecordRPCCall_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// RecordScanFinished provides a mock function with given fields: ctx
+func (_m *MockMetricService) RecordScanFinished(ctx context.Context) {
+	_m.Called(ctx)
+}
+
+// MockMetricService_RecordScanFinished_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RecordScanFinished'
+type MockMetricService_RecordScanFinished_Call struct {
+	*mock.Call
+}
+
+// RecordScanFinished is a helper method to define mock.On call
+//   - ctx context.Context
+func (_e *MockMetricService_Expecter) RecordScanFinished(ctx interface{}) *MockMetricService_RecordScanFinished_Call {
+	return &MockMetricService_RecordScanFinished_Call{Call: _e.mock.On("RecordScanFinished", ctx)}
+}
+
+func (_c *MockMetricService_RecordScanFinished_Call) Run(run func(ctx context.Context)) *MockMetricService_RecordScanFinished_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context))
+	})
+	return _c
+}
+
+func (_c *MockMetricService_RecordScanFinished_Call) Return() *MockMetricService_RecordScanFinished_Call {
+	_c.Call.Return()
+	return _c
+}
+
+func (_c *MockMetricService_RecordScanFinished_Call) RunAndReturn(run func(context.Context)) *MockMetricService_RecordScanFinished_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// RecordScanStarted provides a mock function with given fields: ctx
+func (_m *MockMetricService) RecordScanStarted(ctx context.Context) {
+	_m.Called(ctx)
+}
+
+// MockMetricService_RecordScanStarted_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RecordScanStarted'
+type MockMetricService_RecordScanStarted_Call struct {
+	*mock.Call
+}
+
+// RecordScanStarted is a helper method to define mock.On call
+//   - ctx context.Context
+func (_e *MockMetricService_Expecter) RecordScanStarted(ctx interface{}) *MockMetricService_RecordScanStarted_Call {
+	return &MockMetricService_RecordScanStarted_Call{Call: _e.mock.On("RecordScanStarted", ctx)}
+}
+
+func (_c *MockMetricService_RecordScanStarted_Call) Run(run func(ctx context.Context)) *MockMetricService_RecordScanStarted_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context))
+	})
+	return _c
+}
+
+func (_c *MockMetricService_RecordScanStarted_Call) Return() *MockMetricService_RecordScanStarted_Call {
+	_c.Call.Return()
+	return _c
+}
+
+func (_c *MockMetricService_RecordScanStarted_Call) RunAndReturn(run func(context.Context)) *MockMetricService_RecordScanStarted_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewMockMetricService creates a new instance of MockMetricService. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewMockMetricService(t interface {
@@ -0,0 +1,124 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package otel
+
+import (
+	"context"
+	"sync/atomic"
+
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// backpressure tracks the spans queued for export. Once limit spans are
+// queued, the queue is saturated and new spans are dropped until the exporter
+// has drained the queue to half of limit.
+type backpressure struct {
+	limit     int64
+	pending   atomic.Int64
+	saturated atomic.Bool
+}
+
+// enqueue reserves room in the queue for a span, reporting whether there was
+// any.
+func (b *backpressure) enqueue() bool {
+	if b.saturated.Load() {
+		return false
+	}
+	for {
+		n := b.pending.Load()
+		if n >= b.limit {
+			b.saturated.Store(true)
+			return false
+		}
+		if b.pending.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// exported releases the room of n exported spans.
+func (b *backpressure) exported(n int) {
+	if b.pending.Add(-int64(n)) <= b.limit/2 {
+		b.saturated.Store(false)
+	}
+}
+
+// backpressureSampler drops every span while the export queue is saturated,
+// leaving the decision to next otherwise.
+type backpressureSampler struct {
+	next     sdkTrace.Sampler
+	pressure *backpressure
+}
+
+func (s backpressureSampler) ShouldSample(p sdkTrace.SamplingParameters) sdkTrace.SamplingResult {
+	if s.pressure.saturated.Load() {
+		return sdkTrace.SamplingResult{
+			Decision:   sdkTrace.Drop,
+			Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+	return s.next.ShouldSample(p)
+}
+
+func (s backpressureSampler) Description() string {
+	return "DropOnBackpressure{" + s.next.Description() + "}"
+}
+
+// backpressureProcessor only passes spans on to the wrapped processor if
+// there is room for them in the export queue, so the queue never blocks or
+// overflows.
+type backpressureProcessor struct {
+	sdkTrace.SpanProcessor
+	pressure *backpressure
+}
+
+func (p backpressureProcessor) OnEnd(s sdkTrace.ReadOnlySpan) {
+	if s.SpanContext().IsSampled() && !p.pressure.enqueue() {
+		return
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+// backpressureExporter releases the room of the spans it exports, whether or
+// not exporting them succeeds.
+type backpressureExporter struct {
+	sdkTrace.SpanExporter
+	pressure *backpressure
+}
+
+func (e backpressureExporter) ExportSpans(ctx context.Context, spans []sdkTrace.ReadOnlySpan) error {
+	defer e.pressure.exported(len(spans))
+	return e.SpanExporter.ExportSpans(ctx, spans)
+}
+
+// newBackpressureTraceProvider returns a provider batching spans for exporter
+// in a queue of queueSize spans, dropping spans while the queue is saturated
+// rather than queuing them.
+func newBackpressureTraceProvider(exporter sdkTrace.SpanExporter, queueSize int, opts []sdkTrace.BatchSpanProcessorOption, providerOpts ...sdkTrace.TracerProviderOption) *sdkTrace.TracerProvider {
+	pressure := &backpressure{limit: int64(queueSize)}
+	opts = append(opts,
+		sdkTrace.WithMaxQueueSize(queueSize),
+		sdkTrace.WithMaxExportBatchSize(min(queueSize, sdkTrace.DefaultMaxExportBatchSize)),
+	)
+	processor := sdkTrace.NewBatchSpanProcessor(backpressureExporter{SpanExporter: exporter, pressure: pressure}, opts...)
+	providerOpts = append(providerOpts,
+		sdkTrace.WithSpanProcessor(backpressureProcessor{SpanProcessor: processor, pressure: pressure}),
+		sdkTrace.WithSampler(backpressureSampler{next: sdkTrace.ParentBased(sdkTrace.AlwaysSample()), pressure: pressure}),
+	)
+	return sdkTrace.NewTracerProvider(providerOpts...)
+}
@@ -0,0 +1,95 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package otel
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// blockingExporter blocks exporting spans until release is closed, like an
+// exporter whose backend does not keep up.
+type blockingExporter struct {
+	release  chan struct{}
+	exported atomic.Int64
+}
+
+func (e *blockingExporter) ExportSpans(ctx context.Context, spans []sdkTrace.ReadOnlySpan) error {
+	<-e.release
+	e.exported.Add(int64(len(spans)))
+	return nil
+}
+
+func (e *blockingExporter) Shutdown(ctx context.Context) error { return nil }
+
+func TestBackpressure(t *testing.T) {
+	b := &backpressure{limit: 4}
+	for range 4 {
+		require.True(t, b.enqueue())
+	}
+	require.False(t, b.saturated.Load(), "saturated before the queue is full")
+	require.False(t, b.enqueue())
+	require.True(t, b.saturated.Load())
+
+	b.exported(1)
+	require.True(t, b.saturated.Load(), "recovered before the queue drained")
+	require.False(t, b.enqueue())
+
+	b.exported(1)
+	require.False(t, b.saturated.Load())
+	require.True(t, b.enqueue())
+}
+
+func TestBackpressureTraceProvider(t *testing.T) {
+	ctx := context.Background()
+	exporter := &blockingExporter{release: make(chan struct{})}
+	const queueSize = 4
+	tp := newBackpressureTraceProvider(exporter, queueSize, []sdkTrace.BatchSpanProcessorOption{sdkTrace.WithBatchTimeout(time.Millisecond)})
+	t.Cleanup(func() { _ = tp.Shutdown(ctx) })
+	tracer := tp.Tracer("test")
+
+	start := time.Now()
+	var sampled, dropped int
+	for range 100 {
+		_, span := tracer.Start(ctx, "span")
+		if span.SpanContext().IsSampled() {
+			sampled++
+		} else {
+			dropped++
+		}
+		span.End()
+	}
+	require.Less(t, time.Since(start), time.Second, "ending spans blocked on the exporter")
+	// The span finding the queue full is sampled, but not queued.
+	require.LessOrEqual(t, sampled, queueSize+1)
+	require.Equal(t, 100-sampled, dropped)
+
+	close(exporter.release)
+	require.NoError(t, tp.ForceFlush(ctx))
+	require.Equal(t, int64(queueSize), exporter.exported.Load())
+
+	_, span := tracer.Start(ctx, "span")
+	require.True(t, span.SpanContext().IsSampled(), "spans are not sampled after the queue drained")
+	span.End()
+}
@@ -20,12 +20,17 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/metric"
@@ -41,7 +46,10 @@ import (
 
 // SetupOTelSDK bootstraps the OpenTelemetry pipeline.
 // If it does not return an error, make sure to call shutdown for proper cleanup.
-func SetupOTelSDK(ctx context.Context, config *sophrosyne.Config) (shutdown func(context.Context) error, err error) {
+//
+// If metrics.prometheus.enabled is set, metricsHandler serves the collected
+// metrics in the Prometheus text format. Otherwise it is nil.
+func SetupOTelSDK(ctx context.Context, config *sophrosyne.Config) (shutdown func(context.Context) error, metricsHandler http.Handler, err error) {
 	var shutdownFuncs []func(context.Context) error
 
 	// shutdown calls cleanup functions registered via shutdownFuncs.
@@ -67,7 +75,7 @@ func SetupOTelSDK(ctx context.Context, config *sophrosyne.Config) (shutdown func
 	),
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Set up propagator.
@@ -79,21 +87,22 @@ func SetupOTelSDK(ctx context.Context, config *sophrosyne.Config) (shutdown func
 		tracerProvider, err := newTraceProvider(ctx, config, res)
 		if err != nil {
 			handleErr(err)
-			return shutdown, err
+			return shutdown, nil, err
 		}
 		shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
 		otel.SetTracerProvider(tracerProvider)
 	}
 
-	if config.Metrics.Enabled {
+	if config.Metrics.Enabled || config.Metrics.Prometheus.Enabled {
 		// Set up meter provider.
-		meterProvider, err := newMeterProvider(ctx, config, res)
+		meterProvider, handler, err := newMeterProvider(ctx, config, res)
 		if err != nil {
 			handleErr(err)
-			return shutdown, err
+			return shutdown, nil, err
 		}
 		shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
 		otel.SetMeterProvider(meterProvider)
+		metricsHandler = handler
 	}
 
 	return
@@ -118,32 +127,50 @@ func newTraceProvider(ctx context.Context, config *sophrosyne.Config, res *resou
 		return nil, err
 	}
 
+	batchOpts := []sdkTrace.BatchSpanProcessorOption{
+		sdkTrace.WithBatchTimeout(time.Duration(config.Tracing.Batch.Timeout) * time.Second),
+	}
+	if config.Tracing.DropOnBackpressure {
+		return newBackpressureTraceProvider(traceExporter, sdkTrace.DefaultMaxQueueSize, batchOpts, sdkTrace.WithResource(res)), nil
+	}
+
 	traceProvider := sdkTrace.NewTracerProvider(
-		sdkTrace.WithBatcher(traceExporter,
-			sdkTrace.WithBatchTimeout(time.Duration(config.Tracing.Batch.Timeout)*time.Second)),
+		sdkTrace.WithBatcher(traceExporter, batchOpts...),
 		sdkTrace.WithResource(res),
 	)
 	return traceProvider, nil
 }
 
-func newMeterProvider(ctx context.Context, config *sophrosyne.Config, res *resource.Resource) (*sdkMetric.MeterProvider, error) {
-	var metricExporter sdkMetric.Exporter
-	var err error
-	if config.Metrics.Output == sophrosyne.OtelOutputHTTP {
-		metricExporter, err = otlpmetrichttp.New(ctx)
-	} else {
-		metricExporter, err = stdoutmetric.New()
+func newMeterProvider(ctx context.Context, config *sophrosyne.Config, res *resource.Resource) (*sdkMetric.MeterProvider, http.Handler, error) {
+	opts := []sdkMetric.Option{sdkMetric.WithResource(res)}
+
+	if config.Metrics.Enabled {
+		var metricExporter sdkMetric.Exporter
+		var err error
+		if config.Metrics.Output == sophrosyne.OtelOutputHTTP {
+			metricExporter, err = otlpmetrichttp.New(ctx)
+		} else {
+			metricExporter, err = stdoutmetric.New()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, sdkMetric.WithReader(sdkMetric.NewPeriodicReader(metricExporter,
+			sdkMetric.WithInterval(time.Duration(config.Metrics.Interval)*time.Second))))
 	}
-	if err != nil {
-		return nil, err
+
+	var handler http.Handler
+	if config.Metrics.Prometheus.Enabled {
+		registry := prometheus.NewRegistry()
+		promExporter, err := otelprom.New(otelprom.WithRegisterer(registry))
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, sdkMetric.WithReader(promExporter))
+		handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
 	}
 
-	meterProvider := sdkMetric.NewMeterProvider(
-		sdkMetric.WithReader(sdkMetric.NewPeriodicReader(metricExporter,
-			sdkMetric.WithInterval(time.Duration(config.Metrics.Interval)*time.Second))),
-		sdkMetric.WithResource(res),
-	)
-	return meterProvider, nil
+	return sdkMetric.NewMeterProvider(opts...), handler, nil
 }
 
 type Span struct {
@@ -155,8 +182,19 @@ func (s *Span) End() {
 }
 
 type OtelService struct {
-	panicMeter metric.Meter
-	panicCnt   metric.Int64Counter
+	panicMeter    metric.Meter
+	panicCnt      metric.Int64Counter
+	cacheMeter    metric.Meter
+	cacheEntries  metric.Int64ObservableGauge
+	cacheLookups  metric.Int64Counter
+	rpcMeter      metric.Meter
+	rpcDuration   metric.Float64Histogram
+	scanMeter     metric.Meter
+	scanCnt       metric.Int64Counter
+	scanInFlight  metric.Int64UpDownCounter
+	startedAt     time.Time
+	scansTotal    atomic.Int64
+	scansInFlight atomic.Int64
 }
 
 func NewOtelService() (*OtelService, error) {
@@ -167,13 +205,115 @@ func NewOtelService() (*OtelService, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &OtelService{panicMeter: panicMeter, panicCnt: panicCnt}, nil
+	cacheMeter := otel.Meter("cache")
+	cacheEntries, err := cacheMeter.Int64ObservableGauge("cache.entries",
+		metric.WithDescription("Approximate number of entries held in a cache"),
+		metric.WithUnit("{entries}"))
+	if err != nil {
+		return nil, err
+	}
+	cacheLookups, err := cacheMeter.Int64Counter("cache.lookups",
+		metric.WithDescription("Number of lookups made by the caching services, by service, method and result"),
+		metric.WithUnit("{lookups}"))
+	if err != nil {
+		return nil, err
+	}
+	rpcMeter := otel.Meter("rpc")
+	rpcDuration, err := rpcMeter.Float64Histogram("rpc.server.duration",
+		metric.WithDescription("Duration of RPC method invocations"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	scanMeter := otel.Meter("scans")
+	scanCnt, err := scanMeter.Int64Counter("scans",
+		metric.WithDescription("Number of scans performed"),
+		metric.WithUnit("{{total}}"))
+	if err != nil {
+		return nil, err
+	}
+	scanInFlight, err := scanMeter.Int64UpDownCounter("scans.in_flight",
+		metric.WithDescription("Number of scans currently being performed"),
+		metric.WithUnit("{scans}"))
+	if err != nil {
+		return nil, err
+	}
+	return &OtelService{
+		panicMeter:   panicMeter,
+		panicCnt:     panicCnt,
+		cacheMeter:   cacheMeter,
+		cacheEntries: cacheEntries,
+		cacheLookups: cacheLookups,
+		rpcMeter:     rpcMeter,
+		rpcDuration:  rpcDuration,
+		scanMeter:    scanMeter,
+		scanCnt:      scanCnt,
+		scanInFlight: scanInFlight,
+		startedAt:    time.Now(),
+	}, nil
 }
 
 func (o *OtelService) RecordPanic(ctx context.Context) {
 	o.panicCnt.Add(ctx, 1)
 }
 
+func (o *OtelService) RecordScanStarted(ctx context.Context) {
+	o.scansInFlight.Add(1)
+	o.scanInFlight.Add(ctx, 1)
+}
+
+func (o *OtelService) RecordScanFinished(ctx context.Context) {
+	o.scansInFlight.Add(-1)
+	o.scanInFlight.Add(ctx, -1)
+	o.scansTotal.Add(1)
+	o.scanCnt.Add(ctx, 1)
+}
+
+// Status returns a snapshot of the server's activity since the service was
+// created.
+func (o *OtelService) Status() sophrosyne.ServerStatus {
+	return sophrosyne.ServerStatus{
+		StartedAt:     o.startedAt,
+		ScansTotal:    o.scansTotal.Load(),
+		ScansInFlight: o.scansInFlight.Load(),
+	}
+}
+
+func (o *OtelService) RecordRPCCall(ctx context.Context, method string, duration time.Duration) {
+	o.rpcDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attribute.String("rpc.method", method)))
+}
+
+// RecordCacheLookup records whether a lookup made by method of the caching
+// service identified by service was served from the cache.
+func (o *OtelService) RecordCacheLookup(ctx context.Context, service, method string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	o.cacheLookups.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("service", service),
+		attribute.String("method", method),
+		attribute.String("result", result),
+	))
+}
+
+// ObservableCache is a cache whose size can be observed.
+type ObservableCache interface {
+	ItemCount() int
+}
+
+// RegisterCache reports the size of the cache of the caching service
+// identified by name whenever metrics are collected. Lookups in the cache are
+// reported by [OtelService.RecordCacheLookup].
+func (o *OtelService) RegisterCache(name string, c ObservableCache) error {
+	attrs := metric.WithAttributes(attribute.String("service", name))
+	_, err := o.cacheMeter.RegisterCallback(func(_ context.Context, obs metric.Observer) error {
+		obs.ObserveInt64(o.cacheEntries, int64(c.ItemCount()), attrs)
+		return nil
+	}, o.cacheEntries)
+	return err
+}
+
 func (o *OtelService) StartSpan(ctx context.Context, name string) (context.Context, sophrosyne.Span) {
 	ctx, span := otel.Tracer("internal/otel").Start(ctx, name)
 	return ctx, &Span{span: span}
@@ -0,0 +1,118 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package otel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+func TestOtelService_Status(t *testing.T) {
+	ctx := context.Background()
+	o, err := NewOtelService()
+	require.NoError(t, err)
+
+	status := o.Status()
+	require.False(t, status.StartedAt.IsZero())
+	require.Zero(t, status.ScansTotal)
+	require.Zero(t, status.ScansInFlight)
+
+	o.RecordScanStarted(ctx)
+	o.RecordScanStarted(ctx)
+	status = o.Status()
+	require.Equal(t, int64(0), status.ScansTotal)
+	require.Equal(t, int64(2), status.ScansInFlight)
+
+	o.RecordScanFinished(ctx)
+	status = o.Status()
+	require.Equal(t, int64(1), status.ScansTotal)
+	require.Equal(t, int64(1), status.ScansInFlight)
+}
+
+type fakeCache struct{}
+
+func (fakeCache) ItemCount() int { return 3 }
+
+func TestSetupOTelSDK_Prometheus(t *testing.T) {
+	ctx := context.Background()
+	config := &sophrosyne.Config{}
+	config.Metrics.Prometheus.Enabled = true
+
+	shutdown, metricsHandler, err := SetupOTelSDK(ctx, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = shutdown(ctx) })
+	require.NotNil(t, metricsHandler)
+
+	o, err := NewOtelService()
+	require.NoError(t, err)
+	o.RecordRPCCall(ctx, "Users::GetUser", 25*time.Millisecond)
+	require.NoError(t, o.RegisterCache("users", fakeCache{}))
+	o.RecordCacheLookup(ctx, "users", "GetUser", true)
+
+	rec := httptest.NewRecorder()
+	metricsHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	require.Contains(t, body, `rpc_server_duration_seconds_count{otel_scope_name="rpc",otel_scope_version="",rpc_method="Users::GetUser"} 1`)
+	require.Contains(t, body, `cache_lookups_total{method="GetUser",otel_scope_name="cache",otel_scope_version="",result="hit",service="users"} 1`)
+	require.NotContains(t, body, "cache_service_lookups")
+	require.Contains(t, body, `cache_entries{otel_scope_name="cache",otel_scope_version="",service="users"} 3`)
+}
+
+func TestSetupOTelSDK_PrometheusDisabled(t *testing.T) {
+	shutdown, metricsHandler, err := SetupOTelSDK(context.Background(), &sophrosyne.Config{})
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+	require.Nil(t, metricsHandler)
+}
+
+func TestOtelService_NewHTTPHandler_ContinuesTrace(t *testing.T) {
+	prevPropagator := otel.GetTextMapPropagator()
+	prevProvider := otel.GetTracerProvider()
+	otel.SetTextMapPropagator(newPropagator())
+	otel.SetTracerProvider(sdkTrace.NewTracerProvider())
+	t.Cleanup(func() {
+		otel.SetTextMapPropagator(prevPropagator)
+		otel.SetTracerProvider(prevProvider)
+	})
+
+	o, err := NewOtelService()
+	require.NoError(t, err)
+
+	var gotTraceID string
+	handler := o.NewHTTPHandler("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceID = o.GetTraceID(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/rpc", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=value")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", gotTraceID)
+}
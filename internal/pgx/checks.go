@@ -31,13 +31,16 @@ import (
 )
 
 type checkDbEntry struct {
-	ID               string     `db:"id"`
-	Name             string     `db:"name"`
-	UpstreamServices []string   `db:"upstream_services"`
-	CreatedAt        time.Time  `db:"created_at"`
-	UpdatedAt        time.Time  `db:"updated_at"`
-	DeletedAt        *time.Time `db:"deleted_at"`
-	Profiles         []string   `db:"profiles"`
+	ID               string        `db:"id"`
+	Name             string        `db:"name"`
+	UpstreamServices []string      `db:"upstream_services"`
+	ForceResult      string        `db:"force_result"`
+	Shadow           bool          `db:"shadow"`
+	Timeout          time.Duration `db:"timeout"`
+	CreatedAt        time.Time     `db:"created_at"`
+	UpdatedAt        time.Time     `db:"updated_at"`
+	DeletedAt        *time.Time    `db:"deleted_at"`
+	Profiles         []string      `db:"profiles"`
 }
 
 type CheckService struct {
@@ -65,7 +68,10 @@ func (p *CheckService) nameToID(ctx context.Context, name string) (string, error
 	var id string
 	err := row.Scan(&id)
 	if err != nil {
-		return "", err
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", sophrosyne.ErrNotFound
+		}
+		return "", connectionError(err)
 	}
 	return id, nil
 }
@@ -89,7 +95,7 @@ LIMIT 1;`, id)
 		if errors.Is(err, pgx.ErrNoRows) {
 			return sophrosyne.Check{}, sophrosyne.ErrNotFound
 		}
-		return sophrosyne.Check{}, err
+		return sophrosyne.Check{}, connectionError(err)
 	}
 
 	var uss []url.URL
@@ -106,6 +112,9 @@ LIMIT 1;`, id)
 		ID:               check.ID,
 		Name:             check.Name,
 		UpstreamServices: uss,
+		ForceResult:      sophrosyne.ForceResult(check.ForceResult),
+		Shadow:           check.Shadow,
+		Timeout:          check.Timeout,
 		CreatedAt:        check.CreatedAt,
 		UpdatedAt:        check.UpdatedAt,
 		DeletedAt:        check.DeletedAt,
@@ -135,18 +144,20 @@ func (p *CheckService) GetChecks(ctx context.Context, cursor *sophrosyne.Databas
 	rows, _ := p.pool.Query(ctx, `SELECT * FROM checks WHERE id > $1 AND deleted_at IS NULL ORDER BY id ASC LIMIT $2`, cursor.Position, p.config.Services.Checks.PageSize+1)
 	checks, err := pgx.CollectRows(rows, pgx.RowToStructByNameLax[sophrosyne.Check])
 	if err != nil {
-		return []sophrosyne.Check{}, err
-	}
-	if len(checks) == 0 {
-		cursor.Reset()
-	} else if len(checks) <= p.config.Services.Profiles.PageSize && len(checks) > 0 {
-		cursor.Reset()
-	} else if len(checks) > p.config.Services.Profiles.PageSize {
-		cursor.Advance(checks[len(checks)-2].ID)
-		checks = checks[:len(checks)-1]
+		return []sophrosyne.Check{}, connectionError(err)
 	}
+	return pageOfChecks(checks, cursor, p.config.Services.Checks.PageSize), nil
+}
 
-	return checks, nil
+// pageOfChecks trims checks, read with a limit of pageSize+1, to a single page
+// and advances the cursor to the last check of the page.
+func pageOfChecks(checks []sophrosyne.Check, cursor *sophrosyne.DatabaseCursor, pageSize int) []sophrosyne.Check {
+	if len(checks) <= pageSize {
+		cursor.Reset() // We read all the checks, or none at all, so reset the cursor
+		return checks
+	}
+	cursor.Advance(checks[pageSize-1].ID) // We read one extra check, so set the cursor to the second-to-last check
+	return checks[:pageSize]              // Remove the last check
 }
 
 func (p *CheckService) CreateCheck(ctx context.Context, check sophrosyne.CreateCheckRequest) (sophrosyne.Check, error) {
@@ -158,7 +169,11 @@ func (p *CheckService) CreateCheck(ctx context.Context, check sophrosyne.CreateC
 		_ = tx.Rollback(ctx)
 	}()
 
-	rows, _ := tx.Query(ctx, `INSERT INTO checks (name, upstream_services) VALUES ($1, $2) RETURNING *`, check.Name, check.UpstreamServices)
+	forceResult := check.ForceResult
+	if forceResult == "" {
+		forceResult = sophrosyne.ForceResultNone
+	}
+	rows, _ := tx.Query(ctx, `INSERT INTO checks (name, upstream_services, force_result, shadow, timeout) VALUES ($1, $2, $3, $4, $5) RETURNING *`, check.Name, check.UpstreamServices, forceResult, check.Shadow, time.Duration(check.TimeoutMS)*time.Millisecond)
 	retP, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByNameLax[checkDbEntry])
 	if err != nil {
 		return sophrosyne.Check{}, err
@@ -210,6 +225,9 @@ SELECT $1, unnest($2::TEXT[])`, retP.ID, ids)
 		Name:             retP.Name,
 		Profiles:         make([]sophrosyne.Profile, 0, len(check.Profiles)),
 		UpstreamServices: uss,
+		ForceResult:      sophrosyne.ForceResult(retP.ForceResult),
+		Shadow:           retP.Shadow,
+		Timeout:          retP.Timeout,
 		CreatedAt:        retP.CreatedAt,
 		UpdatedAt:        retP.UpdatedAt,
 		DeletedAt:        retP.DeletedAt,
@@ -232,6 +250,27 @@ func (p *CheckService) UpdateCheck(ctx context.Context, check sophrosyne.UpdateC
 		return sophrosyne.Check{}, err
 	}
 
+	if check.ForceResult != "" {
+		_, err = tx.Exec(ctx, `UPDATE checks SET force_result = $2, updated_at = NOW() WHERE id = $1`, pp.ID, check.ForceResult)
+		if err != nil {
+			return sophrosyne.Check{}, err
+		}
+	}
+
+	if check.Shadow != nil {
+		_, err = tx.Exec(ctx, `UPDATE checks SET shadow = $2, updated_at = NOW() WHERE id = $1`, pp.ID, *check.Shadow)
+		if err != nil {
+			return sophrosyne.Check{}, err
+		}
+	}
+
+	if check.TimeoutMS != nil {
+		_, err = tx.Exec(ctx, `UPDATE checks SET timeout = $2, updated_at = NOW() WHERE id = $1`, pp.ID, time.Duration(*check.TimeoutMS)*time.Millisecond)
+		if err != nil {
+			return sophrosyne.Check{}, err
+		}
+	}
+
 	_, err = tx.Exec(ctx, `DELETE FROM profiles_checks
 WHERE check_id = $1 AND profile_id NOT IN (SELECT unnest($2));`, pp.ID, check.Profiles)
 	if err != nil {
@@ -262,11 +301,41 @@ AND c.name = ANY($2);`, pp.ID, check.Profiles)
 		return sophrosyne.Check{}, err
 	}
 
-	return sophrosyne.Check{
-		ID:       pp.ID,
-		Name:     check.Name,
-		Profiles: profiles,
-	}, nil
+	ret := sophrosyne.Check{
+		ID:          pp.ID,
+		Name:        check.Name,
+		Profiles:    profiles,
+		ForceResult: check.ForceResult,
+	}
+	if check.Shadow != nil {
+		ret.Shadow = *check.Shadow
+	}
+	if check.TimeoutMS != nil {
+		ret.Timeout = time.Duration(*check.TimeoutMS) * time.Millisecond
+	}
+	return ret, nil
+}
+
+// checkUsageQuery selects the profiles that include the check with the given
+// ID, ordered by name.
+const checkUsageQuery = `SELECT p.id, p.name, p.created_at, p.updated_at
+FROM profiles p
+JOIN profiles_checks pc ON p.id = pc.profile_id
+WHERE pc.check_id = $1 AND p.deleted_at IS NULL
+ORDER BY p.name ASC`
+
+func (p *CheckService) GetCheckUsage(ctx context.Context, name string) ([]sophrosyne.Profile, error) {
+	id, err := p.nameToID(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	p.logger.DebugContext(ctx, "GetCheckUsage", "name", name, "id", id)
+	rows, _ := p.pool.Query(ctx, checkUsageQuery, id)
+	profiles, err := pgx.CollectRows(rows, pgx.RowToStructByNameLax[sophrosyne.Profile])
+	if err != nil {
+		return nil, connectionError(err)
+	}
+	return profiles, nil
 }
 
 func (p *CheckService) DeleteCheck(ctx context.Context, name string) error {
@@ -23,6 +23,9 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
@@ -45,7 +48,18 @@ func newPool(ctx context.Context, config *sophrosyne.Config, logger *slog.Logger
 		logger.DebugContext(ctx, "database connection established")
 		return nil
 	}
-	return pgxpool.NewWithConfig(ctx, pgxconfig)
+	pool, err := pgxpool.NewWithConfig(ctx, pgxconfig)
+	if err != nil {
+		return nil, err
+	}
+	// Connections are established lazily, so ping to surface an unreachable
+	// database at startup.
+	err = pool.Ping(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
 }
 
 type UserService struct {
@@ -56,7 +70,27 @@ type UserService struct {
 	profileService sophrosyne.ProfileService
 }
 
+// NewUserService connects to the users in the database and creates, or
+// recreates, the root user. It is used by the server; use [OpenUserService]
+// to manage users without doing so.
 func NewUserService(ctx context.Context, config *sophrosyne.Config, logger *slog.Logger, randomSource io.Reader, profileService sophrosyne.ProfileService) (*UserService, error) {
+	ue, err := OpenUserService(ctx, config, logger, randomSource, profileService)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ue.createRootUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ue, nil
+}
+
+// OpenUserService connects to the users in the database without writing to
+// it. It fails if emails are to be hashed at rest but some are stored in
+// plaintext.
+func OpenUserService(ctx context.Context, config *sophrosyne.Config, logger *slog.Logger, randomSource io.Reader, profileService sophrosyne.ProfileService) (*UserService, error) {
 	pool, err := newPool(ctx, config, logger)
 	if err != nil {
 		return nil, err
@@ -70,7 +104,7 @@ func NewUserService(ctx context.Context, config *sophrosyne.Config, logger *slog
 		profileService: profileService,
 	}
 
-	err = ue.createRootUser(ctx)
+	err = ue.checkEmailsAtRest(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -78,27 +112,54 @@ func NewUserService(ctx context.Context, config *sophrosyne.Config, logger *slog
 	return ue, nil
 }
 
+// errPlaintextEmails is returned when
+// [sophrosyne.Config.Services.Users.HashEmails] is enabled but users already
+// have emails stored in plaintext. Emails are only hashed when written, so such
+// users could no longer be looked up by email.
+var errPlaintextEmails = errors.New("services.users.hashEmails cannot be enabled while users have emails stored in plaintext")
+
+// checkEmailsAtRest returns errPlaintextEmails if emails are hashed at rest
+// but a user that is not deleted has an email that is not a hash.
+func (s *UserService) checkEmailsAtRest(ctx context.Context) error {
+	if !s.config.Services.Users.HashEmails {
+		return nil
+	}
+	var plaintext bool
+	err := s.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE deleted_at IS NULL AND email <> '' AND email !~ '^[0-9a-f]{64}$')").Scan(&plaintext)
+	if err != nil {
+		return connectionError(err)
+	}
+	if plaintext {
+		return errPlaintextEmails
+	}
+	return nil
+}
+
 func (s *UserService) getUser(ctx context.Context, column, input any) (sophrosyne.User, error) {
 	type dbret struct {
-		ID             string      `db:"id"`
-		Name           string      `db:"name"`
-		Email          string      `db:"email"`
-		Token          []byte      `db:"token"`
-		IsAdmin        bool        `db:"is_admin"`
-		DefaultProfile pgtype.Text `db:"default_profile"`
-		CreatedAt      time.Time   `db:"created_at"`
-		UpdatedAt      time.Time   `db:"updated_at"`
-		DeletedAt      *time.Time  `db:"deleted_at"`
+		ID                     string      `db:"id"`
+		Name                   string      `db:"name"`
+		Email                  string      `db:"email"`
+		Token                  []byte      `db:"token"`
+		IsAdmin                bool        `db:"is_admin"`
+		DefaultProfile         pgtype.Text `db:"default_profile"`
+		CreatedAt              time.Time   `db:"created_at"`
+		UpdatedAt              time.Time   `db:"updated_at"`
+		DeletedAt              *time.Time  `db:"deleted_at"`
+		TokenExpiresAt         *time.Time  `db:"token_expires_at"`
+		PreviousToken          []byte      `db:"previous_token"`
+		PreviousTokenExpiresAt *time.Time  `db:"previous_token_expires_at"`
 	}
 	var rows pgx.Rows
 	if column == "email" {
-		rows, _ = s.pool.Query(ctx, "SELECT * FROM users WHERE email = $1 AND deleted_at IS NULL LIMIT 1", input)
+		rows, _ = s.pool.Query(ctx, "SELECT * FROM users WHERE email = $1 AND deleted_at IS NULL LIMIT 1", s.emailAtRest(input.(string)))
 	} else if column == "name" {
 		rows, _ = s.pool.Query(ctx, "SELECT * FROM users WHERE name = $1 AND deleted_at IS NULL LIMIT 1", input)
 	} else if column == "id" {
 		rows, _ = s.pool.Query(ctx, "SELECT * FROM users WHERE id = $1 AND deleted_at IS NULL LIMIT 1", input)
 	} else if column == "token" {
-		rows, _ = s.pool.Query(ctx, "SELECT * FROM users WHERE token = $1 AND deleted_at IS NULL LIMIT 1", input)
+		// Expiry is left to the caller, see sophrosyne.User.ValidToken
+		rows, _ = s.pool.Query(ctx, "SELECT * FROM users WHERE (token = $1 OR previous_token = $1) AND deleted_at IS NULL LIMIT 1", input)
 	} else {
 		return sophrosyne.User{}, sophrosyne.NewUnreachableCodeError()
 	}
@@ -107,37 +168,75 @@ func (s *UserService) getUser(ctx context.Context, column, input any) (sophrosyn
 		if errors.Is(err, pgx.ErrNoRows) {
 			return sophrosyne.User{}, sophrosyne.ErrNotFound
 		}
-		return sophrosyne.User{}, err
+		return sophrosyne.User{}, connectionError(err)
 	}
 
 	ret := sophrosyne.User{
-		ID:        user.ID,
-		Name:      user.Name,
-		Email:     user.Email,
-		Token:     user.Token,
-		IsAdmin:   user.IsAdmin,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-		DeletedAt: user.DeletedAt,
-	}
-
-	if user.DefaultProfile.String == "" {
-		prof, err := s.profileService.GetProfileByName(ctx, "default")
-		if err != nil {
-			return sophrosyne.User{}, err
-		}
-		ret.DefaultProfile = prof
-	} else {
-		prof, err := s.profileService.GetProfileByName(ctx, user.DefaultProfile.String)
-		if err != nil {
-			return sophrosyne.User{}, err
-		}
-		ret.DefaultProfile = prof
+		ID:                     user.ID,
+		Name:                   user.Name,
+		Email:                  user.Email,
+		Token:                  user.Token,
+		TokenExpiresAt:         user.TokenExpiresAt,
+		PreviousToken:          user.PreviousToken,
+		PreviousTokenExpiresAt: user.PreviousTokenExpiresAt,
+		IsAdmin:                user.IsAdmin,
+		CreatedAt:              user.CreatedAt,
+		UpdatedAt:              user.UpdatedAt,
+		DeletedAt:              user.DeletedAt,
+	}
+
+	prof, err := s.defaultProfile(ctx, user.ID, user.DefaultProfile.String)
+	if err != nil {
+		return sophrosyne.User{}, err
 	}
+	ret.DefaultProfile = prof
 
 	return ret, nil
 }
 
+// defaultProfile retrieves the profile with the given name to be used as the
+// default profile of a user. If name is empty, the service-wide default profile
+// is retrieved instead.
+//
+// If the profile does not exist, the outcome depends on the configured
+// [sophrosyne.MissingProfileMode]. Any other error is returned as is.
+func (s *UserService) defaultProfile(ctx context.Context, userID string, name string) (sophrosyne.Profile, error) {
+	if name == "" {
+		name = "default"
+	}
+	prof, err := s.profileService.GetProfileByName(ctx, name)
+	if err == nil {
+		return prof, nil
+	}
+	if !errors.Is(err, sophrosyne.ErrNotFound) {
+		return sophrosyne.Profile{}, err
+	}
+
+	switch s.config.Services.Users.MissingProfile {
+	case sophrosyne.MissingProfileModeDefault:
+		if name != "default" {
+			s.logger.WarnContext(ctx, "unable to get default profile of user, falling back to service-wide default profile", "user", userID, "profile", name, "error", err)
+			return s.profileService.GetProfileByName(ctx, "default")
+		}
+	case sophrosyne.MissingProfileModeNone:
+		s.logger.WarnContext(ctx, "unable to get default profile of user, continuing without it", "user", userID, "profile", name, "error", err)
+		return sophrosyne.Profile{}, nil
+	}
+
+	return sophrosyne.Profile{}, err
+}
+
+// emailAtRest returns the email as it is stored in the database. If
+// [sophrosyne.Config.Services.Users.HashEmails] is enabled, this is the
+// protected email as returned by [sophrosyne.ProtectEmail]. An empty email is
+// stored as it is, so that it is never considered taken by withEmail.
+func (s *UserService) emailAtRest(email string) string {
+	if s.config.Services.Users.HashEmails && email != "" {
+		return sophrosyne.ProtectEmail(email, s.config)
+	}
+	return email
+}
+
 func (s *UserService) GetUser(ctx context.Context, id string) (sophrosyne.User, error) {
 	return s.getUser(ctx, "id", id)
 }
@@ -155,22 +254,40 @@ func (s *UserService) GetUsers(ctx context.Context, cursor *sophrosyne.DatabaseC
 		cursor = &sophrosyne.DatabaseCursor{}
 	}
 	s.logger.DebugContext(ctx, "getting users", "cursor", cursor)
-	rows, _ := s.pool.Query(ctx, "SELECT * FROM users WHERE id > $1 AND deleted_at IS NULL ORDER BY id ASC LIMIT $2", cursor.Position, s.config.Services.Users.PageSize+1)
+	query, args := getUsersQuery(cursor, s.config.Services.Users.PageSize+1)
+	rows, _ := s.pool.Query(ctx, query, args...)
 	users, err := pgx.CollectRows(rows, pgx.RowToStructByName[sophrosyne.User])
 	if err != nil {
-		return []sophrosyne.User{}, err
-	}
-	// Advance the cursor
-	if len(users) == 0 {
-		cursor.Reset() // No users were read, so reset the cursor
-	} else if len(users) <= s.config.Services.Users.PageSize && len(users) > 0 {
-		cursor.Reset() // We read all the users, so reset the cursor
-	} else if len(users) > s.config.Services.Users.PageSize {
-		cursor.Advance(users[len(users)-2].ID) // We read one extra user, so set the cursor to the second-to-last user
-		users = users[:len(users)-1]           // Remove the last user
-	}
-	return users, nil
+		return []sophrosyne.User{}, connectionError(err)
+	}
+	return pageOfUsers(users, cursor, s.config.Services.Users.PageSize), nil
+}
+
+// getUsersQuery returns the query, and its arguments, selecting up to limit
+// users following the position of the cursor in the order of the cursor.
+func getUsersQuery(cursor *sophrosyne.DatabaseCursor, limit int) (string, []any) {
+	if cursor.Order != sophrosyne.SortOrderDescending {
+		return "SELECT * FROM users WHERE id > $1 AND deleted_at IS NULL ORDER BY id ASC LIMIT $2", []any{cursor.Position, limit}
+	}
+	if cursor.Position == "" {
+		return "SELECT * FROM users WHERE deleted_at IS NULL ORDER BY id DESC LIMIT $1", []any{limit}
+	}
+	return "SELECT * FROM users WHERE id < $1 AND deleted_at IS NULL ORDER BY id DESC LIMIT $2", []any{cursor.Position, limit}
 }
+
+// pageOfUsers trims users, read with a limit of pageSize+1, to a single page
+// and advances the cursor. As the users are already in the order of the
+// cursor, the cursor is advanced to the last user of the page regardless of
+// the direction.
+func pageOfUsers(users []sophrosyne.User, cursor *sophrosyne.DatabaseCursor, pageSize int) []sophrosyne.User {
+	if len(users) <= pageSize {
+		cursor.Reset() // We read all the users, or none at all, so reset the cursor
+		return users
+	}
+	cursor.Advance(users[pageSize-1].ID) // We read one extra user, so set the cursor to the second-to-last user
+	return users[:pageSize]              // Remove the last user
+}
+
 func (s *UserService) CreateUser(ctx context.Context, user sophrosyne.CreateUserRequest) (sophrosyne.User, error) {
 	token, err := sophrosyne.NewToken(s.randomSource)
 	if err != nil {
@@ -178,33 +295,34 @@ func (s *UserService) CreateUser(ctx context.Context, user sophrosyne.CreateUser
 	}
 	tokenHash := sophrosyne.ProtectToken(token, s.config)
 
-	rows, _ := s.pool.Query(ctx, "INSERT INTO users (name, email, token, is_admin) VALUES ($1, $2, $3, $4) RETURNING *", user.Name, user.Email, tokenHash, user.IsAdmin)
-	newUser, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[sophrosyne.User])
+	var newUser *sophrosyne.User
+	err = s.withEmail(ctx, user.Name, user.Email, func(tx pgx.Tx) error {
+		var err error
+		rows, _ := tx.Query(ctx, "INSERT INTO users (name, email, token, is_admin, token_expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING *", user.Name, s.emailAtRest(user.Email), tokenHash, user.IsAdmin, expiresIn(s.config.Security.Tokens.TTL))
+		newUser, err = pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[sophrosyne.User])
+		if err != nil {
+			return err
+		}
+		return sophrosyne.DeliverToken(ctx, user.Name, token)
+	})
 	if err != nil {
 		s.logger.DebugContext(ctx, "database returned error", "error", err)
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			if pgErr.Code == "23505" {
-				return sophrosyne.User{}, sophrosyne.NewConstraintViolationError(pgErr, pgErr.Code, pgErr.Detail, pgErr.TableName, pgErr.ConstraintName)
-			}
-		}
-		return sophrosyne.User{}, err
+		return sophrosyne.User{}, writeError(err)
 	}
 	newUser.Token = token // ensure returned token is the raw token, not the hashed token
 	return *newUser, nil
 }
 func (s *UserService) UpdateUser(ctx context.Context, user sophrosyne.UpdateUserRequest) (sophrosyne.User, error) {
-	rows, _ := s.pool.Query(ctx, "UPDATE users SET email = $1, is_admin = $2 WHERE name = $3 AND deleted_at IS NULL RETURNING *", user.Email, user.IsAdmin, user.Name)
-	updatedUser, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[sophrosyne.User])
+	var updatedUser *sophrosyne.User
+	err := s.withEmail(ctx, user.Name, user.Email, func(tx pgx.Tx) error {
+		var err error
+		rows, _ := tx.Query(ctx, "UPDATE users SET email = $1, is_admin = $2 WHERE name = $3 AND deleted_at IS NULL RETURNING *", s.emailAtRest(user.Email), user.IsAdmin, user.Name)
+		updatedUser, err = pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[sophrosyne.User])
+		return err
+	})
 	if err != nil {
 		s.logger.DebugContext(ctx, "database returned error", "error", err)
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			if pgErr.Code == "23505" {
-				return sophrosyne.User{}, sophrosyne.NewConstraintViolationError(pgErr, pgErr.Code, pgErr.Detail, pgErr.TableName, pgErr.ConstraintName)
-			}
-		}
-		return sophrosyne.User{}, err
+		return sophrosyne.User{}, writeError(err)
 	}
 	return *updatedUser, nil
 }
@@ -226,16 +344,118 @@ func (s *UserService) RotateToken(ctx context.Context, name string) ([]byte, err
 	}
 	tokenHash := sophrosyne.ProtectToken(token, s.config)
 
-	cmdTag, err := s.pool.Exec(ctx, "UPDATE users SET token = $1 WHERE name = $2 AND deleted_at IS NULL", tokenHash, name)
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	// The previous token is only kept if it remains valid for a grace window.
+	// All expressions of SET refer to the row before the update.
+	cmdTag, err := tx.Exec(ctx, `UPDATE users SET
+    previous_token = CASE WHEN $3::timestamptz IS NULL THEN NULL ELSE token END,
+    previous_token_expires_at = $3,
+    token = $1,
+    token_expires_at = $4
+WHERE name = $2 AND deleted_at IS NULL`, tokenHash, name, expiresIn(s.config.Security.Tokens.RotationGrace), expiresIn(s.config.Security.Tokens.TTL))
 	if err != nil {
 		return nil, err
 	}
 	if cmdTag.RowsAffected() == 0 {
 		return nil, sophrosyne.ErrNotFound
 	}
+	err = sophrosyne.DeliverToken(ctx, name, token)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
 	return token, nil
 }
 
+// withEmail runs fn, writing a user named name with the given email, in a
+// transaction. If [sophrosyne.Config.Services.Users.UniqueEmail] is enabled,
+// the email is locked for the duration of the transaction and fn is not run if
+// another user that is not deleted already has it. Empty emails are not
+// subject to this.
+func (s *UserService) withEmail(ctx context.Context, name, email string, fn func(tx pgx.Tx) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if s.config.Services.Users.UniqueEmail && email != "" {
+		atRest := s.emailAtRest(email)
+		// Serializes writes of the same email, so that two users cannot be
+		// given it concurrently.
+		_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtextextended('users.email:' || $1, 0))", atRest)
+		if err != nil {
+			return err
+		}
+		var taken bool
+		err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE email = $1 AND name <> $2 AND deleted_at IS NULL)", atRest, name).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return sophrosyne.ErrEmailInUse
+		}
+	}
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// writeError returns the error to report for err, as returned by a statement
+// creating or updating a user. Unique violations are returned as a
+// [sophrosyne.ConstraintViolationError].
+func writeError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return err
+	}
+	return sophrosyne.NewConstraintViolationError(pgErr, pgErr.Code, pgErr.Detail, pgErr.TableName, pgErr.ConstraintName)
+}
+
+// expiresIn returns the time d from now, or nil if d is not positive.
+func expiresIn(d time.Duration) *time.Time {
+	if d <= 0 {
+		return nil
+	}
+	t := time.Now().Add(d)
+	return &t
+}
+
+// connectionError wraps err in [sophrosyne.ErrConnection] if it was caused by
+// the connection to the database, rather than by the statement, such as when
+// the connection is lost or the server shuts down during a failover.
+func connectionError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection exceptions, 57P01 to 57P03 is the server
+		// shutting down or not accepting connections.
+		if strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03" {
+			return fmt.Errorf("%w: %w", sophrosyne.ErrConnection, err)
+		}
+		return err
+	}
+	var netErr net.Error
+	if pgconn.SafeToRetry(err) || errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %w", sophrosyne.ErrConnection, err)
+	}
+	return err
+}
+
 func (s *UserService) Health(ctx context.Context) (bool, []byte) {
 	_, err := s.pool.Exec(ctx, "SELECT 1")
 	if err != nil {
@@ -257,7 +477,7 @@ func (s *UserService) createRootUser(ctx context.Context) error {
 	}()
 	// Check if root user exists and exit early if it does
 	var exists bool
-	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE name = $1 AND email = $2 AND is_admin = true)", s.config.Principals.Root.Name, s.config.Principals.Root.Email).Scan(&exists)
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE name = $1 AND email = $2 AND is_admin = true)", s.config.Principals.Root.Name, s.emailAtRest(s.config.Principals.Root.Email)).Scan(&exists)
 	if err != nil {
 		return err
 	}
@@ -278,9 +498,8 @@ func (s *UserService) createRootUser(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	s.logger.InfoContext(ctx, "root token", "token", base64.StdEncoding.EncodeToString(token))
 	tokenHash := sophrosyne.ProtectToken(token, s.config)
-	_, err = tx.Exec(ctx, "INSERT INTO users (name, email, token, is_admin) VALUES ($1, $2, $3, true) ON CONFLICT (name) DO UPDATE SET email = $2, token = $3, is_admin = true", s.config.Principals.Root.Name, s.config.Principals.Root.Email, tokenHash)
+	_, err = tx.Exec(ctx, "INSERT INTO users (name, email, token, is_admin) VALUES ($1, $2, $3, true) ON CONFLICT (name) DO UPDATE SET email = $2, token = $3, is_admin = true, token_expires_at = NULL, previous_token = NULL, previous_token_expires_at = NULL", s.config.Principals.Root.Name, s.emailAtRest(s.config.Principals.Root.Email), tokenHash)
 	if err != nil {
 		return err
 	}
@@ -289,6 +508,33 @@ func (s *UserService) createRootUser(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	// The token is only handed out once it is stored, so that a failed commit
+	// does not replace the token in the file with one that does not work.
+	if s.config.Development.StaticRootToken == "" && s.config.Principals.Root.TokenOutputFile != "" {
+		err = writeRootToken(s.config.Principals.Root.TokenOutputFile, token)
+		if err != nil {
+			return err
+		}
+		s.logger.InfoContext(ctx, "root token written to file", "path", s.config.Principals.Root.TokenOutputFile)
+	} else {
+		s.logger.InfoContext(ctx, "root token", "token", base64.StdEncoding.EncodeToString(token))
+	}
 	return nil
 
 }
+
+// writeRootToken writes the base64 encoded token to the file at path. The file
+// is created if it does not exist, and is truncated and made readable and
+// writable by the owner only if it does.
+func writeRootToken(path string, token []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(0600)
+	if err != nil {
+		return errors.Join(err, f.Close())
+	}
+	_, err = f.WriteString(base64.StdEncoding.EncodeToString(token))
+	return errors.Join(err, f.Close())
+}
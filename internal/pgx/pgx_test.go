@@ -0,0 +1,357 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package pgx
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+)
+
+func TestUserService_defaultProfile(t *testing.T) {
+	defaultProfile := sophrosyne.Profile{ID: "1", Name: "default"}
+	namedProfile := sophrosyne.Profile{ID: "2", Name: "named"}
+
+	cases := []struct {
+		name    string
+		mode    sophrosyne.MissingProfileMode
+		profile string
+		setup   func(ps *sophrosyne2.MockProfileService)
+		want    sophrosyne.Profile
+		wantErr error
+	}{
+		{
+			name:    "named profile found",
+			mode:    sophrosyne.MissingProfileModeFail,
+			profile: "named",
+			setup: func(ps *sophrosyne2.MockProfileService) {
+				ps.EXPECT().GetProfileByName(context.Background(), "named").Return(namedProfile, nil).Once()
+			},
+			want: namedProfile,
+		},
+		{
+			name: "no profile uses service-wide default",
+			mode: sophrosyne.MissingProfileModeFail,
+			setup: func(ps *sophrosyne2.MockProfileService) {
+				ps.EXPECT().GetProfileByName(context.Background(), "default").Return(defaultProfile, nil).Once()
+			},
+			want: defaultProfile,
+		},
+		{
+			name:    "missing profile fails",
+			mode:    sophrosyne.MissingProfileModeFail,
+			profile: "named",
+			setup: func(ps *sophrosyne2.MockProfileService) {
+				ps.EXPECT().GetProfileByName(context.Background(), "named").Return(sophrosyne.Profile{}, sophrosyne.ErrNotFound).Once()
+			},
+			wantErr: sophrosyne.ErrNotFound,
+		},
+		{
+			name:    "missing profile falls back to default",
+			mode:    sophrosyne.MissingProfileModeDefault,
+			profile: "named",
+			setup: func(ps *sophrosyne2.MockProfileService) {
+				ps.EXPECT().GetProfileByName(context.Background(), "named").Return(sophrosyne.Profile{}, sophrosyne.ErrNotFound).Once()
+				ps.EXPECT().GetProfileByName(context.Background(), "default").Return(defaultProfile, nil).Once()
+			},
+			want: defaultProfile,
+		},
+		{
+			name:    "missing profile and missing default fails",
+			mode:    sophrosyne.MissingProfileModeDefault,
+			profile: "named",
+			setup: func(ps *sophrosyne2.MockProfileService) {
+				ps.EXPECT().GetProfileByName(context.Background(), "named").Return(sophrosyne.Profile{}, sophrosyne.ErrNotFound).Once()
+				ps.EXPECT().GetProfileByName(context.Background(), "default").Return(sophrosyne.Profile{}, assert.AnError).Once()
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "missing service-wide default is not retried",
+			mode: sophrosyne.MissingProfileModeDefault,
+			setup: func(ps *sophrosyne2.MockProfileService) {
+				ps.EXPECT().GetProfileByName(context.Background(), "default").Return(sophrosyne.Profile{}, sophrosyne.ErrNotFound).Once()
+			},
+			wantErr: sophrosyne.ErrNotFound,
+		},
+		{
+			name:    "error getting profile is not a missing profile",
+			mode:    sophrosyne.MissingProfileModeDefault,
+			profile: "named",
+			setup: func(ps *sophrosyne2.MockProfileService) {
+				ps.EXPECT().GetProfileByName(context.Background(), "named").Return(sophrosyne.Profile{}, assert.AnError).Once()
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name:    "error getting profile does not continue without it",
+			mode:    sophrosyne.MissingProfileModeNone,
+			profile: "named",
+			setup: func(ps *sophrosyne2.MockProfileService) {
+				ps.EXPECT().GetProfileByName(context.Background(), "named").Return(sophrosyne.Profile{}, assert.AnError).Once()
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name:    "missing profile returns no profile",
+			mode:    sophrosyne.MissingProfileModeNone,
+			profile: "named",
+			setup: func(ps *sophrosyne2.MockProfileService) {
+				ps.EXPECT().GetProfileByName(context.Background(), "named").Return(sophrosyne.Profile{}, sophrosyne.ErrNotFound).Once()
+			},
+			want: sophrosyne.Profile{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &sophrosyne.Config{}
+			config.Services.Users.MissingProfile = tc.mode
+			profileService := sophrosyne2.NewMockProfileService(t)
+			tc.setup(profileService)
+			s := &UserService{
+				config:         config,
+				logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+				profileService: profileService,
+			}
+
+			got, err := s.defaultProfile(context.Background(), "user-id", tc.profile)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestUserService_emailAtRest(t *testing.T) {
+	t.Run("plaintext", func(t *testing.T) {
+		s := &UserService{config: &sophrosyne.Config{}}
+		require.Equal(t, "user@example.com", s.emailAtRest("user@example.com"))
+	})
+	t.Run("hashed", func(t *testing.T) {
+		config := &sophrosyne.Config{}
+		config.Services.Users.HashEmails = true
+		config.Security.SiteKey = []byte("0123456789012345678901234567890123456789012345678901234567890123")
+		s := &UserService{config: config}
+
+		// The value written on create must match the value queried on lookup.
+		stored := s.emailAtRest("user@example.com")
+		require.NotEqual(t, "user@example.com", stored)
+		require.Equal(t, sophrosyne.ProtectEmail("user@example.com", config), stored)
+		require.Equal(t, stored, s.emailAtRest("user@example.com"))
+		require.Empty(t, s.emailAtRest(""), "empty emails are left out of the unique index")
+	})
+}
+
+func TestWriteRootToken(t *testing.T) {
+	token := []byte("this is the root token")
+	want := base64.StdEncoding.EncodeToString(token)
+
+	t.Run("new file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "root.token")
+
+		require.NoError(t, writeRootToken(path, token))
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, want, string(content))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+	t.Run("existing file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "root.token")
+		require.NoError(t, os.WriteFile(path, []byte("a much longer previous token that must be truncated"), 0644))
+
+		require.NoError(t, writeRootToken(path, token))
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, want, string(content))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+	t.Run("missing directory", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "missing", "root.token")
+		require.Error(t, writeRootToken(path, token))
+	})
+}
+
+func TestGetUsersQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		cursor    sophrosyne.DatabaseCursor
+		wantQuery string
+		wantArgs  []any
+	}{
+		{
+			name:      "ascending first page",
+			cursor:    sophrosyne.DatabaseCursor{},
+			wantQuery: "SELECT * FROM users WHERE id > $1 AND deleted_at IS NULL ORDER BY id ASC LIMIT $2",
+			wantArgs:  []any{"", 3},
+		},
+		{
+			name:      "ascending next page",
+			cursor:    sophrosyne.DatabaseCursor{Position: "b", Order: sophrosyne.SortOrderAscending},
+			wantQuery: "SELECT * FROM users WHERE id > $1 AND deleted_at IS NULL ORDER BY id ASC LIMIT $2",
+			wantArgs:  []any{"b", 3},
+		},
+		{
+			name:      "descending first page",
+			cursor:    sophrosyne.DatabaseCursor{Order: sophrosyne.SortOrderDescending},
+			wantQuery: "SELECT * FROM users WHERE deleted_at IS NULL ORDER BY id DESC LIMIT $1",
+			wantArgs:  []any{3},
+		},
+		{
+			name:      "descending next page",
+			cursor:    sophrosyne.DatabaseCursor{Position: "b", Order: sophrosyne.SortOrderDescending},
+			wantQuery: "SELECT * FROM users WHERE id < $1 AND deleted_at IS NULL ORDER BY id DESC LIMIT $2",
+			wantArgs:  []any{"b", 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args := getUsersQuery(&tt.cursor, 3)
+			require.Equal(t, tt.wantQuery, query)
+			require.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestPageOfUsers(t *testing.T) {
+	users := func(ids ...string) []sophrosyne.User {
+		var u []sophrosyne.User
+		for _, id := range ids {
+			u = append(u, sophrosyne.User{ID: id})
+		}
+		return u
+	}
+	tests := []struct {
+		name         string
+		order        sophrosyne.SortOrder
+		rows         []sophrosyne.User
+		want         []sophrosyne.User
+		wantPosition string
+	}{
+		{name: "ascending with more pages", order: sophrosyne.SortOrderAscending, rows: users("a", "b", "c"), want: users("a", "b"), wantPosition: "b"},
+		{name: "ascending last page", order: sophrosyne.SortOrderAscending, rows: users("c"), want: users("c")},
+		{name: "descending with more pages", order: sophrosyne.SortOrderDescending, rows: users("c", "b", "a"), want: users("c", "b"), wantPosition: "b"},
+		{name: "descending last page", order: sophrosyne.SortOrderDescending, rows: users("b", "a"), want: users("b", "a")},
+		{name: "no users", order: sophrosyne.SortOrderDescending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := &sophrosyne.DatabaseCursor{OwnerID: "owner", Position: "previous", Order: tt.order}
+			got := pageOfUsers(tt.rows, cursor, 2)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.wantPosition, cursor.Position)
+			require.Equal(t, tt.order, cursor.Order)
+		})
+	}
+}
+
+func TestPageOfChecks(t *testing.T) {
+	checks := func(ids ...string) []sophrosyne.Check {
+		var c []sophrosyne.Check
+		for _, id := range ids {
+			c = append(c, sophrosyne.Check{ID: id})
+		}
+		return c
+	}
+	tests := []struct {
+		name         string
+		rows         []sophrosyne.Check
+		want         []sophrosyne.Check
+		wantPosition string
+	}{
+		{name: "more pages", rows: checks("a", "b", "c"), want: checks("a", "b"), wantPosition: "b"},
+		{name: "full last page", rows: checks("c", "d"), want: checks("c", "d")},
+		{name: "short last page", rows: checks("e"), want: checks("e")},
+		{name: "no checks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := &sophrosyne.DatabaseCursor{OwnerID: "owner", Position: "previous"}
+			got := pageOfChecks(tt.rows, cursor, 2)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.wantPosition, cursor.Position)
+		})
+	}
+}
+
+func TestConnectionError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, want: true},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, want: true},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, want: true},
+		{name: "network error", err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, want: true},
+		{name: "other", err: assert.AnError},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := connectionError(tc.err)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.want, errors.Is(err, sophrosyne.ErrConnection))
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	cases := []struct {
+		name           string
+		err            error
+		wantConstraint bool
+		wantEmailInUse bool
+	}{
+		{name: "name taken", err: &pgconn.PgError{Code: "23505", ConstraintName: "users_name_key"}, wantConstraint: true},
+		{name: "other database error", err: &pgconn.PgError{Code: "23502", ConstraintName: "users_name_key"}},
+		{name: "email taken", err: sophrosyne.ErrEmailInUse, wantEmailInUse: true},
+		{name: "other", err: assert.AnError},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := writeError(tc.err)
+			require.ErrorIs(t, err, tc.err)
+			var cvErr *sophrosyne.ConstraintViolationError
+			require.Equal(t, tc.wantConstraint, errors.As(err, &cvErr))
+			require.Equal(t, tc.wantEmailInUse, errors.Is(err, sophrosyne.ErrEmailInUse))
+		})
+	}
+}
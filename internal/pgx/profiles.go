@@ -36,17 +36,14 @@ type ProfileService struct {
 	checkService sophrosyne.CheckService
 }
 
+// NewProfileService connects to the profiles in the database and creates the
+// default profile if it does not exist. Use [OpenProfileService] to read
+// profiles without doing so.
 func NewProfileService(ctx context.Context, config *sophrosyne.Config, logger *slog.Logger, checkService sophrosyne.CheckService) (*ProfileService, error) {
-	pool, err := newPool(ctx, config, logger)
+	ps, err := OpenProfileService(ctx, config, logger, checkService)
 	if err != nil {
 		return nil, err
 	}
-	ps := &ProfileService{
-		config:       config,
-		pool:         pool,
-		logger:       logger,
-		checkService: checkService,
-	}
 
 	err = ps.createDefaultProfile(ctx)
 	if err != nil {
@@ -56,12 +53,30 @@ func NewProfileService(ctx context.Context, config *sophrosyne.Config, logger *s
 	return ps, nil
 }
 
+// OpenProfileService connects to the profiles in the database without writing
+// to it.
+func OpenProfileService(ctx context.Context, config *sophrosyne.Config, logger *slog.Logger, checkService sophrosyne.CheckService) (*ProfileService, error) {
+	pool, err := newPool(ctx, config, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &ProfileService{
+		config:       config,
+		pool:         pool,
+		logger:       logger,
+		checkService: checkService,
+	}, nil
+}
+
 func (p *ProfileService) nameToID(ctx context.Context, name string) (string, error) {
 	row := p.pool.QueryRow(ctx, `SELECT id FROM profiles WHERE name = $1 LIMIT 1`, name)
 	var id string
 	err := row.Scan(&id)
 	if err != nil {
-		return "", err
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", sophrosyne.ErrNotFound
+		}
+		return "", connectionError(err)
 	}
 	return id, nil
 }
@@ -93,7 +108,7 @@ LIMIT 1;`, id)
 		if errors.Is(err, pgx.ErrNoRows) {
 			return sophrosyne.Profile{}, sophrosyne.ErrNotFound
 		}
-		return sophrosyne.Profile{}, err
+		return sophrosyne.Profile{}, connectionError(err)
 	}
 
 	ret := sophrosyne.Profile{
@@ -130,7 +145,7 @@ func (p *ProfileService) GetProfiles(ctx context.Context, cursor *sophrosyne.Dat
 	rows, _ := p.pool.Query(ctx, `SELECT * FROM profiles WHERE id > $1 AND deleted_at IS NULL ORDER BY id ASC LIMIT $2`, cursor.Position, p.config.Services.Profiles.PageSize+1)
 	profiles, err := pgx.CollectRows(rows, pgx.RowToStructByNameLax[sophrosyne.Profile])
 	if err != nil {
-		return []sophrosyne.Profile{}, err
+		return []sophrosyne.Profile{}, connectionError(err)
 	}
 	if len(profiles) == 0 {
 		cursor.Reset()
@@ -0,0 +1,132 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgx
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+// pruneQueries hard-deletes at most $2 rows soft-deleted before $1, per entity
+// type. Rows still referenced by other rows are kept until the reference is
+// gone. Users are pruned before profiles, and profiles before checks, so that
+// references removed by one statement free up rows for the next.
+var pruneQueries = []struct {
+	entity string
+	query  string
+}{
+	{
+		entity: "users",
+		query:  `DELETE FROM users WHERE id IN (SELECT id FROM users WHERE deleted_at < $1 LIMIT $2)`,
+	},
+	{
+		entity: "profiles",
+		query: `DELETE FROM profiles WHERE id IN (SELECT p.id FROM profiles p WHERE p.deleted_at < $1
+AND NOT EXISTS (SELECT 1 FROM users u WHERE u.default_profile = p.id) LIMIT $2)`,
+	},
+	{
+		entity: "checks",
+		query: `DELETE FROM checks WHERE id IN (SELECT c.id FROM checks c WHERE c.deleted_at < $1
+AND NOT EXISTS (SELECT 1 FROM profiles_checks pc WHERE pc.check_id = c.id) LIMIT $2)`,
+	},
+}
+
+type txBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// Pruner hard-deletes records that have been soft-deleted for longer than
+// services.retention.deletedTTL.
+type Pruner struct {
+	config *sophrosyne.Config
+	db     txBeginner
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+func NewPruner(ctx context.Context, config *sophrosyne.Config, logger *slog.Logger) (*Pruner, error) {
+	pool, err := newPool(ctx, config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pruner{
+		config: config,
+		db:     pool,
+		logger: logger,
+		now:    time.Now,
+	}, nil
+}
+
+// Run prunes records every services.retention.interval until ctx is done.
+// Errors are logged and retried on the next interval.
+func (p *Pruner) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.config.Services.Retention.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pruned, err := p.Prune(ctx)
+			if err != nil {
+				p.logger.ErrorContext(ctx, "unable to prune deleted records", "error", err)
+				continue
+			}
+			p.logger.InfoContext(ctx, "pruned deleted records", "pruned", pruned)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Prune hard-deletes the configured entity types in a single transaction and
+// returns the number of records deleted per entity type.
+func (p *Pruner) Prune(ctx context.Context) (map[string]int64, error) {
+	cutoff := p.now().Add(-p.config.Services.Retention.DeletedTTL)
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	pruned := make(map[string]int64)
+	for _, q := range pruneQueries {
+		if !slices.Contains(p.config.Services.Retention.Entities, q.entity) {
+			continue
+		}
+		cmdTag, err := tx.Exec(ctx, q.query, cutoff, p.config.Services.Retention.BatchSize)
+		if err != nil {
+			return nil, err
+		}
+		pruned[q.entity] = cmdTag.RowsAffected()
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,128 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package pgx
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+type execCall struct {
+	sql  string
+	args []any
+}
+
+// fakeTx records the statements executed in it. Methods not overridden panic
+// through the nil embedded interface.
+type fakeTx struct {
+	pgx.Tx
+	execErr    error
+	calls      []execCall
+	committed  bool
+	rolledBack bool
+}
+
+func (f *fakeTx) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	f.calls = append(f.calls, execCall{sql: sql, args: args})
+	return pgconn.NewCommandTag("DELETE 2"), f.execErr
+}
+
+func (f *fakeTx) Commit(_ context.Context) error {
+	f.committed = true
+	return nil
+}
+
+func (f *fakeTx) Rollback(_ context.Context) error {
+	if !f.committed {
+		f.rolledBack = true
+	}
+	return nil
+}
+
+type fakeBeginner struct {
+	tx *fakeTx
+}
+
+func (f *fakeBeginner) Begin(_ context.Context) (pgx.Tx, error) {
+	return f.tx, nil
+}
+
+func TestPruner_Prune(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	newPruner := func(tx *fakeTx, entities ...string) *Pruner {
+		config := &sophrosyne.Config{}
+		config.Services.Retention.DeletedTTL = 24 * time.Hour
+		config.Services.Retention.BatchSize = 10
+		config.Services.Retention.Entities = entities
+		return &Pruner{
+			config: config,
+			db:     &fakeBeginner{tx: tx},
+			logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			now:    func() time.Time { return now },
+		}
+	}
+
+	t.Run("only rows deleted before the cutoff are targeted", func(t *testing.T) {
+		tx := &fakeTx{}
+		p := newPruner(tx, "users", "profiles", "checks")
+
+		pruned, err := p.Prune(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, map[string]int64{"users": 2, "profiles": 2, "checks": 2}, pruned)
+		require.True(t, tx.committed)
+		require.Len(t, tx.calls, 3)
+		for _, call := range tx.calls {
+			assert.Contains(t, call.sql, "deleted_at < $1")
+			assert.Contains(t, call.sql, "LIMIT $2")
+			assert.Equal(t, []any{now.Add(-24 * time.Hour), 10}, call.args)
+		}
+	})
+
+	t.Run("entity types can be scoped", func(t *testing.T) {
+		tx := &fakeTx{}
+		p := newPruner(tx, "checks")
+
+		pruned, err := p.Prune(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, map[string]int64{"checks": 2}, pruned)
+		require.Len(t, tx.calls, 1)
+		require.Contains(t, tx.calls[0].sql, "DELETE FROM checks")
+	})
+
+	t.Run("errors roll back", func(t *testing.T) {
+		tx := &fakeTx{execErr: assert.AnError}
+		p := newPruner(tx, "users", "profiles")
+
+		_, err := p.Prune(context.Background())
+		require.ErrorIs(t, err, assert.AnError)
+		require.False(t, tx.committed)
+		require.True(t, tx.rolledBack)
+		require.Len(t, tx.calls, 1)
+	})
+}
@@ -17,9 +17,11 @@
 package jsonrpc
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"strings"
 )
@@ -99,8 +101,9 @@ type ParamsObject map[string]interface{}
 // unmarshal into an interface{}.
 //
 // If an element is a JSON number, its mathematical value is referenced in order to determine if it is an integer or a
-// float. If it is an integer, it is converted into an int64, otherwise it is converted into a float64. This is in
-// contrast to the Go JSON unmarshaller, which unmarshals all numbers into float64.
+// float. If it is an integer that fits in an int64, it is converted into an int64 without losing precision, otherwise
+// it is converted into a float64. This is in contrast to the Go JSON unmarshaller, which unmarshals all numbers into
+// float64. Numbers nested inside objects or arrays are left as float64.
 func (p *ParamsObject) UnmarshalJSON(data []byte) error {
 	var obj map[string]*json.RawMessage
 	err := json.Unmarshal(data, &obj)
@@ -111,20 +114,7 @@ func (p *ParamsObject) UnmarshalJSON(data []byte) error {
 	*p = make(ParamsObject)
 
 	for key, raw := range obj {
-		var value interface{}
-		// Skipping error check since this shouldn't error out.
-		_ = json.Unmarshal(*raw, &value)
-
-		switch value := value.(type) {
-		case float64:
-			if value == float64(int(value)) {
-				(*p)[key] = int(value)
-			} else {
-				(*p)[key] = value
-			}
-		default:
-			(*p)[key] = value
-		}
+		(*p)[key] = decodeParamValue(*raw)
 	}
 
 	return nil
@@ -149,8 +139,9 @@ func (*ParamsArray) isParams() {
 // interface{}.
 //
 // If an element is a JSON number, its mathematical value is referenced in order to determine if it is an integer or a
-// float. If it is an integer, it is converted into an int64, otherwise it is converted into a float64. This is in
-// contrast to the Go JSON unmarshaller, which unmarshals all numbers into float64.
+// float. If it is an integer that fits in an int64, it is converted into an int64 without losing precision, otherwise
+// it is converted into a float64. This is in contrast to the Go JSON unmarshaller, which unmarshals all numbers into
+// float64. Numbers nested inside objects or arrays are left as float64.
 func (p *ParamsArray) UnmarshalJSON(data []byte) error {
 	var arr []json.RawMessage
 	err := json.Unmarshal(data, &arr)
@@ -159,23 +150,37 @@ func (p *ParamsArray) UnmarshalJSON(data []byte) error {
 	}
 
 	for _, raw := range arr {
-		var value interface{}
-		// Skipping error check since this shouldn't error out.
-		_ = json.Unmarshal(raw, &value)
-
-		switch value := value.(type) {
-		case float64:
-			if value == float64(int(value)) {
-				*p = append(*p, int(value))
-			} else {
-				*p = append(*p, value)
+		*p = append(*p, decodeParamValue(raw))
+	}
+
+	return nil
+}
+
+// decodeParamValue decodes a single element of a [ParamsObject] or [ParamsArray]. Integral JSON numbers within the
+// range of an int64 are returned as int64, parsed from their textual representation so that values beyond 2^53 keep
+// their precision. Other numbers are returned as float64.
+func decodeParamValue(raw []byte) interface{} {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && (raw[0] == '-' || (raw[0] >= '0' && raw[0] <= '9')) {
+		var num json.Number
+		if err := json.Unmarshal(raw, &num); err == nil {
+			if i, err := num.Int64(); err == nil {
+				return i
+			}
+			if f, err := num.Float64(); err == nil {
+				// Integral numbers written with an exponent, such as 1e3.
+				if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+					return int64(f)
+				}
+				return f
 			}
-		default:
-			*p = append(*p, value)
 		}
 	}
 
-	return nil
+	var value interface{}
+	// Skipping error check since this shouldn't error out.
+	_ = json.Unmarshal(raw, &value)
+	return value
 }
 
 // NewID represent the ID field of a [Request] or [Response] as per the JSON-RPC 2.0 specification.
@@ -209,6 +214,19 @@ func (id ID) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf(`"%s"`, id.value)), nil
 }
 
+// ErrIDTooLong is returned by [ID.CheckLength] when an id is too long.
+var ErrIDTooLong = errors.New("id is too long")
+
+// CheckLength returns [ErrIDTooLong] if id is longer than maxLength bytes. As
+// the id is echoed back in the [Response], limiting it limits how much a
+// client can inflate responses. A maxLength of 0 or less disables the limit.
+func (id ID) CheckLength(maxLength int) error {
+	if maxLength > 0 && len(id.value) > maxLength {
+		return fmt.Errorf("%w: %d bytes exceeds the maximum of %d", ErrIDTooLong, len(id.value), maxLength)
+	}
+	return nil
+}
+
 func (id *ID) UnmarshalJSON(data []byte) error {
 	if string(data) == "null" {
 		id.isNull = true
@@ -909,3 +927,16 @@ func ResponseParseError() Response {
 		},
 	}
 }
+
+func ResponseInvalidRequest() Response {
+	return Response{
+		ID: ID{
+			isNull: true,
+			value:  "",
+		},
+		Error: &Error{
+			Code:    InvalidRequest,
+			Message: string(InvalidRequestMessage),
+		},
+	}
+}
@@ -251,6 +251,37 @@ func TestParamsObject_UnmarshalJSON(t *testing.T) {
 	}
 }
 
+func TestParams_UnmarshalJSON_Numbers(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want interface{}
+	}{
+		{name: "small integer", data: `1`, want: int64(1)},
+		{name: "negative integer", data: `-42`, want: int64(-42)},
+		{name: "2^53", data: `9007199254740992`, want: int64(9007199254740992)},
+		{name: "2^53 + 1", data: `9007199254740993`, want: int64(9007199254740993)},
+		{name: "max int64", data: `9223372036854775807`, want: int64(9223372036854775807)},
+		{name: "min int64", data: `-9223372036854775808`, want: int64(-9223372036854775808)},
+		{name: "beyond int64", data: `9223372036854775808`, want: float64(9223372036854775808)},
+		{name: "integral exponent", data: `1e3`, want: int64(1000)},
+		{name: "float", data: `1.5`, want: 1.5},
+		{name: "numeric string", data: `"9007199254740993"`, want: "9007199254740993"},
+		{name: "nested number", data: `[1]`, want: []interface{}{float64(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var po ParamsObject
+			require.NoError(t, po.UnmarshalJSON([]byte(`{"value":`+tt.data+`}`)))
+			require.Equal(t, tt.want, po["value"])
+
+			var pa ParamsArray
+			require.NoError(t, pa.UnmarshalJSON([]byte(`[`+tt.data+`]`)))
+			require.Equal(t, ParamsArray{tt.want}, pa)
+		})
+	}
+}
+
 func TestParamsObject_isParams(t *testing.T) {
 	tests := []struct {
 		name string
@@ -957,7 +988,7 @@ func Test_Request_with_ParamsArray(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, ID{value: "1"}, r.ID)
 	require.Equal(t, Method("test"), r.Method)
-	require.Equal(t, &ParamsArray{1, 2, 3}, r.Params)
+	require.Equal(t, &ParamsArray{int64(1), int64(2), int64(3)}, r.Params)
 
 }
 
@@ -966,7 +997,7 @@ func Test_Notification_with_ParamsArray(t *testing.T) {
 	err := json.Unmarshal([]byte(`{"jsonrpc":"2.0","method":"test","params":[1,2,3]}`), &n)
 	require.NoError(t, err)
 	require.Equal(t, Method("test"), n.Method)
-	require.Equal(t, &ParamsArray{1, 2, 3}, n.Params)
+	require.Equal(t, &ParamsArray{int64(1), int64(2), int64(3)}, n.Params)
 
 }
 
@@ -977,7 +1008,7 @@ func Test_BatchRequest_with_Notification(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, br[0].isNotification)
 	require.Equal(t, Method("test"), br[0].Method)
-	require.Equal(t, &ParamsArray{1, 2, 3}, br[0].Params)
+	require.Equal(t, &ParamsArray{int64(1), int64(2), int64(3)}, br[0].Params)
 }
 
 func Test_BatchRequest_with_Request(t *testing.T) {
@@ -988,7 +1019,7 @@ func Test_BatchRequest_with_Request(t *testing.T) {
 	require.False(t, br[0].isNotification)
 	require.Equal(t, ID{value: "1"}, br[0].ID)
 	require.Equal(t, Method("test"), br[0].Method)
-	require.Equal(t, &ParamsArray{1, 2, 3}, br[0].Params)
+	require.Equal(t, &ParamsArray{int64(1), int64(2), int64(3)}, br[0].Params)
 }
 
 func Test_BatchRequest_with_Mixed(t *testing.T) {
@@ -1100,3 +1131,10 @@ func TestNewIDWithMultipleIsNullValues(t *testing.T) {
 	require.Equal(t, true, id.isNull)
 	require.Equal(t, "", id.value)
 }
+
+func TestID_CheckLength(t *testing.T) {
+	require.NoError(t, NewID("abcd").CheckLength(4))
+	require.ErrorIs(t, NewID("abcde").CheckLength(4), ErrIDTooLong)
+	require.NoError(t, NewID("abcde").CheckLength(0), "0 disables the limit")
+	require.NoError(t, NewID("", true).CheckLength(4))
+}
@@ -17,11 +17,15 @@
 package rpc
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 
 	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
 
@@ -29,26 +33,56 @@ import (
 )
 
 type Server struct {
-	services map[string]Service
-	logger   *slog.Logger
+	config        *sophrosyne.Config
+	services      map[string]Service
+	logger        *slog.Logger
+	metricService sophrosyne.MetricService
+	errorRecorder sophrosyne.ErrorRecorder
 }
 
-func NewRPCServer(logger *slog.Logger) (*Server, error) {
+func NewRPCServer(config *sophrosyne.Config, logger *slog.Logger, metricService sophrosyne.MetricService, errorRecorder sophrosyne.ErrorRecorder) (*Server, error) {
 	return &Server{
-		services: make(map[string]Service),
-		logger:   logger,
+		config:        config,
+		services:      make(map[string]Service),
+		logger:        logger,
+		metricService: metricService,
+		errorRecorder: errorRecorder,
 	}, nil
 }
 
 func (s *Server) HandleRPCRequest(ctx context.Context, req []byte) ([]byte, error) {
 	s.logger.DebugContext(ctx, "handling rpc request", "request", req)
-	pReq := jsonrpc.Request{}
-	err := pReq.UnmarshalJSON(req)
+	if isBatch(req) {
+		return s.handleBatch(ctx, req)
+	}
+
+	pReq, err := s.unmarshalRequest(req)
 	if err != nil {
 		s.logger.ErrorContext(ctx, "error unmarshaling rpc request", "error", err)
 		return jsonrpc.ResponseParseError().MarshalJSON()
 	}
 
+	return s.dispatch(ctx, pReq)
+}
+
+// unmarshalRequest parses a single request. Requests with an id longer than
+// [sophrosyne.ServerConfig.MaxRPCIDLength] are rejected.
+func (s *Server) unmarshalRequest(data []byte) (jsonrpc.Request, error) {
+	req := jsonrpc.Request{}
+	err := req.UnmarshalJSON(data)
+	if err != nil {
+		return jsonrpc.Request{}, err
+	}
+	if s.config != nil {
+		err = req.ID.CheckLength(s.config.Server.MaxRPCIDLength)
+		if err != nil {
+			return jsonrpc.Request{}, err
+		}
+	}
+	return req, nil
+}
+
+func (s *Server) dispatch(ctx context.Context, pReq jsonrpc.Request) ([]byte, error) {
 	svcName := strings.Split(string(pReq.Method), "::")[0]
 
 	service, ok := s.services[svcName]
@@ -56,16 +90,170 @@ func (s *Server) HandleRPCRequest(ctx context.Context, req []byte) ([]byte, erro
 		s.logger.InfoContext(ctx, "rpc service not found", "service", svcName, "method", pReq.Method)
 		return ErrorFromRequest(&pReq, jsonrpc.MethodNotFound, string(jsonrpc.MethodNotFoundMessage))
 	}
-	data, err := service.InvokeMethod(ctx, pReq)
+	method := metricMethod(service, pReq.Method)
+	start := time.Now()
+	data, err := s.invoke(ctx, service, pReq)
+	s.metricService.RecordRPCCall(ctx, method, time.Since(start))
 	if err != nil {
+		s.errorRecorder.RecordError(ctx, method, err)
 		return nil, err
 	}
 
 	return data, nil
 }
 
-func (s *Server) Register(name string, service Service) {
+// invoke calls the method of pReq on service. A panic in the service is
+// returned as a [sophrosyne.PanicError], so that it is recorded with the
+// method it happened in.
+func (s *Server) invoke(ctx context.Context, service Service, pReq jsonrpc.Request) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.metricService.RecordPanic(ctx)
+			err = sophrosyne.NewPanicError(r)
+		}
+	}()
+	return service.InvokeMethod(ctx, pReq)
+}
+
+// unknownMethod is recorded in metrics and recent errors in place of methods
+// that a service does not describe, so callers cannot create metric
+// attributes at will.
+const unknownMethod = "unknown"
+
+// metricMethod returns the name method is recorded under in metrics. That is
+// method itself if service describes it as one of its methods, and
+// [unknownMethod] otherwise.
+func metricMethod(service Service, method jsonrpc.Method) string {
+	d, ok := service.(Describer)
+	if !ok {
+		return unknownMethod
+	}
+	_, name, err := SplitMethod(method)
+	if err != nil {
+		return unknownMethod
+	}
+	for _, m := range d.Methods() {
+		if m.Name == name {
+			return string(method)
+		}
+	}
+	return unknownMethod
+}
+
+// isBatch reports whether req is a JSON array, ignoring leading whitespace.
+func isBatch(req []byte) bool {
+	trimmed := bytes.TrimLeft(req, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// handleBatch dispatches the requests in a batch concurrently, at most
+// [sophrosyne.ServerConfig.BatchConcurrency] at a time, and returns the
+// responses as an array in the order of the requests. Notifications get no
+// response, and nothing at all is returned if the batch only contains
+// notifications. A failing request does not affect the rest of the batch.
+// Batches longer than [sophrosyne.ServerConfig.MaxBatchLength] are rejected
+// as a whole.
+func (s *Server) handleBatch(ctx context.Context, req []byte) ([]byte, error) {
+	var raws []json.RawMessage
+	err := json.Unmarshal(req, &raws)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error unmarshaling rpc batch request", "error", err)
+		return jsonrpc.ResponseParseError().MarshalJSON()
+	}
+	if len(raws) == 0 {
+		return jsonrpc.ResponseInvalidRequest().MarshalJSON()
+	}
+	if maxLength := s.maxBatchLength(); maxLength > 0 && len(raws) > maxLength {
+		s.logger.InfoContext(ctx, "rpc batch too long", "length", len(raws), "max", maxLength)
+		return jsonrpc.ResponseInvalidRequest().MarshalJSON()
+	}
+
+	responses := make([][]byte, len(raws))
+	var g errgroup.Group
+	g.SetLimit(s.batchConcurrency())
+	for i, raw := range raws {
+		g.Go(func() error {
+			responses[i] = s.handleBatchElement(ctx, raw)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	var out [][]byte
+	for _, r := range responses {
+		if r != nil {
+			out = append(out, r)
+		}
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+
+	return append(append([]byte{'['}, bytes.Join(out, []byte{','})...), ']'), nil
+}
+
+// maxBatchLength returns the number of requests allowed in a batch, 0 meaning
+// any number.
+func (s *Server) maxBatchLength() int {
+	if s.config == nil {
+		return 0
+	}
+	return s.config.Server.MaxBatchLength
+}
+
+// batchConcurrency returns the number of requests of a batch handled at the
+// same time.
+func (s *Server) batchConcurrency() int {
+	if s.config == nil || s.config.Server.BatchConcurrency < 1 {
+		return 1
+	}
+	return s.config.Server.BatchConcurrency
+}
+
+// handleBatchElement returns the response to a single request in a batch, or
+// nil if no response should be sent.
+func (s *Server) handleBatchElement(ctx context.Context, raw json.RawMessage) []byte {
+	pReq, err := s.unmarshalRequest(raw)
+	if err != nil {
+		s.logger.InfoContext(ctx, "invalid request in rpc batch", "error", err)
+		b, _ := jsonrpc.ResponseInvalidRequest().MarshalJSON()
+		return b
+	}
+
+	data, err := s.dispatch(ctx, pReq)
+	if pReq.IsNotification() {
+		return nil
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error handling request in rpc batch", "method", pReq.Method, "error", err)
+		data, _ = ErrorFromRequest(&pReq, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
+	return data
+}
+
+// ErrDuplicateService is returned by [Server.Register] when a service has
+// already been registered under the given name.
+var ErrDuplicateService = fmt.Errorf("service already registered")
+
+// Register makes service available under name. Registering a second service
+// under a name that is already in use returns [ErrDuplicateService] and leaves
+// the existing service in place.
+func (s *Server) Register(name string, service Service) error {
+	if _, ok := s.services[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateService, name)
+	}
 	s.services[name] = service
+	return nil
+}
+
+// MustRegister is like [Server.Register] but panics if the service cannot be
+// registered. It is intended for use during startup, where a duplicate name
+// is a programming error.
+func (s *Server) MustRegister(name string, service Service) {
+	if err := s.Register(name, service); err != nil {
+		panic(err)
+	}
 }
 
 type Service interface {
@@ -109,7 +297,28 @@ func GetParams(req *jsonrpc.Request) (*jsonrpc.ParamsObject, *jsonrpc.ParamsArra
 
 var ErrNoParams = fmt.Errorf("no params found")
 
+// ErrMalformedMethod is returned by [SplitMethod] if a method is not of the
+// form Service::Method.
+var ErrMalformedMethod = fmt.Errorf("method must be of the form Service::Method")
+
+// SplitMethod splits method into the name of the service and the name of the
+// method within that service.
+func SplitMethod(method jsonrpc.Method) (string, string, error) {
+	m := strings.Split(string(method), "::")
+	if len(m) != 2 || m[0] == "" || m[1] == "" {
+		return "", "", ErrMalformedMethod
+	}
+	return m[0], m[1], nil
+}
+
 func ParamsIntoAny(req *jsonrpc.Request, target any, validate sophrosyne.Validator) error {
+	return ParamsIntoAnyNormalized(req, target, validate, nil)
+}
+
+// ParamsIntoAnyNormalized is like [ParamsIntoAny], but if target implements
+// [sophrosyne.NameNormalizer] and config is not nil, the names in target are
+// normalized according to config before target is validated.
+func ParamsIntoAnyNormalized(req *jsonrpc.Request, target any, validate sophrosyne.Validator, config *sophrosyne.Config) error {
 	pa, po, ok := GetParams(req)
 	if !ok {
 		return ErrNoParams
@@ -132,6 +341,10 @@ func ParamsIntoAny(req *jsonrpc.Request, target any, validate sophrosyne.Validat
 		return err
 	}
 
+	if nn, ok := target.(sophrosyne.NameNormalizer); ok && config != nil {
+		nn.NormalizeNames(config)
+	}
+
 	if validate != nil {
 		err = validate.Validate(target)
 		if err != nil {
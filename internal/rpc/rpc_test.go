@@ -19,13 +19,23 @@
 package rpc
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
 
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/madsrc/sophrosyne"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
 	"github.com/madsrc/sophrosyne/internal/validator"
 )
 
@@ -89,6 +99,32 @@ func TestParamsIntoAny(t *testing.T) {
 	}
 }
 
+func TestParamsIntoAnyNormalized(t *testing.T) {
+	config := &sophrosyne.Config{}
+	config.Services.Users.NormalizeNames = sophrosyne.NameNormalizationLowercase
+
+	for _, name := range []string{" Foo ", "foo"} {
+		req := &jsonrpc.Request{Params: &jsonrpc.ParamsObject{"name": name, "email": "foo@example.com"}}
+		var params sophrosyne.CreateUserRequest
+		err := ParamsIntoAnyNormalized(req, &params, validator.NewValidator(), config)
+		require.NoError(t, err)
+		require.Equal(t, "foo", params.Name)
+	}
+
+	// Normalization happens before validation
+	req := &jsonrpc.Request{Params: &jsonrpc.ParamsObject{"name": "  ", "email": "foo@example.com"}}
+	var params sophrosyne.CreateUserRequest
+	err := ParamsIntoAnyNormalized(req, &params, validator.NewValidator(), config)
+	require.Error(t, err)
+
+	// Without a config names are left as they are
+	req = &jsonrpc.Request{Params: &jsonrpc.ParamsObject{"name": " Foo ", "email": "foo@example.com"}}
+	params = sophrosyne.CreateUserRequest{}
+	err = ParamsIntoAnyNormalized(req, &params, validator.NewValidator(), nil)
+	require.NoError(t, err)
+	require.Equal(t, " Foo ", params.Name)
+}
+
 func TestSomething(t *testing.T) {
 	b := []byte(`{"jsonrpc":"2.0","method":"Users::GetUser","id":"1234","params":{"id":"coo1tog2e0g00gf27t70"}}`)
 	req := &jsonrpc.Request{}
@@ -98,3 +134,269 @@ func TestSomething(t *testing.T) {
 	require.NotNil(t, req)
 	require.NotNil(t, req.Params)
 }
+
+type echoService struct{}
+
+func (e echoService) EntityType() string { return "Service" }
+
+func (e echoService) EntityID() string { return "Echo" }
+
+func (e echoService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	switch req.Method {
+	case "Echo::Fail":
+		return nil, errors.New("failed")
+	case "Echo::Panic":
+		panic("boom")
+	}
+	return ResponseToRequest(&req, string(req.Method))
+}
+
+// describedEchoService is an echoService describing some of the methods it
+// answers.
+type describedEchoService struct {
+	echoService
+}
+
+func (describedEchoService) Methods() []Method {
+	return []Method{{Name: "One"}}
+}
+
+func TestServer_HandleRPCRequest_Batch(t *testing.T) {
+	metricService := sophrosyne2.NewMockMetricService(t)
+	metricService.EXPECT().RecordRPCCall(mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	config := &sophrosyne.Config{}
+	config.Server.MaxBatchLength = 4
+	config.Server.BatchConcurrency = 2
+	errorRecorder := sophrosyne2.NewMockErrorRecorder(t)
+	errorRecorder.EXPECT().RecordError(mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	s, err := NewRPCServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)), metricService, errorRecorder)
+	require.NoError(t, err)
+	s.MustRegister("Echo", echoService{})
+
+	tests := []struct {
+		name string
+		req  string
+		want string
+	}{
+		{
+			name: "responses in request order",
+			req:  `[{"jsonrpc":"2.0","method":"Echo::One","id":"1"},{"jsonrpc":"2.0","method":"Echo::Two","id":"2"}]`,
+			want: `[{"jsonrpc":"2.0","result":"Echo::One","id":"1"},{"jsonrpc":"2.0","result":"Echo::Two","id":"2"}]`,
+		},
+		{
+			name: "notifications get no response",
+			req:  ` [{"jsonrpc":"2.0","method":"Echo::One"},{"jsonrpc":"2.0","method":"Echo::Two","id":"2"}]`,
+			want: `[{"jsonrpc":"2.0","result":"Echo::Two","id":"2"}]`,
+		},
+		{
+			name: "errors do not abort the batch",
+			req:  `[{"jsonrpc":"2.0","method":"Echo::Fail","id":"1"},{"jsonrpc":"2.0","method":"Nope::One","id":"2"},1,{"jsonrpc":"2.0","method":"Echo::Three","id":"3"}]`,
+			want: `[{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"},"id":"1"},{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":"2"},{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null},{"jsonrpc":"2.0","result":"Echo::Three","id":"3"}]`,
+		},
+		{
+			name: "empty batch",
+			req:  `[]`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`,
+		},
+		{
+			name: "too long",
+			req:  `[{"jsonrpc":"2.0","method":"Echo::One","id":"1"},{"jsonrpc":"2.0","method":"Echo::One","id":"2"},{"jsonrpc":"2.0","method":"Echo::One","id":"3"},{"jsonrpc":"2.0","method":"Echo::One","id":"4"},{"jsonrpc":"2.0","method":"Echo::One","id":"5"}]`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`,
+		},
+		{
+			name: "invalid json",
+			req:  `[{"jsonrpc":"2.0"`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.HandleRPCRequest(context.Background(), []byte(tt.req))
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+
+	t.Run("only notifications", func(t *testing.T) {
+		got, err := s.HandleRPCRequest(context.Background(), []byte(`[{"jsonrpc":"2.0","method":"Echo::One"},{"jsonrpc":"2.0","method":"Echo::Fail"}]`))
+		require.NoError(t, err)
+		require.Nil(t, got)
+	})
+}
+
+// concurrencyService records the number of requests it handles at the same
+// time.
+type concurrencyService struct {
+	echoService
+	current, max *atomic.Int32
+}
+
+func (c concurrencyService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	n := c.current.Add(1)
+	defer c.current.Add(-1)
+	for {
+		m := c.max.Load()
+		if n <= m || c.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return c.echoService.InvokeMethod(ctx, req)
+}
+
+func TestServer_HandleRPCRequest_BatchConcurrency(t *testing.T) {
+	metricService := sophrosyne2.NewMockMetricService(t)
+	metricService.EXPECT().RecordRPCCall(mock.Anything, mock.Anything, mock.Anything).Return()
+	config := &sophrosyne.Config{}
+	config.Server.BatchConcurrency = 2
+	s, err := NewRPCServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)), metricService, nil)
+	require.NoError(t, err)
+	svc := concurrencyService{current: &atomic.Int32{}, max: &atomic.Int32{}}
+	s.MustRegister("Echo", svc)
+
+	var reqs []string
+	for i := range 6 {
+		reqs = append(reqs, fmt.Sprintf(`{"jsonrpc":"2.0","method":"Echo::One","id":"%d"}`, i))
+	}
+	got, err := s.HandleRPCRequest(context.Background(), []byte("["+strings.Join(reqs, ",")+"]"))
+	require.NoError(t, err)
+	require.Contains(t, string(got), `"id":"5"`)
+	require.LessOrEqual(t, svc.max.Load(), int32(2))
+}
+
+func TestServer_HandleRPCRequest_RecordsRPCCall(t *testing.T) {
+	ctx := context.Background()
+	metricService := sophrosyne2.NewMockMetricService(t)
+	metricService.EXPECT().RecordRPCCall(ctx, "Echo::One", mock.AnythingOfType("time.Duration")).Return().Once()
+	metricService.EXPECT().RecordRPCCall(ctx, "unknown", mock.AnythingOfType("time.Duration")).Return().Times(3)
+	s, err := NewRPCServer(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), metricService, nil)
+	require.NoError(t, err)
+	s.MustRegister("Echo", describedEchoService{})
+
+	_, err = s.HandleRPCRequest(ctx, []byte(`{"jsonrpc":"2.0","method":"Echo::One","id":"1"}`))
+	require.NoError(t, err)
+
+	// Methods of unknown services are not recorded.
+	_, err = s.HandleRPCRequest(ctx, []byte(`{"jsonrpc":"2.0","method":"Unknown::One","id":"2"}`))
+	require.NoError(t, err)
+
+	// Methods the service does not describe are recorded as unknown.
+	_, err = s.HandleRPCRequest(ctx, []byte(`{"jsonrpc":"2.0","method":"Echo::Random1234","id":"3"}`))
+	require.NoError(t, err)
+	_, err = s.HandleRPCRequest(ctx, []byte(`{"jsonrpc":"2.0","method":"Echo::One::Extra","id":"4"}`))
+	require.NoError(t, err)
+
+	// Services that do not describe their methods only record unknown.
+	s.MustRegister("Plain", echoService{})
+	_, err = s.HandleRPCRequest(ctx, []byte(`{"jsonrpc":"2.0","method":"Plain::One","id":"5"}`))
+	require.NoError(t, err)
+}
+
+func TestServer_HandleRPCRequest_RecordsErrors(t *testing.T) {
+	ctx := context.Background()
+	metricService := sophrosyne2.NewMockMetricService(t)
+	metricService.EXPECT().RecordRPCCall(ctx, mock.Anything, mock.Anything).Return()
+	metricService.EXPECT().RecordPanic(ctx).Return().Once()
+	errorRecorder := sophrosyne2.NewMockErrorRecorder(t)
+	errorRecorder.EXPECT().RecordError(ctx, "unknown", mock.MatchedBy(func(err error) bool {
+		return err.Error() == "failed"
+	})).Return().Once()
+	errorRecorder.EXPECT().RecordError(ctx, "unknown", mock.MatchedBy(func(err error) bool {
+		var panicErr *sophrosyne.PanicError
+		return errors.As(err, &panicErr) && panicErr.Reason() == "boom"
+	})).Return().Once()
+	errorRecorder.EXPECT().RecordError(ctx, "Failing::One", mock.Anything).Return().Once()
+	s, err := NewRPCServer(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), metricService, errorRecorder)
+	require.NoError(t, err)
+	s.MustRegister("Echo", echoService{})
+	s.MustRegister("Failing", failingDescribedService{})
+
+	_, err = s.HandleRPCRequest(ctx, []byte(`{"jsonrpc":"2.0","method":"Echo::Fail","id":"1"}`))
+	require.Error(t, err)
+
+	// Panics are returned as errors, rather than reaching the HTTP server.
+	_, err = s.HandleRPCRequest(ctx, []byte(`{"jsonrpc":"2.0","method":"Echo::Panic","id":"2"}`))
+	require.Error(t, err)
+
+	// Methods the service describes are recorded by name.
+	_, err = s.HandleRPCRequest(ctx, []byte(`{"jsonrpc":"2.0","method":"Failing::One","id":"3"}`))
+	require.Error(t, err)
+
+	// Successful requests are not recorded.
+	_, err = s.HandleRPCRequest(ctx, []byte(`{"jsonrpc":"2.0","method":"Echo::One","id":"4"}`))
+	require.NoError(t, err)
+}
+
+// failingDescribedService fails every method it describes.
+type failingDescribedService struct {
+	echoService
+}
+
+func (failingDescribedService) Methods() []Method {
+	return []Method{{Name: "One"}}
+}
+
+func (failingDescribedService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	return nil, errors.New("failed")
+}
+
+type otherEchoService struct {
+	echoService
+}
+
+func TestServer_Register_Duplicate(t *testing.T) {
+	s, err := NewRPCServer(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Register("Echo", echoService{}))
+
+	err = s.Register("Echo", otherEchoService{})
+	require.ErrorIs(t, err, ErrDuplicateService)
+	require.Equal(t, echoService{}, s.services["Echo"], "the first registration is kept")
+
+	require.PanicsWithError(t, "service already registered: Echo", func() {
+		s.MustRegister("Echo", otherEchoService{})
+	})
+	require.NotPanics(t, func() {
+		s.MustRegister("Other", otherEchoService{})
+	})
+}
+
+func TestServer_HandleRPCRequest_IDTooLong(t *testing.T) {
+	config := &sophrosyne.Config{}
+	config.Server.MaxRPCIDLength = 4
+	s, err := NewRPCServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)), nil, nil)
+	require.NoError(t, err)
+	s.MustRegister("Echo", echoService{})
+
+	id := strings.Repeat("a", config.Server.MaxRPCIDLength+1)
+	got, err := s.HandleRPCRequest(context.Background(), []byte(`{"jsonrpc":"2.0","method":"Echo::One","id":"`+id+`"}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`, string(got))
+
+	got, err = s.HandleRPCRequest(context.Background(), []byte(`[{"jsonrpc":"2.0","method":"Echo::One","id":"`+id+`"}]`))
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}]`, string(got))
+}
+
+func TestSplitMethod(t *testing.T) {
+	tests := []struct {
+		method      jsonrpc.Method
+		wantService string
+		wantMethod  string
+		wantErr     error
+	}{
+		{method: "Users::GetUser", wantService: "Users", wantMethod: "GetUser"},
+		{method: "GetUser", wantErr: ErrMalformedMethod},
+		{method: "Users::", wantErr: ErrMalformedMethod},
+		{method: "::GetUser", wantErr: ErrMalformedMethod},
+		{method: "Users::Get::User", wantErr: ErrMalformedMethod},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.method), func(t *testing.T) {
+			service, method, err := SplitMethod(tt.method)
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.wantService, service)
+			require.Equal(t, tt.wantMethod, method)
+		})
+	}
+}
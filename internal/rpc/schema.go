@@ -0,0 +1,172 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Method describes a single method of a [Service] for the schema served at
+// /v1/rpc/schema. Params and Result are zero values of the types the method
+// accepts and returns. A nil Params means the method takes no params.
+type Method struct {
+	Name   string
+	Params any
+	Result any
+}
+
+// Describer is implemented by services that describe their methods. Services
+// that do not implement it are left out of the schema.
+type Describer interface {
+	Methods() []Method
+}
+
+// Schema is a machine-readable description of the methods served by a
+// [Server].
+type Schema struct {
+	Methods []MethodSchema `json:"methods"`
+}
+
+type MethodSchema struct {
+	Name   string      `json:"name"`
+	Params *TypeSchema `json:"params,omitempty"`
+	Result *TypeSchema `json:"result,omitempty"`
+}
+
+// TypeSchema describes a JSON value using a subset of JSON Schema.
+type TypeSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Properties           map[string]*TypeSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *TypeSchema            `json:"items,omitempty"`
+	AdditionalProperties *TypeSchema            `json:"additionalProperties,omitempty"`
+}
+
+// Schema describes the methods of all registered services, ordered by name.
+func (s *Server) Schema() Schema {
+	schema := Schema{Methods: []MethodSchema{}}
+	for name, service := range s.services {
+		d, ok := service.(Describer)
+		if !ok {
+			continue
+		}
+		for _, m := range d.Methods() {
+			ms := MethodSchema{Name: name + "::" + m.Name}
+			if m.Params != nil {
+				ms.Params = typeSchema(reflect.TypeOf(m.Params), nil)
+			}
+			if m.Result != nil {
+				ms.Result = typeSchema(reflect.TypeOf(m.Result), nil)
+			}
+			schema.Methods = append(schema.Methods, ms)
+		}
+	}
+	slices.SortFunc(schema.Methods, func(a, b MethodSchema) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// typeSchema derives the schema of t from the way encoding/json marshals it.
+// Struct types already being described in seen are not expanded again.
+func typeSchema(t reflect.Type, seen []reflect.Type) *TypeSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &TypeSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &TypeSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &TypeSchema{Type: "number"}
+	case reflect.String:
+		return &TypeSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &TypeSchema{Type: "string", Format: "byte"}
+		}
+		return &TypeSchema{Type: "array", Items: typeSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return &TypeSchema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if t == timeType {
+			return &TypeSchema{Type: "string", Format: "date-time"}
+		}
+		if slices.Contains(seen, t) {
+			return &TypeSchema{Type: "object"}
+		}
+		ts := &TypeSchema{Type: "object", Properties: map[string]*TypeSchema{}}
+		addFields(ts, t, append(seen, t))
+		return ts
+	default:
+		return &TypeSchema{}
+	}
+}
+
+// addFields adds the fields of the struct type t to ts, flattening embedded
+// structs like encoding/json does.
+func addFields(ts *TypeSchema, t reflect.Type, seen []reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(ts, ft, seen)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs := typeSchema(f.Type, seen)
+		target := fs // rules after dive apply to the elements
+		for _, rule := range strings.Split(f.Tag.Get("validate"), ",") {
+			switch {
+			case rule == "dive":
+				target = target.Items
+				if target == nil {
+					target = &TypeSchema{} // maps are not described in that detail
+				}
+			case rule == "required" && target == fs:
+				ts.Required = append(ts.Required, name)
+			case strings.HasPrefix(rule, "oneof="):
+				target.Enum = strings.Fields(strings.TrimPrefix(rule, "oneof="))
+			}
+		}
+		ts.Properties[name] = fs
+	}
+}
@@ -0,0 +1,92 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package rpc
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type schemaBase struct {
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type schemaParams struct {
+	Name     string   `json:"name" validate:"required"`
+	Order    string   `json:"order" validate:"omitempty,oneof=asc desc"`
+	Tags     []string `json:"tags" validate:"required,min=1,dive,oneof=a b"`
+	Token    []byte   `json:"token"`
+	Optional *int     `json:"optional"`
+	Ignored  string   `json:"-"`
+	internal string
+}
+
+type schemaResult struct {
+	schemaBase
+	Values map[string]bool `json:"values,omitempty"`
+}
+
+type describedService struct {
+	echoService
+}
+
+func (describedService) Methods() []Method {
+	return []Method{
+		{Name: "Write", Params: schemaParams{}, Result: schemaResult{}},
+		{Name: "Read", Result: ""},
+	}
+}
+
+func TestServer_Schema(t *testing.T) {
+	s, err := NewRPCServer(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), nil, nil)
+	require.NoError(t, err)
+	s.MustRegister("Described", describedService{})
+	s.MustRegister("Echo", echoService{})
+
+	got, err := json.Marshal(s.Schema())
+	require.NoError(t, err)
+	require.JSONEq(t, `{"methods":[
+		{"name":"Described::Read","result":{"type":"string"}},
+		{"name":"Described::Write",
+		 "params":{"type":"object","required":["name","tags"],"properties":{
+			"name":{"type":"string"},
+			"order":{"type":"string","enum":["asc","desc"]},
+			"tags":{"type":"array","items":{"type":"string","enum":["a","b"]}},
+			"token":{"type":"string","format":"byte"},
+			"optional":{"type":"integer"}}},
+		 "result":{"type":"object","properties":{
+			"created_at":{"type":"string","format":"date-time"},
+			"values":{"type":"object","additionalProperties":{"type":"boolean"}}}}}
+	]}`, string(got))
+}
+
+func TestServer_Schema_NoDescribers(t *testing.T) {
+	s, err := NewRPCServer(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), nil, nil)
+	require.NoError(t, err)
+	s.MustRegister("Echo", echoService{})
+
+	got, err := json.Marshal(s.Schema())
+	require.NoError(t, err)
+	require.JSONEq(t, `{"methods":[]}`, string(got))
+}
@@ -18,29 +18,49 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/url"
 	"strings"
+	"sync"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 
 	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
 
 	"github.com/madsrc/sophrosyne"
+	"github.com/madsrc/sophrosyne/internal/grpc/checks"
 	"github.com/madsrc/sophrosyne/internal/rpc"
 )
 
+type probeFunc func(ctx context.Context, upstream url.URL) error
+
 type CheckService struct {
+	config       *sophrosyne.Config
 	checkService sophrosyne.CheckService
 	authz        sophrosyne.AuthorizationProvider
 	logger       *slog.Logger
 	validator    sophrosyne.Validator
+	prober       probeFunc
+	checker      checkFunc
 }
 
-func NewCheckService(checkService sophrosyne.CheckService, authz sophrosyne.AuthorizationProvider, logger *slog.Logger, validator sophrosyne.Validator) (*CheckService, error) {
+func NewCheckService(config *sophrosyne.Config, checkService sophrosyne.CheckService, authz sophrosyne.AuthorizationProvider, logger *slog.Logger, validator sophrosyne.Validator) (*CheckService, error) {
 	u := &CheckService{
+		config:       config,
 		checkService: checkService,
 		authz:        authz,
 		logger:       logger,
 		validator:    validator,
+		prober:       probeUpstream,
+		checker:      doCheck,
 	}
 
 	return u, nil
@@ -48,6 +68,7 @@ func NewCheckService(checkService sophrosyne.CheckService, authz sophrosyne.Auth
 
 const paramExtractError = "error extracting params from request"
 const checkNotFoundError = "check not found"
+const forceResultNotAllowedError = "force_result is not allowed"
 
 func (u CheckService) EntityType() string {
 	return "Service"
@@ -58,12 +79,12 @@ func (u CheckService) EntityID() string {
 }
 
 func (u CheckService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
-	m := strings.Split(string(req.Method), "::")
-	if len(m) != 2 {
-		u.logger.ErrorContext(ctx, "unreachable", "error", sophrosyne.NewUnreachableCodeError())
-		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	_, method, err := rpc.SplitMethod(req.Method)
+	if err != nil {
+		u.logger.DebugContext(ctx, "cannot invoke method", "method", req.Method, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.MethodNotFound, string(jsonrpc.MethodNotFoundMessage))
 	}
-	switch m[1] {
+	switch method {
 	case "Getcheck":
 		return u.GetCheck(ctx, req)
 	case "GetChecks":
@@ -74,15 +95,32 @@ func (u CheckService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]
 		return u.UpdateCheck(ctx, req)
 	case "DeleteCheck":
 		return u.DeleteCheck(ctx, req)
+	case "TestCheck":
+		return u.TestCheck(ctx, req)
+	case "GetCheckUsage":
+		return u.GetCheckUsage(ctx, req)
 	default:
 		u.logger.DebugContext(ctx, "cannot invoke method", "method", req.Method)
 		return rpc.ErrorFromRequest(&req, jsonrpc.MethodNotFound, string(jsonrpc.MethodNotFoundMessage))
 	}
 }
 
+// Methods describes the methods handled by InvokeMethod.
+func (u CheckService) Methods() []rpc.Method {
+	return []rpc.Method{
+		{Name: "Getcheck", Params: sophrosyne.GetCheckRequest{}, Result: sophrosyne.GetCheckResponse{}},
+		{Name: "GetChecks", Params: sophrosyne.GetChecksRequest{}, Result: sophrosyne.GetChecksResponse{}},
+		{Name: "CreateCheck", Params: sophrosyne.CreateCheckRequest{}, Result: sophrosyne.CreateCheckResponse{}},
+		{Name: "UpdateCheck", Params: sophrosyne.UpdateCheckRequest{}, Result: sophrosyne.UpdateCheckResponse{}},
+		{Name: "DeleteCheck", Params: sophrosyne.DeleteCheckRequest{}, Result: ""},
+		{Name: "TestCheck", Params: sophrosyne.TestCheckRequest{}, Result: sophrosyne.TestCheckResponse{}},
+		{Name: "GetCheckUsage", Params: sophrosyne.GetCheckUsageRequest{}, Result: sophrosyne.GetCheckUsageResponse{}},
+	}
+}
+
 func (u CheckService) GetCheck(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.GetCheckRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -100,7 +138,7 @@ func (u CheckService) GetCheck(ctx context.Context, req jsonrpc.Request) ([]byte
 
 	if !u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curCheck,
-		Action:    sophrosyne.AuthorizationAction("GetCheck"),
+		Action:    u.config.AuthzAction("GetCheck"),
 		Resource:  sophrosyne.Check{ID: params.ID},
 	}) {
 		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
@@ -119,7 +157,7 @@ func (u CheckService) GetCheck(ctx context.Context, req jsonrpc.Request) ([]byte
 
 func (u CheckService) GetChecks(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.GetChecksRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		if errors.Is(err, rpc.ErrNoParams) {
 			params = sophrosyne.GetChecksRequest{}
@@ -136,7 +174,7 @@ func (u CheckService) GetChecks(ctx context.Context, req jsonrpc.Request) ([]byt
 
 	var cursor *sophrosyne.DatabaseCursor
 	if params.Cursor != "" {
-		cursor, err = sophrosyne.DecodeDatabaseCursorWithOwner(params.Cursor, curCheck.ID)
+		cursor, err = sophrosyne.DecodeDatabaseCursorWithOwner(params.Cursor, curCheck.ID, u.config)
 		if err != nil {
 			u.logger.ErrorContext(ctx, "unable to decode cursor", "error", err)
 			return rpc.ErrorFromRequest(&req, 12347, "invalid cursor")
@@ -145,6 +183,15 @@ func (u CheckService) GetChecks(ctx context.Context, req jsonrpc.Request) ([]byt
 		cursor = sophrosyne.NewDatabaseCursor(curCheck.ID, "")
 	}
 
+	err = cursor.ValidatePosition(ctx, u.checkExists)
+	if errors.Is(err, sophrosyne.ErrInvalidCursor) {
+		u.logger.InfoContext(ctx, "cursor refers to a check that no longer exists", "cursor", cursor)
+		return rpc.ErrorFromRequest(&req, 12347, "invalid cursor")
+	} else if err != nil {
+		u.logger.ErrorContext(ctx, "unable to validate cursor", "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
 	checks, err := u.checkService.GetChecks(ctx, cursor)
 	if err != nil {
 		u.logger.ErrorContext(ctx, "unable to get checks", "error", err)
@@ -155,7 +202,7 @@ func (u CheckService) GetChecks(ctx context.Context, req jsonrpc.Request) ([]byt
 	for _, uu := range checks {
 		ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 			Principal: curCheck,
-			Action:    sophrosyne.AuthorizationAction("GetChecks"),
+			Action:    u.config.AuthzAction("GetChecks"),
 			Resource:  sophrosyne.Check{ID: uu.ID},
 		})
 		if ok {
@@ -167,14 +214,14 @@ func (u CheckService) GetChecks(ctx context.Context, req jsonrpc.Request) ([]byt
 	u.logger.DebugContext(ctx, "returning checks", "total", len(checksResponse), "checks", checksResponse)
 	return rpc.ResponseToRequest(&req, sophrosyne.GetChecksResponse{
 		Checks: checksResponse,
-		Cursor: cursor.String(),
+		Cursor: cursor.Encode(u.config),
 		Total:  len(checksResponse),
 	})
 }
 
 func (u CheckService) CreateCheck(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.CreateCheckRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -187,13 +234,21 @@ func (u CheckService) CreateCheck(ctx context.Context, req jsonrpc.Request) ([]b
 
 	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curCheck,
-		Action:    sophrosyne.AuthorizationAction("CreateCheck"),
+		Action:    u.config.AuthzAction("CreateCheck"),
 	})
 
 	if !ok {
 		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
 	}
 
+	if !u.forceResultAllowed(params.ForceResult) {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, forceResultNotAllowedError)
+	}
+
+	if unreachable := u.unreachableUpstreams(ctx, params.UpstreamServices, params.ValidateUpstream); len(unreachable) > 0 {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, unreachableUpstreamsMessage(unreachable))
+	}
+
 	check, err := u.checkService.CreateCheck(ctx, params)
 	if err != nil {
 		u.logger.ErrorContext(ctx, "unable to create check", "error", err)
@@ -206,7 +261,7 @@ func (u CheckService) CreateCheck(ctx context.Context, req jsonrpc.Request) ([]b
 
 func (u CheckService) UpdateCheck(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.UpdateCheckRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -224,7 +279,7 @@ func (u CheckService) UpdateCheck(ctx context.Context, req jsonrpc.Request) ([]b
 
 	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curCheck,
-		Action:    sophrosyne.AuthorizationAction("UpdateCheck"),
+		Action:    u.config.AuthzAction("UpdateCheck"),
 		Resource:  sophrosyne.Check{ID: checkToUpdate.ID},
 	})
 
@@ -232,6 +287,14 @@ func (u CheckService) UpdateCheck(ctx context.Context, req jsonrpc.Request) ([]b
 		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
 	}
 
+	if !u.forceResultAllowed(params.ForceResult) {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, forceResultNotAllowedError)
+	}
+
+	if unreachable := u.unreachableUpstreams(ctx, params.UpstreamServices, params.ValidateUpstream); len(unreachable) > 0 {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, unreachableUpstreamsMessage(unreachable))
+	}
+
 	check, err := u.checkService.UpdateCheck(ctx, params)
 	if err != nil {
 		u.logger.ErrorContext(ctx, "unable to update check", "error", err)
@@ -244,7 +307,7 @@ func (u CheckService) UpdateCheck(ctx context.Context, req jsonrpc.Request) ([]b
 
 func (u CheckService) DeleteCheck(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.DeleteCheckRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -262,7 +325,7 @@ func (u CheckService) DeleteCheck(ctx context.Context, req jsonrpc.Request) ([]b
 
 	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curCheck,
-		Action:    sophrosyne.AuthorizationAction("DeleteCheck"),
+		Action:    u.config.AuthzAction("DeleteCheck"),
 		Resource:  sophrosyne.Check{ID: checkToDelete.ID},
 	})
 
@@ -278,3 +341,205 @@ func (u CheckService) DeleteCheck(ctx context.Context, req jsonrpc.Request) ([]b
 
 	return rpc.ResponseToRequest(&req, "ok")
 }
+
+// GetCheckUsage returns the profiles that include a check, leaving out the
+// profiles the caller is not authorized to see.
+func (u CheckService) GetCheckUsage(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	var params sophrosyne.GetCheckUsageRequest
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
+	if err != nil {
+		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
+	}
+
+	curCheck := sophrosyne.ExtractUser(ctx)
+	if curCheck == nil {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
+	check, err := u.checkService.GetCheckByName(ctx, params.Name)
+	if err != nil {
+		return rpc.ErrorFromRequest(&req, 12346, checkNotFoundError)
+	}
+
+	if !u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
+		Principal: curCheck,
+		Action:    u.config.AuthzAction("GetCheckUsage"),
+		Resource:  sophrosyne.Check{ID: check.ID},
+	}) {
+		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
+	}
+
+	profiles, err := u.checkService.GetCheckUsage(ctx, check.Name)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "unable to get check usage", "error", err)
+		return rpc.ErrorFromRequest(&req, 12346, checkNotFoundError)
+	}
+
+	resp := sophrosyne.GetCheckUsageResponse{
+		Name:     check.Name,
+		Profiles: []string{},
+	}
+	for _, profile := range profiles {
+		if u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
+			Principal: curCheck,
+			Action:    u.config.AuthzAction("GetProfile"),
+			Resource:  sophrosyne.Profile{ID: profile.ID},
+		}) {
+			resp.Profiles = append(resp.Profiles, profile.Name)
+		}
+	}
+	resp.Total = len(resp.Profiles)
+
+	return rpc.ResponseToRequest(&req, resp)
+}
+
+// TestCheck calls the provider of a single check with the given content and
+// returns its result. Nothing is scanned or persisted. The check is either an
+// existing one, looked up by name, or an inline upstream service.
+func (u CheckService) TestCheck(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	var params sophrosyne.TestCheckRequest
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
+	if err == nil && (params.Name == "") == (params.Upstream == "") {
+		err = errors.New("exactly one of name and upstream is required")
+	}
+	if err == nil && (params.Text == "") == (len(params.Image) == 0) {
+		err = errors.New("exactly one of text and image is required")
+	}
+	var upstream *url.URL
+	if err == nil && params.Upstream != "" {
+		upstream, err = url.Parse(params.Upstream)
+	}
+	if err != nil {
+		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
+	}
+
+	curUser := sophrosyne.ExtractUser(ctx)
+	if curUser == nil {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
+	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
+		Principal: curUser,
+		Action:    u.config.AuthzAction("TestCheck"),
+		Resource:  u,
+	})
+
+	if !ok {
+		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
+	}
+
+	check := sophrosyne.Check{Name: params.Upstream}
+	if upstream != nil {
+		check.UpstreamServices = []url.URL{*upstream}
+	} else {
+		check, err = u.checkService.GetCheckByName(ctx, params.Name)
+		if err != nil {
+			return rpc.ErrorFromRequest(&req, 12346, checkNotFoundError)
+		}
+	}
+
+	content := &checks.CheckRequest{Check: &checks.CheckRequest_Text{Text: params.Text}}
+	if len(params.Image) > 0 {
+		content = &checks.CheckRequest{Check: &checks.CheckRequest_Image{Image: base64.StdEncoding.EncodeToString(params.Image)}}
+	}
+	res, err := u.checker(ctx, u.logger, check, content)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "unable to test check", "check", check.Name, "error", err)
+		return rpc.ErrorFromRequest(&req, 12346, "unable to test check")
+	}
+
+	return rpc.ResponseToRequest(&req, sophrosyne.TestCheckResponse{
+		Result:   res.Status,
+		Detail:   res.Detail,
+		Metadata: res.Metadata,
+	})
+}
+
+// checkExists reports whether the check with the given ID exists. It is used to validate
+// the position of cursors.
+func (u CheckService) checkExists(ctx context.Context, id string) (bool, error) {
+	_, err := u.checkService.GetCheck(ctx, id)
+	if errors.Is(err, sophrosyne.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// forceResultAllowed reports whether a check may be given the result override
+// f. Overrides other than [sophrosyne.ForceResultNone] require
+// services.checks.allowForceResult.
+func (u CheckService) forceResultAllowed(f sophrosyne.ForceResult) bool {
+	if f == "" || f == sophrosyne.ForceResultNone {
+		return true
+	}
+	return u.config != nil && u.config.Services.Checks.AllowForceResult
+}
+
+// unreachableUpstreams probes the given upstream services concurrently and
+// returns the ones that could not be reached, in the order they were given.
+// The validate_upstream param of the request decides whether anything is
+// probed, defaulting to services.checks.probeOnCreate if it is not given.
+func (u CheckService) unreachableUpstreams(ctx context.Context, upstreams []string, validate *bool) []string {
+	probe := u.config != nil && u.config.Services.Checks.ProbeOnCreate
+	if validate != nil {
+		probe = *validate
+	}
+	if !probe || len(upstreams) == 0 {
+		return nil
+	}
+
+	if u.config != nil && u.config.Services.Checks.ProbeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.config.Services.Checks.ProbeTimeout)
+		defer cancel()
+	}
+
+	failed := make([]bool, len(upstreams))
+	var wg sync.WaitGroup
+	for i, upstream := range upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			target, err := url.Parse(upstream)
+			if err == nil {
+				err = u.prober(ctx, *target)
+			}
+			if err != nil {
+				u.logger.InfoContext(ctx, "upstream service unreachable", "upstream", upstream, "error", err)
+				failed[i] = true
+			}
+		}()
+	}
+	wg.Wait()
+
+	var unreachable []string
+	for i, upstream := range upstreams {
+		if failed[i] {
+			unreachable = append(unreachable, upstream)
+		}
+	}
+	return unreachable
+}
+
+func unreachableUpstreamsMessage(unreachable []string) string {
+	return fmt.Sprintf("unreachable upstream services: %s", strings.Join(unreachable, ", "))
+}
+
+// probeUpstream issues a gRPC health check against the upstream service. An
+// upstream that answers, even if only to say that it does not implement the
+// health service, is considered reachable.
+func probeUpstream(ctx context.Context, upstream url.URL) error {
+	conn, err := grpc.NewClient(upstream.Host, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+	return err
+}
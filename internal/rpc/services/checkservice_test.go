@@ -0,0 +1,488 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/madsrc/sophrosyne"
+	"github.com/madsrc/sophrosyne/internal/grpc/checks"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
+)
+
+func TestCheckService_CreateCheck_ProbeOnCreate(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+	tests := []struct {
+		name     string
+		enabled  bool
+		validate interface{} // validate_upstream param, omitted if nil
+		upstream []interface{}
+		creates  bool
+		wantErr  string
+	}{
+		{
+			name:     "reachable",
+			enabled:  true,
+			upstream: []interface{}{"grpc://up:1", "grpc://up:2"},
+			creates:  true,
+		},
+		{
+			name:     "unreachable",
+			enabled:  true,
+			upstream: []interface{}{"grpc://down:1", "grpc://up:1", "grpc://down:2"},
+			wantErr:  `{"jsonrpc":"2.0","error":{"code":-32602,"message":"unreachable upstream services: grpc://down:1, grpc://down:2"},"id":"1"}`,
+		},
+		{
+			name:     "disabled",
+			upstream: []interface{}{"grpc://down:1"},
+			creates:  true,
+		},
+		{
+			name:     "requested when disabled",
+			validate: true,
+			upstream: []interface{}{"grpc://down:1"},
+			wantErr:  `{"jsonrpc":"2.0","error":{"code":-32602,"message":"unreachable upstream services: grpc://down:1"},"id":"1"}`,
+		},
+		{
+			name:     "skipped when enabled",
+			enabled:  true,
+			validate: false,
+			upstream: []interface{}{"grpc://down:1"},
+			creates:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &sophrosyne.Config{}
+			config.Services.Checks.ProbeOnCreate = tt.enabled
+			config.Services.Checks.ProbeTimeout = time.Second
+
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(true).Once()
+			checkService := sophrosyne2.NewMockCheckService(t)
+			if tt.creates {
+				checkService.EXPECT().CreateCheck(ctx, mock.Anything).Return(sophrosyne.Check{Name: "check"}, nil).Once()
+			}
+
+			u := CheckService{
+				config:       config,
+				checkService: checkService,
+				authz:        authz,
+				logger:       slog.Default(),
+				prober: func(ctx context.Context, upstream url.URL) error {
+					if upstream.Hostname() == "down" {
+						return errors.New("unreachable")
+					}
+					return nil
+				},
+			}
+
+			params := jsonrpc.ParamsObject{"name": "check", "upstream_services": tt.upstream}
+			if tt.validate != nil {
+				params["validate_upstream"] = tt.validate
+			}
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Checks::CreateCheck",
+				Params: &params,
+			})
+			require.NoError(t, err)
+			if tt.wantErr != "" {
+				require.JSONEq(t, tt.wantErr, string(got))
+				return
+			}
+			require.Contains(t, string(got), `"result":{"name":"check"`)
+		})
+	}
+}
+
+func TestProbeUpstream(t *testing.T) {
+	serve := func(t *testing.T, withHealth bool) url.URL {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		srv := grpc.NewServer()
+		if withHealth {
+			grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+		}
+		go func() { _ = srv.Serve(lis) }()
+		t.Cleanup(srv.Stop)
+		return url.URL{Scheme: "grpc", Host: lis.Addr().String()}
+	}
+
+	t.Run("health service", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		require.NoError(t, probeUpstream(ctx, serve(t, true)))
+	})
+
+	t.Run("no health service", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		require.NoError(t, probeUpstream(ctx, serve(t, false)))
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := lis.Addr().String()
+		require.NoError(t, lis.Close())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		require.Error(t, probeUpstream(ctx, url.URL{Scheme: "grpc", Host: addr}))
+	})
+}
+
+func TestCheckService_CreateCheck_ForceResult(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+	tests := []struct {
+		name        string
+		allowed     bool
+		forceResult string
+		creates     bool
+	}{
+		{name: "none", forceResult: "none", creates: true},
+		{name: "pass allowed", allowed: true, forceResult: "pass", creates: true},
+		{name: "fail allowed", allowed: true, forceResult: "fail", creates: true},
+		{name: "pass not allowed", forceResult: "pass"},
+		{name: "fail not allowed", forceResult: "fail"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &sophrosyne.Config{}
+			config.Services.Checks.AllowForceResult = tt.allowed
+
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(true).Once()
+			checkService := sophrosyne2.NewMockCheckService(t)
+			if tt.creates {
+				checkService.EXPECT().CreateCheck(ctx, sophrosyne.CreateCheckRequest{Name: "check", ForceResult: sophrosyne.ForceResult(tt.forceResult)}).
+					Return(sophrosyne.Check{Name: "check", ForceResult: sophrosyne.ForceResult(tt.forceResult)}, nil).Once()
+			}
+
+			u := CheckService{
+				config:       config,
+				checkService: checkService,
+				authz:        authz,
+				logger:       slog.Default(),
+			}
+
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Checks::CreateCheck",
+				Params: &jsonrpc.ParamsObject{"name": "check", "force_result": tt.forceResult},
+			})
+			require.NoError(t, err)
+			if !tt.creates {
+				require.JSONEq(t, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"force_result is not allowed"},"id":"1"}`, string(got))
+				return
+			}
+			require.Contains(t, string(got), `"result":{"name":"check"`)
+		})
+	}
+}
+
+func TestCheckService_GetChecks_Cursor(t *testing.T) {
+	const owner, position = "cs3ntbcfv20jrb7hv0f0", "cs3ntbcfv20jrb7hv0fg"
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: owner})
+	config := &sophrosyne.Config{}
+	config.Server.CursorMode = sophrosyne.CursorModePlain
+	cursor := sophrosyne.NewDatabaseCursor(owner, position).Encode(config)
+	check := `{"name":"last","profiles":null,"upstream_services":null,"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}`
+
+	tests := []struct {
+		name   string
+		params *jsonrpc.ParamsObject
+		setup  func(cs *sophrosyne2.MockCheckService, authz *sophrosyne2.MockAuthorizationProvider)
+		want   string
+	}{
+		{
+			name: "first page advances cursor",
+			setup: func(cs *sophrosyne2.MockCheckService, authz *sophrosyne2.MockAuthorizationProvider) {
+				cs.EXPECT().GetChecks(ctx, sophrosyne.NewDatabaseCursor(owner, "")).RunAndReturn(func(_ context.Context, c *sophrosyne.DatabaseCursor) ([]sophrosyne.Check, error) {
+					c.Advance(position)
+					return []sophrosyne.Check{{ID: position, Name: "last"}}, nil
+				}).Once()
+				authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(true).Once()
+			},
+			want: `{"jsonrpc":"2.0","result":{"checks":[` + check + `],"cursor":"` + cursor + `","total":1},"id":"1"}`,
+		},
+		{
+			name:   "last page resets cursor",
+			params: &jsonrpc.ParamsObject{"cursor": cursor},
+			setup: func(cs *sophrosyne2.MockCheckService, authz *sophrosyne2.MockAuthorizationProvider) {
+				cs.EXPECT().GetCheck(ctx, position).Return(sophrosyne.Check{ID: position}, nil).Once()
+				cs.EXPECT().GetChecks(ctx, sophrosyne.NewDatabaseCursor(owner, position)).RunAndReturn(func(_ context.Context, c *sophrosyne.DatabaseCursor) ([]sophrosyne.Check, error) {
+					c.Reset()
+					return []sophrosyne.Check{{ID: "cs3ntbcfv20jrb7hv0g0", Name: "last"}}, nil
+				}).Once()
+				authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(true).Once()
+			},
+			want: `{"jsonrpc":"2.0","result":{"checks":[` + check + `],"cursor":"","total":1},"id":"1"}`,
+		},
+		{
+			name:   "deleted position",
+			params: &jsonrpc.ParamsObject{"cursor": cursor},
+			setup: func(cs *sophrosyne2.MockCheckService, authz *sophrosyne2.MockAuthorizationProvider) {
+				cs.EXPECT().GetCheck(ctx, position).Return(sophrosyne.Check{}, sophrosyne.ErrNotFound).Once()
+			},
+			want: `{"jsonrpc":"2.0","error":{"code":12347,"message":"invalid cursor"},"id":"1"}`,
+		},
+		{
+			name:   "cursor of another owner",
+			params: &jsonrpc.ParamsObject{"cursor": sophrosyne.NewDatabaseCursor("cs3ntbcfv20jrb7hv0h0", position).Encode(config)},
+			setup:  func(cs *sophrosyne2.MockCheckService, authz *sophrosyne2.MockAuthorizationProvider) {},
+			want:   `{"jsonrpc":"2.0","error":{"code":12347,"message":"invalid cursor"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkService := sophrosyne2.NewMockCheckService(t)
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			tt.setup(checkService, authz)
+			u := CheckService{
+				config:       config,
+				checkService: checkService,
+				authz:        authz,
+				logger:       slog.Default(),
+			}
+
+			req := jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Checks::GetChecks",
+			}
+			if tt.params != nil {
+				req.Params = tt.params
+			}
+			got, err := u.InvokeMethod(ctx, req)
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestCheckService_TestCheck(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+	tests := []struct {
+		name       string
+		params     jsonrpc.ParamsObject
+		authorize  bool // whether the params pass validation and authorization is asked
+		authorized bool
+		lookup     bool
+		wantCheck  string
+		wantText   string
+		want       string
+	}{
+		{
+			name:       "by name",
+			params:     jsonrpc.ParamsObject{"name": "check", "text": "hello"},
+			authorize:  true,
+			authorized: true,
+			lookup:     true,
+			wantCheck:  "grpc://stored:1",
+			wantText:   "hello",
+			want:       `{"jsonrpc":"2.0","result":{"result":true,"detail":"grpc://stored:1"},"id":"1"}`,
+		},
+		{
+			name:       "inline upstream",
+			params:     jsonrpc.ParamsObject{"upstream": "grpc://inline:1", "text": "hello"},
+			authorize:  true,
+			authorized: true,
+			wantCheck:  "grpc://inline:1",
+			wantText:   "hello",
+			want:       `{"jsonrpc":"2.0","result":{"result":true,"detail":"grpc://inline:1"},"id":"1"}`,
+		},
+		{
+			name:   "name and upstream",
+			params: jsonrpc.ParamsObject{"name": "check", "upstream": "grpc://inline:1", "text": "hello"},
+			want:   `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid Params"},"id":"1"}`,
+		},
+		{
+			name:   "no content",
+			params: jsonrpc.ParamsObject{"name": "check"},
+			want:   `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid Params"},"id":"1"}`,
+		},
+		{
+			name:      "unauthorized",
+			params:    jsonrpc.ParamsObject{"name": "check", "text": "hello"},
+			authorize: true,
+			want:      `{"jsonrpc":"2.0","error":{"code":12345,"message":"unauthorized"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			checkService := sophrosyne2.NewMockCheckService(t)
+			if tt.authorize {
+				authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(tt.authorized).Once()
+			}
+			if tt.lookup {
+				stored, _ := url.Parse("grpc://stored:1")
+				checkService.EXPECT().GetCheckByName(ctx, "check").Return(sophrosyne.Check{Name: "check", UpstreamServices: []url.URL{*stored}}, nil).Once()
+			}
+
+			u := CheckService{
+				config:       &sophrosyne.Config{},
+				checkService: checkService,
+				authz:        authz,
+				logger:       slog.Default(),
+				checker: func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+					require.Equal(t, tt.wantText, content.GetText())
+					return checkResult{Status: true, Detail: check.UpstreamServices[0].String()}, nil
+				},
+			}
+
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Checks::TestCheck",
+				Params: &tt.params,
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestCheckService_GetCheckUsage(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+	profiles := []sophrosyne.Profile{{ID: "p1", Name: "default"}, {ID: "p2", Name: "strict"}, {ID: "p3", Name: "secret"}}
+	tests := []struct {
+		name       string
+		profiles   []sophrosyne.Profile
+		notFound   bool
+		authorized bool
+		hidden     string // ID of a profile the caller may not see
+		want       string
+	}{
+		{
+			name:       "used by multiple profiles",
+			profiles:   profiles,
+			authorized: true,
+			want:       `{"jsonrpc":"2.0","result":{"name":"check","profiles":["default","strict","secret"],"total":3},"id":"1"}`,
+		},
+		{
+			name:       "profiles are filtered by authorization",
+			profiles:   profiles,
+			authorized: true,
+			hidden:     "p3",
+			want:       `{"jsonrpc":"2.0","result":{"name":"check","profiles":["default","strict"],"total":2},"id":"1"}`,
+		},
+		{
+			name:       "used by none",
+			profiles:   []sophrosyne.Profile{},
+			authorized: true,
+			want:       `{"jsonrpc":"2.0","result":{"name":"check","profiles":[],"total":0},"id":"1"}`,
+		},
+		{
+			name:     "unknown check",
+			notFound: true,
+			want:     `{"jsonrpc":"2.0","error":{"code":12346,"message":"check not found"},"id":"1"}`,
+		},
+		{
+			name: "unauthorized",
+			want: `{"jsonrpc":"2.0","error":{"code":12345,"message":"unauthorized"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			checkService := sophrosyne2.NewMockCheckService(t)
+			if tt.notFound {
+				checkService.EXPECT().GetCheckByName(ctx, "check").Return(sophrosyne.Check{}, sophrosyne.ErrNotFound).Once()
+			} else {
+				checkService.EXPECT().GetCheckByName(ctx, "check").Return(sophrosyne.Check{ID: "c1", Name: "check"}, nil).Once()
+				authz.EXPECT().IsAuthorized(ctx, mock.MatchedBy(func(r sophrosyne.AuthorizationRequest) bool {
+					return r.Resource.EntityType() == "Check"
+				})).Return(tt.authorized).Once()
+			}
+			if tt.authorized {
+				checkService.EXPECT().GetCheckUsage(ctx, "check").Return(tt.profiles, nil).Once()
+			}
+			if len(tt.profiles) > 0 {
+				authz.EXPECT().IsAuthorized(ctx, mock.MatchedBy(func(r sophrosyne.AuthorizationRequest) bool {
+					return r.Resource.EntityType() == "Profile"
+				})).RunAndReturn(func(_ context.Context, r sophrosyne.AuthorizationRequest) bool {
+					return r.Resource.EntityID() != tt.hidden
+				}).Times(len(tt.profiles))
+			}
+
+			u := CheckService{
+				config:       &sophrosyne.Config{},
+				checkService: checkService,
+				authz:        authz,
+				logger:       slog.Default(),
+			}
+
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Checks::GetCheckUsage",
+				Params: &jsonrpc.ParamsObject{"name": "check"},
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestCheckService_GetCheck_ServedStale(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1", IsAdmin: true})
+	for _, stale := range []bool{false, true} {
+		t.Run(fmt.Sprint(stale), func(t *testing.T) {
+			checkService := sophrosyne2.NewMockCheckService(t)
+			checkService.EXPECT().GetCheck(ctx, "2").Return(sophrosyne.Check{ID: "2", Name: "test", ServedStale: stale}, nil).Once()
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(true).Once()
+			u := CheckService{
+				config:       &sophrosyne.Config{},
+				checkService: checkService,
+				authz:        authz,
+				logger:       slog.Default(),
+			}
+
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Checks::Getcheck",
+				Params: &jsonrpc.ParamsObject{"id": "2"},
+			})
+			require.NoError(t, err)
+			var resp struct {
+				Result map[string]any `json:"result"`
+			}
+			require.NoError(t, json.Unmarshal(got, &resp))
+			require.Equal(t, "test", resp.Result["name"])
+			_, ok := resp.Result["served_stale"]
+			require.Equal(t, stale, ok)
+		})
+	}
+}
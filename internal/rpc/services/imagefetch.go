@@ -0,0 +1,137 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"syscall"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+var (
+	errImageTooLarge     = errors.New("image exceeds the maximum size")
+	errSchemeNotAllowed  = errors.New("url scheme is not allowed")
+	errImageFetchFailure = errors.New("unable to fetch image")
+	errAddressNotAllowed = errors.New("address is not allowed")
+	errTooManyRedirects  = errors.New("stopped after 10 redirects")
+)
+
+// maxImageRedirects is the number of redirects followed when fetching an
+// image.
+const maxImageRedirects = 10
+
+// dialControlFunc is the signature of [net.Dialer.Control].
+type dialControlFunc func(network, address string, c syscall.RawConn) error
+
+// sharedAddressSpace is the range reserved for carrier-grade NAT by RFC 6598.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddressOnly is a [dialControlFunc] refusing connections to addresses
+// that are not publicly routable, such as loopback, private and link-local
+// addresses. The latter includes the metadata service of cloud providers at
+// 169.254.169.254. It is called with the address resolved from the hostname,
+// for every connection including those following a redirect.
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("%w: %s", errAddressNotAllowed, ip)
+	}
+	return nil
+}
+
+// fetchImage downloads the image at rawURL, bounded by the size, schemes and
+// timeout in services.scans.imageFetch. Redirects are only followed to URLs
+// with an allowed scheme, and at most [maxImageRedirects] times. If control is
+// not nil, it is called before connecting to each address, see
+// [publicAddressOnly].
+func fetchImage(ctx context.Context, config *sophrosyne.Config, rawURL string, control dialControlFunc) ([]byte, error) {
+	cfg := config.Services.Scans.ImageFetch
+	allowed := func(u *url.URL) error {
+		if !slices.Contains(cfg.Schemes, u.Scheme) {
+			return fmt.Errorf("%w: %q", errSchemeNotAllowed, u.Scheme)
+		}
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := allowed(u); err != nil {
+		return nil, err
+	}
+
+	// The transport does not use a proxy, as the proxy would connect to the
+	// image rather than the dialer checked by control.
+	transport := &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: cfg.Timeout, Control: control}).DialContext,
+		TLSHandshakeTimeout: cfg.Timeout,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxImageRedirects {
+				return errTooManyRedirects
+			}
+			return allowed(req.URL)
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%w: status %d", errImageFetchFailure, resp.StatusCode)
+	}
+	if resp.ContentLength > cfg.MaxSize {
+		return nil, errImageTooLarge
+	}
+
+	// Read one byte more than allowed to detect bodies larger than announced.
+	image, err := io.ReadAll(io.LimitReader(resp.Body, cfg.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(image)) > cfg.MaxSize {
+		return nil, errImageTooLarge
+	}
+	return image, nil
+}
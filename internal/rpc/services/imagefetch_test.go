@@ -0,0 +1,197 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+	"github.com/madsrc/sophrosyne/internal/grpc/checks"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
+)
+
+func imageFetchConfig(schemes ...string) *sophrosyne.Config {
+	config := &sophrosyne.Config{}
+	config.Services.Scans.ImageFetch.MaxSize = 8
+	config.Services.Scans.ImageFetch.Schemes = schemes
+	config.Services.Scans.ImageFetch.Timeout = time.Second
+	return config
+}
+
+func newImageServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/small.png", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("image"))
+	})
+	mux.HandleFunc("/large.png", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("x", 9)))
+	})
+	mux.HandleFunc("/unannounced.png", func(w http.ResponseWriter, r *http.Request) {
+		// Flushing before writing forces a chunked response without a
+		// Content-Length.
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(strings.Repeat("x", 9)))
+	})
+	mux.HandleFunc("/missing.png", http.NotFound)
+	mux.HandleFunc("/redirect.png", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "ftp://example.com/image.png", http.StatusFound)
+	})
+	mux.HandleFunc("/loop.png", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop.png", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchImage(t *testing.T) {
+	srv := newImageServer(t)
+	tests := []struct {
+		name    string
+		path    string
+		schemes []string
+		want    string
+		wantErr error
+	}{
+		{name: "within limits", path: "/small.png", schemes: []string{"http"}, want: "image"},
+		{name: "too large", path: "/large.png", schemes: []string{"http"}, wantErr: errImageTooLarge},
+		{name: "too large without content length", path: "/unannounced.png", schemes: []string{"http"}, wantErr: errImageTooLarge},
+		{name: "scheme not allowed", path: "/small.png", schemes: []string{"https"}, wantErr: errSchemeNotAllowed},
+		{name: "redirect to disallowed scheme", path: "/redirect.png", schemes: []string{"http"}, wantErr: errSchemeNotAllowed},
+		{name: "not found", path: "/missing.png", schemes: []string{"http"}, wantErr: errImageFetchFailure},
+		{name: "too many redirects", path: "/loop.png", schemes: []string{"http"}, wantErr: errTooManyRedirects},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fetchImage(context.Background(), imageFetchConfig(tt.schemes...), srv.URL+tt.path, nil)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestFetchImage_PublicAddressOnly(t *testing.T) {
+	srv := newImageServer(t)
+	_, err := fetchImage(context.Background(), imageFetchConfig("http"), srv.URL+"/small.png", publicAddressOnly)
+	require.ErrorIs(t, err, errAddressNotAllowed)
+
+	// The hostname is checked by the address it resolves to.
+	_, err = fetchImage(context.Background(), imageFetchConfig("http"), strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)+"/small.png", publicAddressOnly)
+	require.ErrorIs(t, err, errAddressNotAllowed)
+}
+
+func TestPublicAddressOnly(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{address: "93.184.215.14:443", allowed: true},
+		{address: "[2606:2800:21f:cb07:6820:80da:af6b:8b2c]:443", allowed: true},
+		{address: "127.0.0.1:80"},
+		{address: "[::1]:80"},
+		{address: "10.0.0.1:80"},
+		{address: "172.16.0.1:80"},
+		{address: "192.168.1.1:80"},
+		{address: "169.254.169.254:80"},
+		{address: "[fe80::1]:80"},
+		{address: "[fd00::1]:80"},
+		{address: "100.64.0.1:80"},
+		{address: "0.0.0.0:80"},
+		{address: "[::ffff:127.0.0.1]:80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := publicAddressOnly("tcp", tt.address, nil)
+			if tt.allowed {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, errAddressNotAllowed)
+		})
+	}
+}
+
+func TestScanService_PerformScan_ImageURL(t *testing.T) {
+	srv := newImageServer(t)
+	tests := []struct {
+		name      string
+		path      string
+		wantImage string
+		want      string
+	}{
+		{
+			name:      "image forwarded",
+			path:      "/small.png",
+			wantImage: base64.StdEncoding.EncodeToString([]byte("image")),
+			want:      `{"jsonrpc":"2.0","result":{"result":true,"profile":"test","profile_id":"p1","checks":{"check":{"status":true,"detail":"fine"}}},"id":"1"}`,
+		},
+		{
+			name: "image too large",
+			path: "/large.png",
+			want: `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid Params"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+			profileService := sophrosyne2.NewMockProfileService(t)
+			if tt.wantImage != "" {
+				profile := sophrosyne.Profile{ID: "p1", Name: "test", Checks: []sophrosyne.Check{{Name: "check"}}}
+				profileService.EXPECT().GetProfileByName(ctx, "test").Return(profile, nil).Once()
+			}
+			metricService := sophrosyne2.NewMockMetricService(t)
+			metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+			metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+			var gotImage string
+			s := ScanService{
+				config:         imageFetchConfig("http"),
+				logger:         slog.Default(),
+				profileService: profileService,
+				metricService:  metricService,
+				checker: func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+					gotImage = content.GetImage()
+					return checkResult{Status: true, Detail: "fine"}, nil
+				},
+			}
+
+			got, err := s.PerformScan(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Scans::PerformScan",
+				Params: &jsonrpc.ParamsObject{"profile": "test", "image_url": srv.URL + tt.path},
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+			require.Equal(t, tt.wantImage, gotImage)
+		})
+	}
+}
@@ -19,8 +19,8 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
-	"strings"
 
 	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
 
@@ -29,15 +29,19 @@ import (
 )
 
 type ProfileService struct {
+	config         *sophrosyne.Config
 	profileService sophrosyne.ProfileService
+	checkService   sophrosyne.CheckService
 	authz          sophrosyne.AuthorizationProvider
 	logger         *slog.Logger
 	validator      sophrosyne.Validator
 }
 
-func NewProfileService(profileService sophrosyne.ProfileService, authz sophrosyne.AuthorizationProvider, logger *slog.Logger, validator sophrosyne.Validator) (*ProfileService, error) {
+func NewProfileService(config *sophrosyne.Config, profileService sophrosyne.ProfileService, checkService sophrosyne.CheckService, authz sophrosyne.AuthorizationProvider, logger *slog.Logger, validator sophrosyne.Validator) (*ProfileService, error) {
 	u := &ProfileService{
+		config:         config,
 		profileService: profileService,
+		checkService:   checkService,
 		authz:          authz,
 		logger:         logger,
 		validator:      validator,
@@ -55,12 +59,12 @@ func (u ProfileService) EntityID() string {
 }
 
 func (u ProfileService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
-	m := strings.Split(string(req.Method), "::")
-	if len(m) != 2 {
-		u.logger.ErrorContext(ctx, "unreachable", "error", sophrosyne.NewUnreachableCodeError())
-		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	_, method, err := rpc.SplitMethod(req.Method)
+	if err != nil {
+		u.logger.DebugContext(ctx, "cannot invoke method", "method", req.Method, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.MethodNotFound, string(jsonrpc.MethodNotFoundMessage))
 	}
-	switch m[1] {
+	switch method {
 	case "GetProfile":
 		return u.GetProfile(ctx, req)
 	case "GetProfiles":
@@ -71,15 +75,29 @@ func (u ProfileService) InvokeMethod(ctx context.Context, req jsonrpc.Request) (
 		return u.UpdateProfile(ctx, req)
 	case "DeleteProfile":
 		return u.DeleteProfile(ctx, req)
+	case "ValidateProfile":
+		return u.ValidateProfile(ctx, req)
 	default:
 		u.logger.DebugContext(ctx, "cannot invoke method", "method", req.Method)
 		return rpc.ErrorFromRequest(&req, jsonrpc.MethodNotFound, string(jsonrpc.MethodNotFoundMessage))
 	}
 }
 
+// Methods describes the methods handled by InvokeMethod.
+func (u ProfileService) Methods() []rpc.Method {
+	return []rpc.Method{
+		{Name: "GetProfile", Params: sophrosyne.GetProfileRequest{}, Result: sophrosyne.GetProfileResponse{}},
+		{Name: "GetProfiles", Params: sophrosyne.GetProfilesRequest{}, Result: sophrosyne.GetProfilesResponse{}},
+		{Name: "CreateProfile", Params: sophrosyne.CreateProfileRequest{}, Result: sophrosyne.CreateProfileResponse{}},
+		{Name: "UpdateProfile", Params: sophrosyne.UpdateProfileRequest{}, Result: sophrosyne.UpdateProfileResponse{}},
+		{Name: "DeleteProfile", Params: sophrosyne.DeleteProfileRequest{}, Result: ""},
+		{Name: "ValidateProfile", Params: sophrosyne.ValidateProfileRequest{}, Result: sophrosyne.ValidateProfileResponse{}},
+	}
+}
+
 func (u ProfileService) GetProfile(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.GetProfileRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -97,7 +115,7 @@ func (u ProfileService) GetProfile(ctx context.Context, req jsonrpc.Request) ([]
 
 	if !u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curUser,
-		Action:    sophrosyne.AuthorizationAction("GetProfile"),
+		Action:    u.config.AuthzAction("GetProfile"),
 		Resource:  sophrosyne.Profile{ID: params.ID},
 	}) {
 		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
@@ -116,9 +134,13 @@ func (u ProfileService) GetProfile(ctx context.Context, req jsonrpc.Request) ([]
 
 const profileNotFoundError = "profile not found"
 
+// maxProfilePagesScanned is the number of pages of profiles GetProfiles reads
+// at most, looking for a profile the user may see.
+const maxProfilePagesScanned = 10
+
 func (u ProfileService) GetProfiles(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.GetProfilesRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		if errors.Is(err, rpc.ErrNoParams) {
 			params = sophrosyne.GetProfilesRequest{}
@@ -135,7 +157,7 @@ func (u ProfileService) GetProfiles(ctx context.Context, req jsonrpc.Request) ([
 
 	var cursor *sophrosyne.DatabaseCursor
 	if params.Cursor != "" {
-		cursor, err = sophrosyne.DecodeDatabaseCursorWithOwner(params.Cursor, curProfile.ID)
+		cursor, err = sophrosyne.DecodeDatabaseCursorWithOwner(params.Cursor, curProfile.ID, u.config)
 		if err != nil {
 			u.logger.ErrorContext(ctx, "unable to decode cursor", "error", err)
 			return rpc.ErrorFromRequest(&req, 12347, "invalid cursor")
@@ -144,36 +166,56 @@ func (u ProfileService) GetProfiles(ctx context.Context, req jsonrpc.Request) ([
 		cursor = sophrosyne.NewDatabaseCursor(curProfile.ID, "")
 	}
 
-	Profiles, err := u.profileService.GetProfiles(ctx, cursor)
-	if err != nil {
-		u.logger.ErrorContext(ctx, "unable to get Profiles", "error", err)
-		return rpc.ErrorFromRequest(&req, 12346, "Profiles not found")
-	}
-
-	var ProfilesResponse []sophrosyne.GetProfileResponse
-	for _, uu := range Profiles {
-		ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
-			Principal: curProfile,
-			Action:    sophrosyne.AuthorizationAction("GetProfiles"),
-			Resource:  sophrosyne.Profile{ID: uu.ID},
-		})
-		if ok {
-			ent := &sophrosyne.GetProfileResponse{}
-			ProfilesResponse = append(ProfilesResponse, *ent.FromProfile(uu))
+	err = cursor.ValidatePosition(ctx, u.profileExists)
+	if errors.Is(err, sophrosyne.ErrInvalidCursor) {
+		u.logger.InfoContext(ctx, "cursor refers to a profile that no longer exists", "cursor", cursor)
+		return rpc.ErrorFromRequest(&req, 12347, "invalid cursor")
+	} else if err != nil {
+		u.logger.ErrorContext(ctx, "unable to validate cursor", "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
+	// Pages without any profile the user may see are skipped, so a user
+	// without access to most profiles does not have to page through all of
+	// them. To bound the work of a single request, at most
+	// maxProfilePagesScanned pages are read. If none of them has a profile
+	// the user may see, an empty list is returned with the cursor reached.
+	ProfilesResponse := []sophrosyne.GetProfileResponse{}
+	for range maxProfilePagesScanned {
+		position := cursor.Position
+		Profiles, err := u.profileService.GetProfiles(ctx, cursor)
+		if err != nil {
+			u.logger.ErrorContext(ctx, "unable to get Profiles", "error", err)
+			return rpc.ErrorFromRequest(&req, 12346, "Profiles not found")
+		}
+
+		for _, uu := range Profiles {
+			ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
+				Principal: curProfile,
+				Action:    u.config.AuthzAction("GetProfiles"),
+				Resource:  sophrosyne.Profile{ID: uu.ID},
+			})
+			if ok {
+				ent := &sophrosyne.GetProfileResponse{}
+				ProfilesResponse = append(ProfilesResponse, *ent.FromProfile(uu))
+			}
+		}
+		if len(ProfilesResponse) > 0 || cursor.Position == "" || cursor.Position == position {
+			break
 		}
 	}
 
 	u.logger.DebugContext(ctx, "returning Profiles", "total", len(ProfilesResponse), "Profiles", ProfilesResponse)
 	return rpc.ResponseToRequest(&req, sophrosyne.GetProfilesResponse{
 		Profiles: ProfilesResponse,
-		Cursor:   cursor.String(),
+		Cursor:   cursor.Encode(u.config),
 		Total:    len(ProfilesResponse),
 	})
 }
 
 func (u ProfileService) CreateProfile(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.CreateProfileRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -186,13 +228,23 @@ func (u ProfileService) CreateProfile(ctx context.Context, req jsonrpc.Request)
 
 	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curProfile,
-		Action:    sophrosyne.AuthorizationAction("CreateProfile"),
+		Action:    u.config.AuthzAction("CreateProfile"),
 	})
 
 	if !ok {
 		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
 	}
 
+	problems, err := u.validateProfile(ctx, params.Name, params.Checks)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "unable to validate Profile", "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+	if len(problems) > 0 {
+		u.logger.DebugContext(ctx, "refusing to create invalid Profile", "problems", problems)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
+	}
+
 	Profile, err := u.profileService.CreateProfile(ctx, params)
 	if err != nil {
 		u.logger.ErrorContext(ctx, "unable to create Profile", "error", err)
@@ -205,7 +257,7 @@ func (u ProfileService) CreateProfile(ctx context.Context, req jsonrpc.Request)
 
 func (u ProfileService) UpdateProfile(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.UpdateProfileRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -223,7 +275,7 @@ func (u ProfileService) UpdateProfile(ctx context.Context, req jsonrpc.Request)
 
 	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curProfile,
-		Action:    sophrosyne.AuthorizationAction("UpdateProfile"),
+		Action:    u.config.AuthzAction("UpdateProfile"),
 		Resource:  sophrosyne.Profile{ID: ProfileToUpdate.ID},
 	})
 
@@ -243,7 +295,7 @@ func (u ProfileService) UpdateProfile(ctx context.Context, req jsonrpc.Request)
 
 func (u ProfileService) DeleteProfile(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.DeleteProfileRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -261,7 +313,7 @@ func (u ProfileService) DeleteProfile(ctx context.Context, req jsonrpc.Request)
 
 	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curProfile,
-		Action:    sophrosyne.AuthorizationAction("DeleteProfile"),
+		Action:    u.config.AuthzAction("DeleteProfile"),
 		Resource:  sophrosyne.Profile{ID: ProfileToDelete.ID},
 	})
 
@@ -277,3 +329,81 @@ func (u ProfileService) DeleteProfile(ctx context.Context, req jsonrpc.Request)
 
 	return rpc.ResponseToRequest(&req, "ok")
 }
+
+func (u ProfileService) ValidateProfile(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	var params sophrosyne.ValidateProfileRequest
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
+	if err != nil {
+		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
+	}
+
+	curProfile := sophrosyne.ExtractUser(ctx)
+	if curProfile == nil {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
+	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
+		Principal: curProfile,
+		Action:    u.config.AuthzAction("ValidateProfile"),
+	})
+
+	if !ok {
+		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
+	}
+
+	problems, err := u.validateProfile(ctx, params.Name, params.Checks)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "unable to validate Profile", "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
+	return rpc.ResponseToRequest(&req, sophrosyne.ValidateProfileResponse{
+		Valid:    len(problems) == 0,
+		Problems: problems,
+	})
+}
+
+// validateProfile returns the problems that would prevent a profile with the
+// given name and checks from being created. The profile is valid if no
+// problems are returned.
+func (u ProfileService) validateProfile(ctx context.Context, name string, checks []string) ([]string, error) {
+	problems := []string{}
+	if name == "" {
+		problems = append(problems, "name is required")
+	} else {
+		_, err := u.profileService.GetProfileByName(ctx, name)
+		if err == nil {
+			problems = append(problems, fmt.Sprintf("profile %q already exists", name))
+		} else if !errors.Is(err, sophrosyne.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool, len(checks))
+	for _, check := range checks {
+		if seen[check] {
+			problems = append(problems, fmt.Sprintf("check %q is referenced more than once", check))
+			continue
+		}
+		seen[check] = true
+		_, err := u.checkService.GetCheckByName(ctx, check)
+		if errors.Is(err, sophrosyne.ErrNotFound) {
+			problems = append(problems, fmt.Sprintf("check %q does not exist", check))
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return problems, nil
+}
+
+// profileExists reports whether the profile with the given ID exists. It is used to validate
+// the position of cursors.
+func (u ProfileService) profileExists(ctx context.Context, id string) (bool, error) {
+	_, err := u.profileService.GetProfile(ctx, id)
+	if errors.Is(err, sophrosyne.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,313 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
+)
+
+func TestProfileService_ValidateProfile(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1", IsAdmin: true})
+	tests := []struct {
+		name   string
+		params jsonrpc.ParamsObject
+		setup  func(ps *sophrosyne2.MockProfileService, cs *sophrosyne2.MockCheckService)
+		want   string
+	}{
+		{
+			name:   "valid profile",
+			params: jsonrpc.ParamsObject{"name": "new", "checks": []interface{}{"one", "two"}},
+			setup: func(ps *sophrosyne2.MockProfileService, cs *sophrosyne2.MockCheckService) {
+				ps.EXPECT().GetProfileByName(ctx, "new").Return(sophrosyne.Profile{}, sophrosyne.ErrNotFound).Once()
+				cs.EXPECT().GetCheckByName(ctx, "one").Return(sophrosyne.Check{Name: "one"}, nil).Once()
+				cs.EXPECT().GetCheckByName(ctx, "two").Return(sophrosyne.Check{Name: "two"}, nil).Once()
+			},
+			want: `{"jsonrpc":"2.0","result":{"valid":true,"problems":[]},"id":"1"}`,
+		},
+		{
+			name:   "missing name",
+			params: jsonrpc.ParamsObject{"checks": []interface{}{}},
+			setup:  func(ps *sophrosyne2.MockProfileService, cs *sophrosyne2.MockCheckService) {},
+			want:   `{"jsonrpc":"2.0","result":{"valid":false,"problems":["name is required"]},"id":"1"}`,
+		},
+		{
+			name:   "profile already exists",
+			params: jsonrpc.ParamsObject{"name": "default"},
+			setup: func(ps *sophrosyne2.MockProfileService, cs *sophrosyne2.MockCheckService) {
+				ps.EXPECT().GetProfileByName(ctx, "default").Return(sophrosyne.Profile{Name: "default"}, nil).Once()
+			},
+			want: `{"jsonrpc":"2.0","result":{"valid":false,"problems":["profile \"default\" already exists"]},"id":"1"}`,
+		},
+		{
+			name:   "unknown and duplicate checks",
+			params: jsonrpc.ParamsObject{"name": "new", "checks": []interface{}{"one", "missing", "one"}},
+			setup: func(ps *sophrosyne2.MockProfileService, cs *sophrosyne2.MockCheckService) {
+				ps.EXPECT().GetProfileByName(ctx, "new").Return(sophrosyne.Profile{}, sophrosyne.ErrNotFound).Once()
+				cs.EXPECT().GetCheckByName(ctx, "one").Return(sophrosyne.Check{Name: "one"}, nil).Once()
+				cs.EXPECT().GetCheckByName(ctx, "missing").Return(sophrosyne.Check{}, sophrosyne.ErrNotFound).Once()
+			},
+			want: `{"jsonrpc":"2.0","result":{"valid":false,"problems":["check \"missing\" does not exist","check \"one\" is referenced more than once"]},"id":"1"}`,
+		},
+		{
+			name:   "error looking up check",
+			params: jsonrpc.ParamsObject{"name": "new", "checks": []interface{}{"one"}},
+			setup: func(ps *sophrosyne2.MockProfileService, cs *sophrosyne2.MockCheckService) {
+				ps.EXPECT().GetProfileByName(ctx, "new").Return(sophrosyne.Profile{}, sophrosyne.ErrNotFound).Once()
+				cs.EXPECT().GetCheckByName(ctx, "one").Return(sophrosyne.Check{}, assert.AnError).Once()
+			},
+			want: `{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profileService := sophrosyne2.NewMockProfileService(t)
+			checkService := sophrosyne2.NewMockCheckService(t)
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(true).Once()
+			tt.setup(profileService, checkService)
+			u := ProfileService{
+				profileService: profileService,
+				checkService:   checkService,
+				authz:          authz,
+				logger:         slog.Default(),
+			}
+
+			params := tt.params
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Profiles::ValidateProfile",
+				Params: &params,
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestProfileService_RemappedAuthzAction(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+	config := &sophrosyne.Config{}
+	config.Security.AuthzActions = map[string]string{"ValidateProfile": "profiles:validate"}
+
+	authz := sophrosyne2.NewMockAuthorizationProvider(t)
+	authz.EXPECT().IsAuthorized(ctx, mock.MatchedBy(func(req sophrosyne.AuthorizationRequest) bool {
+		return req.Action == sophrosyne.AuthorizationAction("profiles:validate")
+	})).Return(false).Once()
+	u := ProfileService{
+		config: config,
+		authz:  authz,
+		logger: slog.Default(),
+	}
+
+	got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+		ID:     jsonrpc.NewID("1"),
+		Method: "Profiles::ValidateProfile",
+		Params: &jsonrpc.ParamsObject{"name": "new"},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"jsonrpc":"2.0","error":{"code":12345,"message":"unauthorized"},"id":"1"}`, string(got))
+}
+
+func TestProfileService_GetProfiles_StaleCursor(t *testing.T) {
+	const owner, position = "cs3ntbcfv20jrb7hv0f0", "cs3ntbcfv20jrb7hv0fg"
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: owner})
+	config := &sophrosyne.Config{}
+	config.Server.CursorMode = sophrosyne.CursorModePlain
+
+	profileService := sophrosyne2.NewMockProfileService(t)
+	profileService.EXPECT().GetProfile(ctx, position).Return(sophrosyne.Profile{}, sophrosyne.ErrNotFound).Once()
+	u := ProfileService{
+		config:         config,
+		profileService: profileService,
+		logger:         slog.Default(),
+	}
+
+	got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+		ID:     jsonrpc.NewID("1"),
+		Method: "Profiles::GetProfiles",
+		Params: &jsonrpc.ParamsObject{"cursor": sophrosyne.NewDatabaseCursor(owner, position).Encode(config)},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"jsonrpc":"2.0","error":{"code":12347,"message":"invalid cursor"},"id":"1"}`, string(got))
+}
+
+func TestProfileService_GetProfiles_NoAccessibleProfiles(t *testing.T) {
+	const owner = "cs3ntbcfv20jrb7hv0f0"
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: owner})
+	config := &sophrosyne.Config{}
+	config.Server.CursorMode = sophrosyne.CursorModePlain
+
+	pages := [][]sophrosyne.Profile{
+		{{ID: "a", Name: "a"}, {ID: "b", Name: "b"}},
+		{{ID: "c", Name: "c"}, {ID: "d", Name: "d"}},
+		{{ID: "e", Name: "e"}},
+	}
+	tests := []struct {
+		name       string
+		accessible string
+		want       string
+	}{
+		{
+			name: "no accessible profiles",
+			want: `{"jsonrpc":"2.0","result":{"profiles":[],"cursor":"","total":0},"id":"1"}`,
+		},
+		{
+			name:       "accessible profile on later page",
+			accessible: "c",
+			want:       `{"jsonrpc":"2.0","result":{"profiles":[{"name":"c","checks":null,"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}],"cursor":"` + sophrosyne.NewDatabaseCursor(owner, "d").Encode(config) + `","total":1},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := 0
+			profileService := sophrosyne2.NewMockProfileService(t)
+			profileService.EXPECT().GetProfiles(ctx, mock.Anything).RunAndReturn(func(_ context.Context, cursor *sophrosyne.DatabaseCursor) ([]sophrosyne.Profile, error) {
+				profiles := pages[page]
+				page++
+				if page == len(pages) {
+					cursor.Reset()
+				} else {
+					cursor.Advance(profiles[len(profiles)-1].ID)
+				}
+				return profiles, nil
+			})
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			authz.EXPECT().IsAuthorized(ctx, mock.Anything).RunAndReturn(func(_ context.Context, req sophrosyne.AuthorizationRequest) bool {
+				return req.Resource.(sophrosyne.Profile).ID == tt.accessible
+			})
+			u := ProfileService{
+				config:         config,
+				profileService: profileService,
+				authz:          authz,
+				logger:         slog.Default(),
+			}
+
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Profiles::GetProfiles",
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestProfileService_GetProfiles_CursorNotAdvancing(t *testing.T) {
+	const owner = "cs3ntbcfv20jrb7hv0f0"
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: owner})
+	config := &sophrosyne.Config{}
+	config.Server.CursorMode = sophrosyne.CursorModePlain
+
+	profileService := sophrosyne2.NewMockProfileService(t)
+	profileService.EXPECT().GetProfiles(ctx, mock.Anything).RunAndReturn(func(_ context.Context, cursor *sophrosyne.DatabaseCursor) ([]sophrosyne.Profile, error) {
+		cursor.Advance("a")
+		return []sophrosyne.Profile{{ID: "a"}}, nil
+	}).Times(2)
+	authz := sophrosyne2.NewMockAuthorizationProvider(t)
+	authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(false).Times(2)
+	u := ProfileService{
+		config:         config,
+		profileService: profileService,
+		authz:          authz,
+		logger:         slog.Default(),
+	}
+
+	got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+		ID:     jsonrpc.NewID("1"),
+		Method: "Profiles::GetProfiles",
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"jsonrpc":"2.0","result":{"profiles":[],"cursor":"`+sophrosyne.NewDatabaseCursor(owner, "a").Encode(config)+`","total":0},"id":"1"}`, string(got))
+}
+
+func TestProfileService_GetProfiles_MaxPagesScanned(t *testing.T) {
+	const owner = "cs3ntbcfv20jrb7hv0f0"
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: owner})
+	config := &sophrosyne.Config{}
+	config.Server.CursorMode = sophrosyne.CursorModePlain
+
+	// Every page advances the cursor, but none has a profile the user may see.
+	page := 0
+	profileService := sophrosyne2.NewMockProfileService(t)
+	profileService.EXPECT().GetProfiles(ctx, mock.Anything).RunAndReturn(func(_ context.Context, cursor *sophrosyne.DatabaseCursor) ([]sophrosyne.Profile, error) {
+		page++
+		id := fmt.Sprintf("p%d", page)
+		cursor.Advance(id)
+		return []sophrosyne.Profile{{ID: id}}, nil
+	}).Times(maxProfilePagesScanned)
+	authz := sophrosyne2.NewMockAuthorizationProvider(t)
+	authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(false).Times(maxProfilePagesScanned)
+	u := ProfileService{
+		config:         config,
+		profileService: profileService,
+		authz:          authz,
+		logger:         slog.Default(),
+	}
+
+	got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+		ID:     jsonrpc.NewID("1"),
+		Method: "Profiles::GetProfiles",
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"jsonrpc":"2.0","result":{"profiles":[],"cursor":"`+sophrosyne.NewDatabaseCursor(owner, fmt.Sprintf("p%d", maxProfilePagesScanned)).Encode(config)+`","total":0},"id":"1"}`, string(got))
+}
+
+func TestProfileService_GetProfile_ServedStale(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1", IsAdmin: true})
+	for _, stale := range []bool{false, true} {
+		t.Run(fmt.Sprint(stale), func(t *testing.T) {
+			profileService := sophrosyne2.NewMockProfileService(t)
+			profileService.EXPECT().GetProfile(ctx, "2").Return(sophrosyne.Profile{ID: "2", Name: "test", ServedStale: stale}, nil).Once()
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(true).Once()
+			u := ProfileService{
+				config:         &sophrosyne.Config{},
+				profileService: profileService,
+				authz:          authz,
+				logger:         slog.Default(),
+			}
+
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Profiles::GetProfile",
+				Params: &jsonrpc.ParamsObject{"id": "2"},
+			})
+			require.NoError(t, err)
+			var resp struct {
+				Result map[string]any `json:"result"`
+			}
+			require.NoError(t, json.Unmarshal(got, &resp))
+			require.Equal(t, "test", resp.Result["name"])
+			_, ok := resp.Result["servedStale"]
+			require.Equal(t, stale, ok)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+// ProviderHealth is a [sophrosyne.HealthChecker] for the upstream services
+// of all checks. Each upstream service is probed the same way as when
+// creating a check.
+type ProviderHealth struct {
+	config       *sophrosyne.Config
+	checkService sophrosyne.CheckService
+	logger       *slog.Logger
+	prober       probeFunc
+}
+
+func NewProviderHealth(config *sophrosyne.Config, checkService sophrosyne.CheckService, logger *slog.Logger) *ProviderHealth {
+	return &ProviderHealth{
+		config:       config,
+		checkService: checkService,
+		logger:       logger,
+		prober:       probeUpstream,
+	}
+}
+
+// maxConcurrentProbes is the number of upstream services probed at once.
+const maxConcurrentProbes = 16
+
+// Health probes the upstream services concurrently. All probes share one
+// deadline of [sophrosyne.Config.Services.Checks.ProbeTimeout], so the health
+// check takes no longer than that however many upstream services there are.
+func (p *ProviderHealth) Health(ctx context.Context) (bool, []byte) {
+	upstreams, err := p.upstreams(ctx)
+	if err != nil {
+		p.logger.DebugContext(ctx, "healthcheck unable to get checks", "error", err)
+		return false, []byte(`{"providers":{"healthy":false}}`)
+	}
+
+	pctx, cancel := context.WithTimeout(ctx, p.config.Services.Checks.ProbeTimeout)
+	defer cancel()
+	errs := make([]error, len(upstreams))
+	var g errgroup.Group
+	g.SetLimit(maxConcurrentProbes)
+	for i, upstream := range upstreams {
+		g.Go(func() error {
+			errs[i] = p.prober(pctx, upstream)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	healthy := true
+	providers := make(map[string]map[string]bool, len(upstreams))
+	for i, upstream := range upstreams {
+		if errs[i] != nil {
+			p.logger.DebugContext(ctx, "healthcheck provider error", "upstream", upstream.String(), "error", errs[i])
+			healthy = false
+		}
+		providers[upstream.String()] = map[string]bool{"healthy": errs[i] == nil}
+	}
+
+	b, err := json.Marshal(map[string]any{"providers": providers})
+	if err != nil {
+		return false, []byte(`{"providers":{"healthy":false}}`)
+	}
+	return healthy, b
+}
+
+// upstreams returns the distinct upstream services of all checks.
+func (p *ProviderHealth) upstreams(ctx context.Context) ([]url.URL, error) {
+	seen := make(map[string]bool)
+	var upstreams []url.URL
+	cursor := &sophrosyne.DatabaseCursor{}
+	for {
+		checks, err := p.checkService.GetChecks(ctx, cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, check := range checks {
+			for _, upstream := range check.UpstreamServices {
+				if !seen[upstream.String()] {
+					seen[upstream.String()] = true
+					upstreams = append(upstreams, upstream)
+				}
+			}
+		}
+		if cursor.Position == "" {
+			return upstreams, nil
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+)
+
+func TestProviderHealth_Health(t *testing.T) {
+	ctx := context.Background()
+	up, _ := url.Parse("grpc://up:1")
+	down, _ := url.Parse("grpc://down:1")
+
+	config := &sophrosyne.Config{}
+	config.Services.Checks.ProbeTimeout = time.Second
+
+	checkService := sophrosyne2.NewMockCheckService(t)
+	checkService.EXPECT().GetChecks(ctx, mock.Anything).RunAndReturn(func(ctx context.Context, cursor *sophrosyne.DatabaseCursor) ([]sophrosyne.Check, error) {
+		if cursor.Position == "" {
+			cursor.Advance("1")
+			return []sophrosyne.Check{{ID: "1", UpstreamServices: []url.URL{*up}}}, nil
+		}
+		cursor.Reset()
+		return []sophrosyne.Check{{ID: "2", UpstreamServices: []url.URL{*up, *down}}}, nil
+	}).Twice()
+
+	var mu sync.Mutex
+	var probed []string
+	p := NewProviderHealth(config, checkService, slog.Default())
+	p.prober = func(ctx context.Context, upstream url.URL) error {
+		mu.Lock()
+		defer mu.Unlock()
+		probed = append(probed, upstream.String())
+		if upstream.Hostname() == "down" {
+			return errors.New("unreachable")
+		}
+		return nil
+	}
+
+	ok, detail := p.Health(ctx)
+	require.False(t, ok)
+	require.ElementsMatch(t, []string{"grpc://up:1", "grpc://down:1"}, probed)
+	require.JSONEq(t, `{"providers":{"grpc://up:1":{"healthy":true},"grpc://down:1":{"healthy":false}}}`, string(detail))
+}
+
+func TestProviderHealth_HealthSharesDeadline(t *testing.T) {
+	ctx := context.Background()
+	var upstreams []url.URL
+	for _, host := range []string{"a", "b", "c", "d"} {
+		upstreams = append(upstreams, url.URL{Scheme: "grpc", Host: host + ":1"})
+	}
+
+	config := &sophrosyne.Config{}
+	config.Services.Checks.ProbeTimeout = 50 * time.Millisecond
+
+	checkService := sophrosyne2.NewMockCheckService(t)
+	checkService.EXPECT().GetChecks(ctx, mock.Anything).Return([]sophrosyne.Check{{ID: "1", UpstreamServices: upstreams}}, nil).Once()
+
+	p := NewProviderHealth(config, checkService, slog.Default())
+	p.prober = func(ctx context.Context, upstream url.URL) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	start := time.Now()
+	ok, _ := p.Health(ctx)
+	require.False(t, ok)
+	require.Less(t, time.Since(start), 4*config.Services.Checks.ProbeTimeout, "probes must run concurrently")
+}
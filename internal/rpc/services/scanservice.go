@@ -18,35 +18,63 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
-	"strings"
+	"time"
 
 	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 
 	"github.com/madsrc/sophrosyne"
 	"github.com/madsrc/sophrosyne/internal/grpc/checks"
 	"github.com/madsrc/sophrosyne/internal/rpc"
 )
 
+// errVerdictSettled is the cause of cancelling the checks of a scan once its
+// result no longer depends on them.
+var errVerdictSettled = errors.New("result of scan is determined")
+
+// cancelledResult is the result of a check that was cancelled, or never run,
+// as the result of the scan was already determined.
+var cancelledResult = checkResult{Status: false, Detail: "cancelled", Cancelled: true}
+
+// checkFunc calls the check provider of check with content. A nil content
+// leaves it to the implementation what to send.
+type checkFunc func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error)
+
 type ScanService struct {
+	config         *sophrosyne.Config
 	authz          sophrosyne.AuthorizationProvider
 	logger         *slog.Logger
 	validator      sophrosyne.Validator
 	profileService sophrosyne.ProfileService
 	checkService   sophrosyne.CheckService
+	metricService  sophrosyne.MetricService
+	errorRecorder  sophrosyne.ErrorRecorder
+	checker        checkFunc
+	imageDial      dialControlFunc
 }
 
-func NewScanService(authz sophrosyne.AuthorizationProvider, logger *slog.Logger, validator sophrosyne.Validator, profileService sophrosyne.ProfileService, checkService sophrosyne.CheckService) (*ScanService, error) {
+func NewScanService(config *sophrosyne.Config, authz sophrosyne.AuthorizationProvider, logger *slog.Logger, validator sophrosyne.Validator, profileService sophrosyne.ProfileService, checkService sophrosyne.CheckService, metricService sophrosyne.MetricService, errorRecorder sophrosyne.ErrorRecorder) (*ScanService, error) {
 	s := &ScanService{
+		config:         config,
 		authz:          authz,
 		logger:         logger,
 		validator:      validator,
 		profileService: profileService,
 		checkService:   checkService,
+		metricService:  metricService,
+		errorRecorder:  errorRecorder,
+		checker:        doCheck,
+		imageDial:      publicAddressOnly,
 	}
 
 	return s, nil
@@ -57,12 +85,12 @@ func (s ScanService) EntityType() string { return "Service" }
 func (s ScanService) EntityID() string { return "Scans" }
 
 func (s ScanService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
-	m := strings.Split(string(req.Method), "::")
-	if len(m) != 2 {
-		s.logger.ErrorContext(ctx, "unreachable", "error", sophrosyne.NewUnreachableCodeError())
-		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	_, method, err := rpc.SplitMethod(req.Method)
+	if err != nil {
+		s.logger.DebugContext(ctx, "cannot invoke method", "method", req.Method, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.MethodNotFound, string(jsonrpc.MethodNotFoundMessage))
 	}
-	switch m[1] {
+	switch method {
 	case "PerformScan":
 		return s.PerformScan(ctx, req)
 	default:
@@ -71,18 +99,42 @@ func (s ScanService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]b
 	}
 }
 
+// Methods describes the methods handled by InvokeMethod.
+func (s ScanService) Methods() []rpc.Method {
+	return []rpc.Method{
+		{Name: "PerformScan", Params: sophrosyne.PerformScanRequest{}, Result: performScanResponse{}},
+	}
+}
+
 func (p ScanService) PerformScan(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	curUser := sophrosyne.ExtractUser(ctx)
 	if curUser == nil {
 		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
 	}
 
+	p.metricService.RecordScanStarted(ctx)
+	defer p.metricService.RecordScanFinished(ctx)
+
 	var params sophrosyne.PerformScanRequest
-	err := rpc.ParamsIntoAny(&req, &params, p.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, p.validator, p.config)
 	if err != nil {
 		p.logger.ErrorContext(ctx, "error extracting params from request", "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
 	}
+	if params.Profile == "" && p.requireProfile() {
+		p.logger.InfoContext(ctx, "scan without a profile rejected as profiles are required")
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
+	}
+
+	var content *checks.CheckRequest
+	if params.ImageURL != "" {
+		image, err := fetchImage(ctx, p.config, params.ImageURL, p.imageDial)
+		if err != nil {
+			p.logger.InfoContext(ctx, "unable to fetch image", "url", params.ImageURL, "error", err)
+			return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
+		}
+		content = &checks.CheckRequest{Check: &checks.CheckRequest_Image{Image: base64.StdEncoding.EncodeToString(image)}}
+	}
 
 	var profile *sophrosyne.Profile
 	if params.Profile != "" {
@@ -108,47 +160,237 @@ func (p ScanService) PerformScan(ctx context.Context, req jsonrpc.Request) ([]by
 		}
 	}
 
+	// checkCtx is cancelled with errVerdictSettled once the result of the
+	// scan no longer depends on the checks still pending.
+	checkCtx, cancel := ctx, context.CancelCauseFunc(func(error) {})
+	if p.cancelOnVerdict() {
+		checkCtx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+	}
+	aggregation := p.aggregation()
+
+	results := make([]checkResult, len(profile.Checks))
+	errs := make([]error, len(profile.Checks))
+	var g errgroup.Group
+	g.SetLimit(p.maxConcurrency())
+	for i, check := range profile.Checks {
+		g.Go(func() error {
+			if context.Cause(checkCtx) == errVerdictSettled {
+				results[i] = cancelledResult
+				return nil
+			}
+			p.logger.DebugContext(ctx, "running check from profile", "profile", profile.Name, "check", check.Name)
+			res, err := p.runCheck(checkCtx, check, content)
+			if err != nil && context.Cause(checkCtx) == errVerdictSettled {
+				p.logger.DebugContext(ctx, "check cancelled as the result of the scan is determined", "check", check.Name)
+				results[i] = cancelledResult
+				return nil
+			}
+			if err == nil && !check.Shadow && aggregation.Decides(res.Status) {
+				cancel(errVerdictSettled)
+			}
+			if err != nil {
+				var panicErr *sophrosyne.PanicError
+				if !errors.As(err, &panicErr) && !check.Shadow {
+					p.logger.ErrorContext(ctx, "error running check", "check", check.Name, "error", err)
+					return err
+				}
+				res = checkResult{Status: false, Detail: "check failed"}
+			}
+			results[i], errs[i] = res, err
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
 	checkResults := make(map[string]checkResult)
-	var success bool
+	var statuses []bool
 
-	for _, check := range profile.Checks {
-		p.logger.DebugContext(ctx, "running check from profile", "profile", profile.Name, "check", check.Name)
-		res, err := doCheck(ctx, p.logger, check)
-		if err != nil {
-			p.logger.ErrorContext(ctx, "error running check", "check", check.Name, "error", err)
-			return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	for i, check := range profile.Checks {
+		res, err := results[i], errs[i]
+		if !params.IncludeMetadata {
+			res.Metadata = nil
+		}
+		if check.Shadow {
+			// Shadow checks are evaluated against real traffic, but must not
+			// affect the result of the scan.
+			p.logger.InfoContext(ctx, "shadow check result", "profile", profile.Name, "check", check.Name, "status", res.Status, "detail", res.Detail, "error", err)
+			res.Shadow = true
+			checkResults[check.Name] = res
+			continue
 		}
 		checkResults[check.Name] = res
-		if res.Status {
-			success = true
-		} else {
-			success = false
+		if !res.Cancelled {
+			statuses = append(statuses, res.Status)
 		}
 	}
 
-	resp := struct {
-		Result bool                   `json:"result"`
-		Checks map[string]checkResult `json:"checks"`
-	}{
-		Result: success,
-		Checks: checkResults,
+	resp := performScanResponse{
+		Result:    aggregation.Aggregate(statuses),
+		Profile:   profile.Name,
+		ProfileID: profile.ID,
+		Checks:    checkResults,
 	}
 
 	return rpc.ResponseToRequest(&req, resp)
 }
 
+// aggregation returns the strategy used to combine check results into the
+// result of a scan. Profiles do not carry a strategy of their own, so the
+// service-wide default from the configuration is used.
+func (p ScanService) aggregation() sophrosyne.ScanAggregation {
+	if p.config == nil {
+		return sophrosyne.ScanAggregationAllMustPass
+	}
+	return p.config.Services.Scans.DefaultAggregation
+}
+
+// cancelOnVerdict reports whether checks still pending are cancelled once the
+// result of a scan is determined by the checks already completed.
+func (p ScanService) cancelOnVerdict() bool {
+	return p.config != nil && p.config.Services.Scans.CancelOnVerdict
+}
+
+// maxConcurrency returns how many checks of a scan may run at the same time.
+func (p ScanService) maxConcurrency() int {
+	if p.config == nil || p.config.Services.Scans.MaxConcurrency < 1 {
+		return 1
+	}
+	return p.config.Services.Scans.MaxConcurrency
+}
+
+// requireProfile reports whether scans must name their profile rather than
+// relying on a default profile.
+func (p ScanService) requireProfile() bool {
+	return p.config != nil && p.config.Services.Scans.RequireProfile
+}
+
+// forcedResult returns the result forced on check by its override, if any.
+// Overrides are ignored unless services.checks.allowForceResult is enabled.
+func (p ScanService) forcedResult(check sophrosyne.Check) (result bool, forced bool) {
+	if p.config == nil || !p.config.Services.Checks.AllowForceResult {
+		return false, false
+	}
+	return check.ForceResult.Result()
+}
+
+// runCheck runs the check, recovering from any panic raised while doing so. A
+// recovered panic is recorded and returned as a [sophrosyne.PanicError]. The
+// check is not run if its result is forced by an override. A check that times
+// out is reported as failed rather than returning an error.
+func (p ScanService) runCheck(ctx context.Context, check sophrosyne.Check, content *checks.CheckRequest) (res checkResult, err error) {
+	if result, forced := p.forcedResult(check); forced {
+		p.logger.DebugContext(ctx, "result of check forced by override", "check", check.Name, "result", result)
+		return checkResult{Status: result, Detail: "result forced by override", Forced: true}, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			p.metricService.RecordPanic(ctx)
+			err = sophrosyne.NewPanicError(r)
+			p.errorRecorder.RecordError(ctx, "Scans::PerformScan", err)
+			p.logger.ErrorContext(ctx, "panic encountered while running check", "check", check.Name, "error", err)
+		}
+	}()
+	res, err = p.checkWithRetry(ctx, check, content)
+	if err != nil && ctx.Err() == nil && isTimeout(err) {
+		p.logger.WarnContext(ctx, "check timed out", "check", check.Name, "timeout", p.checkTimeout(check), "error", err)
+		return checkResult{Status: false, Detail: "timeout"}, nil
+	}
+	return res, err
+}
+
+// checkTimeout returns how long a single call to the check provider of check
+// may take. Checks without a timeout of their own use
+// services.checks.defaultTimeout. A timeout of 0 means no timeout.
+func (p ScanService) checkTimeout(check sophrosyne.Check) time.Duration {
+	if check.Timeout > 0 || p.config == nil {
+		return check.Timeout
+	}
+	return p.config.Services.Checks.DefaultTimeout
+}
+
+// callChecker makes a single call to the check provider, bounded by the
+// timeout of check.
+func (p ScanService) callChecker(ctx context.Context, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+	if timeout := p.checkTimeout(check); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return p.checker(ctx, p.logger, check, content)
+}
+
+// checkWithRetry calls the check provider, retrying with exponential backoff
+// as long as it fails with a transient error and services.scans.retry allows
+// for another attempt.
+func (p ScanService) checkWithRetry(ctx context.Context, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+	attempts := 1
+	var delay, maxDelay time.Duration
+	if p.config != nil {
+		attempts = p.config.Services.Scans.Retry.MaxAttempts
+		delay = p.config.Services.Scans.Retry.BaseDelay
+		maxDelay = p.config.Services.Scans.Retry.MaxDelay
+	}
+	for attempt := 1; ; attempt++ {
+		res, err := p.callChecker(ctx, check, content)
+		if err == nil || attempt >= attempts || !isRetryable(err) {
+			return res, err
+		}
+		p.logger.WarnContext(ctx, "check failed, retrying", "check", check.Name, "attempt", attempt, "backoff", delay, "error", err)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return res, errors.Join(err, ctx.Err())
+		case <-t.C:
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
+
+// isTimeout reports whether err was caused by a call to a check provider
+// exceeding its deadline.
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded
+}
+
+// isRetryable reports whether err returned by a check provider is likely to
+// be transient.
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+type performScanResponse struct {
+	Result    bool                   `json:"result"`
+	Profile   string                 `json:"profile"`
+	ProfileID string                 `json:"profile_id"`
+	Checks    map[string]checkResult `json:"checks"`
+}
+
 type checkResult struct {
-	Status bool   `json:"status"`
-	Detail string `json:"detail"`
+	Status    bool              `json:"status"`
+	Detail    string            `json:"detail"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Forced    bool              `json:"forced,omitempty"`    // set when the result was forced by an override
+	Shadow    bool              `json:"shadow,omitempty"`    // set when the result did not count towards the scan
+	Cancelled bool              `json:"cancelled,omitempty"` // set when the check was cancelled as the scan result was determined
 }
 
-func doCheck(ctx context.Context, logger *slog.Logger, check sophrosyne.Check) (checkResult, error) {
+func doCheck(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
 	if len(check.UpstreamServices) == 0 {
 		logger.ErrorContext(ctx, "no upstream services for check", "check", check.Name)
 		return checkResult{}, fmt.Errorf("missing upstream services")
 	}
 	var opts []grpc.DialOption
 	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	opts = append(opts, grpc.WithStatsHandler(otelgrpc.NewClientHandler())) // continues the trace of ctx in the check provider
 	conn, err := grpc.NewClient(check.UpstreamServices[0].Host, opts...)
 	if err != nil {
 		logger.ErrorContext(ctx, "error connecting to check", "check", check.Name, "error", err)
@@ -161,13 +403,17 @@ func doCheck(ctx context.Context, logger *slog.Logger, check sophrosyne.Check) (
 		}
 	}()
 	client := checks.NewCheckServiceClient(conn)
-	resp, err := client.Check(ctx, &checks.CheckRequest{Check: &checks.CheckRequest_Text{Text: "something"}})
+	if content == nil {
+		content = &checks.CheckRequest{Check: &checks.CheckRequest_Text{Text: "something"}}
+	}
+	resp, err := client.Check(ctx, content)
 	if err != nil {
 		logger.ErrorContext(ctx, "error calling check", "check", check.Name, "error", err)
 		return checkResult{}, err
 	}
 	return checkResult{
-		Status: resp.Result,
-		Detail: resp.Details,
+		Status:   resp.GetResult(),
+		Detail:   resp.GetDetails(),
+		Metadata: resp.GetMetadata(),
 	}, nil
 }
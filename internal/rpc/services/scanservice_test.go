@@ -0,0 +1,830 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/madsrc/sophrosyne"
+	"github.com/madsrc/sophrosyne/internal/grpc/checks"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
+)
+
+func TestScanService_PerformScan_CheckPanics(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+	profile := sophrosyne.Profile{
+		ID:   "p1",
+		Name: "test",
+		Checks: []sophrosyne.Check{
+			{Name: "good"},
+			{Name: "bad"},
+		},
+	}
+
+	profileService := sophrosyne2.NewMockProfileService(t)
+	profileService.EXPECT().GetProfileByName(ctx, "test").Return(profile, nil).Once()
+	metricService := sophrosyne2.NewMockMetricService(t)
+	metricService.EXPECT().RecordPanic(ctx).Return().Once()
+	metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+	metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+	errorRecorder := sophrosyne2.NewMockErrorRecorder(t)
+	errorRecorder.EXPECT().RecordError(ctx, "Scans::PerformScan", mock.AnythingOfType("*sophrosyne.PanicError")).Return().Once()
+
+	s := ScanService{
+		logger:         slog.Default(),
+		profileService: profileService,
+		metricService:  metricService,
+		errorRecorder:  errorRecorder,
+		checker: func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+			if check.Name == "bad" {
+				panic("boom")
+			}
+			return checkResult{Status: true, Detail: "fine"}, nil
+		},
+	}
+
+	got, err := s.PerformScan(ctx, jsonrpc.Request{
+		ID:     jsonrpc.NewID("1"),
+		Method: "Scans::PerformScan",
+		Params: &jsonrpc.ParamsObject{"profile": "test"},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"jsonrpc":"2.0","result":{"result":false,"profile":"test","profile_id":"p1","checks":{"good":{"status":true,"detail":"fine"},"bad":{"status":false,"detail":"check failed"}}},"id":"1"}`, string(got))
+}
+
+func TestScanService_PerformScan_Profile(t *testing.T) {
+	passing := func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+		return checkResult{Status: true, Detail: "fine"}, nil
+	}
+	explicit := sophrosyne.Profile{ID: "p1", Name: "explicit", Checks: []sophrosyne.Check{{Name: "check"}}}
+	userDefault := sophrosyne.Profile{ID: "p2", Name: "mine", Checks: []sophrosyne.Check{{Name: "check"}}}
+	serviceDefault := sophrosyne.Profile{ID: "p3", Name: "default", Checks: []sophrosyne.Check{{Name: "check"}}}
+
+	cases := []struct {
+		name   string
+		user   *sophrosyne.User
+		params jsonrpc.ParamsObject
+		lookup *sophrosyne.Profile
+		want   string
+	}{
+		{
+			name:   "explicit profile",
+			user:   &sophrosyne.User{ID: "1", DefaultProfile: userDefault},
+			params: jsonrpc.ParamsObject{"profile": "explicit"},
+			lookup: &explicit,
+			want:   `{"jsonrpc":"2.0","result":{"result":true,"profile":"explicit","profile_id":"p1","checks":{"check":{"status":true,"detail":"fine"}}},"id":"1"}`,
+		},
+		{
+			name:   "user default profile",
+			user:   &sophrosyne.User{ID: "1", DefaultProfile: userDefault},
+			params: jsonrpc.ParamsObject{},
+			want:   `{"jsonrpc":"2.0","result":{"result":true,"profile":"mine","profile_id":"p2","checks":{"check":{"status":true,"detail":"fine"}}},"id":"1"}`,
+		},
+		{
+			name:   "service-wide default profile",
+			user:   &sophrosyne.User{ID: "1"},
+			params: jsonrpc.ParamsObject{},
+			lookup: &serviceDefault,
+			want:   `{"jsonrpc":"2.0","result":{"result":true,"profile":"default","profile_id":"p3","checks":{"check":{"status":true,"detail":"fine"}}},"id":"1"}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, tc.user)
+			profileService := sophrosyne2.NewMockProfileService(t)
+			if tc.lookup != nil {
+				profileService.EXPECT().GetProfileByName(ctx, tc.lookup.Name).Return(*tc.lookup, nil).Once()
+			}
+			metricService := sophrosyne2.NewMockMetricService(t)
+			metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+			metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+			s := ScanService{
+				logger:         slog.Default(),
+				profileService: profileService,
+				metricService:  metricService,
+				checker:        passing,
+			}
+
+			got, err := s.PerformScan(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Scans::PerformScan",
+				Params: &tc.params,
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestScanService_PerformScan_RequireProfile(t *testing.T) {
+	passing := func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+		return checkResult{Status: true, Detail: "fine"}, nil
+	}
+	explicit := sophrosyne.Profile{ID: "p1", Name: "explicit", Checks: []sophrosyne.Check{{Name: "check"}}}
+	userDefault := sophrosyne.Profile{ID: "p2", Name: "mine", Checks: []sophrosyne.Check{{Name: "check"}}}
+
+	cases := []struct {
+		name    string
+		require bool
+		params  jsonrpc.ParamsObject
+		lookup  bool
+		want    string
+	}{
+		{
+			name:   "explicit profile when not required",
+			params: jsonrpc.ParamsObject{"profile": "explicit"},
+			lookup: true,
+			want:   `{"jsonrpc":"2.0","result":{"result":true,"profile":"explicit","profile_id":"p1","checks":{"check":{"status":true,"detail":"fine"}}},"id":"1"}`,
+		},
+		{
+			name:   "no profile when not required",
+			params: jsonrpc.ParamsObject{},
+			want:   `{"jsonrpc":"2.0","result":{"result":true,"profile":"mine","profile_id":"p2","checks":{"check":{"status":true,"detail":"fine"}}},"id":"1"}`,
+		},
+		{
+			name:    "explicit profile when required",
+			require: true,
+			params:  jsonrpc.ParamsObject{"profile": "explicit"},
+			lookup:  true,
+			want:    `{"jsonrpc":"2.0","result":{"result":true,"profile":"explicit","profile_id":"p1","checks":{"check":{"status":true,"detail":"fine"}}},"id":"1"}`,
+		},
+		{
+			name:    "no profile when required",
+			require: true,
+			params:  jsonrpc.ParamsObject{},
+			want:    `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid Params"},"id":"1"}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1", DefaultProfile: userDefault})
+			config := &sophrosyne.Config{}
+			config.Services.Scans.RequireProfile = tc.require
+			profileService := sophrosyne2.NewMockProfileService(t)
+			if tc.lookup {
+				profileService.EXPECT().GetProfileByName(ctx, explicit.Name).Return(explicit, nil).Once()
+			}
+			metricService := sophrosyne2.NewMockMetricService(t)
+			metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+			metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+			s := ScanService{
+				config:         config,
+				logger:         slog.Default(),
+				profileService: profileService,
+				metricService:  metricService,
+				checker:        passing,
+			}
+
+			got, err := s.PerformScan(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Scans::PerformScan",
+				Params: &tc.params,
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestScanService_PerformScan_DefaultAggregation(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+	profile := sophrosyne.Profile{ID: "p1", Name: "mixed", Checks: []sophrosyne.Check{{Name: "good"}, {Name: "bad"}}}
+	mixed := func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+		return checkResult{Status: check.Name == "good"}, nil
+	}
+
+	cases := []struct {
+		name        string
+		aggregation sophrosyne.ScanAggregation
+		want        bool
+	}{
+		{name: "all must pass", aggregation: sophrosyne.ScanAggregationAllMustPass, want: false},
+		{name: "any must pass", aggregation: sophrosyne.ScanAggregationAnyMustPass, want: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &sophrosyne.Config{}
+			config.Services.Scans.DefaultAggregation = tc.aggregation
+			profileService := sophrosyne2.NewMockProfileService(t)
+			profileService.EXPECT().GetProfileByName(ctx, "mixed").Return(profile, nil).Once()
+			metricService := sophrosyne2.NewMockMetricService(t)
+			metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+			metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+			s := ScanService{
+				config:         config,
+				logger:         slog.Default(),
+				profileService: profileService,
+				metricService:  metricService,
+				checker:        mixed,
+			}
+
+			got, err := s.PerformScan(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Scans::PerformScan",
+				Params: &jsonrpc.ParamsObject{"profile": "mixed"},
+			})
+			require.NoError(t, err)
+			var resp struct {
+				Result struct {
+					Result bool `json:"result"`
+				} `json:"result"`
+			}
+			require.NoError(t, json.Unmarshal(got, &resp))
+			require.Equal(t, tc.want, resp.Result.Result)
+		})
+	}
+}
+
+func TestScanService_PerformScan_IncludeMetadata(t *testing.T) {
+	profile := sophrosyne.Profile{ID: "p1", Name: "test", Checks: []sophrosyne.Check{{Name: "check"}}}
+	withMetadata := func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+		return checkResult{Status: true, Detail: "fine", Metadata: map[string]string{"score": "0.1"}}, nil
+	}
+
+	cases := []struct {
+		name   string
+		params jsonrpc.ParamsObject
+		want   string
+	}{
+		{
+			name:   "not requested",
+			params: jsonrpc.ParamsObject{"profile": "test"},
+			want:   `{"jsonrpc":"2.0","result":{"result":true,"profile":"test","profile_id":"p1","checks":{"check":{"status":true,"detail":"fine"}}},"id":"1"}`,
+		},
+		{
+			name:   "requested",
+			params: jsonrpc.ParamsObject{"profile": "test", "include_metadata": true},
+			want:   `{"jsonrpc":"2.0","result":{"result":true,"profile":"test","profile_id":"p1","checks":{"check":{"status":true,"detail":"fine","metadata":{"score":"0.1"}}}},"id":"1"}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+			profileService := sophrosyne2.NewMockProfileService(t)
+			profileService.EXPECT().GetProfileByName(ctx, "test").Return(profile, nil).Once()
+			metricService := sophrosyne2.NewMockMetricService(t)
+			metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+			metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+			s := ScanService{
+				logger:         slog.Default(),
+				profileService: profileService,
+				metricService:  metricService,
+				checker:        withMetadata,
+			}
+
+			got, err := s.PerformScan(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Scans::PerformScan",
+				Params: &tc.params,
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tc.want, string(got))
+		})
+	}
+}
+
+type metadataCheckServer struct {
+	checks.UnimplementedCheckServiceServer
+	metadata map[string]string
+}
+
+func (m metadataCheckServer) Check(ctx context.Context, request *checks.CheckRequest) (*checks.CheckResponse, error) {
+	return &checks.CheckResponse{Result: true, Details: "fine", Metadata: m.metadata}, nil
+}
+
+func TestDoCheck_Metadata(t *testing.T) {
+	cases := []struct {
+		name     string
+		metadata map[string]string
+	}{
+		{name: "with metadata", metadata: map[string]string{"model": "v2", "score": "0.1"}},
+		{name: "without metadata"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			srv := grpc.NewServer()
+			checks.RegisterCheckServiceServer(srv, metadataCheckServer{metadata: tc.metadata})
+			go func() { _ = srv.Serve(lis) }()
+			t.Cleanup(srv.Stop)
+
+			got, err := doCheck(context.Background(), slog.Default(), sophrosyne.Check{
+				Name:             "check",
+				UpstreamServices: []url.URL{{Host: lis.Addr().String()}},
+			}, nil)
+			require.NoError(t, err)
+			require.True(t, got.Status)
+			require.Equal(t, "fine", got.Detail)
+			require.Equal(t, len(tc.metadata), len(got.Metadata))
+			for k, v := range tc.metadata {
+				require.Equal(t, v, got.Metadata[k])
+			}
+		})
+	}
+}
+
+func TestScanService_PerformScan_ForceResult(t *testing.T) {
+	upstream := func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+		return checkResult{Status: true, Detail: "from upstream"}, nil
+	}
+
+	cases := []struct {
+		name        string
+		allowed     bool
+		forceResult sophrosyne.ForceResult
+		want        string
+	}{
+		{
+			name:        "none",
+			allowed:     true,
+			forceResult: sophrosyne.ForceResultNone,
+			want:        `{"status":true,"detail":"from upstream"}`,
+		},
+		{
+			name:        "pass",
+			allowed:     true,
+			forceResult: sophrosyne.ForceResultPass,
+			want:        `{"status":true,"detail":"result forced by override","forced":true}`,
+		},
+		{
+			name:        "fail",
+			allowed:     true,
+			forceResult: sophrosyne.ForceResultFail,
+			want:        `{"status":false,"detail":"result forced by override","forced":true}`,
+		},
+		{
+			name:        "fail not allowed",
+			forceResult: sophrosyne.ForceResultFail,
+			want:        `{"status":true,"detail":"from upstream"}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+			config := &sophrosyne.Config{}
+			config.Services.Checks.AllowForceResult = tc.allowed
+			profile := sophrosyne.Profile{ID: "p1", Name: "test", Checks: []sophrosyne.Check{{Name: "check", ForceResult: tc.forceResult}}}
+			profileService := sophrosyne2.NewMockProfileService(t)
+			profileService.EXPECT().GetProfileByName(ctx, "test").Return(profile, nil).Once()
+			metricService := sophrosyne2.NewMockMetricService(t)
+			metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+			metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+			var called bool
+			s := ScanService{
+				config:         config,
+				logger:         slog.Default(),
+				profileService: profileService,
+				metricService:  metricService,
+				checker: func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+					called = true
+					return upstream(ctx, logger, check, content)
+				},
+			}
+
+			got, err := s.PerformScan(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Scans::PerformScan",
+				Params: &jsonrpc.ParamsObject{"profile": "test"},
+			})
+			require.NoError(t, err)
+			var resp struct {
+				Result struct {
+					Checks map[string]json.RawMessage `json:"checks"`
+				} `json:"result"`
+			}
+			require.NoError(t, json.Unmarshal(got, &resp))
+			require.JSONEq(t, tc.want, string(resp.Result.Checks["check"]))
+			_, forced := tc.forceResult.Result()
+			require.Equal(t, !(forced && tc.allowed), called, "upstream is only bypassed when a result is forced")
+		})
+	}
+}
+
+type traceCheckServer struct {
+	checks.UnimplementedCheckServiceServer
+	traceparent chan string
+}
+
+func (s traceCheckServer) Check(ctx context.Context, request *checks.CheckRequest) (*checks.CheckResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.traceparent <- strings.Join(md.Get("traceparent"), ",")
+	return &checks.CheckResponse{Result: true}, nil
+}
+
+func TestDoCheck_PropagatesTrace(t *testing.T) {
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(prev) })
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	traceparent := make(chan string, 1)
+	checks.RegisterCheckServiceServer(srv, traceCheckServer{traceparent: traceparent})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	_, err = doCheck(ctx, slog.Default(), sophrosyne.Check{
+		Name:             "check",
+		UpstreamServices: []url.URL{{Host: lis.Addr().String()}},
+	}, nil)
+	require.NoError(t, err)
+	require.Contains(t, <-traceparent, "-4bf92f3577b34da6a3ce929d0e0e4736-")
+}
+
+// flakyCheckServer fails the first failures calls with code and passes after.
+type flakyCheckServer struct {
+	checks.UnimplementedCheckServiceServer
+	failures int
+	code     codes.Code
+	calls    atomic.Int32
+}
+
+func (f *flakyCheckServer) Check(ctx context.Context, request *checks.CheckRequest) (*checks.CheckResponse, error) {
+	if int(f.calls.Add(1)) <= f.failures {
+		return nil, status.Error(f.code, "flaky")
+	}
+	return &checks.CheckResponse{Result: true, Details: "fine"}, nil
+}
+
+func TestScanService_PerformScan_Retry(t *testing.T) {
+	cases := []struct {
+		name      string
+		failures  int
+		code      codes.Code
+		wantCalls int32
+		wantErr   bool
+	}{
+		{name: "no failures", wantCalls: 1},
+		{name: "unavailable then success", failures: 2, code: codes.Unavailable, wantCalls: 3},
+		{name: "deadline exceeded then success", failures: 1, code: codes.DeadlineExceeded, wantCalls: 2},
+		{name: "attempts exhausted", failures: 3, code: codes.Unavailable, wantCalls: 3, wantErr: true},
+		{name: "not retryable", failures: 1, code: codes.InvalidArgument, wantCalls: 1, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			provider := &flakyCheckServer{failures: tc.failures, code: tc.code}
+			srv := grpc.NewServer()
+			checks.RegisterCheckServiceServer(srv, provider)
+			go func() { _ = srv.Serve(lis) }()
+			t.Cleanup(srv.Stop)
+
+			ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+			config := &sophrosyne.Config{}
+			config.Services.Scans.Retry.MaxAttempts = 3
+			config.Services.Scans.Retry.BaseDelay = time.Millisecond
+			config.Services.Scans.Retry.MaxDelay = 2 * time.Millisecond
+			profile := sophrosyne.Profile{ID: "p1", Name: "test", Checks: []sophrosyne.Check{{
+				Name:             "check",
+				UpstreamServices: []url.URL{{Host: lis.Addr().String()}},
+			}}}
+			profileService := sophrosyne2.NewMockProfileService(t)
+			profileService.EXPECT().GetProfileByName(ctx, "test").Return(profile, nil).Once()
+			metricService := sophrosyne2.NewMockMetricService(t)
+			metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+			metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+			s := ScanService{
+				config:         config,
+				logger:         slog.Default(),
+				profileService: profileService,
+				metricService:  metricService,
+				checker:        doCheck,
+			}
+
+			got, err := s.PerformScan(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Scans::PerformScan",
+				Params: &jsonrpc.ParamsObject{"profile": "test"},
+			})
+			require.NoError(t, err)
+			if tc.wantErr {
+				require.JSONEq(t, `{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"},"id":"1"}`, string(got))
+			} else {
+				require.JSONEq(t, `{"jsonrpc":"2.0","result":{"result":true,"profile":"test","profile_id":"p1","checks":{"check":{"status":true,"detail":"fine"}}},"id":"1"}`, string(got))
+			}
+			require.Equal(t, tc.wantCalls, provider.calls.Load())
+		})
+	}
+}
+
+func TestScanService_PerformScan_Shadow(t *testing.T) {
+	cases := []struct {
+		name   string
+		shadow checkResult
+		err    error
+		want   string
+	}{
+		{
+			name:   "failing shadow check",
+			shadow: checkResult{Status: false, Detail: "flagged"},
+			want:   `{"jsonrpc":"2.0","result":{"result":true,"profile":"test","profile_id":"p1","checks":{"check":{"status":true,"detail":"fine"},"shadow":{"status":false,"detail":"flagged","shadow":true}}},"id":"1"}`,
+		},
+		{
+			name:   "passing shadow check",
+			shadow: checkResult{Status: true, Detail: "fine"},
+			want:   `{"jsonrpc":"2.0","result":{"result":true,"profile":"test","profile_id":"p1","checks":{"check":{"status":true,"detail":"fine"},"shadow":{"status":true,"detail":"fine","shadow":true}}},"id":"1"}`,
+		},
+		{
+			name: "erroring shadow check",
+			err:  assert.AnError,
+			want: `{"jsonrpc":"2.0","result":{"result":true,"profile":"test","profile_id":"p1","checks":{"check":{"status":true,"detail":"fine"},"shadow":{"status":false,"detail":"check failed","shadow":true}}},"id":"1"}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+			profile := sophrosyne.Profile{ID: "p1", Name: "test", Checks: []sophrosyne.Check{
+				{Name: "check"},
+				{Name: "shadow", Shadow: true},
+			}}
+			profileService := sophrosyne2.NewMockProfileService(t)
+			profileService.EXPECT().GetProfileByName(ctx, "test").Return(profile, nil).Once()
+			metricService := sophrosyne2.NewMockMetricService(t)
+			metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+			metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+			var called []string
+			s := ScanService{
+				config:         &sophrosyne.Config{},
+				logger:         slog.Default(),
+				profileService: profileService,
+				metricService:  metricService,
+				checker: func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+					called = append(called, check.Name)
+					if check.Shadow {
+						return tc.shadow, tc.err
+					}
+					return checkResult{Status: true, Detail: "fine"}, nil
+				},
+			}
+
+			got, err := s.PerformScan(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Scans::PerformScan",
+				Params: &jsonrpc.ParamsObject{"profile": "test"},
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tc.want, string(got))
+			require.Equal(t, []string{"check", "shadow"}, called, "shadow checks are still run")
+		})
+	}
+}
+
+func TestScanService_PerformScan_Timeout(t *testing.T) {
+	cases := []struct {
+		name           string
+		checkTimeout   time.Duration
+		defaultTimeout time.Duration
+	}{
+		{name: "check timeout", checkTimeout: 10 * time.Millisecond, defaultTimeout: time.Minute},
+		{name: "default timeout", defaultTimeout: 10 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+			config := &sophrosyne.Config{}
+			config.Services.Checks.DefaultTimeout = tc.defaultTimeout
+			config.Services.Scans.DefaultAggregation = sophrosyne.ScanAggregationAnyMustPass
+			profile := sophrosyne.Profile{ID: "p1", Name: "test", Checks: []sophrosyne.Check{
+				{Name: "slow", Timeout: tc.checkTimeout},
+				{Name: "fast"},
+			}}
+			profileService := sophrosyne2.NewMockProfileService(t)
+			profileService.EXPECT().GetProfileByName(ctx, "test").Return(profile, nil).Once()
+			metricService := sophrosyne2.NewMockMetricService(t)
+			metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+			metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+			s := ScanService{
+				config:         config,
+				logger:         slog.Default(),
+				profileService: profileService,
+				metricService:  metricService,
+				checker: func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+					if check.Name == "slow" {
+						<-ctx.Done()
+						return checkResult{}, status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+					}
+					return checkResult{Status: true, Detail: "fine"}, nil
+				},
+			}
+
+			got, err := s.PerformScan(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Scans::PerformScan",
+				Params: &jsonrpc.ParamsObject{"profile": "test"},
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, `{"jsonrpc":"2.0","result":{"result":true,"profile":"test","profile_id":"p1","checks":{"slow":{"status":false,"detail":"timeout"},"fast":{"status":true,"detail":"fine"}}},"id":"1"}`, string(got))
+		})
+	}
+}
+
+func TestScanService_PerformScan_Concurrency(t *testing.T) {
+	const limit = 3
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+	config := &sophrosyne.Config{}
+	config.Services.Scans.MaxConcurrency = limit
+	var checkList []sophrosyne.Check
+	for i := 0; i < 8; i++ {
+		checkList = append(checkList, sophrosyne.Check{Name: fmt.Sprintf("check%d", i)})
+	}
+	profile := sophrosyne.Profile{ID: "p1", Name: "test", Checks: checkList}
+
+	var inFlight, maxInFlight atomic.Int32
+	checker := func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return checkResult{Status: check.Name != "check5", Detail: check.Name}, nil
+	}
+
+	var outputs []string
+	for run := 0; run < 2; run++ {
+		profileService := sophrosyne2.NewMockProfileService(t)
+		profileService.EXPECT().GetProfileByName(ctx, "test").Return(profile, nil).Once()
+		metricService := sophrosyne2.NewMockMetricService(t)
+		metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+		metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+		s := ScanService{
+			config:         config,
+			logger:         slog.Default(),
+			profileService: profileService,
+			metricService:  metricService,
+			checker:        checker,
+		}
+
+		got, err := s.PerformScan(ctx, jsonrpc.Request{
+			ID:     jsonrpc.NewID("1"),
+			Method: "Scans::PerformScan",
+			Params: &jsonrpc.ParamsObject{"profile": "test"},
+		})
+		require.NoError(t, err)
+		outputs = append(outputs, string(got))
+	}
+
+	require.LessOrEqual(t, maxInFlight.Load(), int32(limit), "concurrency exceeded the limit")
+	require.Greater(t, maxInFlight.Load(), int32(1), "checks did not run concurrently")
+	require.Equal(t, outputs[0], outputs[1], "results are not deterministic")
+	var resp struct {
+		Result struct {
+			Result bool                   `json:"result"`
+			Checks map[string]checkResult `json:"checks"`
+		} `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(outputs[0]), &resp))
+	require.False(t, resp.Result.Result, "a single failing check fails the scan")
+	require.Len(t, resp.Result.Checks, len(checkList))
+	for _, check := range checkList {
+		require.Equal(t, check.Name, resp.Result.Checks[check.Name].Detail)
+	}
+}
+
+func TestScanService_PerformScan_CancelOnVerdict(t *testing.T) {
+	cases := []struct {
+		name            string
+		aggregation     sophrosyne.ScanAggregation
+		fastStatus      bool
+		cancelOnVerdict bool
+		want            string
+	}{
+		{
+			name:            "all must pass, fast check fails",
+			aggregation:     sophrosyne.ScanAggregationAllMustPass,
+			fastStatus:      false,
+			cancelOnVerdict: true,
+			want:            `{"jsonrpc":"2.0","result":{"result":false,"profile":"test","profile_id":"p1","checks":{"slow":{"status":false,"detail":"cancelled","cancelled":true},"fast":{"status":false,"detail":"fast"}}},"id":"1"}`,
+		},
+		{
+			name:            "any must pass, fast check passes",
+			aggregation:     sophrosyne.ScanAggregationAnyMustPass,
+			fastStatus:      true,
+			cancelOnVerdict: true,
+			want:            `{"jsonrpc":"2.0","result":{"result":true,"profile":"test","profile_id":"p1","checks":{"slow":{"status":false,"detail":"cancelled","cancelled":true},"fast":{"status":true,"detail":"fast"}}},"id":"1"}`,
+		},
+		{
+			name:            "all must pass, fast check passes",
+			aggregation:     sophrosyne.ScanAggregationAllMustPass,
+			fastStatus:      true,
+			cancelOnVerdict: true,
+			want:            `{"jsonrpc":"2.0","result":{"result":true,"profile":"test","profile_id":"p1","checks":{"slow":{"status":true,"detail":"slow"},"fast":{"status":true,"detail":"fast"}}},"id":"1"}`,
+		},
+		{
+			name:        "disabled",
+			aggregation: sophrosyne.ScanAggregationAllMustPass,
+			fastStatus:  false,
+			want:        `{"jsonrpc":"2.0","result":{"result":false,"profile":"test","profile_id":"p1","checks":{"slow":{"status":true,"detail":"slow"},"fast":{"status":false,"detail":"fast"}}},"id":"1"}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			const slowDelay = 2 * time.Second
+			ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+			config := &sophrosyne.Config{}
+			config.Services.Scans.DefaultAggregation = tc.aggregation
+			config.Services.Scans.MaxConcurrency = 2
+			config.Services.Scans.CancelOnVerdict = tc.cancelOnVerdict
+			profile := sophrosyne.Profile{ID: "p1", Name: "test", Checks: []sophrosyne.Check{{Name: "slow"}, {Name: "fast"}}}
+			profileService := sophrosyne2.NewMockProfileService(t)
+			profileService.EXPECT().GetProfileByName(ctx, "test").Return(profile, nil).Once()
+			metricService := sophrosyne2.NewMockMetricService(t)
+			metricService.EXPECT().RecordScanStarted(ctx).Return().Once()
+			metricService.EXPECT().RecordScanFinished(ctx).Return().Once()
+
+			var slowCancelled atomic.Bool
+			slowStarted := make(chan struct{})
+			s := ScanService{
+				config:         config,
+				logger:         slog.Default(),
+				profileService: profileService,
+				metricService:  metricService,
+				checker: func(ctx context.Context, logger *slog.Logger, check sophrosyne.Check, content *checks.CheckRequest) (checkResult, error) {
+					if check.Name == "fast" {
+						<-slowStarted
+						return checkResult{Status: tc.fastStatus, Detail: "fast"}, nil
+					}
+					close(slowStarted)
+					select {
+					case <-ctx.Done():
+						slowCancelled.Store(true)
+						return checkResult{}, status.Error(codes.Canceled, ctx.Err().Error())
+					case <-time.After(slowDelay):
+						return checkResult{Status: true, Detail: "slow"}, nil
+					}
+				},
+			}
+
+			start := time.Now()
+			got, err := s.PerformScan(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Scans::PerformScan",
+				Params: &jsonrpc.ParamsObject{"profile": "test"},
+			})
+			elapsed := time.Since(start)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.want, string(got))
+
+			cancelled := strings.Contains(tc.want, `"cancelled":true`)
+			require.Equal(t, cancelled, slowCancelled.Load())
+			if cancelled {
+				require.Less(t, elapsed, slowDelay, "scan waited for the cancelled check")
+			} else {
+				require.GreaterOrEqual(t, elapsed, slowDelay)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
+
+	"github.com/madsrc/sophrosyne"
+	"github.com/madsrc/sophrosyne/internal/rpc"
+)
+
+type SystemService struct {
+	config    *sophrosyne.Config
+	authz     sophrosyne.AuthorizationProvider
+	simulator sophrosyne.AuthorizationSimulator
+	status    sophrosyne.StatusProvider
+	errors    sophrosyne.ErrorRecorder
+	failures  sophrosyne.AuthFailureRecorder // nil unless logging.authFailures is set
+	logger    *slog.Logger
+	validator sophrosyne.Validator
+}
+
+func NewSystemService(config *sophrosyne.Config, authz sophrosyne.AuthorizationProvider, simulator sophrosyne.AuthorizationSimulator, status sophrosyne.StatusProvider, errors sophrosyne.ErrorRecorder, failures sophrosyne.AuthFailureRecorder, logger *slog.Logger, validator sophrosyne.Validator) (*SystemService, error) {
+	s := &SystemService{
+		config:    config,
+		authz:     authz,
+		simulator: simulator,
+		status:    status,
+		errors:    errors,
+		failures:  failures,
+		logger:    logger,
+		validator: validator,
+	}
+
+	return s, nil
+}
+
+func (s SystemService) EntityType() string { return "Service" }
+
+func (s SystemService) EntityID() string { return "System" }
+
+func (s SystemService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	_, method, err := rpc.SplitMethod(req.Method)
+	if err != nil {
+		s.logger.DebugContext(ctx, "cannot invoke method", "method", req.Method, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.MethodNotFound, string(jsonrpc.MethodNotFoundMessage))
+	}
+	switch method {
+	case "SimulateAuthz":
+		return s.SimulateAuthz(ctx, req)
+	case "GetStatus":
+		return s.GetStatus(ctx, req)
+	case "GetRecentErrors":
+		return s.GetRecentErrors(ctx, req)
+	case "GetAuthFailures":
+		return s.GetAuthFailures(ctx, req)
+	default:
+		s.logger.DebugContext(ctx, "cannot invoke method", "method", req.Method)
+		return rpc.ErrorFromRequest(&req, jsonrpc.MethodNotFound, string(jsonrpc.MethodNotFoundMessage))
+	}
+}
+
+// Methods describes the methods handled by InvokeMethod.
+func (s SystemService) Methods() []rpc.Method {
+	return []rpc.Method{
+		{Name: "SimulateAuthz", Params: sophrosyne.SimulateAuthzRequest{}, Result: sophrosyne.SimulateAuthzResponse{}},
+		{Name: "GetStatus", Result: sophrosyne.GetStatusResponse{}},
+		{Name: "GetRecentErrors", Result: sophrosyne.GetRecentErrorsResponse{}},
+		{Name: "GetAuthFailures", Result: sophrosyne.GetAuthFailuresResponse{}},
+	}
+}
+
+func (s SystemService) SimulateAuthz(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	var params sophrosyne.SimulateAuthzRequest
+	err := rpc.ParamsIntoAny(&req, &params, s.validator)
+	if err != nil {
+		s.logger.ErrorContext(ctx, paramExtractError, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
+	}
+
+	curUser := sophrosyne.ExtractUser(ctx)
+	if curUser == nil {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
+	if !s.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
+		Principal: curUser,
+		Action:    s.config.AuthzAction("SimulateAuthz"),
+	}) {
+		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
+	}
+
+	reqs := make([]sophrosyne.AuthorizationRequest, 0, len(params.Requests))
+	for _, r := range params.Requests {
+		reqs = append(reqs, r.AuthorizationRequest())
+	}
+
+	decisions, err := s.simulator.SimulateAuthorization(ctx, []byte(params.Policies), reqs)
+	if err != nil {
+		s.logger.InfoContext(ctx, "unable to simulate authorization", "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, "invalid policies")
+	}
+
+	return rpc.ResponseToRequest(&req, sophrosyne.SimulateAuthzResponse{Decisions: decisions})
+}
+
+// GetStatus returns a snapshot of the server's activity. It is available to
+// every authenticated user, so no authorization is performed.
+func (s SystemService) GetStatus(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	curUser := sophrosyne.ExtractUser(ctx)
+	if curUser == nil {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
+	resp := sophrosyne.GetStatusResponse{}
+
+	return rpc.ResponseToRequest(&req, resp.FromStatus(s.status.Status(), time.Now()))
+}
+
+// GetRecentErrors returns the most recently recorded panics and internal
+// errors, newest first. Stack traces are only included if
+// [sophrosyne.Config.IncludeStackTraces] allows it.
+func (s SystemService) GetRecentErrors(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	curUser := sophrosyne.ExtractUser(ctx)
+	if curUser == nil {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
+	if !s.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
+		Principal: curUser,
+		Action:    s.config.AuthzAction("GetRecentErrors"),
+	}) {
+		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
+	}
+
+	resp := sophrosyne.GetRecentErrorsResponse{}
+
+	return rpc.ResponseToRequest(&req, resp.FromEvents(s.errors.RecentErrors(ctx), s.config.IncludeStackTraces()))
+}
+
+// GetAuthFailures returns the most recently recorded authentication failures,
+// newest first. Nothing is returned unless logging.authFailures is set.
+func (s SystemService) GetAuthFailures(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	curUser := sophrosyne.ExtractUser(ctx)
+	if curUser == nil {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
+	if !s.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
+		Principal: curUser,
+		Action:    s.config.AuthzAction("GetAuthFailures"),
+	}) {
+		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
+	}
+
+	var events []sophrosyne.AuthFailureEvent
+	if s.failures != nil {
+		events = s.failures.RecentAuthFailures(ctx)
+	}
+	resp := sophrosyne.GetAuthFailuresResponse{}
+
+	return rpc.ResponseToRequest(&req, resp.FromEvents(events))
+}
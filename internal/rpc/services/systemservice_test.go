@@ -0,0 +1,247 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
+)
+
+func TestSystemService_SimulateAuthz(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1", IsAdmin: true})
+	params := jsonrpc.ParamsObject{
+		"policies": "permit (principal, action, resource);",
+		"requests": []interface{}{
+			map[string]interface{}{"principal": "2", "action": "GetProfile", "resource_type": "Profile", "resource_id": "3"},
+			map[string]interface{}{"principal": "2", "action": "ValidateProfile"},
+		},
+	}
+	wantReqs := []sophrosyne.AuthorizationRequest{
+		{Principal: sophrosyne.User{ID: "2"}, Action: sophrosyne.AuthorizationAction("GetProfile"), Resource: sophrosyne.Profile{ID: "3"}},
+		{Principal: sophrosyne.User{ID: "2"}, Action: sophrosyne.AuthorizationAction("ValidateProfile")},
+	}
+	tests := []struct {
+		name       string
+		authorized bool
+		setup      func(s *sophrosyne2.MockAuthorizationSimulator)
+		want       string
+	}{
+		{
+			name:       "decisions",
+			authorized: true,
+			setup: func(s *sophrosyne2.MockAuthorizationSimulator) {
+				s.EXPECT().SimulateAuthorization(ctx, []byte("permit (principal, action, resource);"), wantReqs).Return([]sophrosyne.SimulatedAuthzDecision{
+					{Current: true, Candidate: true},
+					{Current: false, Candidate: true, Changed: true},
+				}, nil).Once()
+			},
+			want: `{"jsonrpc":"2.0","result":{"decisions":[{"current":true,"candidate":true,"changed":false},{"current":false,"candidate":true,"changed":true}]},"id":"1"}`,
+		},
+		{
+			name:       "invalid policies",
+			authorized: true,
+			setup: func(s *sophrosyne2.MockAuthorizationSimulator) {
+				s.EXPECT().SimulateAuthorization(ctx, mock.Anything, mock.Anything).Return(nil, assert.AnError).Once()
+			},
+			want: `{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid policies"},"id":"1"}`,
+		},
+		{
+			name:  "unauthorized",
+			setup: func(s *sophrosyne2.MockAuthorizationSimulator) {},
+			want:  `{"jsonrpc":"2.0","error":{"code":12345,"message":"unauthorized"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(tt.authorized).Once()
+			simulator := sophrosyne2.NewMockAuthorizationSimulator(t)
+			tt.setup(simulator)
+			s := SystemService{
+				authz:     authz,
+				simulator: simulator,
+				logger:    slog.Default(),
+			}
+
+			got, err := s.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "System::SimulateAuthz",
+				Params: &params,
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestSystemService_GetStatus(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1"})
+	startedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	status := sophrosyne2.NewMockStatusProvider(t)
+	status.EXPECT().Status().Return(sophrosyne.ServerStatus{
+		StartedAt:     startedAt,
+		ScansTotal:    42,
+		ScansInFlight: 2,
+	}).Once()
+	s := SystemService{
+		status: status,
+		logger: slog.Default(),
+	}
+
+	got, err := s.InvokeMethod(ctx, jsonrpc.Request{
+		ID:     jsonrpc.NewID("1"),
+		Method: "System::GetStatus",
+	})
+	require.NoError(t, err)
+
+	var resp struct {
+		Result sophrosyne.GetStatusResponse `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(got, &resp))
+	require.Equal(t, startedAt.Format(sophrosyne.TimeFormatInResponse), resp.Result.StartedAt)
+	require.GreaterOrEqual(t, resp.Result.UptimeSeconds, int64(3600))
+	require.Equal(t, int64(42), resp.Result.ScansTotal)
+	require.Equal(t, int64(2), resp.Result.ScansInFlight)
+}
+
+func TestSystemService_GetRecentErrors(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1", IsAdmin: true})
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	events := []sophrosyne.ErrorEvent{{Time: at, Method: "Scans::PerformScan", Message: "boom", Stack: "goroutine 1"}}
+	tests := []struct {
+		name       string
+		authorized bool
+		level      sophrosyne.LogLevel
+		want       string
+	}{
+		{
+			name:       "without stack",
+			authorized: true,
+			level:      sophrosyne.LogLevelInfo,
+			want:       `{"jsonrpc":"2.0","result":{"errors":[{"time":"` + at.Format(sophrosyne.TimeFormatInResponse) + `","method":"Scans::PerformScan","message":"boom"}]},"id":"1"}`,
+		},
+		{
+			name:       "with stack in debug",
+			authorized: true,
+			level:      sophrosyne.LogLevelDebug,
+			want:       `{"jsonrpc":"2.0","result":{"errors":[{"time":"` + at.Format(sophrosyne.TimeFormatInResponse) + `","method":"Scans::PerformScan","message":"boom","stack":"goroutine 1"}]},"id":"1"}`,
+		},
+		{
+			name: "unauthorized",
+			want: `{"jsonrpc":"2.0","error":{"code":12345,"message":"unauthorized"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &sophrosyne.Config{}
+			config.Logging.Level = tt.level
+			config.Logging.StackTraces = true
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			authz.EXPECT().IsAuthorized(ctx, mock.MatchedBy(func(req sophrosyne.AuthorizationRequest) bool {
+				return req.Action == sophrosyne.AuthorizationAction("GetRecentErrors")
+			})).Return(tt.authorized).Once()
+			recorder := sophrosyne2.NewMockErrorRecorder(t)
+			if tt.authorized {
+				recorder.EXPECT().RecentErrors(ctx).Return(events).Once()
+			}
+			s := SystemService{
+				config: config,
+				authz:  authz,
+				errors: recorder,
+				logger: slog.Default(),
+			}
+
+			got, err := s.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "System::GetRecentErrors",
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestSystemService_GetAuthFailures(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1", IsAdmin: true})
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	events := []sophrosyne.AuthFailureEvent{
+		{Time: at, Source: "192.0.2.1", Reason: sophrosyne.AuthFailureInvalidToken, TokenHash: "0123456789abcdef"},
+		{Time: at, Source: "192.0.2.2", Reason: sophrosyne.AuthFailureMissingToken},
+	}
+	tests := []struct {
+		name       string
+		authorized bool
+		disabled   bool
+		want       string
+	}{
+		{
+			name:       "authorized",
+			authorized: true,
+			want:       `{"jsonrpc":"2.0","result":{"failures":[{"time":"` + at.Format(sophrosyne.TimeFormatInResponse) + `","source":"192.0.2.1","reason":"invalid token","token_hash":"0123456789abcdef"},{"time":"` + at.Format(sophrosyne.TimeFormatInResponse) + `","source":"192.0.2.2","reason":"missing token"}]},"id":"1"}`,
+		},
+		{
+			name:       "recording disabled",
+			authorized: true,
+			disabled:   true,
+			want:       `{"jsonrpc":"2.0","result":{"failures":[]},"id":"1"}`,
+		},
+		{
+			name: "unauthorized",
+			want: `{"jsonrpc":"2.0","error":{"code":12345,"message":"unauthorized"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			authz.EXPECT().IsAuthorized(ctx, mock.MatchedBy(func(req sophrosyne.AuthorizationRequest) bool {
+				return req.Action == sophrosyne.AuthorizationAction("GetAuthFailures")
+			})).Return(tt.authorized).Once()
+			s := SystemService{
+				config: &sophrosyne.Config{},
+				authz:  authz,
+				logger: slog.Default(),
+			}
+			if !tt.disabled {
+				recorder := sophrosyne2.NewMockAuthFailureRecorder(t)
+				if tt.authorized {
+					recorder.EXPECT().RecentAuthFailures(ctx).Return(events).Once()
+				}
+				s.failures = recorder
+			}
+
+			got, err := s.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "System::GetAuthFailures",
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
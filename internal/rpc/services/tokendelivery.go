@@ -0,0 +1,124 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+// deliverToken hands the token of the named user to the channel configured in
+// services.users.tokenDelivery. It returns the reference to put in the
+// response instead of the token, or an empty reference if the token should be
+// returned as is.
+//
+// It is called through [sophrosyne.DeliverToken] by the user service, before
+// the token is stored, see withTokenDelivery.
+func (u UserService) deliverToken(ctx context.Context, name string, token []byte) (string, error) {
+	switch u.config.Services.Users.TokenDelivery {
+	case sophrosyne.TokenDeliveryFile:
+		ref, err := newTokenReference()
+		if err != nil {
+			return "", err
+		}
+		return ref, writeTokenFile(filepath.Join(u.config.Services.Users.TokenDirectory, ref), token)
+	case sophrosyne.TokenDeliveryWebhook:
+		ref, err := newTokenReference()
+		if err != nil {
+			return "", err
+		}
+		client := &http.Client{Timeout: u.config.Services.Users.TokenWebhookTimeout}
+		return ref, postToken(ctx, client, u.config.Services.Users.TokenWebhook, ref, name, token)
+	default:
+		return "", nil
+	}
+}
+
+func newTokenReference() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeTokenFile(path string, token []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(base64.StdEncoding.EncodeToString(token))
+	return errors.Join(err, f.Close())
+}
+
+type tokenWebhookPayload struct {
+	Reference string `json:"reference"`
+	Name      string `json:"name"`
+	Token     []byte `json:"token"`
+}
+
+func postToken(ctx context.Context, client *http.Client, url, ref, name string, token []byte) error {
+	body, err := json.Marshal(tokenWebhookPayload{Reference: ref, Name: name, Token: token})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("token webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// errTokenDelivery is wrapped around errors returned by deliverToken, to tell
+// them apart from errors storing the token.
+var errTokenDelivery = errors.New("unable to deliver token")
+
+// withTokenDelivery returns a context that has the user service deliver new
+// tokens with deliverToken before storing them. The reference returned by
+// deliverToken is stored in ref.
+func (u UserService) withTokenDelivery(ctx context.Context, ref *string) context.Context {
+	deliver := sophrosyne.TokenDeliverer(func(name string, token []byte) error {
+		r, err := u.deliverToken(ctx, name, token)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errTokenDelivery, err)
+		}
+		*ref = r
+		return nil
+	})
+	return context.WithValue(ctx, sophrosyne.TokenDelivererContextKey{}, deliver)
+}
@@ -19,8 +19,9 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
-	"strings"
+	"net/mail"
 
 	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
 
@@ -29,14 +30,16 @@ import (
 )
 
 type UserService struct {
+	config      *sophrosyne.Config
 	userService sophrosyne.UserService
 	authz       sophrosyne.AuthorizationProvider
 	logger      *slog.Logger
 	validator   sophrosyne.Validator
 }
 
-func NewUserService(userService sophrosyne.UserService, authz sophrosyne.AuthorizationProvider, logger *slog.Logger, validator sophrosyne.Validator) (*UserService, error) {
+func NewUserService(config *sophrosyne.Config, userService sophrosyne.UserService, authz sophrosyne.AuthorizationProvider, logger *slog.Logger, validator sophrosyne.Validator) (*UserService, error) {
 	u := &UserService{
+		config:      config,
 		userService: userService,
 		authz:       authz,
 		logger:      logger,
@@ -55,12 +58,12 @@ func (u UserService) EntityID() string {
 }
 
 func (u UserService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
-	m := strings.Split(string(req.Method), "::")
-	if len(m) != 2 {
-		u.logger.ErrorContext(ctx, "unreachable", "error", sophrosyne.NewUnreachableCodeError())
-		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	_, method, err := rpc.SplitMethod(req.Method)
+	if err != nil {
+		u.logger.DebugContext(ctx, "cannot invoke method", "method", req.Method, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.MethodNotFound, string(jsonrpc.MethodNotFoundMessage))
 	}
-	switch m[1] {
+	switch method {
 	case "GetUser":
 		return u.GetUser(ctx, req)
 	case "GetUsers":
@@ -71,6 +74,8 @@ func (u UserService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]b
 		return u.UpdateUser(ctx, req)
 	case "DeleteUser":
 		return u.DeleteUser(ctx, req)
+	case "DeleteUsers":
+		return u.DeleteUsers(ctx, req)
 	case "RotateToken":
 		return u.RotateToken(ctx, req)
 	default:
@@ -79,11 +84,46 @@ func (u UserService) InvokeMethod(ctx context.Context, req jsonrpc.Request) ([]b
 	}
 }
 
+// Methods describes the methods handled by InvokeMethod.
+func (u UserService) Methods() []rpc.Method {
+	return []rpc.Method{
+		{Name: "GetUser", Params: sophrosyne.GetUserRequest{}, Result: sophrosyne.GetUserResponse{}},
+		{Name: "GetUsers", Params: sophrosyne.GetUsersRequest{}, Result: sophrosyne.GetUsersResponse{}},
+		{Name: "CreateUser", Params: sophrosyne.CreateUserRequest{}, Result: sophrosyne.CreateUserResponse{}},
+		{Name: "UpdateUser", Params: sophrosyne.UpdateUserRequest{}, Result: sophrosyne.UpdateUserResponse{}},
+		{Name: "DeleteUser", Params: sophrosyne.DeleteUserRequest{}, Result: ""},
+		{Name: "DeleteUsers", Params: sophrosyne.DeleteUsersRequest{}, Result: sophrosyne.DeleteUsersResponse{}},
+		{Name: "RotateToken", Params: sophrosyne.RotateTokenRequest{}, Result: sophrosyne.RotateTokenResponse{}},
+	}
+}
+
 const userNotFoundError = "user not found"
 
+var errInvalidEmail = errors.New("a valid email address is required")
+
+// checkEmail rejects empty or malformed email addresses if
+// [sophrosyne.Config.Services.Users.RequireEmail] is enabled.
+func (u UserService) checkEmail(email string) error {
+	if !u.config.Services.Users.RequireEmail {
+		return nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errInvalidEmail
+	}
+	return nil
+}
+
+const emailInUseError = "email already in use"
+
+// tokenDeliveryError is returned when a new token could not be delivered
+// out-of-band. The token is not stored, so the user is not created, or keeps
+// its previous token.
+const tokenDeliveryError = "unable to deliver token"
+
 func (u UserService) GetUser(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.GetUserRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -105,7 +145,7 @@ func (u UserService) GetUser(ctx context.Context, req jsonrpc.Request) ([]byte,
 
 	if !u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curUser,
-		Action:    sophrosyne.AuthorizationAction("GetUser"),
+		Action:    u.config.AuthzAction("GetUser"),
 		Resource:  sophrosyne.User{ID: params.ID},
 	}) {
 		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
@@ -124,7 +164,7 @@ func (u UserService) GetUser(ctx context.Context, req jsonrpc.Request) ([]byte,
 
 func (u UserService) GetUsers(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.GetUsersRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		if errors.Is(err, rpc.ErrNoParams) {
 			params = sophrosyne.GetUsersRequest{}
@@ -141,7 +181,7 @@ func (u UserService) GetUsers(ctx context.Context, req jsonrpc.Request) ([]byte,
 
 	var cursor *sophrosyne.DatabaseCursor
 	if params.Cursor != "" {
-		cursor, err = sophrosyne.DecodeDatabaseCursorWithOwner(params.Cursor, curUser.ID)
+		cursor, err = sophrosyne.DecodeDatabaseCursorWithOwner(params.Cursor, curUser.ID, u.config)
 		if err != nil {
 			u.logger.ErrorContext(ctx, "unable to decode cursor", "error", err)
 			return rpc.ErrorFromRequest(&req, 12347, "invalid cursor")
@@ -150,6 +190,16 @@ func (u UserService) GetUsers(ctx context.Context, req jsonrpc.Request) ([]byte,
 		cursor = sophrosyne.NewDatabaseCursor(curUser.ID, "")
 	}
 
+	err = cursor.ValidatePosition(ctx, u.userExists)
+	if errors.Is(err, sophrosyne.ErrInvalidCursor) {
+		u.logger.InfoContext(ctx, "cursor refers to a user that no longer exists", "cursor", cursor)
+		return rpc.ErrorFromRequest(&req, 12347, "invalid cursor")
+	} else if err != nil {
+		u.logger.ErrorContext(ctx, "unable to validate cursor", "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+	cursor.Order = params.Order
+
 	users, err := u.userService.GetUsers(ctx, cursor)
 	if err != nil {
 		u.logger.ErrorContext(ctx, "unable to get users", "error", err)
@@ -160,7 +210,7 @@ func (u UserService) GetUsers(ctx context.Context, req jsonrpc.Request) ([]byte,
 	for _, uu := range users {
 		ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 			Principal: curUser,
-			Action:    sophrosyne.AuthorizationAction("GetUsers"),
+			Action:    u.config.AuthzAction("GetUsers"),
 			Resource:  sophrosyne.User{ID: uu.ID},
 		})
 		if ok {
@@ -172,14 +222,19 @@ func (u UserService) GetUsers(ctx context.Context, req jsonrpc.Request) ([]byte,
 	u.logger.DebugContext(ctx, "returning users", "total", len(usersResponse), "users", usersResponse)
 	return rpc.ResponseToRequest(&req, sophrosyne.GetUsersResponse{
 		Users:  usersResponse,
-		Cursor: cursor.String(),
+		Cursor: cursor.Encode(u.config),
 		Total:  len(usersResponse),
 	})
 }
 
 func (u UserService) CreateUser(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.CreateUserRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
+	if err != nil {
+		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
+	}
+	err = u.checkEmail(params.Email)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -192,26 +247,42 @@ func (u UserService) CreateUser(ctx context.Context, req jsonrpc.Request) ([]byt
 
 	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curUser,
-		Action:    sophrosyne.AuthorizationAction("CreateUser"),
+		Action:    u.config.AuthzAction("CreateUser"),
 	})
 
 	if !ok {
 		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
 	}
 
-	user, err := u.userService.CreateUser(ctx, params)
-	if err != nil {
+	var ref string
+	user, err := u.userService.CreateUser(u.withTokenDelivery(ctx, &ref), params)
+	if errors.Is(err, sophrosyne.ErrEmailInUse) {
+		return rpc.ErrorFromRequest(&req, 12348, emailInUseError)
+	} else if errors.Is(err, errTokenDelivery) {
+		u.logger.ErrorContext(ctx, "unable to deliver token", "name", params.Name, "error", err)
+		return rpc.ErrorFromRequest(&req, 12346, tokenDeliveryError)
+	} else if err != nil {
 		u.logger.ErrorContext(ctx, "unable to create user", "error", err)
 		return rpc.ErrorFromRequest(&req, 12346, "unable to create user")
 	}
 
 	resp := sophrosyne.CreateUserResponse{}
-	return rpc.ResponseToRequest(&req, resp.FromUser(user))
+	resp.FromUser(user)
+	if ref != "" {
+		resp.Token = nil
+		resp.TokenRef = ref
+	}
+	return rpc.ResponseToRequest(&req, &resp)
 }
 
 func (u UserService) UpdateUser(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.UpdateUserRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
+	if err != nil {
+		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
+	}
+	err = u.checkEmail(params.Email)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -229,7 +300,7 @@ func (u UserService) UpdateUser(ctx context.Context, req jsonrpc.Request) ([]byt
 
 	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curUser,
-		Action:    sophrosyne.AuthorizationAction("UpdateUser"),
+		Action:    u.config.AuthzAction("UpdateUser"),
 		Resource:  sophrosyne.User{ID: userToUpdate.ID},
 	})
 
@@ -238,7 +309,9 @@ func (u UserService) UpdateUser(ctx context.Context, req jsonrpc.Request) ([]byt
 	}
 
 	user, err := u.userService.UpdateUser(ctx, params)
-	if err != nil {
+	if errors.Is(err, sophrosyne.ErrEmailInUse) {
+		return rpc.ErrorFromRequest(&req, 12348, emailInUseError)
+	} else if err != nil {
 		u.logger.ErrorContext(ctx, "unable to update user", "error", err)
 		return rpc.ErrorFromRequest(&req, 12346, "unable to update user")
 	}
@@ -249,7 +322,7 @@ func (u UserService) UpdateUser(ctx context.Context, req jsonrpc.Request) ([]byt
 
 func (u UserService) DeleteUser(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.DeleteUserRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -267,7 +340,7 @@ func (u UserService) DeleteUser(ctx context.Context, req jsonrpc.Request) ([]byt
 
 	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curUser,
-		Action:    sophrosyne.AuthorizationAction("DeleteUser"),
+		Action:    u.config.AuthzAction("DeleteUser"),
 		Resource:  sophrosyne.User{ID: userToDelete.ID},
 	})
 
@@ -284,9 +357,82 @@ func (u UserService) DeleteUser(ctx context.Context, req jsonrpc.Request) ([]byt
 	return rpc.ResponseToRequest(&req, "ok")
 }
 
+// DeleteUsers soft-deletes every named user that the caller is authorized to
+// delete. Each name is handled on its own, so a failure is reported in that
+// name's result without affecting the rest.
+func (u UserService) DeleteUsers(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
+	var params sophrosyne.DeleteUsersRequest
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
+	if err == nil && (len(params.Names) == 0 || len(params.Names) > u.config.Services.Users.MaxBatchSize) {
+		err = fmt.Errorf("between 1 and %d names are required", u.config.Services.Users.MaxBatchSize)
+	}
+	if err != nil {
+		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
+		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
+	}
+
+	curUser := sophrosyne.ExtractUser(ctx)
+	if curUser == nil {
+		return rpc.ErrorFromRequest(&req, jsonrpc.InternalError, string(jsonrpc.InternalErrorMessage))
+	}
+
+	resp := sophrosyne.DeleteUsersResponse{Results: make([]sophrosyne.DeleteUsersResult, 0, len(params.Names))}
+	for _, name := range params.Names {
+		result := sophrosyne.DeleteUsersResult{Name: name}
+		err = u.deleteUser(ctx, curUser, name)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Deleted = true
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	return rpc.ResponseToRequest(&req, resp)
+}
+
+var (
+	errUserNotFound       = errors.New(userNotFoundError)
+	errUnauthorized       = errors.New("unauthorized")
+	errUnableToDeleteUser = errors.New("unable to delete user")
+)
+
+// deleteUser authorizes and soft-deletes a single user on behalf of
+// DeleteUsers. Users that are missing or already deleted are reported as not
+// found, but only to callers authorized to delete a user without an ID, so
+// others cannot learn which users exist.
+func (u UserService) deleteUser(ctx context.Context, curUser *sophrosyne.User, name string) error {
+	userToDelete, lookupErr := u.userService.GetUserByName(ctx, name)
+	if lookupErr != nil && !errors.Is(lookupErr, sophrosyne.ErrNotFound) {
+		u.logger.ErrorContext(ctx, "unable to get user", "name", name, "error", lookupErr)
+		return errUnableToDeleteUser
+	}
+
+	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
+		Principal: curUser,
+		Action:    u.config.AuthzAction("DeleteUser"),
+		Resource:  sophrosyne.User{ID: userToDelete.ID},
+	})
+	if !ok {
+		return errUnauthorized
+	}
+	if lookupErr != nil {
+		return errUserNotFound
+	}
+
+	err := u.userService.DeleteUser(ctx, userToDelete.Name)
+	if errors.Is(err, sophrosyne.ErrNotFound) {
+		return errUserNotFound
+	} else if err != nil {
+		u.logger.ErrorContext(ctx, "unable to delete user", "name", name, "error", err)
+		return errUnableToDeleteUser
+	}
+	return nil
+}
+
 func (u UserService) RotateToken(ctx context.Context, req jsonrpc.Request) ([]byte, error) {
 	var params sophrosyne.RotateTokenRequest
-	err := rpc.ParamsIntoAny(&req, &params, u.validator)
+	err := rpc.ParamsIntoAnyNormalized(&req, &params, u.validator, u.config)
 	if err != nil {
 		u.logger.ErrorContext(ctx, paramExtractError, "error", err)
 		return rpc.ErrorFromRequest(&req, jsonrpc.InvalidParams, string(jsonrpc.InvalidParamsMessage))
@@ -304,7 +450,7 @@ func (u UserService) RotateToken(ctx context.Context, req jsonrpc.Request) ([]by
 
 	ok := u.authz.IsAuthorized(ctx, sophrosyne.AuthorizationRequest{
 		Principal: curUser,
-		Action:    sophrosyne.AuthorizationAction("RotateToken"),
+		Action:    u.config.AuthzAction("RotateToken"),
 		Resource:  sophrosyne.User{ID: userToRotate.ID},
 	})
 
@@ -312,12 +458,31 @@ func (u UserService) RotateToken(ctx context.Context, req jsonrpc.Request) ([]by
 		return rpc.ErrorFromRequest(&req, 12345, "unauthorized")
 	}
 
-	token, err := u.userService.RotateToken(ctx, userToRotate.Name)
-	if err != nil {
+	var ref string
+	token, err := u.userService.RotateToken(u.withTokenDelivery(ctx, &ref), userToRotate.Name)
+	if errors.Is(err, errTokenDelivery) {
+		u.logger.ErrorContext(ctx, "unable to deliver token", "name", userToRotate.Name, "error", err)
+		return rpc.ErrorFromRequest(&req, 12346, tokenDeliveryError)
+	} else if err != nil {
 		u.logger.ErrorContext(ctx, "unable to rotate token", "error", err)
 		return rpc.ErrorFromRequest(&req, 12346, "unable to rotate token")
 	}
 
 	resp := &sophrosyne.RotateTokenResponse{}
-	return rpc.ResponseToRequest(&req, resp.FromUser(sophrosyne.User{Token: token}))
+	resp.FromUser(sophrosyne.User{Token: token})
+	if ref != "" {
+		resp.Token = nil
+		resp.TokenRef = ref
+	}
+	return rpc.ResponseToRequest(&req, resp)
+}
+
+// userExists reports whether the user with the given ID exists. It is used to validate
+// the position of cursors.
+func (u UserService) userExists(ctx context.Context, id string) (bool, error) {
+	_, err := u.userService.GetUser(ctx, id)
+	if errors.Is(err, sophrosyne.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
 }
@@ -21,20 +21,31 @@ package services
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/madsrc/sophrosyne"
+	sophrosyne2 "github.com/madsrc/sophrosyne/internal/mocks"
+	"github.com/madsrc/sophrosyne/internal/rpc"
 	"github.com/madsrc/sophrosyne/internal/rpc/jsonrpc"
 )
 
 func TestNewUserService(t *testing.T) {
 	type args struct {
+		config      *sophrosyne.Config
 		userService sophrosyne.UserService
 		authz       sophrosyne.AuthorizationProvider
 		logger      *slog.Logger
@@ -53,29 +64,34 @@ func TestNewUserService(t *testing.T) {
 				nil,
 				nil,
 				nil,
+				nil,
 			},
 			&UserService{
 				nil,
 				nil,
 				nil,
 				nil,
+				nil,
 			},
 			assert.NoError,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := NewUserService(tt.args.userService, tt.args.authz, tt.args.logger, tt.args.validator)
-			if !tt.wantErr(t, err, fmt.Sprintf("NewUserService(%v, %v, %v, %v)", tt.args.userService, tt.args.authz, tt.args.logger, tt.args.validator)) {
+			got, err := NewUserService(tt.args.config, tt.args.userService, tt.args.authz, tt.args.logger, tt.args.validator)
+			if !tt.wantErr(t, err, fmt.Sprintf("NewUserService(%v, %v, %v, %v, %v)", tt.args.config, tt.args.userService, tt.args.authz, tt.args.logger, tt.args.validator)) {
 				return
 			}
-			assert.Equalf(t, tt.want, got, "NewUserService(%v, %v, %v, %v)", tt.args.userService, tt.args.authz, tt.args.logger, tt.args.validator)
+			assert.Equalf(t, tt.want, got, "NewUserService(%v, %v, %v, %v, %v)", tt.args.config, tt.args.userService, tt.args.authz, tt.args.logger, tt.args.validator)
 		})
 	}
 }
 
 func TestUserService_CreateUser(t *testing.T) {
+	requireEmail := &sophrosyne.Config{}
+	requireEmail.Services.Users.RequireEmail = true
 	type fields struct {
+		config      *sophrosyne.Config
 		userService sophrosyne.UserService
 		authz       sophrosyne.AuthorizationProvider
 		logger      *slog.Logger
@@ -92,11 +108,31 @@ func TestUserService_CreateUser(t *testing.T) {
 		want    []byte
 		wantErr assert.ErrorAssertionFunc
 	}{
-		// TODO: Add test cases.
+		{
+			name: "invalid email when email is required",
+			fields: fields{
+				config: requireEmail,
+				logger: slog.Default(),
+			},
+			args: args{
+				ctx: context.Background(),
+				req: jsonrpc.Request{
+					ID:     jsonrpc.NewID("1"),
+					Method: "Users::CreateUser",
+					Params: &jsonrpc.ParamsObject{
+						"name":  "test",
+						"email": "not an email",
+					},
+				},
+			},
+			want:    []byte(`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid Params"},"id":"1"}`),
+			wantErr: assert.NoError,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			u := UserService{
+				config:      tt.fields.config,
 				userService: tt.fields.userService,
 				authz:       tt.fields.authz,
 				logger:      tt.fields.logger,
@@ -274,10 +310,10 @@ func TestUserService_InvokeMethod(t *testing.T) {
 					Method: "test",
 				},
 			},
-			want:    []byte(`{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"},"id":""}`),
+			want:    []byte(`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":""}`),
 			wantErr: assert.NoError,
 			assertLogs: []string{
-				`{"error":{"stack":"stacktrace"}, "level":"ERROR", "msg":"unreachable"}`,
+				`{"level":"DEBUG", "method":"test", "msg":"cannot invoke method", "error":"method must be of the form Service::Method"}`,
 			},
 		},
 		{
@@ -486,3 +522,460 @@ func TestUserService_UpdateUser(t *testing.T) {
 		})
 	}
 }
+
+func TestUserService_checkEmail(t *testing.T) {
+	tests := []struct {
+		name         string
+		requireEmail bool
+		email        string
+		wantErr      error
+	}{
+		{
+			name:         "required with valid email",
+			requireEmail: true,
+			email:        "user@example.com",
+		},
+		{
+			name:         "required with empty email",
+			requireEmail: true,
+			email:        "",
+			wantErr:      errInvalidEmail,
+		},
+		{
+			name:         "required with invalid email",
+			requireEmail: true,
+			email:        "not an email",
+			wantErr:      errInvalidEmail,
+		},
+		{
+			name:         "required with display name",
+			requireEmail: true,
+			email:        "User <user@example.com>",
+			wantErr:      errInvalidEmail,
+		},
+		{
+			name:  "optional with valid email",
+			email: "user@example.com",
+		},
+		{
+			name:  "optional with empty email",
+			email: "",
+		},
+		{
+			name:  "optional with invalid email",
+			email: "not an email",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &sophrosyne.Config{}
+			config.Services.Users.RequireEmail = tt.requireEmail
+			u := UserService{config: config}
+			err := u.checkEmail(tt.email)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestUserService_GetUsers_StaleCursor(t *testing.T) {
+	const owner, position = "cs3ntbcfv20jrb7hv0f0", "cs3ntbcfv20jrb7hv0fg"
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: owner})
+	config := &sophrosyne.Config{}
+	config.Server.CursorMode = sophrosyne.CursorModePlain
+	cursor := sophrosyne.NewDatabaseCursor(owner, position).Encode(config)
+
+	tests := []struct {
+		name  string
+		found error
+		want  string
+	}{
+		{
+			name:  "deleted position",
+			found: sophrosyne.ErrNotFound,
+			want:  `{"jsonrpc":"2.0","error":{"code":12347,"message":"invalid cursor"},"id":"1"}`,
+		},
+		{
+			name:  "lookup failure",
+			found: assert.AnError,
+			want:  `{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userService := sophrosyne2.NewMockUserService(t)
+			userService.EXPECT().GetUser(ctx, position).Return(sophrosyne.User{}, tt.found).Once()
+			u := UserService{
+				config:      config,
+				userService: userService,
+				logger:      slog.Default(),
+			}
+
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Users::GetUsers",
+				Params: &jsonrpc.ParamsObject{"cursor": cursor},
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestUserService_DeleteUsers(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1", IsAdmin: true})
+	config := &sophrosyne.Config{}
+	config.Services.Users.MaxBatchSize = 6
+
+	tests := []struct {
+		name  string
+		names []interface{}
+		setup func(us *sophrosyne2.MockUserService, authz *sophrosyne2.MockAuthorizationProvider)
+		want  string
+	}{
+		{
+			name:  "existing, missing and already deleted users",
+			names: []interface{}{"existing", "missing", "deleted", "raced", "forbidden", "broken"},
+			setup: func(us *sophrosyne2.MockUserService, authz *sophrosyne2.MockAuthorizationProvider) {
+				us.EXPECT().GetUserByName(ctx, "existing").Return(sophrosyne.User{ID: "2", Name: "existing"}, nil).Once()
+				us.EXPECT().DeleteUser(ctx, "existing").Return(nil).Once()
+				us.EXPECT().GetUserByName(ctx, "missing").Return(sophrosyne.User{}, sophrosyne.ErrNotFound).Once()
+				us.EXPECT().GetUserByName(ctx, "deleted").Return(sophrosyne.User{}, sophrosyne.ErrNotFound).Once()
+				us.EXPECT().GetUserByName(ctx, "raced").Return(sophrosyne.User{ID: "3", Name: "raced"}, nil).Once()
+				us.EXPECT().DeleteUser(ctx, "raced").Return(sophrosyne.ErrNotFound).Once()
+				us.EXPECT().GetUserByName(ctx, "forbidden").Return(sophrosyne.User{ID: "4", Name: "forbidden"}, nil).Once()
+				us.EXPECT().GetUserByName(ctx, "broken").Return(sophrosyne.User{ID: "5", Name: "broken"}, nil).Once()
+				us.EXPECT().DeleteUser(ctx, "broken").Return(assert.AnError).Once()
+				authz.EXPECT().IsAuthorized(ctx, mock.MatchedBy(func(req sophrosyne.AuthorizationRequest) bool {
+					return req.Resource.(sophrosyne.User).ID != "4"
+				})).Return(true).Times(5)
+				authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(false).Once()
+			},
+			want: `{"jsonrpc":"2.0","result":{"results":[` +
+				`{"name":"existing","deleted":true},` +
+				`{"name":"missing","deleted":false,"error":"user not found"},` +
+				`{"name":"deleted","deleted":false,"error":"user not found"},` +
+				`{"name":"raced","deleted":false,"error":"user not found"},` +
+				`{"name":"forbidden","deleted":false,"error":"unauthorized"},` +
+				`{"name":"broken","deleted":false,"error":"unable to delete user"}` +
+				`]},"id":"1"}`,
+		},
+		{
+			name:  "existence hidden from unauthorized callers",
+			names: []interface{}{"missing", "other"},
+			setup: func(us *sophrosyne2.MockUserService, authz *sophrosyne2.MockAuthorizationProvider) {
+				us.EXPECT().GetUserByName(ctx, "missing").Return(sophrosyne.User{}, sophrosyne.ErrNotFound).Once()
+				us.EXPECT().GetUserByName(ctx, "other").Return(sophrosyne.User{ID: "2", Name: "other"}, nil).Once()
+				authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(false).Twice()
+			},
+			want: `{"jsonrpc":"2.0","result":{"results":[` +
+				`{"name":"missing","deleted":false,"error":"unauthorized"},` +
+				`{"name":"other","deleted":false,"error":"unauthorized"}` +
+				`]},"id":"1"}`,
+		},
+		{
+			name:  "failing lookup",
+			names: []interface{}{"unreachable"},
+			setup: func(us *sophrosyne2.MockUserService, authz *sophrosyne2.MockAuthorizationProvider) {
+				us.EXPECT().GetUserByName(ctx, "unreachable").Return(sophrosyne.User{}, assert.AnError).Once()
+			},
+			want: `{"jsonrpc":"2.0","result":{"results":[{"name":"unreachable","deleted":false,"error":"unable to delete user"}]},"id":"1"}`,
+		},
+		{
+			name:  "empty batch",
+			names: []interface{}{},
+			setup: func(us *sophrosyne2.MockUserService, authz *sophrosyne2.MockAuthorizationProvider) {},
+			want:  `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid Params"},"id":"1"}`,
+		},
+		{
+			name:  "batch too large",
+			names: []interface{}{"a", "b", "c", "d", "e", "f", "g"},
+			setup: func(us *sophrosyne2.MockUserService, authz *sophrosyne2.MockAuthorizationProvider) {},
+			want:  `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid Params"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userService := sophrosyne2.NewMockUserService(t)
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			tt.setup(userService, authz)
+			u := UserService{
+				config:      config,
+				userService: userService,
+				authz:       authz,
+				logger:      slog.Default(),
+			}
+
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Users::DeleteUsers",
+				Params: &jsonrpc.ParamsObject{"names": tt.names},
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestUserService_EmailInUse(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1", IsAdmin: true})
+	emailInUse := fmt.Errorf("%w: %w", sophrosyne.ErrEmailInUse, assert.AnError)
+	tests := []struct {
+		name   string
+		method string
+		setup  func(us *sophrosyne2.MockUserService)
+		want   string
+	}{
+		{
+			name:   "create with email in use",
+			method: "Users::CreateUser",
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().CreateUser(mock.Anything, mock.Anything).Return(sophrosyne.User{}, emailInUse).Once()
+			},
+			want: `{"jsonrpc":"2.0","error":{"code":12348,"message":"email already in use"},"id":"1"}`,
+		},
+		{
+			name:   "create with unused email",
+			method: "Users::CreateUser",
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().CreateUser(mock.Anything, mock.Anything).Return(sophrosyne.User{Name: "test", Email: "shared@example.com"}, nil).Once()
+			},
+			want: `{"jsonrpc":"2.0","result":{"name":"test","email":"shared@example.com","token":null,"is_admin":false,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"},"id":"1"}`,
+		},
+		{
+			name:   "create with other error",
+			method: "Users::CreateUser",
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().CreateUser(mock.Anything, mock.Anything).Return(sophrosyne.User{}, assert.AnError).Once()
+			},
+			want: `{"jsonrpc":"2.0","error":{"code":12346,"message":"unable to create user"},"id":"1"}`,
+		},
+		{
+			name:   "update to email in use",
+			method: "Users::UpdateUser",
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().GetUserByName(ctx, "test").Return(sophrosyne.User{ID: "2", Name: "test"}, nil).Once()
+				us.EXPECT().UpdateUser(ctx, mock.Anything).Return(sophrosyne.User{}, emailInUse).Once()
+			},
+			want: `{"jsonrpc":"2.0","error":{"code":12348,"message":"email already in use"},"id":"1"}`,
+		},
+		{
+			name:   "update to unused email",
+			method: "Users::UpdateUser",
+			setup: func(us *sophrosyne2.MockUserService) {
+				us.EXPECT().GetUserByName(ctx, "test").Return(sophrosyne.User{ID: "2", Name: "test"}, nil).Once()
+				us.EXPECT().UpdateUser(ctx, mock.Anything).Return(sophrosyne.User{Name: "test", Email: "shared@example.com"}, nil).Once()
+			},
+			want: `{"jsonrpc":"2.0","result":{"name":"test","email":"shared@example.com","is_admin":false,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userService := sophrosyne2.NewMockUserService(t)
+			tt.setup(userService)
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(true).Once()
+			u := UserService{
+				config:      &sophrosyne.Config{},
+				userService: userService,
+				authz:       authz,
+				logger:      slog.Default(),
+			}
+
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: jsonrpc.Method(tt.method),
+				Params: &jsonrpc.ParamsObject{"name": "test", "email": "shared@example.com"},
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestUserService_TokenDelivery(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1", IsAdmin: true})
+	token := []byte("secret")
+
+	var hooked tokenWebhookPayload
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&hooked))
+	}))
+	defer webhook.Close()
+	failingWebhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failingWebhook.Close()
+
+	tests := []struct {
+		name     string
+		mode     sophrosyne.TokenDeliveryMode
+		webhook  string
+		masked   bool
+		delivery func(t *testing.T, dir, ref string) []byte
+		wantErr  string
+	}{
+		{
+			name: "response",
+			mode: sophrosyne.TokenDeliveryResponse,
+		},
+		{
+			name:   "file",
+			mode:   sophrosyne.TokenDeliveryFile,
+			masked: true,
+			delivery: func(t *testing.T, dir, ref string) []byte {
+				b, err := os.ReadFile(filepath.Join(dir, ref))
+				require.NoError(t, err)
+				got, err := base64.StdEncoding.DecodeString(string(b))
+				require.NoError(t, err)
+				return got
+			},
+		},
+		{
+			name:    "webhook",
+			mode:    sophrosyne.TokenDeliveryWebhook,
+			webhook: webhook.URL,
+			masked:  true,
+			delivery: func(t *testing.T, dir, ref string) []byte {
+				require.Equal(t, ref, hooked.Reference)
+				require.Equal(t, "test", hooked.Name)
+				return hooked.Token
+			},
+		},
+		{
+			name:    "failing webhook",
+			mode:    sophrosyne.TokenDeliveryWebhook,
+			webhook: failingWebhook.URL,
+			wantErr: `{"jsonrpc":"2.0","error":{"code":12346,"message":"unable to deliver token"},"id":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		for _, method := range []string{"Users::CreateUser", "Users::RotateToken"} {
+			t.Run(tt.name+" "+method, func(t *testing.T) {
+				hooked = tokenWebhookPayload{}
+				config := &sophrosyne.Config{}
+				config.Services.Users.TokenDelivery = tt.mode
+				config.Services.Users.TokenDirectory = t.TempDir()
+				config.Services.Users.TokenWebhook = tt.webhook
+				config.Services.Users.TokenWebhookTimeout = time.Second
+				userService := sophrosyne2.NewMockUserService(t)
+				// The token is only stored if it was delivered.
+				stored := false
+				if method == "Users::CreateUser" {
+					userService.EXPECT().CreateUser(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, _ sophrosyne.CreateUserRequest) (sophrosyne.User, error) {
+						err := sophrosyne.DeliverToken(ctx, "test", token)
+						if err != nil {
+							return sophrosyne.User{}, err
+						}
+						stored = true
+						return sophrosyne.User{Name: "test", Token: token}, nil
+					}).Once()
+				} else {
+					userService.EXPECT().GetUserByName(ctx, "test").Return(sophrosyne.User{ID: "2", Name: "test"}, nil).Once()
+					userService.EXPECT().RotateToken(mock.Anything, "test").RunAndReturn(func(ctx context.Context, _ string) ([]byte, error) {
+						err := sophrosyne.DeliverToken(ctx, "test", token)
+						if err != nil {
+							return nil, err
+						}
+						stored = true
+						return token, nil
+					}).Once()
+				}
+				authz := sophrosyne2.NewMockAuthorizationProvider(t)
+				authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(true).Once()
+				u := UserService{
+					config:      config,
+					userService: userService,
+					authz:       authz,
+					logger:      slog.Default(),
+				}
+
+				got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+					ID:     jsonrpc.NewID("1"),
+					Method: jsonrpc.Method(method),
+					Params: &jsonrpc.ParamsObject{"name": "test", "email": "test@example.com"},
+				})
+				require.NoError(t, err)
+				require.Equal(t, tt.wantErr == "", stored)
+				if tt.wantErr != "" {
+					require.JSONEq(t, tt.wantErr, string(got))
+					return
+				}
+
+				var resp struct {
+					Result struct {
+						Token    []byte `json:"token"`
+						TokenRef string `json:"token_reference"`
+					} `json:"result"`
+				}
+				require.NoError(t, json.Unmarshal(got, &resp))
+				if !tt.masked {
+					require.Equal(t, token, resp.Result.Token)
+					require.Empty(t, resp.Result.TokenRef)
+					return
+				}
+				require.Nil(t, resp.Result.Token)
+				require.NotEmpty(t, resp.Result.TokenRef)
+				require.Equal(t, token, tt.delivery(t, config.Services.Users.TokenDirectory, resp.Result.TokenRef))
+			})
+		}
+	}
+}
+
+func TestUserService_Methods(t *testing.T) {
+	s, err := rpc.NewRPCServer(nil, slog.Default(), nil, nil)
+	require.NoError(t, err)
+	s.MustRegister("Users", UserService{})
+
+	methods := map[string]rpc.MethodSchema{}
+	for _, m := range s.Schema().Methods {
+		methods[m.Name] = m
+	}
+	for _, name := range []string{"GetUser", "GetUsers", "CreateUser", "UpdateUser", "DeleteUser", "DeleteUsers", "RotateToken"} {
+		require.Contains(t, methods, "Users::"+name)
+	}
+
+	create := methods["Users::CreateUser"]
+	var params []string
+	for name := range create.Params.Properties {
+		params = append(params, name)
+	}
+	require.ElementsMatch(t, []string{"name", "email", "is_admin"}, params)
+	require.ElementsMatch(t, []string{"name", "email"}, create.Params.Required)
+	require.Equal(t, "byte", create.Result.Properties["token"].Format)
+}
+
+func TestUserService_GetUser_ServedStale(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sophrosyne.UserContextKey{}, &sophrosyne.User{ID: "1", IsAdmin: true})
+	for _, stale := range []bool{false, true} {
+		t.Run(fmt.Sprint(stale), func(t *testing.T) {
+			userService := sophrosyne2.NewMockUserService(t)
+			userService.EXPECT().GetUser(ctx, "2").Return(sophrosyne.User{ID: "2", Name: "test", ServedStale: stale}, nil).Once()
+			authz := sophrosyne2.NewMockAuthorizationProvider(t)
+			authz.EXPECT().IsAuthorized(ctx, mock.Anything).Return(true).Once()
+			u := UserService{
+				config:      &sophrosyne.Config{},
+				userService: userService,
+				authz:       authz,
+				logger:      slog.Default(),
+			}
+
+			got, err := u.InvokeMethod(ctx, jsonrpc.Request{
+				ID:     jsonrpc.NewID("1"),
+				Method: "Users::GetUser",
+				Params: &jsonrpc.ParamsObject{"id": "2"},
+			})
+			require.NoError(t, err)
+			var resp struct {
+				Result map[string]any `json:"result"`
+			}
+			require.NoError(t, json.Unmarshal(got, &resp))
+			require.Equal(t, "test", resp.Result["name"])
+			_, ok := resp.Result["served_stale"]
+			require.Equal(t, stale, ok)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tls
+
+import (
+	"crypto/tls"
+	"io"
+	"sync"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+// CertificateReloader serves the certificate of the server through
+// [tls.Config.GetCertificate], allowing it to be replaced without restarting
+// the server.
+type CertificateReloader struct {
+	mu         sync.RWMutex
+	cert       *tls.Certificate
+	generated  bool // the certificate was generated rather than read from files
+	config     *sophrosyne.Config
+	randSource io.Reader
+}
+
+// NewReloadableTLSServerConfig is like [NewTLSServerConfig], but serves the
+// certificate through the returned [CertificateReloader] instead.
+func NewReloadableTLSServerConfig(config *sophrosyne.Config, randSource io.Reader) (*tls.Config, *CertificateReloader, error) {
+	randSource = ensureRand(randSource)
+	c, err := NewTLSServerConfig(config, randSource)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config == nil {
+		return c, nil, nil
+	}
+
+	r := &CertificateReloader{
+		cert:       &c.Certificates[0],
+		generated:  isGenerated(config),
+		config:     config,
+		randSource: randSource,
+	}
+	c.Certificates = nil
+	c.GetCertificate = r.GetCertificate
+	return c, r, nil
+}
+
+// GetCertificate returns the current certificate, regardless of hello.
+func (r *CertificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload reads the certificate and key again, as configured by
+// security.tls.certificatePath and security.tls.keyPath. A generated
+// certificate is kept as long as neither path is set. If the certificate
+// cannot be read, the error is returned and the current certificate is kept.
+func (r *CertificateReloader) Reload() error {
+	generated := isGenerated(r.config)
+	r.mu.RLock()
+	keep := generated && r.generated
+	r.mu.RUnlock()
+	if keep {
+		return nil
+	}
+
+	cert, err := serverCertificate(r.config, r.randSource)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.generated = generated
+	return nil
+}
+
+// isGenerated reports whether the certificate of the server is generated
+// rather than read from files.
+func isGenerated(config *sophrosyne.Config) bool {
+	return config.Security.TLS.CertificatePath == "" && config.Security.TLS.KeyPath == ""
+}
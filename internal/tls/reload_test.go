@@ -0,0 +1,88 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package tls
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/madsrc/sophrosyne"
+)
+
+func TestNewReloadableTLSServerConfig(t *testing.T) {
+	config := &sophrosyne.Config{}
+	config.Security.TLS.KeyType = "EC-P384"
+
+	got, r, err := NewReloadableTLSServerConfig(config, nil)
+	require.NoError(t, err)
+	require.Empty(t, got.Certificates)
+	require.NotNil(t, got.GetCertificate)
+	cert, err := got.GetCertificate(nil)
+	require.NoError(t, err)
+	require.NotNil(t, cert)
+
+	// A generated certificate is kept, rather than generating a new one.
+	require.NoError(t, r.Reload())
+	again, err := got.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Same(t, cert, again)
+
+	got, r, err = NewReloadableTLSServerConfig(nil, nil)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.Nil(t, r)
+}
+
+func TestCertificateReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeKeyPair(t, dir, newPrivKey(t))
+	config := &sophrosyne.Config{}
+	config.Security.TLS.KeyType = "EC-P384"
+	config.Security.TLS.CertificatePath = certPath
+	config.Security.TLS.KeyPath = keyPath
+
+	got, r, err := NewReloadableTLSServerConfig(config, nil)
+	require.NoError(t, err)
+	first, err := got.GetCertificate(nil)
+	require.NoError(t, err)
+
+	// Renewing the certificate in place takes effect on reload.
+	writeKeyPair(t, dir, newPrivKey(t))
+	require.NoError(t, r.Reload())
+	renewed, err := got.GetCertificate(nil)
+	require.NoError(t, err)
+	require.NotEqual(t, first.Certificate, renewed.Certificate)
+
+	// A broken certificate leaves the current one in place.
+	require.NoError(t, os.WriteFile(certPath, []byte("not a certificate"), 0o600))
+	require.Error(t, r.Reload())
+	current, err := got.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Same(t, renewed, current)
+
+	// Removing the paths from the configuration generates a certificate.
+	config.Security.TLS.CertificatePath = ""
+	config.Security.TLS.KeyPath = ""
+	require.NoError(t, r.Reload())
+	generated, err := got.GetCertificate(nil)
+	require.NoError(t, err)
+	require.NotSame(t, renewed, generated)
+}
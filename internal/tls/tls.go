@@ -32,6 +32,8 @@ import (
 	"io"
 	"math/big"
 	"net"
+	"net/mail"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -100,7 +102,33 @@ func ensureRand(randSource io.Reader) io.Reader {
 
 const defaultValidity = 365 * 24 * time.Hour
 
-func generateCert(priv interface{}, hosts []string, validFrom time.Time, validFor time.Duration, isCA bool, randSource io.Reader) ([]byte, error) {
+// ErrInvalidWildcard is returned by generateCert when a host contains a
+// wildcard anywhere but as the entire leftmost label, such as *.example.com.
+var ErrInvalidWildcard = errors.New("wildcards are only allowed as the leftmost label of a DNS name")
+
+// ErrInvalidURISAN is returned by generateCert when a URI SAN is not an
+// absolute URI.
+var ErrInvalidURISAN = errors.New("URI SANs must be absolute URIs")
+
+// addHostSAN adds h to the subject alternative names of template as an IP
+// address, an email address or a DNS name.
+func addHostSAN(template *x509.Certificate, h string) error {
+	if ip := net.ParseIP(h); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+		return nil
+	}
+	if addr, err := mail.ParseAddress(h); err == nil && addr.Address == h {
+		template.EmailAddresses = append(template.EmailAddresses, h)
+		return nil
+	}
+	if strings.Contains(strings.TrimPrefix(h, "*."), "*") || h == "*." {
+		return fmt.Errorf("%w: %q", ErrInvalidWildcard, h)
+	}
+	template.DNSNames = append(template.DNSNames, h)
+	return nil
+}
+
+func generateCert(priv interface{}, hosts []string, uris []string, validFrom time.Time, validFor time.Duration, isCA bool, randSource io.Reader) ([]byte, error) {
 	randSource = ensureRand(randSource)
 	var err error
 	keyUsage := x509.KeyUsageDigitalSignature
@@ -135,11 +163,17 @@ func generateCert(priv interface{}, hosts []string, validFrom time.Time, validFo
 		BasicConstraintsValid: true,
 	}
 	for _, h := range hosts {
-		if ip := net.ParseIP(h); ip != nil {
-			template.IPAddresses = append(template.IPAddresses, ip)
-		} else {
-			template.DNSNames = append(template.DNSNames, h)
+		err = addHostSAN(&template, h)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, u := range uris {
+		uri, err := url.Parse(u)
+		if err != nil || !uri.IsAbs() {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidURISAN, u)
 		}
+		template.URIs = append(template.URIs, uri)
 	}
 	if isCA {
 		template.IsCA = true
@@ -174,6 +208,9 @@ func readPEMFile(path string) (*pem.Block, error) {
 		return nil, err
 	}
 	data, _ := pem.Decode(pembytes)
+	if data == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
 
 	return data, nil
 }
@@ -242,11 +279,47 @@ func readPrivateKeyPath(path string) (any, error) {
 //
 // Server.AdvertisedHost - The value will be used as the common name and first Subject
 // Alternative Name of the certificate.
+//
+// Security.TLS.ClientCAPath - Path to a bundle of PEM encoded CA certificates
+// in the filesystem. If set, client certificates are verified against the
+// bundle when presented.
+//
+// Security.TLS.RequireClientCert - if set, clients must present a certificate
+// that verifies against the bundle in Security.TLS.ClientCAPath, which must
+// then also be set.
 func NewTLSServerConfig(config *sophrosyne.Config, randSource io.Reader) (*tls.Config, error) {
 	randSource = ensureRand(randSource)
 	if config == nil {
 		return newDefaultTLSConfig(), nil
 	}
+	cert, err := serverCertificate(config, randSource)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newDefaultTLSConfig()
+	c.Certificates = []tls.Certificate{cert}
+	c.NextProtos = config.Security.TLS.ALPN
+
+	if config.Security.TLS.ClientCAPath != "" {
+		c.ClientCAs, err = readCertPool(config.Security.TLS.ClientCAPath)
+		if err != nil {
+			return nil, err
+		}
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if config.Security.TLS.RequireClientCert {
+		if c.ClientCAs == nil {
+			return nil, ErrMissingClientCA
+		}
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// serverCertificate reads the certificate and key of the server, generating
+// either of them if its path is not set.
+func serverCertificate(config *sophrosyne.Config, randSource io.Reader) (tls.Certificate, error) {
 	var priv any
 	var err error
 	var certBytes []byte
@@ -256,28 +329,46 @@ func NewTLSServerConfig(config *sophrosyne.Config, randSource io.Reader) (*tls.C
 		priv, err = readPrivateKeyPath(config.Security.TLS.KeyPath)
 	}
 	if err != nil {
-		return nil, err
+		return tls.Certificate{}, err
 	}
 
 	if config.Security.TLS.CertificatePath == "" {
-		certBytes, err = generateCert(priv, []string{config.Server.AdvertisedHost}, time.Time{}, 0, false, randSource)
+		certBytes, err = generateCert(priv, []string{config.Server.AdvertisedHost}, config.Security.TLS.URISANs, time.Time{}, config.Security.TLS.CertValidity, false, randSource)
 	} else {
 		certBytes, err = readCertificate(config.Security.TLS.CertificatePath)
 	}
 	if err != nil {
-		return nil, err
+		return tls.Certificate{}, err
 	}
 
-	cert := tls.Certificate{
+	return tls.Certificate{
 		Certificate: [][]byte{certBytes},
 		PrivateKey:  priv,
-	}
+	}, nil
+}
 
-	c := newDefaultTLSConfig()
-	c.Certificates = []tls.Certificate{cert}
-	return c, nil
+// ErrMissingClientCA is returned by [NewTLSServerConfig] when client
+// certificates are required, but no CA bundle to verify them against is
+// configured.
+var ErrMissingClientCA = errors.New("security.tls.clientCAPath must be set when security.tls.requireClientCert is enabled")
+
+// readCertPool reads a bundle of PEM encoded certificates into a pool.
+func readCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
 }
 
+// ErrIncompleteClientCertificate is returned by [NewTLSClientConfig] when only
+// one of Security.TLS.ClientCertPath and Security.TLS.ClientKeyPath is set.
+var ErrIncompleteClientCertificate = errors.New("both security.tls.clientCertPath and security.tls.clientKeyPath must be set to use a client certificate")
+
 // Create a new [tls.Config] for client use, such as with HTTP calls.
 //
 // It takes a [sophrosyne.Config] and references it as the source of configuration when
@@ -289,6 +380,11 @@ func NewTLSServerConfig(config *sophrosyne.Config, randSource io.Reader) (*tls.C
 //
 // Security.TLS.InsecureSkipVerify - if set, the TLS config will be
 // configured to not verify certificates.
+//
+// Security.TLS.ClientCertPath and Security.TLS.ClientKeyPath - Paths to an
+// X.509 certificate and its private key in the filesystem, presented to
+// servers that require mutual TLS. Either both or neither must be set. The
+// key must be an EC key or a PKCS8 encoded key, such as an RSA or ED25519 key.
 func NewTLSClientConfig(config *sophrosyne.Config) (*tls.Config, error) {
 	c := newDefaultTLSConfig()
 	if config == nil {
@@ -296,6 +392,26 @@ func NewTLSClientConfig(config *sophrosyne.Config) (*tls.Config, error) {
 	}
 	c.InsecureSkipVerify = config.Security.TLS.InsecureSkipVerify
 
+	certPath, keyPath := config.Security.TLS.ClientCertPath, config.Security.TLS.ClientKeyPath
+	if certPath == "" && keyPath == "" {
+		return c, nil
+	}
+	if certPath == "" || keyPath == "" {
+		return nil, ErrIncompleteClientCertificate
+	}
+	priv, err := readPrivateKeyPath(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading client key: %w", err)
+	}
+	certBytes, err := readCertificate(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading client certificate: %w", err)
+	}
+	c.Certificates = []tls.Certificate{{
+		Certificate: [][]byte{certBytes},
+		PrivateKey:  priv,
+	}}
+
 	return c, nil
 }
 
@@ -30,6 +30,8 @@ import (
 	"encoding/pem"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"testing/iotest"
 	"time"
@@ -94,15 +96,83 @@ func TestNewTLSClientConfig(t *testing.T) {
 	}
 }
 
+// writeKeyPair writes priv and a certificate for it to dir, returning the
+// paths of the certificate and the key.
+func writeKeyPair(t *testing.T, dir string, priv any) (certPath string, keyPath string) {
+	t.Helper()
+	certBytes, err := generateCert(priv, []string{"client"}, nil, time.Time{}, 0, false, nil)
+	require.NoError(t, err)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}), 0o600))
+	return certPath, keyPath
+}
+
+func TestNewTLSClientConfig_ClientCertificate(t *testing.T) {
+	newConfig := func(certPath, keyPath string) *sophrosyne.Config {
+		return &sophrosyne.Config{
+			Security: sophrosyne.SecurityConfig{
+				TLS: sophrosyne.TLSConfig{
+					ClientCertPath: certPath,
+					ClientKeyPath:  keyPath,
+				},
+			},
+		}
+	}
+
+	keys := []struct {
+		name string
+		priv any
+	}{
+		{name: "ED25519", priv: newED25519PrivKey(t)},
+		{name: "RSA", priv: newRSAPrivKey(t)},
+		{name: "EC", priv: newPrivKey(t)},
+	}
+	for _, k := range keys {
+		t.Run(k.name, func(t *testing.T) {
+			certPath, keyPath := writeKeyPair(t, t.TempDir(), k.priv)
+
+			got, err := NewTLSClientConfig(newConfig(certPath, keyPath))
+			require.NoError(t, err)
+			require.Len(t, got.Certificates, 1)
+			require.Equal(t, k.priv, got.Certificates[0].PrivateKey)
+			cert, err := x509.ParseCertificate(got.Certificates[0].Certificate[0])
+			require.NoError(t, err)
+			require.Equal(t, publicKey(k.priv), cert.PublicKey)
+		})
+	}
+
+	certPath, keyPath := writeKeyPair(t, t.TempDir(), newPrivKey(t))
+	t.Run("only certificate", func(t *testing.T) {
+		got, err := NewTLSClientConfig(newConfig(certPath, ""))
+		require.ErrorIs(t, err, ErrIncompleteClientCertificate)
+		require.Nil(t, got)
+	})
+	t.Run("only key", func(t *testing.T) {
+		got, err := NewTLSClientConfig(newConfig("", keyPath))
+		require.ErrorIs(t, err, ErrIncompleteClientCertificate)
+		require.Nil(t, got)
+	})
+	t.Run("invalid key", func(t *testing.T) {
+		got, err := NewTLSClientConfig(newConfig(certPath, "testdata/invalid_key.pem"))
+		require.Error(t, err)
+		require.Nil(t, got)
+	})
+}
+
 func TestNewTLSServerConfig(t *testing.T) {
 	type args struct {
 		config     *sophrosyne.Config
 		randSource io.Reader
 	}
 	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
+		name           string
+		args           args
+		wantErr        bool
+		wantNextProtos []string
 	}{
 		{
 			name: "successfull call",
@@ -116,6 +186,20 @@ func TestNewTLSServerConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "alpn protocols",
+			args: args{
+				config: &sophrosyne.Config{
+					Security: sophrosyne.SecurityConfig{
+						TLS: sophrosyne.TLSConfig{
+							KeyType: "EC-P384",
+							ALPN:    []string{"h2", "http/1.1"},
+						},
+					},
+				},
+			},
+			wantNextProtos: []string{"h2", "http/1.1"},
+		},
 		{
 			name: "empty config",
 			args: args{
@@ -170,11 +254,98 @@ func TestNewTLSServerConfig(t *testing.T) {
 				require.NotNil(t, got.Certificates)
 				require.Len(t, got.Certificates, 1)
 			}
+			require.Equal(t, tt.wantNextProtos, got.NextProtos)
 
 		})
 	}
 }
 
+func TestNewTLSServerConfig_CertValidity(t *testing.T) {
+	tests := []struct {
+		name     string
+		validity time.Duration
+		want     time.Duration
+	}{
+		{
+			name: "unset",
+			want: defaultValidity,
+		},
+		{
+			name:     "configured",
+			validity: 10 * 365 * 24 * time.Hour,
+			want:     10 * 365 * 24 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &sophrosyne.Config{}
+			config.Security.TLS.KeyType = "EC-P384"
+			config.Security.TLS.CertValidity = tt.validity
+			got, err := NewTLSServerConfig(config, nil)
+			require.NoError(t, err)
+			require.Len(t, got.Certificates, 1)
+
+			cert, err := x509.ParseCertificate(got.Certificates[0].Certificate[0])
+			require.NoError(t, err)
+			require.Equal(t, tt.want, cert.NotAfter.Sub(cert.NotBefore))
+		})
+	}
+}
+
+func TestNewTLSServerConfig_ClientCA(t *testing.T) {
+	dir := t.TempDir()
+	caPriv := newPrivKey(t)
+	caBytes, err := generateCert(caPriv, []string{"client-ca"}, nil, time.Time{}, 0, true, nil)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caBytes)
+	require.NoError(t, err)
+	caPath := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caBytes}), 0o600))
+	wantPool := x509.NewCertPool()
+	wantPool.AddCert(caCert)
+
+	newConfig := func(caPath string, requireCert bool) *sophrosyne.Config {
+		return &sophrosyne.Config{
+			Security: sophrosyne.SecurityConfig{
+				TLS: sophrosyne.TLSConfig{
+					KeyType:           "EC-P384",
+					ClientCAPath:      caPath,
+					RequireClientCert: requireCert,
+				},
+			},
+		}
+	}
+
+	t.Run("required", func(t *testing.T) {
+		got, err := NewTLSServerConfig(newConfig(caPath, true), nil)
+		require.NoError(t, err)
+		require.Equal(t, tls.RequireAndVerifyClientCert, got.ClientAuth)
+		require.True(t, wantPool.Equal(got.ClientCAs))
+	})
+	t.Run("optional", func(t *testing.T) {
+		got, err := NewTLSServerConfig(newConfig(caPath, false), nil)
+		require.NoError(t, err)
+		require.Equal(t, tls.VerifyClientCertIfGiven, got.ClientAuth)
+		require.True(t, wantPool.Equal(got.ClientCAs))
+	})
+	t.Run("not configured", func(t *testing.T) {
+		got, err := NewTLSServerConfig(newConfig("", false), nil)
+		require.NoError(t, err)
+		require.Equal(t, tls.NoClientCert, got.ClientAuth)
+		require.Nil(t, got.ClientCAs)
+	})
+	t.Run("required without CA", func(t *testing.T) {
+		got, err := NewTLSServerConfig(newConfig("", true), nil)
+		require.ErrorIs(t, err, ErrMissingClientCA)
+		require.Nil(t, got)
+	})
+	t.Run("no certificates in bundle", func(t *testing.T) {
+		got, err := NewTLSServerConfig(newConfig("testdata/invalid_key.pem", true), nil)
+		require.Error(t, err)
+		require.Nil(t, got)
+	})
+}
+
 func checkCert(t *testing.T, cert *x509.Certificate, args generateCertArgs) {
 	t.Helper()
 	require.NotNil(t, cert)
@@ -221,6 +392,15 @@ func checkCert(t *testing.T, cert *x509.Certificate, args generateCertArgs) {
 		require.Contains(t, args.hosts, h.String())
 	}
 
+	for _, h := range cert.EmailAddresses {
+		require.Contains(t, args.hosts, h)
+	}
+
+	require.Len(t, cert.URIs, len(args.uris))
+	for i, u := range cert.URIs {
+		require.Equal(t, args.uris[i], u.String())
+	}
+
 }
 
 func requirePrivSignedCert(t *testing.T, priv any, cert *x509.Certificate) {
@@ -260,6 +440,7 @@ type generateCertArgs struct {
 	priv       interface{}
 	randSource io.Reader
 	hosts      []string
+	uris       []string
 	validFrom  time.Time
 	validFor   time.Duration
 	isCA       bool
@@ -374,7 +555,7 @@ func Test_generateCert(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := generateCert(tt.args.priv, tt.args.hosts, tt.args.validFrom, tt.args.validFor, tt.args.isCA, tt.args.randSource)
+			got, err := generateCert(tt.args.priv, tt.args.hosts, tt.args.uris, tt.args.validFrom, tt.args.validFor, tt.args.isCA, tt.args.randSource)
 			if tt.wantErr {
 				require.Error(t, err)
 				require.Nil(t, got)
@@ -389,6 +570,86 @@ func Test_generateCert(t *testing.T) {
 	}
 }
 
+func Test_generateCert_SANs(t *testing.T) {
+	tests := []struct {
+		name       string
+		hosts      []string
+		uris       []string
+		wantDNS    []string
+		wantIPs    []string
+		wantEmails []string
+		wantURIs   []string
+		wantErr    error
+	}{
+		{
+			name:    "wildcard DNS name",
+			hosts:   []string{"*.example.com", "example.com"},
+			wantDNS: []string{"*.example.com", "example.com"},
+		},
+		{
+			name:       "mixed hosts",
+			hosts:      []string{"localhost", "127.0.0.1", "admin@example.com"},
+			wantDNS:    []string{"localhost"},
+			wantIPs:    []string{"127.0.0.1"},
+			wantEmails: []string{"admin@example.com"},
+		},
+		{
+			name:     "URI SANs",
+			hosts:    []string{"localhost"},
+			uris:     []string{"spiffe://example.com/sophrosyne", "https://example.com/path"},
+			wantDNS:  []string{"localhost"},
+			wantURIs: []string{"spiffe://example.com/sophrosyne", "https://example.com/path"},
+		},
+		{
+			name:    "wildcard not leftmost",
+			hosts:   []string{"foo.*.example.com"},
+			wantErr: ErrInvalidWildcard,
+		},
+		{
+			name:    "partial wildcard label",
+			hosts:   []string{"foo*.example.com"},
+			wantErr: ErrInvalidWildcard,
+		},
+		{
+			name:    "bare wildcard",
+			hosts:   []string{"*."},
+			wantErr: ErrInvalidWildcard,
+		},
+		{
+			name:    "relative URI",
+			hosts:   []string{"localhost"},
+			uris:    []string{"example.com/path"},
+			wantErr: ErrInvalidURISAN,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := generateCert(newPrivKey(t), tt.hosts, tt.uris, time.Time{}, 0, false, nil)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				require.Nil(t, got)
+				return
+			}
+			require.NoError(t, err)
+			cert, err := x509.ParseCertificate(got)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.wantDNS, cert.DNSNames)
+			var ips []string
+			for _, ip := range cert.IPAddresses {
+				ips = append(ips, ip.String())
+			}
+			require.Equal(t, tt.wantIPs, ips)
+			require.Equal(t, tt.wantEmails, cert.EmailAddresses)
+			var uris []string
+			for _, u := range cert.URIs {
+				uris = append(uris, u.String())
+			}
+			require.Equal(t, tt.wantURIs, uris)
+		})
+	}
+}
+
 func Test_signCert(t *testing.T) {
 	testKey := newPrivKey(t)
 	type args struct {
@@ -18,6 +18,7 @@ package sophrosyne
 
 import (
 	"context"
+	"io"
 	"log/slog"
 	"os"
 )
@@ -47,6 +48,17 @@ func LogLevelToSlogLevel(level LogLevel) slog.Level {
 	}
 }
 
+// configLevel is the [slog.Leveler] of logging.level. It is looked up on
+// every use, so a reloaded configuration takes effect immediately, including
+// for handlers derived with WithAttrs and WithGroup.
+type configLevel struct {
+	config *Config
+}
+
+func (l configLevel) Level() slog.Level {
+	return LogLevelToSlogLevel(l.config.Logging.Level)
+}
+
 type LogHandler struct {
 	Handler        slog.Handler   `validate:"required"`
 	config         *Config        `validate:"required"`
@@ -54,23 +66,38 @@ type LogHandler struct {
 }
 
 func NewLogHandler(config *Config, tracingService TracingService) *LogHandler {
+	return newLogHandler(config, tracingService, os.Stdout)
+}
+
+func newLogHandler(config *Config, tracingService TracingService, w io.Writer) *LogHandler {
 	h := LogHandler{
 		config:         config,
 		tracingService: tracingService,
 	}
 	handlerOpts := slog.HandlerOptions{
-		Level: LogLevelToSlogLevel(config.Logging.Level),
+		Level:       configLevel{config: config},
+		ReplaceAttr: h.replaceAttr,
 	}
 
 	if config.Logging.Format == LogFormatJSON {
-		h.Handler = slog.NewJSONHandler(os.Stdout, &handlerOpts)
+		h.Handler = slog.NewJSONHandler(w, &handlerOpts)
 	} else {
-		h.Handler = slog.NewTextHandler(os.Stdout, &handlerOpts)
+		h.Handler = slog.NewTextHandler(w, &handlerOpts)
 	}
 
 	return &h
 }
 
+// replaceAttr drops stack traces from grouped attributes, such as the ones
+// produced by the LogValue method of [UnreachableCodeError] and [PanicError],
+// unless [Config.IncludeStackTraces] says otherwise.
+func (h LogHandler) replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 && a.Key == "stack" && !h.config.IncludeStackTraces() {
+		return slog.Attr{}
+	}
+	return a
+}
+
 // Enabled returns true if the log level is enabled for the handler and false
 // otherwise.
 //
@@ -82,8 +109,10 @@ func NewLogHandler(config *Config, tracingService TracingService) *LogHandler {
 // allows us to not have to restart the application to change the log level,
 // provided that the part of the configuraiton we change allows for hot
 // reloading.
+//
+// Every level is enabled for requests with [RequestFeatureDebugLog] enabled.
 func (h LogHandler) Enabled(ctx context.Context, Level slog.Level) bool {
-	return Level >= LogLevelToSlogLevel(h.config.Logging.Level)
+	return Level >= LogLevelToSlogLevel(h.config.Logging.Level) || RequestFeatureEnabled(ctx, RequestFeatureDebugLog)
 }
 
 // Handle adds contextual attributes to the Record before calling the underlying
@@ -0,0 +1,125 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package sophrosyne
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type noTraceService struct {
+	TracingService
+}
+
+func (noTraceService) GetTraceID(context.Context) string { return "" }
+
+func TestLogHandler_StackTraces(t *testing.T) {
+	tests := []struct {
+		name        string
+		level       LogLevel
+		stackTraces bool
+		wantStack   bool
+	}{
+		{name: "debug with stack traces", level: LogLevelDebug, stackTraces: true, wantStack: true},
+		{name: "debug without stack traces", level: LogLevelDebug},
+		{name: "info with stack traces", level: LogLevelInfo, stackTraces: true},
+		{name: "info without stack traces", level: LogLevelInfo},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{}
+			config.Logging.Level = tt.level
+			config.Logging.Format = LogFormatJSON
+			config.Logging.StackTraces = tt.stackTraces
+
+			var buf bytes.Buffer
+			logger := slog.New(newLogHandler(config, noTraceService{}, &buf))
+			logger.Error("unreachable", "error", NewUnreachableCodeError())
+			logger.Error("panic", "error", NewPanicError("boom"))
+
+			dec := json.NewDecoder(&buf)
+			for range 2 {
+				var entry struct {
+					Error map[string]string `json:"error"`
+				}
+				require.NoError(t, dec.Decode(&entry))
+				_, ok := entry.Error["stack"]
+				require.Equal(t, tt.wantStack, ok)
+			}
+		})
+	}
+}
+
+func TestLogHandler_StackTraces_OtherAttrs(t *testing.T) {
+	config := &Config{}
+	config.Logging.Level = LogLevelInfo
+	config.Logging.Format = LogFormatJSON
+
+	var buf bytes.Buffer
+	logger := slog.New(newLogHandler(config, noTraceService{}, &buf))
+	logger.Error("panic", "error", NewPanicError("boom"), "stack", "top-level", "other", errors.New("plain"))
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, map[string]any{"reason": "boom"}, entry["error"])
+	require.Equal(t, "top-level", entry["stack"])
+	require.Equal(t, "plain", entry["other"])
+}
+
+func TestLogHandler_DebugLogFeature(t *testing.T) {
+	config := &Config{}
+	config.Logging.Level = LogLevelInfo
+	config.Logging.Format = LogFormatJSON
+
+	var buf bytes.Buffer
+	logger := slog.New(newLogHandler(config, noTraceService{}, &buf))
+
+	logger.DebugContext(context.Background(), "not logged")
+	require.Empty(t, buf.String())
+
+	ctx := context.WithValue(context.Background(), RequestFeaturesContextKey{}, []RequestFeature{RequestFeatureDebugLog})
+	logger.DebugContext(ctx, "logged")
+	require.Contains(t, buf.String(), `"msg":"logged"`)
+}
+
+func TestLogHandler_ReloadedLevel(t *testing.T) {
+	config := &Config{}
+	config.Logging.Level = LogLevelInfo
+	config.Logging.Format = LogFormatJSON
+
+	var buf bytes.Buffer
+	logger := slog.New(newLogHandler(config, noTraceService{}, &buf))
+	derived := logger.With("component", "test")
+
+	logger.Debug("before reload")
+	derived.Debug("before reload")
+	require.Empty(t, buf.String())
+
+	// Reloading the configuration replaces the values of the Config in place.
+	config.Logging.Level = LogLevelDebug
+	logger.Debug("after reload")
+	derived.Debug("after reload")
+	require.Equal(t, 2, bytes.Count(buf.Bytes(), []byte("after reload")))
+}
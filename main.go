@@ -18,9 +18,13 @@ package sophrosyne
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -55,8 +59,40 @@ func ExtractUser(ctx context.Context) *User {
 	return nil
 }
 
+// RequestFeature is a feature that a trusted client may enable for a single
+// request, see [FeatureOverridesConfig].
+type RequestFeature string
+
+const (
+	// RequestFeatureEchoParams logs the body of the RPC request.
+	RequestFeatureEchoParams RequestFeature = "echo-params"
+	// RequestFeatureDebugLog logs at the debug level, regardless of
+	// logging.level.
+	RequestFeatureDebugLog RequestFeature = "debug-log"
+)
+
+// RequestFeaturesContextKey is the context key under which the
+// []RequestFeature enabled for the current request are stored.
+type RequestFeaturesContextKey struct{}
+
+// RequestFeatureEnabled reports whether feature has been enabled for the
+// request of ctx.
+func RequestFeatureEnabled(ctx context.Context, feature RequestFeature) bool {
+	features, _ := ctx.Value(RequestFeaturesContextKey{}).([]RequestFeature)
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
 type MetricService interface {
 	RecordPanic(ctx context.Context)
+	RecordScanStarted(ctx context.Context)
+	RecordScanFinished(ctx context.Context)
+	RecordRPCCall(ctx context.Context, method string, duration time.Duration)
+	RecordCacheLookup(ctx context.Context, service, method string, hit bool)
 }
 
 type Span interface {
@@ -72,7 +108,7 @@ type TracingService interface {
 
 func NewToken(source io.Reader) ([]byte, error) {
 	b := make([]byte, 64)
-	_, err := source.Read(b)
+	_, err := io.ReadFull(source, b)
 	if err != nil {
 		return nil, err
 	}
@@ -82,6 +118,9 @@ func NewToken(source io.Reader) ([]byte, error) {
 // ProtectToken applies a Keyed-Hash Message Authentication Code (HMAC) to the
 // token using the site key, salt and SHA-256.
 //
+// Protected tokens must only be compared using [CompareToken], never with
+// bytes.Equal or ==, so the comparison does not leak timing information.
+//
 // If, for any reason, the HMAC fails, the function will panic.
 func ProtectToken(token []byte, config *Config) []byte {
 	h := hmac.New(sha256.New, config.Security.SiteKey)
@@ -105,6 +144,25 @@ func ProtectToken(token []byte, config *Config) []byte {
 	return out
 }
 
+// CompareToken reports whether the tokens a and b are equal. The comparison
+// takes the same time regardless of how much of the tokens match.
+func CompareToken(a, b []byte) bool {
+	return hmac.Equal(a, b)
+}
+
+// ProtectEmail applies a Keyed-Hash Message Authentication Code (HMAC) to the
+// email using a key derived from the site key and SHA-256, returning the
+// result hex encoded.
+//
+// The operation is irreversible. Once stored, the plaintext email can only be
+// matched by protecting a candidate email with the same site key, never read
+// back.
+func ProtectEmail(email string, config *Config) string {
+	h := hmac.New(sha256.New, deriveKey(config, keyPurposeEmail))
+	h.Write([]byte(email))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 var TimeFormatInResponse = time.RFC3339
 
 var xidRegex *regexp.Regexp = regexp.MustCompile("^[0-9a-v]{20}$")
@@ -113,11 +171,70 @@ func IsValidXID(s string) bool {
 	return xidRegex.MatchString(s)
 }
 
+var uuidRegex *regexp.Regexp = regexp.MustCompile("^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
+
+func IsValidUUID(s string) bool {
+	return uuidRegex.MatchString(s)
+}
+
+// IDValidator reports whether s is a well-formed entity ID.
+type IDValidator func(s string) bool
+
+// IDFormat is the format of the IDs of entities, such as the ones referenced
+// by a [DatabaseCursor].
+type IDFormat string
+
+const (
+	// IDFormatXID accepts IDs generated by xid. This is the format used by the
+	// bundled database migrations.
+	IDFormatXID IDFormat = "xid"
+	// IDFormatUUID accepts UUIDs in their canonical textual form.
+	IDFormatUUID IDFormat = "uuid"
+)
+
+// Validator returns the [IDValidator] for the format. Unknown formats are
+// validated as [IDFormatXID].
+func (f IDFormat) Validator() IDValidator {
+	switch f {
+	case IDFormatUUID:
+		return IsValidUUID
+	default:
+		return IsValidXID
+	}
+}
+
 const DatabaseCursorSeparator = "::"
 
+// CursorMode determines how a [DatabaseCursor] is encoded before being handed
+// to clients.
+type CursorMode string
+
+const (
+	// CursorModePlain encodes the cursor using base64.
+	CursorModePlain CursorMode = "plain"
+	// CursorModeHMAC appends an HMAC of the cursor, keyed with the site key,
+	// before encoding it using base64. Tampered cursors are rejected.
+	CursorModeHMAC CursorMode = "hmac"
+	// CursorModeEncrypted encrypts the cursor using AES-GCM with a key derived
+	// from the site key before encoding it using base64. The cursor is opaque
+	// to clients and tampered cursors are rejected.
+	CursorModeEncrypted CursorMode = "encrypted"
+)
+
+// SortOrder is the order in which a paginated listing returns its entries.
+type SortOrder string
+
+const (
+	SortOrderAscending  SortOrder = "asc"
+	SortOrderDescending SortOrder = "desc"
+)
+
 type DatabaseCursor struct {
 	OwnerID  string
 	Position string
+	// Order is not part of the encoded cursor, so it must be supplied again
+	// when requesting the following pages. An empty Order is ascending.
+	Order SortOrder
 }
 
 func NewDatabaseCursor(ownerID, position string) *DatabaseCursor {
@@ -134,6 +251,64 @@ func (c DatabaseCursor) String() string {
 	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s%s%s", c.OwnerID, DatabaseCursorSeparator, c.Position)))
 }
 
+// Encode returns the cursor encoded according to [Config.Server.CursorMode].
+// An empty string is returned if either the owner or position is empty.
+//
+// If, for any reason, the cursor cannot be encrypted, the function will panic.
+func (c DatabaseCursor) Encode(config *Config) string {
+	if c.OwnerID == "" || c.Position == "" {
+		return ""
+	}
+	b := []byte(fmt.Sprintf("%s%s%s", c.OwnerID, DatabaseCursorSeparator, c.Position))
+	switch config.Server.CursorMode {
+	case CursorModeHMAC:
+		b = append(b, cursorMAC(b, config)...)
+	case CursorModeEncrypted:
+		aead := cursorAEAD(config)
+		nonce := make([]byte, aead.NonceSize())
+		_, err := rand.Read(nonce)
+		if err != nil {
+			panic(err)
+		}
+		b = aead.Seal(nonce, nonce, b, nil)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Purposes of the keys derived from the site key with [deriveKey].
+const (
+	keyPurposeCursorMAC  = "sophrosyne/cursor-mac"
+	keyPurposeCursorAEAD = "sophrosyne/cursor-aead"
+	keyPurposeEmail      = "sophrosyne/email"
+)
+
+// deriveKey derives a 32 byte key for purpose from the site key, as
+// HMAC-SHA256(siteKey, purpose). Using a separate key for each purpose keeps
+// the output of one use of the site key from being valid in another.
+func deriveKey(config *Config, purpose string) []byte {
+	h := hmac.New(sha256.New, config.Security.SiteKey)
+	h.Write([]byte(purpose))
+	return h.Sum(nil)
+}
+
+func cursorMAC(b []byte, config *Config) []byte {
+	h := hmac.New(sha256.New, deriveKey(config, keyPurposeCursorMAC))
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+func cursorAEAD(config *Config) cipher.AEAD {
+	block, err := aes.NewCipher(deriveKey(config, keyPurposeCursorAEAD))
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
 func (c *DatabaseCursor) Reset() {
 	c.Position = ""
 }
@@ -146,31 +321,80 @@ func (c *DatabaseCursor) LogValue() slog.Value {
 	return slog.GroupValue(slog.String("owner_id", c.OwnerID), slog.String("last_read", c.Position))
 }
 
-var errInvalidCursor = errors.New("invalid cursor")
+// ErrInvalidCursor is returned when a cursor cannot be decoded, belongs to
+// another owner or refers to a position that no longer exists.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ValidatePosition uses checker to confirm that the row referenced by the
+// position of the cursor still exists. A cursor without a position is always
+// valid. [ErrInvalidCursor] is returned if checker reports that the row does
+// not exist, and errors returned by checker are returned as is.
+func (c *DatabaseCursor) ValidatePosition(ctx context.Context, checker func(ctx context.Context, position string) (bool, error)) error {
+	if c.Position == "" {
+		return nil
+	}
+	ok, err := checker(ctx, c.Position)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCursor
+	}
+	return nil
+}
 
-func DecodeDatabaseCursorWithOwner(s string, ownerID string) (*DatabaseCursor, error) {
-	cursor, err := DecodeDatabaseCursor(s)
+func DecodeDatabaseCursorWithOwner(s string, ownerID string, config *Config) (*DatabaseCursor, error) {
+	cursor, err := DecodeDatabaseCursor(s, config)
 	if err != nil {
 		return nil, err
 	}
 	if cursor.OwnerID != ownerID {
-		return nil, errInvalidCursor
+		return nil, ErrInvalidCursor
 	}
 	return cursor, nil
 }
 
-func DecodeDatabaseCursor(s string) (*DatabaseCursor, error) {
+// DecodeDatabaseCursor decodes a cursor previously returned by
+// [DatabaseCursor.Encode] using the same [Config.Server.CursorMode]. The IDs
+// in the cursor are validated according to [Config.Server.IDFormat].
+func DecodeDatabaseCursor(s string, config *Config) (*DatabaseCursor, error) {
+	return DecodeDatabaseCursorFunc(s, config, config.Server.IDFormat.Validator())
+}
+
+// DecodeDatabaseCursorFunc is like [DecodeDatabaseCursor], but validates the
+// IDs in the cursor using validID.
+func DecodeDatabaseCursorFunc(s string, config *Config, validID IDValidator) (*DatabaseCursor, error) {
 	b, err := base64.StdEncoding.DecodeString(s)
 	if err != nil {
 		return nil, err
 	}
+	switch config.Server.CursorMode {
+	case CursorModeHMAC:
+		if len(b) < sha256.Size {
+			return nil, ErrInvalidCursor
+		}
+		mac := b[len(b)-sha256.Size:]
+		b = b[:len(b)-sha256.Size]
+		if !hmac.Equal(mac, cursorMAC(b, config)) {
+			return nil, ErrInvalidCursor
+		}
+	case CursorModeEncrypted:
+		aead := cursorAEAD(config)
+		if len(b) < aead.NonceSize() {
+			return nil, ErrInvalidCursor
+		}
+		b, err = aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
 	parts := strings.Split(string(b), DatabaseCursorSeparator)
 	if len(parts) != 2 {
-		return nil, errInvalidCursor
+		return nil, ErrInvalidCursor
 	}
 
-	if !IsValidXID(parts[0]) || !IsValidXID(parts[1]) {
-		return nil, errInvalidCursor
+	if !validID(parts[0]) || !validID(parts[1]) {
+		return nil, ErrInvalidCursor
 	}
 
 	return &DatabaseCursor{
@@ -217,3 +441,53 @@ type HealthCheckService interface {
 type HealthChecker interface {
 	Health(ctx context.Context) (bool, []byte)
 }
+
+// HealthDetail controls how much of the health of the individual subsystems
+// is revealed by [HealthCheckService.AuthenticatedHealthcheck].
+type HealthDetail string
+
+const (
+	// HealthDetailMinimal only reports whether the service as a whole is healthy.
+	HealthDetailMinimal HealthDetail = "minimal"
+	// HealthDetailDetailed also reports the health of each subsystem.
+	HealthDetailDetailed HealthDetail = "detailed"
+)
+
+// NameNormalization controls how the names of users, profiles and checks are
+// normalized before they are validated, stored and looked up.
+type NameNormalization string
+
+const (
+	// NameNormalizationNone leaves names as they are.
+	NameNormalizationNone NameNormalization = "none"
+	// NameNormalizationTrim removes leading and trailing whitespace.
+	NameNormalizationTrim NameNormalization = "trim"
+	// NameNormalizationLowercase removes leading and trailing whitespace and
+	// lowercases the name.
+	NameNormalizationLowercase NameNormalization = "lowercase"
+)
+
+// Normalize returns name normalized according to n.
+func (n NameNormalization) Normalize(name string) string {
+	switch n {
+	case NameNormalizationTrim:
+		return strings.TrimSpace(name)
+	case NameNormalizationLowercase:
+		return strings.ToLower(strings.TrimSpace(name))
+	}
+	return name
+}
+
+// normalizeAll normalizes each of names in place.
+func (n NameNormalization) normalizeAll(names []string) {
+	for i, name := range names {
+		names[i] = n.Normalize(name)
+	}
+}
+
+// NameNormalizer is implemented by requests referring to users, profiles or
+// checks by name. NormalizeNames normalizes those names according to the
+// NormalizeNames setting of the service of each entity.
+type NameNormalizer interface {
+	NormalizeNames(config *Config)
+}
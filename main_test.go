@@ -0,0 +1,453 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package sophrosyne
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCursorOwner    = "cs3ntbcfv20jrb7hv0f0"
+	testCursorPosition = "cs3ntbcfv20jrb7hv0fg"
+)
+
+func cursorTestConfig(mode CursorMode) *Config {
+	config := &Config{}
+	config.Server.CursorMode = mode
+	config.Security.SiteKey = []byte(strings.Repeat("k", 64))
+	return config
+}
+
+func TestDatabaseCursor_EncodeDecode(t *testing.T) {
+	for _, mode := range []CursorMode{CursorModePlain, CursorModeHMAC, CursorModeEncrypted} {
+		t.Run(string(mode), func(t *testing.T) {
+			config := cursorTestConfig(mode)
+			cursor := NewDatabaseCursor(testCursorOwner, testCursorPosition)
+
+			encoded := cursor.Encode(config)
+			require.NotEmpty(t, encoded)
+
+			decoded, err := DecodeDatabaseCursorWithOwner(encoded, testCursorOwner, config)
+			require.NoError(t, err)
+			require.Equal(t, cursor, decoded)
+		})
+	}
+}
+
+func TestDatabaseCursor_EncodeEmpty(t *testing.T) {
+	cursor := NewDatabaseCursor(testCursorOwner, "")
+	require.Equal(t, "", cursor.Encode(cursorTestConfig(CursorModeEncrypted)))
+}
+
+func TestDatabaseCursor_EncodeEncryptedIsOpaque(t *testing.T) {
+	config := cursorTestConfig(CursorModeEncrypted)
+	cursor := NewDatabaseCursor(testCursorOwner, testCursorPosition)
+
+	first := cursor.Encode(config)
+	b, err := base64.StdEncoding.DecodeString(first)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), testCursorOwner)
+	require.NotContains(t, string(b), testCursorPosition)
+
+	// A fresh nonce is used for every encoding.
+	require.NotEqual(t, first, cursor.Encode(config))
+}
+
+func TestDecodeDatabaseCursor_Tampered(t *testing.T) {
+	for _, mode := range []CursorMode{CursorModeHMAC, CursorModeEncrypted} {
+		t.Run(string(mode), func(t *testing.T) {
+			config := cursorTestConfig(mode)
+			encoded := NewDatabaseCursor(testCursorOwner, testCursorPosition).Encode(config)
+			b, err := base64.StdEncoding.DecodeString(encoded)
+			require.NoError(t, err)
+
+			b[len(b)/2] ^= 0x01
+			_, err = DecodeDatabaseCursor(base64.StdEncoding.EncodeToString(b), config)
+			require.ErrorIs(t, err, ErrInvalidCursor)
+
+			_, err = DecodeDatabaseCursor(base64.StdEncoding.EncodeToString(b[:4]), config)
+			require.ErrorIs(t, err, ErrInvalidCursor)
+
+			// A plain cursor is not accepted when integrity is required.
+			plain := NewDatabaseCursor(testCursorOwner, testCursorPosition).Encode(cursorTestConfig(CursorModePlain))
+			_, err = DecodeDatabaseCursor(plain, config)
+			require.ErrorIs(t, err, ErrInvalidCursor)
+		})
+	}
+}
+
+func TestDecodeDatabaseCursor_WrongKey(t *testing.T) {
+	config := cursorTestConfig(CursorModeEncrypted)
+	encoded := NewDatabaseCursor(testCursorOwner, testCursorPosition).Encode(config)
+
+	other := cursorTestConfig(CursorModeEncrypted)
+	other.Security.SiteKey = []byte(strings.Repeat("o", 64))
+	_, err := DecodeDatabaseCursor(encoded, other)
+	require.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestDeriveKey(t *testing.T) {
+	config := cursorTestConfig(CursorModePlain)
+
+	mac := deriveKey(config, keyPurposeCursorMAC)
+	require.Len(t, mac, 32)
+	require.Equal(t, mac, deriveKey(config, keyPurposeCursorMAC))
+	require.NotEqual(t, mac, deriveKey(config, keyPurposeCursorAEAD))
+	require.NotEqual(t, config.Security.SiteKey[:32], mac)
+
+	other := cursorTestConfig(CursorModePlain)
+	other.Security.SiteKey = []byte(strings.Repeat("o", 64))
+	require.NotEqual(t, mac, deriveKey(other, keyPurposeCursorMAC))
+}
+
+func TestProtectEmail(t *testing.T) {
+	config := cursorTestConfig(CursorModePlain)
+
+	protected := ProtectEmail("user@example.com", config)
+	require.Len(t, protected, 64)
+	require.NotContains(t, protected, "user@example.com")
+	require.Equal(t, protected, ProtectEmail("user@example.com", config))
+	require.NotEqual(t, protected, ProtectEmail("other@example.com", config))
+
+	other := cursorTestConfig(CursorModePlain)
+	other.Security.SiteKey = []byte(strings.Repeat("o", 64))
+	require.NotEqual(t, protected, ProtectEmail("user@example.com", other))
+
+	// The key is not shared with cursors.
+	require.NotEqual(t, protected, hex.EncodeToString(cursorMAC([]byte("user@example.com"), config)))
+}
+
+func TestConfig_AuthzAction(t *testing.T) {
+	config := &Config{}
+	config.Security.AuthzActions = map[string]string{"GetUser": "users:read"}
+
+	require.Equal(t, AuthorizationAction("users:read"), config.AuthzAction("GetUser"))
+	require.Equal(t, AuthorizationAction("GetUsers"), config.AuthzAction("GetUsers"))
+
+	var nilConfig *Config
+	require.Equal(t, AuthorizationAction("GetUser"), nilConfig.AuthzAction("GetUser"))
+}
+
+func TestDatabaseCursor_ValidatePosition(t *testing.T) {
+	ctx := context.Background()
+	exists := func(want bool, err error) func(context.Context, string) (bool, error) {
+		return func(_ context.Context, position string) (bool, error) {
+			require.Equal(t, testCursorPosition, position)
+			return want, err
+		}
+	}
+
+	cursor := NewDatabaseCursor(testCursorOwner, testCursorPosition)
+	require.NoError(t, cursor.ValidatePosition(ctx, exists(true, nil)))
+	require.ErrorIs(t, cursor.ValidatePosition(ctx, exists(false, nil)), ErrInvalidCursor)
+	require.ErrorIs(t, cursor.ValidatePosition(ctx, exists(false, io.ErrUnexpectedEOF)), io.ErrUnexpectedEOF)
+
+	empty := NewDatabaseCursor(testCursorOwner, "")
+	require.NoError(t, empty.ValidatePosition(ctx, func(context.Context, string) (bool, error) {
+		t.Fatal("checker must not be called without a position")
+		return false, nil
+	}))
+}
+
+func TestDecodeDatabaseCursor_IDFormat(t *testing.T) {
+	const uuidOwner, uuidPosition = "0b7f5c1e-7a43-4d4b-9c2e-2f0f6f1f3a10", "9D1E5F3A-2B4C-4E6F-8A0B-1C2D3E4F5A6B"
+	tests := []struct {
+		name     string
+		format   IDFormat
+		owner    string
+		position string
+		wantErr  bool
+	}{
+		{name: "default accepts xid", owner: testCursorOwner, position: testCursorPosition},
+		{name: "default rejects uuid", owner: uuidOwner, position: uuidPosition, wantErr: true},
+		{name: "xid accepts xid", format: IDFormatXID, owner: testCursorOwner, position: testCursorPosition},
+		{name: "uuid accepts uuid", format: IDFormatUUID, owner: uuidOwner, position: uuidPosition},
+		{name: "uuid rejects xid", format: IDFormatUUID, owner: testCursorOwner, position: testCursorPosition, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := cursorTestConfig(CursorModePlain)
+			config.Server.IDFormat = tt.format
+			encoded := NewDatabaseCursor(tt.owner, tt.position).Encode(config)
+
+			decoded, err := DecodeDatabaseCursor(encoded, config)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidCursor)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, NewDatabaseCursor(tt.owner, tt.position), decoded)
+		})
+	}
+}
+
+func TestDecodeDatabaseCursorFunc(t *testing.T) {
+	config := cursorTestConfig(CursorModeHMAC)
+	encoded := NewDatabaseCursor("user-1", "user-2").Encode(config)
+	numbered := func(s string) bool { return strings.HasPrefix(s, "user-") }
+
+	_, err := DecodeDatabaseCursor(encoded, config)
+	require.ErrorIs(t, err, ErrInvalidCursor)
+
+	decoded, err := DecodeDatabaseCursorFunc(encoded, config, numbered)
+	require.NoError(t, err)
+	require.Equal(t, NewDatabaseCursor("user-1", "user-2"), decoded)
+}
+
+func TestNewToken_ShortReads(t *testing.T) {
+	source := strings.NewReader(strings.Repeat("a", 64))
+	token, err := NewToken(iotest.OneByteReader(source))
+	require.NoError(t, err)
+	require.Equal(t, []byte(strings.Repeat("a", 64)), token)
+}
+
+func TestNewToken_EarlyEOF(t *testing.T) {
+	source := strings.NewReader(strings.Repeat("a", 32))
+	_, err := NewToken(iotest.HalfReader(source))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestCompareToken(t *testing.T) {
+	config := &Config{}
+	config.Security.SiteKey = []byte("site key")
+	config.Security.Salt = []byte("salt")
+	token := ProtectToken([]byte("token"), config)
+
+	require.True(t, CompareToken(token, ProtectToken([]byte("token"), config)))
+	require.False(t, CompareToken(token, ProtectToken([]byte("other"), config)))
+	require.False(t, CompareToken(token, token[:len(token)-1]))
+	require.False(t, CompareToken(token, nil))
+}
+
+func TestNameNormalization_Normalize(t *testing.T) {
+	tests := []struct {
+		normalization NameNormalization
+		want          map[string]string
+	}{
+		{NameNormalizationNone, map[string]string{" Foo ": " Foo ", "foo": "foo"}},
+		{"", map[string]string{" Foo ": " Foo ", "foo": "foo"}},
+		{NameNormalizationTrim, map[string]string{" Foo ": "Foo", "foo": "foo", "\tFoo\n": "Foo"}},
+		{NameNormalizationLowercase, map[string]string{" Foo ": "foo", "foo": "foo", "FOO": "foo"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.normalization), func(t *testing.T) {
+			for in, want := range tt.want {
+				require.Equal(t, want, tt.normalization.Normalize(in), "normalizing %q", in)
+			}
+		})
+	}
+}
+
+func TestNameNormalizer(t *testing.T) {
+	config := &Config{}
+	config.Services.Users.NormalizeNames = NameNormalizationLowercase
+	config.Services.Profiles.NormalizeNames = NameNormalizationTrim
+	config.Services.Checks.NormalizeNames = NameNormalizationLowercase
+
+	user := &CreateUserRequest{Name: " Foo "}
+	user.NormalizeNames(config)
+	require.Equal(t, "foo", user.Name)
+
+	users := &DeleteUsersRequest{Names: []string{" Foo ", "foo"}}
+	users.NormalizeNames(config)
+	require.Equal(t, []string{"foo", "foo"}, users.Names)
+
+	profile := &CreateProfileRequest{Name: " Foo ", Checks: []string{" Bar "}}
+	profile.NormalizeNames(config)
+	require.Equal(t, "Foo", profile.Name)
+	require.Equal(t, []string{"bar"}, profile.Checks)
+
+	scan := &PerformScanRequest{Profile: " Foo "}
+	scan.NormalizeNames(config)
+	require.Equal(t, "Foo", scan.Profile)
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	config := &Config{}
+	config.Database.User = "sophrosyne"
+	config.Database.Password = "hunter2"
+	config.Development.StaticRootToken = "root-token"
+	config.Security.SiteKey = []byte("site key")
+	config.Security.Salt = []byte("salt")
+
+	redacted := config.Redacted()
+	require.Equal(t, "sophrosyne", redacted.Database.User)
+	require.Equal(t, RedactedValue, redacted.Database.Password)
+	require.Equal(t, RedactedValue, redacted.Development.StaticRootToken)
+	require.Equal(t, []byte(RedactedValue), redacted.Security.SiteKey)
+	require.Equal(t, []byte(RedactedValue), redacted.Security.Salt)
+	require.Equal(t, "hunter2", config.Database.Password, "the configuration is left untouched")
+	require.Equal(t, []byte("site key"), config.Security.SiteKey, "the configuration is left untouched")
+
+	empty := (&Config{}).Redacted()
+	require.Empty(t, empty.Database.Password, "unset secrets are left unset")
+	require.Empty(t, empty.Security.SiteKey, "unset secrets are left unset")
+}
+
+func validConfig() *Config {
+	config := &Config{}
+	config.Security.SiteKey = []byte(strings.Repeat("k", 64))
+	config.Security.Salt = []byte(strings.Repeat("s", 32))
+	config.Security.TLS.KeyType = "EC-P384"
+	config.Database.Host = "localhost"
+	config.Database.Port = 5432
+	config.Server.Port = 8080
+	config.Services.Users.PageSize = 2
+	config.Services.Profiles.PageSize = 10
+	config.Services.Checks.PageSize = 10
+	config.Database.ConnectRetry.InitialBackoff = time.Second
+	config.Database.ConnectRetry.MaxBackoff = time.Second
+	config.Database.ReadRetry.InitialBackoff = 10 * time.Millisecond
+	config.Database.ReadRetry.MaxBackoff = time.Second
+	config.Services.Scans.Retry.BaseDelay = 100 * time.Millisecond
+	config.Services.Scans.Retry.MaxDelay = 2 * time.Second
+	return config
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		field  string
+		want   string
+	}{
+		{name: "valid"},
+		{
+			name:   "short site key",
+			modify: func(c *Config) { c.Security.SiteKey = []byte("short") },
+			field:  "security.siteKey",
+			want:   "invalid configuration value for security.siteKey: expected exactly 64 bytes, got 5 bytes",
+		},
+		{
+			name:   "long salt",
+			modify: func(c *Config) { c.Security.Salt = []byte(strings.Repeat("s", 33)) },
+			field:  "security.salt",
+			want:   "invalid configuration value for security.salt: expected exactly 32 bytes, got 33 bytes",
+		},
+		{
+			name:   "unknown tls key type",
+			modify: func(c *Config) { c.Security.TLS.KeyType = "RSA-1024" },
+			field:  "security.tls.keyType",
+			want:   `invalid configuration value for security.tls.keyType: expected one of RSA-4096, EC-P224, EC-P256, EC-P384, EC-P521, ED25519, got "RSA-1024"`,
+		},
+		{
+			name:   "empty database host",
+			modify: func(c *Config) { c.Database.Host = "" },
+			field:  "database.host",
+			want:   `invalid configuration value for database.host: expected a host name, got ""`,
+		},
+		{
+			name:   "database port out of range",
+			modify: func(c *Config) { c.Database.Port = 70000 },
+			field:  "database.port",
+			want:   "invalid configuration value for database.port: expected a port between 1 and 65535, got 70000",
+		},
+		{
+			name:   "server port not set",
+			modify: func(c *Config) { c.Server.Port = 0 },
+			field:  "server.port",
+			want:   "invalid configuration value for server.port: expected a port between 1 and 65535, got 0",
+		},
+		{
+			name:   "users page size",
+			modify: func(c *Config) { c.Services.Users.PageSize = 1 },
+			field:  "services.users.pageSize",
+			want:   "invalid configuration value for services.users.pageSize: expected at least 2, got 1",
+		},
+		{
+			name:   "profiles page size",
+			modify: func(c *Config) { c.Services.Profiles.PageSize = -1 },
+			field:  "services.profiles.pageSize",
+			want:   "invalid configuration value for services.profiles.pageSize: expected at least 2, got -1",
+		},
+		{
+			name:   "checks page size",
+			modify: func(c *Config) { c.Services.Checks.PageSize = 0 },
+			field:  "services.checks.pageSize",
+			want:   "invalid configuration value for services.checks.pageSize: expected at least 2, got 0",
+		},
+		{
+			name:   "connect retry backoff",
+			modify: func(c *Config) { c.Database.ConnectRetry.InitialBackoff = time.Minute },
+			field:  "database.connectRetry.initialBackoff",
+			want:   "invalid configuration value for database.connectRetry.initialBackoff: expected at most database.connectRetry.maxBackoff (1s), got 1m0s",
+		},
+		{
+			name:   "read retry backoff",
+			modify: func(c *Config) { c.Database.ReadRetry.MaxBackoff = time.Millisecond },
+			field:  "database.readRetry.initialBackoff",
+			want:   "invalid configuration value for database.readRetry.initialBackoff: expected at most database.readRetry.maxBackoff (1ms), got 10ms",
+		},
+		{
+			name:   "scan retry delay",
+			modify: func(c *Config) { c.Services.Scans.Retry.BaseDelay = 3 * time.Second },
+			field:  "services.scans.retry.baseDelay",
+			want:   "invalid configuration value for services.scans.retry.baseDelay: expected at most services.scans.retry.maxDelay (2s), got 3s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validConfig()
+			if tt.modify != nil {
+				tt.modify(config)
+			}
+			err := config.Validate()
+			if tt.want == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.want)
+			var fieldErr ConfigFieldError
+			require.True(t, errors.As(err, &fieldErr))
+			require.Equal(t, tt.field, fieldErr.Field)
+		})
+	}
+}
+
+func TestConfig_ValidateReportsAll(t *testing.T) {
+	config := validConfig()
+	config.Security.SiteKey = []byte("secret")
+	config.Server.Port = 0
+
+	err := config.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "security.siteKey")
+	require.Contains(t, err.Error(), "server.port")
+	require.NotContains(t, err.Error(), "secret", "secrets must not be included in errors")
+}
+
+func TestAuthFailureTokenHash(t *testing.T) {
+	const token = "c2VjcmV0IHRva2Vu"
+	got := AuthFailureTokenHash(token)
+	require.Len(t, got, authFailureTokenHashLength)
+	require.NotContains(t, got, token)
+	require.Equal(t, got, AuthFailureTokenHash(token), "the same token must hash the same")
+	require.NotEqual(t, got, AuthFailureTokenHash(token+"x"))
+	require.Empty(t, AuthFailureTokenHash(""))
+}
@@ -22,12 +22,13 @@ import (
 )
 
 type Profile struct {
-	ID        string
-	Name      string
-	Checks    []Check
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt *time.Time
+	ID          string
+	Name        string
+	Checks      []Check
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   *time.Time
+	ServedStale bool `db:"-"` // served expired from the cache, see services.cache.serveStaleOnError
 }
 
 func (p Profile) EntityType() string { return "Profile" }
@@ -48,12 +49,17 @@ type GetProfileRequest struct {
 	Name string `json:"name" validate:"required_without=ID,excluded_with=ID"`
 }
 
+func (p *GetProfileRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Profiles.NormalizeNames.Normalize(p.Name)
+}
+
 type GetProfileResponse struct {
-	Name      string   `json:"name"`
-	Checks    []string `json:"checks"`
-	CreatedAt string   `json:"createdAt"`
-	UpdatedAt string   `json:"updatedAt"`
-	DeletedAt string   `json:"deletedAt,omitempty"`
+	Name        string   `json:"name"`
+	Checks      []string `json:"checks"`
+	CreatedAt   string   `json:"createdAt"`
+	UpdatedAt   string   `json:"updatedAt"`
+	DeletedAt   string   `json:"deletedAt,omitempty"`
+	ServedStale bool     `json:"servedStale,omitempty"` // see services.cache.serveStaleOnError
 }
 
 func (r *GetProfileResponse) FromProfile(p Profile) *GetProfileResponse {
@@ -68,6 +74,7 @@ func (r *GetProfileResponse) FromProfile(p Profile) *GetProfileResponse {
 	if p.DeletedAt != nil {
 		r.DeletedAt = p.DeletedAt.Format(TimeFormatInResponse)
 	}
+	r.ServedStale = p.ServedStale
 	return r
 }
 
@@ -86,6 +93,11 @@ type CreateProfileRequest struct {
 	Checks []string `json:"checks"`
 }
 
+func (p *CreateProfileRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Profiles.NormalizeNames.Normalize(p.Name)
+	config.Services.Checks.NormalizeNames.normalizeAll(p.Checks)
+}
+
 type CreateProfileResponse struct {
 	GetProfileResponse
 }
@@ -95,10 +107,34 @@ type UpdateProfileRequest struct {
 	Checks []string `json:"checks"`
 }
 
+func (p *UpdateProfileRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Profiles.NormalizeNames.Normalize(p.Name)
+	config.Services.Checks.NormalizeNames.normalizeAll(p.Checks)
+}
+
 type UpdateProfileResponse struct {
 	GetProfileResponse
 }
 
+type ValidateProfileRequest struct {
+	Name   string   `json:"name"`
+	Checks []string `json:"checks"`
+}
+
+func (p *ValidateProfileRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Profiles.NormalizeNames.Normalize(p.Name)
+	config.Services.Checks.NormalizeNames.normalizeAll(p.Checks)
+}
+
+type ValidateProfileResponse struct {
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+}
+
 type DeleteProfileRequest struct {
 	Name string `json:"name" validate:"required"`
 }
+
+func (p *DeleteProfileRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Profiles.NormalizeNames.Normalize(p.Name)
+}
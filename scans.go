@@ -18,4 +18,52 @@ package sophrosyne
 
 type PerformScanRequest struct {
 	Profile string `json:"profile"`
+	// IncludeMetadata requests that any provider-specific metadata returned
+	// by the checks is included in the result of the scan.
+	IncludeMetadata bool `json:"include_metadata"`
+	// ImageURL is fetched by the server, bounded by services.scans.imageFetch,
+	// and the image is sent to the checks instead of text.
+	ImageURL string `json:"image_url" validate:"omitempty,url"`
+}
+
+func (p *PerformScanRequest) NormalizeNames(config *Config) {
+	p.Profile = config.Services.Profiles.NormalizeNames.Normalize(p.Profile)
+}
+
+// ScanAggregation determines how the results of the checks in a profile are
+// combined into the overall result of a scan.
+type ScanAggregation string
+
+const (
+	// ScanAggregationAllMustPass passes a scan only if every check passes.
+	ScanAggregationAllMustPass ScanAggregation = "allMustPass"
+	// ScanAggregationAnyMustPass passes a scan if at least one check passes.
+	ScanAggregationAnyMustPass ScanAggregation = "anyMustPass"
+)
+
+// Aggregate combines the results of the checks of a scan. A scan without any
+// checks never passes. Unknown strategies are treated as
+// [ScanAggregationAllMustPass].
+func (a ScanAggregation) Aggregate(results []bool) bool {
+	if len(results) == 0 {
+		return false
+	}
+	for _, passed := range results {
+		if a == ScanAggregationAnyMustPass && passed {
+			return true
+		}
+		if a != ScanAggregationAnyMustPass && !passed {
+			return false
+		}
+	}
+	return a != ScanAggregationAnyMustPass
+}
+
+// Decides reports whether a single check result determines the result of a
+// scan, regardless of the results of the remaining checks.
+func (a ScanAggregation) Decides(passed bool) bool {
+	if a == ScanAggregationAnyMustPass {
+		return passed
+	}
+	return !passed
 }
@@ -0,0 +1,66 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package sophrosyne
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanAggregation_Aggregate(t *testing.T) {
+	tests := []struct {
+		name        string
+		aggregation ScanAggregation
+		results     []bool
+		want        bool
+	}{
+		{name: "all must pass, all pass", aggregation: ScanAggregationAllMustPass, results: []bool{true, true}, want: true},
+		{name: "all must pass, one fails", aggregation: ScanAggregationAllMustPass, results: []bool{false, true}},
+		{name: "any must pass, one passes", aggregation: ScanAggregationAnyMustPass, results: []bool{false, true}, want: true},
+		{name: "any must pass, none pass", aggregation: ScanAggregationAnyMustPass, results: []bool{false, false}},
+		{name: "unknown behaves as all must pass", aggregation: "", results: []bool{true, false}},
+		{name: "no checks", aggregation: ScanAggregationAllMustPass},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.aggregation.Aggregate(tt.results))
+		})
+	}
+}
+
+func TestScanAggregation_Decides(t *testing.T) {
+	tests := []struct {
+		name        string
+		aggregation ScanAggregation
+		passed      bool
+		want        bool
+	}{
+		{name: "all must pass, failing check decides", aggregation: ScanAggregationAllMustPass, passed: false, want: true},
+		{name: "all must pass, passing check does not decide", aggregation: ScanAggregationAllMustPass, passed: true},
+		{name: "any must pass, passing check decides", aggregation: ScanAggregationAnyMustPass, passed: true, want: true},
+		{name: "any must pass, failing check does not decide", aggregation: ScanAggregationAnyMustPass, passed: false},
+		{name: "unknown behaves as all must pass", aggregation: "", passed: false, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.aggregation.Decides(tt.passed))
+		})
+	}
+}
@@ -0,0 +1,207 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sophrosyne
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// AuthorizationSimulator evaluates authorization requests against a candidate
+// policy set without installing it, so the decisions can be compared with
+// those made by the policies currently in use.
+type AuthorizationSimulator interface {
+	SimulateAuthorization(ctx context.Context, policies []byte, reqs []AuthorizationRequest) ([]SimulatedAuthzDecision, error)
+}
+
+type SimulateAuthzRequest struct {
+	Policies string                  `json:"policies" validate:"required"`
+	Requests []SimulatedAuthzRequest `json:"requests" validate:"required,min=1,dive"`
+}
+
+type SimulatedAuthzRequest struct {
+	Principal    string `json:"principal" validate:"required"`
+	Action       string `json:"action" validate:"required"`
+	ResourceType string `json:"resource_type" validate:"omitempty,oneof=User Profile Check"`
+	ResourceID   string `json:"resource_id" validate:"required_with=ResourceType,excluded_without=ResourceType"`
+}
+
+// AuthorizationRequest converts the simulated request into the request the
+// authorization provider would have received.
+func (r SimulatedAuthzRequest) AuthorizationRequest() AuthorizationRequest {
+	req := AuthorizationRequest{
+		Principal: User{ID: r.Principal},
+		Action:    AuthorizationAction(r.Action),
+	}
+	switch r.ResourceType {
+	case "User":
+		req.Resource = User{ID: r.ResourceID}
+	case "Profile":
+		req.Resource = Profile{ID: r.ResourceID}
+	case "Check":
+		req.Resource = Check{ID: r.ResourceID}
+	}
+	return req
+}
+
+type SimulateAuthzResponse struct {
+	Decisions []SimulatedAuthzDecision `json:"decisions"`
+}
+
+type SimulatedAuthzDecision struct {
+	Current   bool `json:"current"`
+	Candidate bool `json:"candidate"`
+	Changed   bool `json:"changed"`
+}
+
+type ServerStatus struct {
+	StartedAt     time.Time
+	ScansTotal    int64
+	ScansInFlight int64
+}
+
+type StatusProvider interface {
+	Status() ServerStatus
+}
+
+type GetStatusResponse struct {
+	StartedAt     string `json:"started_at"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	ScansTotal    int64  `json:"scans_total"`
+	ScansInFlight int64  `json:"scans_in_flight"`
+}
+
+func (r *GetStatusResponse) FromStatus(s ServerStatus, now time.Time) *GetStatusResponse {
+	r.StartedAt = s.StartedAt.Format(TimeFormatInResponse)
+	r.UptimeSeconds = int64(now.Sub(s.StartedAt).Seconds())
+	r.ScansTotal = s.ScansTotal
+	r.ScansInFlight = s.ScansInFlight
+	return r
+}
+
+// ErrorEvent is a panic or internal error recorded for diagnostic purposes.
+type ErrorEvent struct {
+	Time    time.Time
+	Method  string
+	Message string // redacted, see ErrorRecorder
+	Stack   string
+}
+
+// ErrorRecorder keeps a bounded record of recent panics and internal errors.
+//
+// Implementations must redact the message of the error before storing it, as
+// it may contain data supplied by users.
+type ErrorRecorder interface {
+	RecordError(ctx context.Context, method string, err error)
+	RecentErrors(ctx context.Context) []ErrorEvent
+}
+
+type GetRecentErrorsResponse struct {
+	Errors []RecentError `json:"errors"`
+}
+
+type RecentError struct {
+	Time    string `json:"time"`
+	Method  string `json:"method"`
+	Message string `json:"message"`
+	Stack   string `json:"stack,omitempty"`
+}
+
+func (r *GetRecentErrorsResponse) FromEvents(events []ErrorEvent, includeStack bool) *GetRecentErrorsResponse {
+	r.Errors = make([]RecentError, 0, len(events))
+	for _, e := range events {
+		re := RecentError{
+			Time:    e.Time.Format(TimeFormatInResponse),
+			Method:  e.Method,
+			Message: e.Message,
+		}
+		if includeStack {
+			re.Stack = e.Stack
+		}
+		r.Errors = append(r.Errors, re)
+	}
+	return r
+}
+
+// AuthFailureReason describes why a request failed authentication.
+type AuthFailureReason string
+
+const (
+	AuthFailureMissingToken   AuthFailureReason = "missing token"
+	AuthFailureMalformedToken AuthFailureReason = "malformed token"
+	AuthFailureInvalidToken   AuthFailureReason = "invalid token" // unknown, revoked or expired
+)
+
+// authFailureTokenHashLength is the number of hex characters of the hash of a
+// token kept by [AuthFailureTokenHash].
+const authFailureTokenHashLength = 16
+
+// AuthFailureTokenHash returns a prefix of the SHA-256 hash of token, as sent
+// by the client. It is enough to correlate repeated failures using the same
+// token, but not to recover the token.
+func AuthFailureTokenHash(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:authFailureTokenHashLength]
+}
+
+// AuthFailureEvent is a failed authentication recorded for intrusion
+// detection.
+type AuthFailureEvent struct {
+	Time      time.Time
+	Source    string // address of the client, without the port
+	Reason    AuthFailureReason
+	TokenHash string // see AuthFailureTokenHash, empty if no token was sent
+}
+
+// AuthFailureRecorder keeps a bounded record of recent authentication
+// failures.
+//
+// Implementations must never be given the token of a failed request, only
+// its [AuthFailureTokenHash].
+type AuthFailureRecorder interface {
+	RecordAuthFailure(ctx context.Context, event AuthFailureEvent)
+	RecentAuthFailures(ctx context.Context) []AuthFailureEvent
+}
+
+type GetAuthFailuresResponse struct {
+	Failures []AuthFailure `json:"failures"`
+}
+
+type AuthFailure struct {
+	Time      string `json:"time"`
+	Source    string `json:"source"`
+	Reason    string `json:"reason"`
+	TokenHash string `json:"token_hash,omitempty"`
+}
+
+func (r *GetAuthFailuresResponse) FromEvents(events []AuthFailureEvent) *GetAuthFailuresResponse {
+	r.Failures = make([]AuthFailure, 0, len(events))
+	for _, e := range events {
+		r.Failures = append(r.Failures, AuthFailure{
+			Time:      e.Time.Format(TimeFormatInResponse),
+			Source:    e.Source,
+			Reason:    string(e.Reason),
+			TokenHash: e.TokenHash,
+		})
+	}
+	return r
+}
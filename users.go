@@ -23,17 +23,63 @@ import (
 )
 
 type User struct {
-	ID             string
-	Name           string
-	Email          string
-	Token          []byte
-	IsAdmin        bool
-	DefaultProfile Profile
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	DeletedAt      *time.Time
+	ID                     string
+	Name                   string
+	Email                  string
+	Token                  []byte
+	TokenExpiresAt         *time.Time // nil if the token never expires
+	PreviousToken          []byte     // the token replaced by RotateToken, if still in its grace window
+	PreviousTokenExpiresAt *time.Time
+	IsAdmin                bool
+	DefaultProfile         Profile
+	CreatedAt              time.Time
+	UpdatedAt              time.Time
+	DeletedAt              *time.Time
+	ServedStale            bool `db:"-"` // served expired from the cache, see services.cache.serveStaleOnError
 }
 
+// ValidToken reports whether token, protected by [ProtectToken], is a valid
+// token of the user at now. It is valid if it is the current token of the user
+// and has not expired, or if it is the previous token of the user and the
+// grace window of the rotation has not passed.
+func (u User) ValidToken(token []byte, now time.Time) bool {
+	if CompareToken(u.Token, token) {
+		return u.TokenExpiresAt == nil || now.Before(*u.TokenExpiresAt)
+	}
+	if len(u.PreviousToken) > 0 && CompareToken(u.PreviousToken, token) {
+		return u.PreviousTokenExpiresAt != nil && now.Before(*u.PreviousTokenExpiresAt)
+	}
+	return false
+}
+
+// MissingProfileMode controls what happens when the default profile of a user
+// cannot be retrieved while looking up the user.
+type MissingProfileMode string
+
+const (
+	// MissingProfileModeFail fails the lookup of the user.
+	MissingProfileModeFail MissingProfileMode = "fail"
+	// MissingProfileModeDefault falls back to the service-wide default profile.
+	MissingProfileModeDefault MissingProfileMode = "default"
+	// MissingProfileModeNone returns the user without a default profile.
+	MissingProfileModeNone MissingProfileMode = "none"
+)
+
+// TokenDeliveryMode controls how the tokens created by CreateUser and
+// RotateToken are handed to the caller.
+type TokenDeliveryMode string
+
+const (
+	// TokenDeliveryResponse returns the token in the response.
+	TokenDeliveryResponse TokenDeliveryMode = "response"
+	// TokenDeliveryFile writes the token to a file in
+	// services.users.tokenDirectory and returns a reference to it.
+	TokenDeliveryFile TokenDeliveryMode = "file"
+	// TokenDeliveryWebhook posts the token to services.users.tokenWebhook and
+	// returns a reference to it.
+	TokenDeliveryWebhook TokenDeliveryMode = "webhook"
+)
+
 func (u User) EntityType() string {
 	return "User"
 }
@@ -62,9 +108,15 @@ type UserService interface {
 	//
 	// The returned list of users should be ordered by ID in ascending order.
 	GetUsers(ctx context.Context, cursor *DatabaseCursor) ([]User, error)
+	// CreateUser creates a user with a new token. The token must be handed to
+	// [DeliverToken] before the user is stored, and the user must not be
+	// stored if that fails.
 	CreateUser(ctx context.Context, user CreateUserRequest) (User, error)
 	UpdateUser(ctx context.Context, user UpdateUserRequest) (User, error)
 	DeleteUser(ctx context.Context, name string) error
+	// RotateToken replaces the token of the named user with a new one. As for
+	// CreateUser, the new token must be handed to [DeliverToken] before it is
+	// stored, and the previous token kept if that fails.
 	RotateToken(ctx context.Context, name string) ([]byte, error)
 }
 
@@ -74,6 +126,10 @@ type GetUserRequest struct {
 	Name  string `json:"name"`
 }
 
+func (p *GetUserRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Users.NormalizeNames.Normalize(p.Name)
+}
+
 func (p GetUserRequest) Validate(interface{}) error {
 	if p.ID == "" && p.Name == "" && p.Email == "" {
 		return fmt.Errorf("one of ID, Name or Email must be provided")
@@ -85,29 +141,36 @@ func (p GetUserRequest) Validate(interface{}) error {
 }
 
 type GetUserResponse struct {
-	Name      string `json:"name"`
-	Email     string `json:"email"`
-	IsAdmin   bool   `json:"is_admin"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
-	DeletedAt string `json:"deleted_at,omitempty"`
+	Name           string `json:"name"`
+	Email          string `json:"email"`
+	IsAdmin        bool   `json:"is_admin"`
+	TokenExpiresAt string `json:"token_expires_at,omitempty"`
+	CreatedAt      string `json:"created_at"`
+	UpdatedAt      string `json:"updated_at"`
+	DeletedAt      string `json:"deleted_at,omitempty"`
+	ServedStale    bool   `json:"served_stale,omitempty"` // see services.cache.serveStaleOnError
 }
 
 func (r *GetUserResponse) FromUser(u User) *GetUserResponse {
 	r.Name = u.Name
 	r.Email = u.Email
 	r.IsAdmin = u.IsAdmin
+	if u.TokenExpiresAt != nil {
+		r.TokenExpiresAt = u.TokenExpiresAt.Format(TimeFormatInResponse)
+	}
 	r.CreatedAt = u.CreatedAt.Format(TimeFormatInResponse)
 	r.UpdatedAt = u.UpdatedAt.Format(TimeFormatInResponse)
 	if u.DeletedAt != nil {
 		r.DeletedAt = u.DeletedAt.Format(TimeFormatInResponse)
 	}
+	r.ServedStale = u.ServedStale
 
 	return r
 }
 
 type GetUsersRequest struct {
-	Cursor string `json:"cursor"`
+	Cursor string    `json:"cursor"`
+	Order  SortOrder `json:"order" validate:"omitempty,oneof=asc desc"`
 }
 
 type GetUsersResponse struct {
@@ -122,10 +185,15 @@ type CreateUserRequest struct {
 	IsAdmin bool   `json:"is_admin"`
 }
 
+func (p *CreateUserRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Users.NormalizeNames.Normalize(p.Name)
+}
+
 type CreateUserResponse struct {
 	Name      string `json:"name"`
 	Email     string `json:"email"`
 	Token     []byte `json:"token"`
+	TokenRef  string `json:"token_reference,omitempty"` // set instead of Token when delivered out-of-band
 	IsAdmin   bool   `json:"is_admin"`
 	CreatedAt string `json:"created_at"`
 	UpdatedAt string `json:"updated_at"`
@@ -152,6 +220,10 @@ type UpdateUserRequest struct {
 	IsAdmin bool   `json:"is_admin"`
 }
 
+func (p *UpdateUserRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Users.NormalizeNames.Normalize(p.Name)
+}
+
 type UpdateUserResponse struct {
 	Name      string `json:"name"`
 	Email     string `json:"email"`
@@ -178,12 +250,43 @@ type DeleteUserRequest struct {
 	Name string `json:"name" validate:"required"`
 }
 
+func (p *DeleteUserRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Users.NormalizeNames.Normalize(p.Name)
+}
+
+// DeleteUsersRequest deletes several users by name. The number of names is
+// bounded by [Config.Services.Users.MaxBatchSize].
+type DeleteUsersRequest struct {
+	Names []string `json:"names" validate:"required,min=1,dive,required"`
+}
+
+func (p *DeleteUsersRequest) NormalizeNames(config *Config) {
+	config.Services.Users.NormalizeNames.normalizeAll(p.Names)
+}
+
+// DeleteUsersResult is the outcome of deleting a single user as part of a
+// [DeleteUsersRequest].
+type DeleteUsersResult struct {
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+type DeleteUsersResponse struct {
+	Results []DeleteUsersResult `json:"results"`
+}
+
 type RotateTokenRequest struct {
 	Name string `json:"name" validate:"required"`
 }
 
+func (p *RotateTokenRequest) NormalizeNames(config *Config) {
+	p.Name = config.Services.Users.NormalizeNames.Normalize(p.Name)
+}
+
 type RotateTokenResponse struct {
-	Token []byte `json:"token"`
+	Token    []byte `json:"token"`
+	TokenRef string `json:"token_reference,omitempty"` // set instead of Token when delivered out-of-band
 }
 
 func (r *RotateTokenResponse) FromUser(u User) *RotateTokenResponse {
@@ -193,3 +296,22 @@ func (r *RotateTokenResponse) FromUser(u User) *RotateTokenResponse {
 }
 
 type UserContextKey struct{}
+
+// TokenDeliverer hands a newly issued token of the named user to whoever is to
+// receive it.
+type TokenDeliverer func(name string, token []byte) error
+
+// TokenDelivererContextKey is the context key under which the
+// [TokenDeliverer] of the current request is stored.
+type TokenDelivererContextKey struct{}
+
+// DeliverToken hands token to the [TokenDeliverer] of ctx, if there is one.
+// It is called by a [UserService] before storing a new token, so that a token
+// that cannot be delivered is never stored.
+func DeliverToken(ctx context.Context, name string, token []byte) error {
+	deliver, ok := ctx.Value(TokenDelivererContextKey{}).(TokenDeliverer)
+	if !ok {
+		return nil
+	}
+	return deliver(name, token)
+}
@@ -0,0 +1,75 @@
+// Sophrosyne
+//   Copyright (C) 2024  Mads R. Havmand
+//
+// This program is free software: you can redistribute it and/or modify
+//   it under the terms of the GNU Affero General Public License as published by
+//   the Free Software Foundation, either version 3 of the License, or
+//   (at your option) any later version.
+//
+//   This program is distributed in the hope that it will be useful,
+//   but WITHOUT ANY WARRANTY; without even the implied warranty of
+//   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//   GNU Affero General Public License for more details.
+//
+//   You should have received a copy of the GNU Affero General Public License
+//   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !integration
+
+package sophrosyne
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUser_ValidToken(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+	token := []byte("token")
+
+	tests := []struct {
+		name string
+		user User
+		want bool
+	}{
+		{name: "never expires", user: User{Token: token}, want: true},
+		{name: "not expired", user: User{Token: token, TokenExpiresAt: &future}, want: true},
+		{name: "expired", user: User{Token: token, TokenExpiresAt: &past}},
+		{name: "expires now", user: User{Token: token, TokenExpiresAt: &now}},
+		{name: "other token", user: User{Token: []byte("other")}},
+		{name: "previous token within grace", user: User{Token: []byte("new"), PreviousToken: token, PreviousTokenExpiresAt: &future}, want: true},
+		{name: "previous token after grace", user: User{Token: []byte("new"), PreviousToken: token, PreviousTokenExpiresAt: &past}},
+		{name: "previous token without grace", user: User{Token: []byte("new"), PreviousToken: token}},
+		{name: "rotated token within grace", user: User{Token: token, PreviousToken: []byte("old"), PreviousTokenExpiresAt: &future}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.user.ValidToken(token, now))
+		})
+	}
+}
+
+func TestDeliverToken(t *testing.T) {
+	require.NoError(t, DeliverToken(context.Background(), "name", []byte("token")))
+
+	var gotName string
+	var gotToken []byte
+	ctx := context.WithValue(context.Background(), TokenDelivererContextKey{}, TokenDeliverer(func(name string, token []byte) error {
+		gotName, gotToken = name, token
+		return nil
+	}))
+	require.NoError(t, DeliverToken(ctx, "name", []byte("token")))
+	require.Equal(t, "name", gotName)
+	require.Equal(t, []byte("token"), gotToken)
+
+	ctx = context.WithValue(context.Background(), TokenDelivererContextKey{}, TokenDeliverer(func(string, []byte) error {
+		return assert.AnError
+	}))
+	require.ErrorIs(t, DeliverToken(ctx, "name", []byte("token")), assert.AnError)
+}